@@ -0,0 +1,156 @@
+package action
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+
+	"nutsh/openapi/gen/nutshapi"
+)
+
+// the prefix of image urls to be loaded from the data dir of the server, which serves the dir at `/data`
+const exportDataProtocol = "data://"
+
+// ExportSamples exports the samples of a project on the running server as a fine-tuning dataset, in which the samples
+// are split into `train` and `val` and their images are downloaded into `image` where `nutsh-sam finetune` looks for
+// them when given the output as its workspace.
+func ExportSamples(ctx context.Context) error {
+	opt := ExportSamplesOption
+	if opt.ValRatio < 0 || opt.ValRatio > 1 {
+		return errors.Errorf("val ratio should be in [0, 1] but got %f", opt.ValRatio)
+	}
+
+	serverUrl := strings.TrimSuffix(opt.ServerUrl, "/")
+	client, err := nutshapi.NewClientWithResponses(serverUrl + "/api")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	resp, err := client.ListProjectSamplesWithResponse(ctx, opt.ProjectId)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if resp.JSON200 == nil {
+		return errors.Errorf("failed to list samples: %s", resp.Status())
+	}
+	samples := resp.JSON200.Samples
+	if len(samples) == 0 {
+		color.Yellow("project %s has no samples", opt.ProjectId)
+		return nil
+	}
+
+	for _, dir := range []string{"train", "val", "image"} {
+		if err := os.MkdirAll(filepath.Join(opt.Output, dir), 0755); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	var train, val, skipped int
+	downloaded := make(map[string]bool)
+	for _, sample := range samples {
+		if sample.ImageUrl == "" {
+			color.Yellow("skipped sample %s which can not be decoded", sample.Id)
+			skipped++
+			continue
+		}
+
+		if !downloaded[sample.ImageUrl] {
+			if err := downloadSampleImage(ctx, serverUrl, sample.ImageUrl, opt.Output); err != nil {
+				return err
+			}
+			downloaded[sample.ImageUrl] = true
+		}
+
+		// the samples of an image always end up in the same split to not leak it into validation
+		split := "train"
+		if isValSample(sample.ImageUrl, opt.ValRatio) {
+			split = "val"
+			val++
+		} else {
+			train++
+		}
+		savePath := filepath.Join(opt.Output, split, sample.Id+".json")
+		if err := os.WriteFile(savePath, []byte(sample.SampleJson), 0644); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	color.Green("exported %d train and %d val samples of %d images to %s", train, val, len(downloaded), opt.Output)
+	if skipped > 0 {
+		color.Yellow("skipped %d samples", skipped)
+	}
+	return nil
+}
+
+// isValSample deterministically assigns an image to the validation split with the given probability.
+func isValSample(imageUrl string, ratio float64) bool {
+	h := md5.Sum([]byte(imageUrl))
+	f := float64(binary.BigEndian.Uint64(h[:8])) / (1 << 64)
+	return f < ratio
+}
+
+// sampleImagePath follows `image_save_path` of the fine-tuning script, which skips downloading existing images.
+func sampleImagePath(output string, imageUrl string) string {
+	h := md5.Sum([]byte(imageUrl))
+	ext := ""
+	if u, err := url.Parse(imageUrl); err == nil {
+		ext = path.Ext(u.Path)
+	}
+	return filepath.Join(output, "image", hex.EncodeToString(h[:])+ext)
+}
+
+func downloadSampleImage(ctx context.Context, serverUrl string, imageUrl string, output string) error {
+	savePath := sampleImagePath(output, imageUrl)
+	if _, err := os.Stat(savePath); err == nil {
+		return nil
+	}
+
+	src := imageUrl
+	if strings.HasPrefix(imageUrl, exportDataProtocol) {
+		src = serverUrl + "/data/" + strings.TrimPrefix(imageUrl, exportDataProtocol)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("failed to download image %s: %s", imageUrl, resp.Status)
+	}
+
+	// write to a temporary file first to not leave a partial image which would be skipped next time
+	tmpPath := savePath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return errors.WithStack(err)
+	}
+	if err := f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.Rename(tmpPath, savePath); err != nil {
+		return errors.WithStack(err)
+	}
+	fmt.Printf("downloaded %s\n", imageUrl)
+	return nil
+}
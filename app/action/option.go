@@ -1,6 +1,9 @@
 package action
 
-import "io/fs"
+import (
+	"io/fs"
+	"time"
+)
 
 var StorageOption struct {
 	Workspace string
@@ -11,13 +14,42 @@ var StartOption struct {
 	YJSServer []byte
 	Doc       fs.FS
 
-	Port                   int
-	Readonly               bool
-	DataDir                string
-	OnlineSegmentationAddr string
-	TrackAddr              string
+	Port                    int
+	Readonly                bool
+	DataDir                 string
+	OnlineSegmentationAddrs []string
+	TrackAddr               string
+	PrecomputeConcurrency   int
+	ShutdownTimeout         time.Duration
+
+	TraceExporter string
+	TraceEndpoint string
+	TraceInsecure bool
+
+	GrpcTimeout       time.Duration
+	GrpcMaxAttempts   int
+	GrpcKeepalive     time.Duration
+	GrpcTlsCa         string
+	GrpcTlsCert       string
+	GrpcTlsKey        string
+	GrpcTlsServerName string
+	GrpcToken         string
 }
 
 var ImportOption struct {
 	DataPath string
 }
+
+var PrecomputeOption struct {
+	ServerUrl   string
+	ProjectId   string
+	DecoderUuid string
+	Detach      bool
+}
+
+var ExportSamplesOption struct {
+	ServerUrl string
+	ProjectId string
+	Output    string
+	ValRatio  float64
+}
@@ -0,0 +1,124 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+
+	"nutsh/app/storage"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+const precomputePollInterval = 2 * time.Second
+
+// Precompute starts a job on the running server to embed all frames of a project, and follows its progress until it
+// finishes. Interrupting the command cancels the job unless it is detached.
+func Precompute(ctx context.Context) error {
+	client, err := nutshapi.NewClientWithResponses(strings.TrimSuffix(PrecomputeOption.ServerUrl, "/") + "/api")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	decoderUuid := PrecomputeOption.DecoderUuid
+	if decoderUuid == "" {
+		resp, err := client.GetOnlineSegmentationWithResponse(ctx)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if resp.JSON200 == nil {
+			return errors.Errorf("failed to get the default decoder: %s", resp.Status())
+		}
+		if resp.JSON200.Decoder == nil {
+			color.Red("online segmentation is not enabled on the server")
+			return nil
+		}
+		decoderUuid = resp.JSON200.Decoder.Uuid
+	}
+
+	resp, err := client.CreateProjectPrecomputeJobWithResponse(ctx, PrecomputeOption.ProjectId, nutshapi.CreateProjectPrecomputeJobReq{
+		DecoderUuid: decoderUuid,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	switch {
+	case resp.JSON200 != nil:
+	case resp.JSON400 != nil:
+		color.Red(resp.JSON400.ErrorCode)
+		return nil
+	default:
+		return errors.Errorf("failed to create the precompute job: %s", resp.Status())
+	}
+	job := resp.JSON200.Job
+	color.Green("created precompute job %s", job.Id)
+	if PrecomputeOption.Detach {
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ticker := time.NewTicker(precomputePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return cancelPrecomputeJob(client, job.Id)
+		case <-ticker.C:
+		}
+
+		resp, err := client.GetPrecomputeJobWithResponse(ctx, job.Id)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			return errors.WithStack(err)
+		}
+		if resp.JSON200 == nil {
+			return errors.Errorf("failed to get the precompute job: %s", resp.Status())
+		}
+		job = resp.JSON200.Job
+		fmt.Printf("%s: %d ready, %d failed, %d total\n", job.State, job.ReadyFrames, job.FailedFrames, job.TotalFrames)
+
+		switch job.State {
+		case storage.PrecomputeJobRunning:
+		case storage.PrecomputeJobSucceeded:
+			color.Green("successfully precomputed %d frames", job.ReadyFrames)
+			return nil
+		default:
+			if job.Error != nil {
+				color.Red("precompute job %s: %s", job.State, *job.Error)
+			} else {
+				color.Red("precompute job %s", job.State)
+			}
+			return nil
+		}
+	}
+}
+
+func cancelPrecomputeJob(client *nutshapi.ClientWithResponses, id string) error {
+	// the command context has been cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := client.CancelPrecomputeJobWithResponse(ctx, id)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	switch {
+	case resp.JSON200 != nil:
+		color.Yellow("cancelled precompute job %s", id)
+	case resp.JSON400 != nil:
+		color.Red(resp.JSON400.ErrorCode)
+	default:
+		return errors.Errorf("failed to cancel the precompute job: %s", resp.Status())
+	}
+	return nil
+}
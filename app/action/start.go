@@ -2,22 +2,34 @@ package action
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 	"go.uber.org/zap"
 
 	"nutsh/app/backend"
+	"nutsh/app/event"
+	"nutsh/app/grpcclient"
+	"nutsh/app/metrics"
 	"nutsh/app/storage/localfs"
 	"nutsh/app/storage/sqlite3"
+	"nutsh/app/supervisor"
+	"nutsh/app/webhook"
+	"nutsh/module/common/tracing"
 	"nutsh/openapi/gen/nutshapi"
 )
 
@@ -28,15 +40,32 @@ func Start(ctx context.Context) error {
 		zap.String("workspace", StorageOption.Workspace),
 		zap.Int("port", StartOption.Port),
 		zap.Bool("readonly", StartOption.Readonly),
-		zap.String("online_segmentation", StartOption.OnlineSegmentationAddr),
+		zap.Strings("online_segmentation", StartOption.OnlineSegmentationAddrs),
 		zap.String("track", StartOption.TrackAddr),
 	)
 
+	// tracing
+	shutdownTracing, err := tracing.Setup(ctx, "nutsh",
+		tracing.WithExporter(StartOption.TraceExporter),
+		tracing.WithOtlpEndpoint(StartOption.TraceEndpoint, StartOption.TraceInsecure),
+	)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			zap.L().Warn("failed to flush traces", zap.Error(err))
+		}
+	}()
+
 	// server
 	e := echo.New()
 	e.HideBanner = true
 	middlewares := []echo.MiddlewareFunc{
+		otelecho.Middleware("nutsh", otelecho.WithSkipper(isProbeRequest)),
+		metrics.Middleware(isProbeRequest),
 		middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+			Skipper:       isProbeRequest,
 			LogURI:        true,
 			LogStatus:     true,
 			LogMethod:     true,
@@ -55,7 +84,12 @@ func Start(ctx context.Context) error {
 	e.Use(middlewares...)
 
 	// proxy yjs-server ws
-	yjsPort := mustStartYJSServer()
+	internalToken := uuid.NewString()
+	yjs, yjsPort, stopYjs, err := startYJSServer(internalToken)
+	if err != nil {
+		return err
+	}
+	defer stopYjs()
 	e.Any("/ws/*", func(c echo.Context) error {
 		target := fmt.Sprintf("http://127.0.0.1:%d", yjsPort)
 		targetUrl, err := url.Parse(target)
@@ -81,19 +115,30 @@ func Start(ctx context.Context) error {
 	}
 
 	// backend
-	s, teardown, err := createServer()
+	s, teardown, err := createServer(yjs)
 	if err != nil {
 		return err
 	}
 	defer teardown()
 
+	// probes
+	e.GET("/healthz", s.Healthz)
+	e.GET("/readyz", s.Readyz)
+	e.GET("/metrics", echo.WrapHandler(metrics.Handler()))
+
 	// normal api
 	apiRouter := e.Group("/api")
-	nutshapi.RegisterHandlers(apiRouter, nutshapi.NewStrictHandler(s, nil))
+	nutshapi.RegisterHandlers(apiRouter, nutshapi.NewStrictHandler(s, []nutshapi.StrictMiddlewareFunc{
+		metrics.StrictMiddleware,
+	}))
 
 	// stream api
 	streamRouter := apiRouter.Group("/stream")
 	streamRouter.POST("/track", s.TrackStream)
+	streamRouter.GET("/project/:projectId", s.ProjectStream)
+
+	// internal api for the yjs-server
+	registerInternalApi(e, s, internalToken)
 
 	// public
 	e.Static(publicUrlPrefix, publicDir())
@@ -106,9 +151,49 @@ func Start(ctx context.Context) error {
 	e.StaticFS("/app", StartOption.Frontend)
 	e.StaticFS("/", StartOption.Frontend)
 
+	// On signals, the yjs-server is stopped and in-flight requests are drained before the deferred teardown closes the
+	// database.
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		shutdown(e, s, stopYjs)
+	}()
+
 	// start
 	lisAddr := fmt.Sprintf(":%d", StartOption.Port)
-	return e.Start(lisAddr)
+	if err := e.Start(lisAddr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	<-shutdownDone
+	zap.L().Info("server stopped")
+	return nil
+}
+
+// shutdown stops accepting new connections and waits for in-flight requests to finish until the timeout, after which
+// the remaining connections are closed. Event streams never finish on their own and are ended by draining. The
+// yjs-server is stopped first, since it persists pending edits through the internal API on stopping.
+func shutdown(e *echo.Echo, s backend.Server, stopYjs func()) {
+	zap.L().Info("shutting down", zap.Duration("timeout", StartOption.ShutdownTimeout))
+	s.Drain()
+	stopYjs()
+
+	ctx, cancel := context.WithTimeout(context.Background(), StartOption.ShutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		zap.L().Warn("failed to drain requests in time, closing connections", zap.Error(err))
+		if err := e.Close(); err != nil {
+			zap.L().Error("failed to close the server", zap.Error(err))
+		}
+	}
+}
+
+// isProbeRequest tells if the request is made by the orchestrator or monitoring system, which are too frequent to log.
+func isProbeRequest(c echo.Context) bool {
+	path := c.Request().URL.Path
+	return path == "/healthz" || path == "/readyz" || path == "/metrics"
 }
 
 func logValuesFunc(c echo.Context, v middleware.RequestLoggerValues) error {
@@ -131,11 +216,24 @@ func logValuesFunc(c echo.Context, v middleware.RequestLoggerValues) error {
 	return nil
 }
 
-func createServer() (backend.Server, func(), error) {
+func createServer(yjs supervisor.Supervisor) (backend.Server, func(), error) {
 	var opts []backend.Option
 
+	// events published by both the handlers and the storage
+	bus := event.NewBus()
+
 	// storage
-	db, err := sqlite3.New(databasePath())
+	db, err := sqlite3.New(databasePath(), sqlite3.WithEventBus(bus))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// webhook
+	webhookStorage := db.WebhookStorage()
+	dispatcher, err := webhook.New(
+		webhook.WithStorage(webhookStorage),
+		webhook.WithEventBus(bus),
+	)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -145,23 +243,43 @@ func createServer() (backend.Server, func(), error) {
 		backend.WithVideoStorage(db.VideoStorage()),
 		backend.WithPublicStorage(localfs.NewPublic(publicDir(), publicUrlPrefix)),
 		backend.WithSampleStorage(localfs.NewSample(sampleDir())),
+		backend.WithEventBus(bus),
+		backend.WithWebhookStorage(webhookStorage),
+		backend.WithWebhookDispatcher(dispatcher),
+		backend.WithPrecomputeJobStorage(db.PrecomputeJobStorage()),
+		backend.WithPrecomputeConcurrency(StartOption.PrecomputeConcurrency),
 		backend.WithDataDir(StartOption.DataDir),
-		backend.WithOnlineSegmentationServerAddr(StartOption.OnlineSegmentationAddr),
+		backend.WithOnlineSegmentationServerAddrs(StartOption.OnlineSegmentationAddrs...),
 		backend.WithTrackServerAddr(StartOption.TrackAddr),
+		backend.WithGrpcClientOptions(
+			grpcclient.WithTimeout(StartOption.GrpcTimeout),
+			grpcclient.WithMaxAttempts(StartOption.GrpcMaxAttempts),
+			grpcclient.WithKeepalive(StartOption.GrpcKeepalive),
+			grpcclient.WithTls(StartOption.GrpcTlsCa, StartOption.GrpcTlsCert, StartOption.GrpcTlsKey, StartOption.GrpcTlsServerName),
+			grpcclient.WithToken(StartOption.GrpcToken),
+		),
+		backend.WithYjsSupervisor(yjs),
 		backend.WithConfig(&nutshapi.Config{
 			Readonly:                  StartOption.Readonly,
-			OnlineSegmentationEnabled: StartOption.OnlineSegmentationAddr != "",
+			OnlineSegmentationEnabled: len(StartOption.OnlineSegmentationAddrs) > 0,
 			TrackEnabled:              StartOption.TrackAddr != "",
 		}),
 	)
 
 	// backend
+	opts = append(opts, backend.WithHealthCheck("database", db.Ping))
 	s, err := backend.New(opts...)
 	if err != nil {
+		dispatcher.Close()
+		db.Close()
 		return nil, nil, err
 	}
 
-	return s, func() { db.Close() }, nil
+	return s, func() {
+		s.Close()
+		dispatcher.Close()
+		db.Close()
+	}, nil
 }
 
 // Enable using `SharedArrayBuffer` to speed up ONNX model inference.
@@ -199,6 +317,26 @@ func readonlyMiddleware() echo.MiddlewareFunc {
 	}
 }
 
+// registerInternalApi serves the API for the yjs-server.
+func registerInternalApi(e *echo.Echo, s backend.Server, internalToken string) {
+	internalRouter := e.Group("/internal", internalMiddleware(internalToken))
+	internalRouter.GET("/yjs/video/:videoId/annotation", s.GetYjsAnnotation)
+	internalRouter.PUT("/yjs/video/:videoId/annotation", s.PutYjsAnnotation)
+}
+
+// internalMiddleware only lets through requests carrying the token shared with child processes.
+func internalMiddleware(token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			auth := c.Request().Header.Get(echo.HeaderAuthorization)
+			if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+				return echo.NewHTTPError(http.StatusUnauthorized)
+			}
+			return next(c)
+		}
+	}
+}
+
 func publicDir() string {
 	return filepath.Join(StorageOption.Workspace, "public")
 }
@@ -221,45 +359,72 @@ func databasePath() string {
 	return filepath.Join(databaseDir(), "db.sqlite3")
 }
 
-func mustStartYJSServer() int {
+// startYJSServer runs the embedded yjs-server under supervision, returning a function to stop it and remove its binary.
+func startYJSServer(internalToken string) (supervisor.Supervisor, int, func(), error) {
 	// create a temporary file
 	bin, err := os.CreateTemp("", "nutsh-yjs-*")
-	mustOk(err)
+	if err != nil {
+		return nil, 0, nil, errors.WithStack(err)
+	}
+	remove := func() {
+		if err := os.Remove(bin.Name()); err != nil && !os.IsNotExist(err) {
+			zap.L().Warn("failed to remove the yjs-server binary", zap.String("path", bin.Name()), zap.Error(err))
+		}
+	}
 
-	// write the embedded binary to the temporary file
+	// write the embedded binary to the temporary file and make it executable
 	_, err = bin.Write(StartOption.YJSServer)
-	mustOk(err)
-	mustOk(bin.Close())
+	if err == nil {
+		err = bin.Close()
+	}
+	if err == nil {
+		err = os.Chmod(bin.Name(), 0755)
+	}
+	if err != nil {
+		remove()
+		return nil, 0, nil, errors.WithStack(err)
+	}
+
+	sv, internalPort, err := newYjsSupervisor(internalToken, bin.Name())
+	if err != nil {
+		remove()
+		return nil, 0, nil, err
+	}
+	zap.L().Info("start yjs-server server", zap.Int("port", internalPort))
+	sv.Start()
 
-	// make the file executable
-	mustOk(os.Chmod(bin.Name(), 0755))
+	// stopping is idempotent, since it is done both on shutdown and by the deferred teardown
+	return sv, internalPort, func() {
+		sv.Stop()
+		remove()
+	}, nil
+}
 
-	// prepare arguments
+// newYjsSupervisor supervises the yjs-server run by the command, which serves at the returned port.
+func newYjsSupervisor(internalToken string, command string, args ...string) (supervisor.Supervisor, int, error) {
 	internalPort := mustFindFreePort()
 
 	// set envs
 	envs := []string{
 		fmt.Sprintf("PORT=%d", internalPort),
-		fmt.Sprintf("DATABASE_PATH=%s", databasePath()),
+		fmt.Sprintf("NUTSH_API_URL=http://127.0.0.1:%d/internal/yjs", StartOption.Port),
+		fmt.Sprintf("NUTSH_INTERNAL_TOKEN=%s", internalToken),
 	}
 	if StartOption.Readonly {
 		envs = append(envs, "READ_ONLY=true")
 	}
 
-	// execute the binary in a new process
-	cmd := exec.Command(bin.Name())
-	cmd.Env = envs
-	zap.L().Info("start yjs-server server", zap.Int("port", internalPort))
-
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-
-	go func() {
-		defer os.Remove(bin.Name())
-		mustOk(cmd.Run())
-	}()
-
-	return internalPort
+	// the yjs-server responds `ok` to any plain HTTP request
+	sv, err := supervisor.New(
+		supervisor.WithName("yjs-server"),
+		supervisor.WithCommand(command, args...),
+		supervisor.WithEnv(envs),
+		supervisor.WithHealthUrl(fmt.Sprintf("http://127.0.0.1:%d", internalPort), 5*time.Second),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	return sv, internalPort, nil
 }
 
 func mustFindFreePort() int {
@@ -0,0 +1,98 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"nutsh/app/storage/sqlite3"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+// TestHelperYjsServer is run as the yjs-server child by other tests. Like the yjs-server, it holds an edit until it is
+// stopped, and then persists it through the internal API before exiting.
+func TestHelperYjsServer(t *testing.T) {
+	apiUrl := os.Getenv("NUTSH_API_URL")
+	if apiUrl == "" {
+		t.Skip("only run as a child")
+	}
+	videoId := os.Args[len(os.Args)-1]
+
+	stopped := make(chan os.Signal, 1)
+	signal.Notify(stopped, syscall.SIGTERM)
+	go http.ListenAndServe("127.0.0.1:"+os.Getenv("PORT"), nil)
+	<-stopped
+
+	req, err := http.NewRequest(http.MethodPut, apiUrl+"/video/"+videoId+"/annotation",
+		strings.NewReader(`{"annotation_json":"{\"entities\":{}}"}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("NUTSH_INTERNAL_TOKEN"))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	os.Exit(0)
+}
+
+func TestShutdownPersistsPendingEdits(t *testing.T) {
+	ctx := context.Background()
+	StorageOption.Workspace = t.TempDir()
+	StartOption.Port = mustFindFreePort()
+	StartOption.ShutdownTimeout = 5 * time.Second
+
+	s, teardown, err := createServer(nil)
+	require.NoError(t, err)
+	project, err := s.CreateProject(ctx, nutshapi.CreateProjectRequestObject{
+		Body: &nutshapi.CreateProjectReq{Name: "project", SpecJson: "{}"},
+	})
+	require.NoError(t, err)
+	pid := project.(*nutshapi.CreateProject200JSONResponse).Project.Id
+	video, err := s.CreateVideo(ctx, nutshapi.CreateVideoRequestObject{
+		Body: &nutshapi.CreateVideoReq{ProjectId: pid, Name: "video"},
+	})
+	require.NoError(t, err)
+	videoId := video.(*nutshapi.CreateVideo200JSONResponse).Video.Id
+
+	e := echo.New()
+	e.HideBanner = true
+	token := "token"
+	registerInternalApi(e, s, token)
+	go e.Start(fmt.Sprintf("127.0.0.1:%d", StartOption.Port))
+	requireListening(t, StartOption.Port)
+
+	yjs, yjsPort, err := newYjsSupervisor(token, os.Args[0], "-test.run=^TestHelperYjsServer$", videoId)
+	require.NoError(t, err)
+	yjs.Start()
+	requireListening(t, yjsPort)
+
+	shutdown(e, s, yjs.Stop)
+	teardown()
+
+	db, err := sqlite3.New(databasePath())
+	require.NoError(t, err)
+	defer db.Close()
+	anno, _, err := db.VideoStorage().GetAnnotation(ctx, videoId)
+	require.NoError(t, err)
+	require.NotNil(t, anno)
+	require.Equal(t, `{"entities":{}}`, *anno)
+}
+
+func requireListening(t *testing.T, port int) {
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/", port))
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
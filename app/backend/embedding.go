@@ -0,0 +1,168 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"nutsh/app/metrics"
+	"nutsh/module/common"
+	servicev1 "nutsh/proto/gen/go/service/v1"
+)
+
+// embedFrames embeds the images for the decoder into the public store, and reports the url of each embedding once it
+// is stored, reusing the ones already embedded. Images are streamed to a segmentation server, and those left when the
+// stream fails are embedded one by one instead. Requests are in the batch priority class, such that
+// servers serve interactive ones first.
+func (s *mServer) embedFrames(ctx context.Context, decoderUuid string, imageUrls []string, report func(i int, embeddingUrl string, err error)) {
+	ctx = common.WithPriority(ctx, common.PriorityBatch)
+
+	var mu sync.Mutex
+	done := make([]bool, len(imageUrls))
+	finish := func(i int, embeddingUrl string, err error) {
+		mu.Lock()
+		done[i] = true
+		mu.Unlock()
+		report(i, embeddingUrl, err)
+	}
+
+	if err := s.streamFrames(ctx, decoderUuid, imageUrls, finish); err != nil {
+		zap.L().Warn("failed to embed images through a stream, falling back to single requests", zap.String("decoder", decoderUuid), zap.Error(err))
+	}
+
+	for i, imageUrl := range imageUrls {
+		if done[i] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			finish(i, "", err)
+			continue
+		}
+		embeddingUrl, err := s.embedFrame(ctx, decoderUuid, imageUrl)
+		finish(i, embeddingUrl, err)
+	}
+}
+
+// streamFrames sends images not embedded yet to a stream while receiving their embeddings, and returns when the stream
+// ends, leaving images not reported if the stream fails.
+func (s *mServer) streamFrames(ctx context.Context, decoderUuid string, imageUrls []string, finish func(int, string, error)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := s.segmentation.EmbedImages(ctx, decoderUuid)
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	keys := make([]string, len(imageUrls))
+
+	sendDone := make(chan error, 1)
+	go func() {
+		sendDone <- s.sendFrames(ctx, stream, decoderUuid, imageUrls, func(i int, key string) {
+			mu.Lock()
+			defer mu.Unlock()
+			keys[i] = key
+		}, finish)
+	}()
+
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			cancel()
+			<-sendDone
+			return errors.WithStack(err)
+		}
+
+		i, err := strconv.Atoi(resp.GetId())
+		if err != nil || i < 0 || i >= len(imageUrls) {
+			zap.L().Warn("unexpected image id in the embedding stream", zap.String("id", resp.GetId()))
+			continue
+		}
+		if msg := resp.GetError(); msg != "" {
+			finish(i, "", errors.New(msg))
+			continue
+		}
+
+		mu.Lock()
+		key := keys[i]
+		mu.Unlock()
+		embeddingUrl, err := s.options.storagePublic.Put(ctx, key, resp.GetResult().GetEmbeddedImageNpy())
+		finish(i, embeddingUrl, err)
+	}
+	return <-sendDone
+}
+
+func (s *mServer) sendFrames(ctx context.Context, stream servicev1.OnlineSegmentationService_EmbedImagesClient, decoderUuid string, imageUrls []string, sent func(int, string), finish func(int, string, error)) error {
+	store := s.options.storagePublic
+	for i, imageUrl := range imageUrls {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		im, err := s.loadImageTraced(ctx, imageUrl)
+		if err != nil {
+			finish(i, "", err)
+			continue
+		}
+		key := embeddingKey(im, decoderUuid)
+		url, err := store.Check(ctx, key)
+		if err != nil {
+			finish(i, "", err)
+			continue
+		}
+		metrics.ObservePublicStoreLookup("embedding", url != "")
+		if url != "" {
+			finish(i, url, nil)
+			continue
+		}
+
+		sent(i, key)
+		if err := stream.Send(&servicev1.EmbedImagesRequest{
+			Id: strconv.Itoa(i),
+			Image: &servicev1.EmbedImageRequest{
+				OriginalImage: im,
+				DecoderUuid:   decoderUuid,
+			},
+		}); err != nil {
+			// the cause is returned by receiving from the stream
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(stream.CloseSend())
+}
+
+// embedFrame embeds a single image into the public store unless it has been embedded.
+func (s *mServer) embedFrame(ctx context.Context, decoderUuid string, imageUrl string) (string, error) {
+	store := s.options.storagePublic
+
+	im, err := s.loadImageTraced(ctx, imageUrl)
+	if err != nil {
+		return "", err
+	}
+	key := embeddingKey(im, decoderUuid)
+	url, err := store.Check(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	metrics.ObservePublicStoreLookup("embedding", url != "")
+	if url != "" {
+		return url, nil
+	}
+
+	resp, err := s.segmentation.EmbedImage(ctx, &servicev1.EmbedImageRequest{
+		OriginalImage: im,
+		DecoderUuid:   decoderUuid,
+	})
+	if err != nil {
+		return "", err
+	}
+	return store.Put(ctx, key, resp.GetEmbeddedImageNpy())
+}
@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"nutsh/app/grpcclient"
+	"nutsh/app/storage/localfs"
+)
+
+// requireEmbeddingServer saves the frames as `0.jpg`, `1.jpg`, ... in the data dir, and returns their urls.
+func requireEmbeddingServer(t *testing.T, segmentation *fakeSegmentationServer, frames []string) (*mServer, []string) {
+	dataDir := t.TempDir()
+	var urls []string
+	for i, frame := range frames {
+		name := fmt.Sprintf("%d.jpg", i)
+		require.NoError(t, os.WriteFile(filepath.Join(dataDir, name), []byte(frame), 0644))
+		urls = append(urls, dataProtocol+name)
+	}
+
+	s := &mServer{
+		options: &Options{
+			dataDir:       dataDir,
+			storagePublic: localfs.NewPublic(t.TempDir(), "/public/"),
+		},
+		segmentation: newSegmentationRouter([]*grpcclient.Client{requireSegmentationClient(t, segmentation)}),
+	}
+	return s, urls
+}
+
+type embedResult struct {
+	url string
+	err error
+}
+
+func embedAll(s *mServer, decoderUuid string, urls []string) []embedResult {
+	var mu sync.Mutex
+	results := make([]embedResult, len(urls))
+	s.embedFrames(context.Background(), decoderUuid, urls, func(i int, url string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[i] = embedResult{url, err}
+	})
+	return results
+}
+
+func TestEmbedFrames(t *testing.T) {
+	segmentation := &fakeSegmentationServer{decoderUuid: "vit_b"}
+	s, urls := requireEmbeddingServer(t, segmentation, []string{"frame0", "bad", "frame2"})
+
+	results := embedAll(s, "vit_b", urls)
+	require.Equal(t, "/public/"+embeddingKey([]byte("frame0"), "vit_b"), results[0].url)
+	require.EqualError(t, results[1].err, "bad image")
+	require.Equal(t, "/public/"+embeddingKey([]byte("frame2"), "vit_b"), results[2].url)
+	require.Equal(t, 2, segmentation.streamed)
+
+	// embedded frames are reused
+	results = embedAll(s, "vit_b", urls)
+	require.NoError(t, results[0].err)
+	require.NoError(t, results[2].err)
+	require.Equal(t, 2, segmentation.streamed)
+	require.Equal(t, 0, segmentation.embedded)
+}
+
+func TestEmbedFramesWithoutStream(t *testing.T) {
+	segmentation := &fakeSegmentationServer{decoderUuid: "vit_b", noStream: true}
+	s, urls := requireEmbeddingServer(t, segmentation, []string{"frame0", "frame1"})
+
+	results := embedAll(s, "vit_b", urls)
+	for _, r := range results {
+		require.NoError(t, r.err)
+	}
+	require.Equal(t, 2, segmentation.embedded)
+}
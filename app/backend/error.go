@@ -19,3 +19,57 @@ func ErrOnlineSegmentationDisabled() error {
 		Code: "ErrOnlineSegmentationDisabled",
 	}
 }
+
+func ErrUnknownDecoder() error {
+	return &Error{
+		Code: "ErrUnknownDecoder",
+	}
+}
+
+func ErrInvalidFrameRange() error {
+	return &Error{
+		Code: "ErrInvalidFrameRange",
+	}
+}
+
+func ErrMissingSegmentationPrompt() error {
+	return &Error{
+		Code: "ErrMissingSegmentationPrompt",
+	}
+}
+
+func ErrUnknownWebhookEvent() error {
+	return &Error{
+		Code: "ErrUnknownWebhookEvent",
+	}
+}
+
+func ErrInvalidWebhookUrl() error {
+	return &Error{
+		Code: "ErrInvalidWebhookUrl",
+	}
+}
+
+func ErrInvalidAnnotationJson() error {
+	return &Error{
+		Code: "ErrInvalidAnnotationJson",
+	}
+}
+
+func ErrPrecomputeJobRunning() error {
+	return &Error{
+		Code: "ErrPrecomputeJobRunning",
+	}
+}
+
+func ErrPrecomputeJobNotRunning() error {
+	return &Error{
+		Code: "ErrPrecomputeJobNotRunning",
+	}
+}
+
+func ErrInvalidTrackRequest() error {
+	return &Error{
+		Code: "ErrInvalidTrackRequest",
+	}
+}
@@ -0,0 +1,89 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"nutsh/app/event"
+	"nutsh/app/storage"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+// Interval to send comments through an idle stream to keep proxies from closing the connection.
+const projectStreamKeepAlive = 15 * time.Second
+
+// ProjectStream pushes the activity of a project as server-sent events.
+// https://html.spec.whatwg.org/multipage/server-sent-events.html
+func (s *mServer) ProjectStream(c echo.Context) error {
+	if err := s.projectStream(c); err != nil {
+		zap.L().Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+func (s *mServer) projectStream(c echo.Context) error {
+	pid := c.Param("projectId")
+	if _, err := s.options.storageProject.Get(c.Request().Context(), pid); err != nil {
+		if storage.IsErrNotFound(err) {
+			return echo.NewHTTPError(http.StatusNotFound)
+		}
+		return err
+	}
+
+	events, unsubscribe := s.options.bus.Subscribe(pid)
+	defer unsubscribe()
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set(echo.HeaderCacheControl, "no-cache")
+	resp.Header().Set(echo.HeaderConnection, "keep-alive")
+	resp.WriteHeader(http.StatusOK)
+	resp.Flush()
+
+	ticker := time.NewTicker(projectStreamKeepAlive)
+	defer ticker.Stop()
+
+	done := c.Request().Context().Done()
+	for {
+		select {
+		case e := <-events:
+			data, err := json.Marshal(e)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if _, err := fmt.Fprintf(resp, "id: %s\nevent: %s\ndata: %s\n\n", e.Id, e.Type, data); err != nil {
+				return errors.WithStack(err)
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(resp, ": keep-alive\n\n"); err != nil {
+				return errors.WithStack(err)
+			}
+		case <-done:
+			return nil
+		case <-s.drained:
+			// the client reconnects to another server
+			return nil
+		}
+		resp.Flush()
+	}
+}
+
+// publishVideoUpdated looks up the project of the video since the update response does not carry it.
+func (s *mServer) publishVideoUpdated(ctx context.Context, rec *nutshapi.Video) {
+	video, err := s.options.storageVideo.Get(ctx, rec.Id)
+	if err != nil {
+		zap.L().Error("failed to get video for the update event", zap.String("video", rec.Id), zap.Error(err))
+		return
+	}
+	s.options.bus.Publish(event.New(event.VideoUpdated, video.ProjectId, map[string]interface{}{
+		"video": rec,
+	}))
+}
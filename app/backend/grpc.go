@@ -0,0 +1,49 @@
+package backend
+
+import (
+	"google.golang.org/grpc"
+
+	"nutsh/app/grpcclient"
+	"nutsh/app/metrics"
+	"nutsh/module/common/tracing"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+// Embeddings and decoders are much larger than the default limit of 4M.
+const maxSegmentationMessageSize = 16 * 1024 * 1024
+
+// newGrpcClient creates a client to a model server, which is instrumented with metrics and tracing.
+func newGrpcClient(o *Options, name string, addr string, dialOpts ...grpc.DialOption) (*grpcclient.Client, error) {
+	dialOpts = append(dialOpts, metrics.GrpcDialOptions()...)
+	dialOpts = append(dialOpts, tracing.GrpcDialOptions()...)
+	opts := append([]grpcclient.Option{}, o.grpcClientOptions...)
+	opts = append(opts, grpcclient.WithDialOptions(dialOpts...))
+	return grpcclient.New(name, addr, opts...)
+}
+
+// grpcClients lists the clients to the configured model servers.
+func (s *mServer) grpcClients() []*grpcclient.Client {
+	var clients []*grpcclient.Client
+	if s.segmentation != nil {
+		clients = append(clients, s.segmentation.clients...)
+	}
+	if s.trackGrpc != nil {
+		clients = append(clients, s.trackGrpc)
+	}
+	return clients
+}
+
+func (s *mServer) grpcConnections() *[]nutshapi.GrpcConnection {
+	clients := s.grpcClients()
+	if len(clients) == 0 {
+		return nil
+	}
+	conns := make([]nutshapi.GrpcConnection, 0, len(clients))
+	for _, c := range clients {
+		conns = append(conns, nutshapi.GrpcConnection{
+			Name:  c.Name(),
+			State: c.State().String(),
+		})
+	}
+	return &conns
+}
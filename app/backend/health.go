@@ -0,0 +1,106 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// HealthCheck returns an error if a dependency of the server is not usable.
+type HealthCheck func(ctx context.Context) error
+
+type namedHealthCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// Time budget for all readiness checks, which are run concurrently.
+const readinessTimeout = 3 * time.Second
+
+type readinessResp struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Healthz reports the liveness, which only requires the server to respond.
+func (s *mServer) Healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz reports whether the server is able to serve requests, which fails once it starts draining.
+func (s *mServer) Readyz(c echo.Context) error {
+	if s.draining.Load() {
+		return c.JSON(http.StatusServiceUnavailable, readinessResp{
+			Status: "draining",
+			Checks: map[string]string{},
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), readinessTimeout)
+	defer cancel()
+
+	resp := readinessResp{
+		Status: "ok",
+		Checks: make(map[string]string),
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, hc := range s.healthChecks() {
+		wg.Add(1)
+		go func(hc namedHealthCheck) {
+			defer wg.Done()
+			result := "ok"
+			if err := hc.check(ctx); err != nil {
+				result = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Checks[hc.name] = result
+			if result != "ok" {
+				resp.Status = "unavailable"
+			}
+		}(hc)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if resp.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	return c.JSON(status, resp)
+}
+
+func (s *mServer) Drain() {
+	s.draining.Store(true)
+	s.drainOnce.Do(func() { close(s.drained) })
+}
+
+// healthChecks adds checks of dependencies known to the server to the ones provided by options.
+func (s *mServer) healthChecks() []namedHealthCheck {
+	opts := s.options
+	checks := append([]namedHealthCheck{}, opts.healthChecks...)
+	if yjs := opts.yjs; yjs != nil {
+		checks = append(checks, namedHealthCheck{"yjs", func(ctx context.Context) error {
+			status := yjs.Status()
+			if status.Healthy {
+				return nil
+			}
+			if status.LastError != nil {
+				return errors.Errorf("%s: %s", status.State, status.LastError)
+			}
+			return errors.New(string(status.State))
+		}})
+	}
+	// any online segmentation server is enough to serve since calls fail over
+	if s.segmentation != nil {
+		checks = append(checks, namedHealthCheck{"online_segmentation", s.segmentation.check})
+	}
+	if s.trackGrpc != nil {
+		checks = append(checks, namedHealthCheck{s.trackGrpc.Name(), s.trackGrpc.Check})
+	}
+	return checks
+}
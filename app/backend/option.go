@@ -3,7 +3,11 @@ package backend
 import (
 	"errors"
 
+	"nutsh/app/event"
+	"nutsh/app/grpcclient"
 	"nutsh/app/storage"
+	"nutsh/app/supervisor"
+	"nutsh/app/webhook"
 	"nutsh/openapi/gen/nutshapi"
 )
 
@@ -12,12 +16,23 @@ type Options struct {
 	storageVideo   storage.Video
 	storageSample  storage.Sample
 	storagePublic  storage.Public
+	storageWebhook storage.Webhook
+
+	storagePrecompute     storage.PrecomputeJob
+	precomputeConcurrency int
+
+	bus     event.Bus
+	webhook webhook.Dispatcher
 
 	config *nutshapi.Config
+	yjs    supervisor.Supervisor
+
+	healthChecks []namedHealthCheck
 
-	dataDir                      string
-	onlineSegmentationServerAddr string
-	trackServerAddr              string
+	dataDir                       string
+	onlineSegmentationServerAddrs []string
+	trackServerAddr               string
+	grpcClientOptions             []grpcclient.Option
 }
 
 func (o *Options) Validate() error {
@@ -27,13 +42,25 @@ func (o *Options) Validate() error {
 	if o.storageVideo == nil {
 		return errors.New("missing video storage")
 	}
-	if o.onlineSegmentationServerAddr != "" {
+	if o.bus == nil {
+		return errors.New("missing event bus")
+	}
+	if o.storageWebhook == nil {
+		return errors.New("missing webhook storage")
+	}
+	if o.webhook == nil {
+		return errors.New("missing webhook dispatcher")
+	}
+	if len(o.onlineSegmentationServerAddrs) > 0 {
 		if o.storagePublic == nil {
 			return errors.New("missing public storage")
 		}
 		if o.storageSample == nil {
 			return errors.New("missing sample storage")
 		}
+		if o.storagePrecompute == nil {
+			return errors.New("missing precompute job storage")
+		}
 	}
 	return nil
 }
@@ -64,15 +91,61 @@ func WithSampleStorage(s storage.Sample) Option {
 	}
 }
 
+func WithPrecomputeJobStorage(s storage.PrecomputeJob) Option {
+	return func(o *Options) {
+		o.storagePrecompute = s
+	}
+}
+
+// WithPrecomputeConcurrency sets the number of chunks of frames embedded concurrently by each precompute job.
+func WithPrecomputeConcurrency(n int) Option {
+	return func(o *Options) {
+		o.precomputeConcurrency = n
+	}
+}
+
+func WithEventBus(bus event.Bus) Option {
+	return func(o *Options) {
+		o.bus = bus
+	}
+}
+
+func WithWebhookStorage(s storage.Webhook) Option {
+	return func(o *Options) {
+		o.storageWebhook = s
+	}
+}
+
+func WithWebhookDispatcher(d webhook.Dispatcher) Option {
+	return func(o *Options) {
+		o.webhook = d
+	}
+}
+
 func WithConfig(config *nutshapi.Config) Option {
 	return func(o *Options) {
 		o.config = config
 	}
 }
 
-func WithOnlineSegmentationServerAddr(addr string) Option {
+// WithHealthCheck adds a check run when probing the readiness of the server.
+func WithHealthCheck(name string, check HealthCheck) Option {
 	return func(o *Options) {
-		o.onlineSegmentationServerAddr = addr
+		o.healthChecks = append(o.healthChecks, namedHealthCheck{name, check})
+	}
+}
+
+// WithYjsSupervisor reports the status of the yjs-server process in the config.
+func WithYjsSupervisor(yjs supervisor.Supervisor) Option {
+	return func(o *Options) {
+		o.yjs = yjs
+	}
+}
+
+// WithOnlineSegmentationServerAddrs adds online segmentation servers, among which calls are routed by the decoder.
+func WithOnlineSegmentationServerAddrs(addrs ...string) Option {
+	return func(o *Options) {
+		o.onlineSegmentationServerAddrs = append(o.onlineSegmentationServerAddrs, addrs...)
 	}
 }
 
@@ -87,3 +160,10 @@ func WithTrackServerAddr(addr string) Option {
 		o.trackServerAddr = addr
 	}
 }
+
+// WithGrpcClientOptions configures the clients to the online segmentation and track servers.
+func WithGrpcClientOptions(opts ...grpcclient.Option) Option {
+	return func(o *Options) {
+		o.grpcClientOptions = append(o.grpcClientOptions, opts...)
+	}
+}
@@ -0,0 +1,447 @@
+package backend
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"nutsh/app/storage"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+// Frames of a precompute job are embedded in chunks, each of which is streamed to a segmentation server.
+const precomputeChunkSize = 16
+
+// Progress of running precompute jobs is persisted periodically, which bounds the work to redo after a restart.
+const precomputeCheckpointInterval = 5 * time.Second
+
+// Closing the server waits this long for running precompute jobs to persist their progress.
+const precomputeCloseTimeout = 2 * precomputeCheckpointInterval
+
+// precomputeRun tracks a precompute job running in this process.
+type precomputeRun struct {
+	cancel    context.CancelFunc
+	cancelled atomic.Bool
+
+	mu sync.Mutex
+	// live counts include frames of chunks done beyond the cursor, which are not persisted since they are redone when
+	// resuming from the cursor
+	live      storage.PrecomputeProgress
+	persisted storage.PrecomputeProgress
+	// chunks done beyond the cursor by their index relative to the first chunk, with their counts
+	chunks    map[int]*storage.PrecomputeProgress
+	nextChunk int
+}
+
+func (r *precomputeRun) report(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.live.FailedFrames++
+		r.live.Error = err.Error()
+	} else {
+		r.live.ReadyFrames++
+	}
+}
+
+// completeChunk advances the cursor over the leading chunks which are all done.
+func (r *precomputeRun) completeChunk(c int, chunk *storage.PrecomputeProgress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.chunks[c] = chunk
+	for {
+		done, ok := r.chunks[r.nextChunk]
+		if !ok {
+			return
+		}
+		delete(r.chunks, r.nextChunk)
+		r.nextChunk++
+		r.persisted.Cursor = done.Cursor
+		r.persisted.ReadyFrames += done.ReadyFrames
+		r.persisted.FailedFrames += done.FailedFrames
+		if done.Error != "" {
+			r.persisted.Error = done.Error
+		}
+	}
+}
+
+func (r *precomputeRun) checkpoint() storage.PrecomputeProgress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.persisted
+}
+
+// overlay reports the live progress on the job.
+func (r *precomputeRun) overlay(job *nutshapi.PrecomputeJob) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job.TotalFrames = r.live.TotalFrames
+	job.ReadyFrames = r.live.ReadyFrames
+	job.FailedFrames = r.live.FailedFrames
+	if msg := r.live.Error; msg != "" {
+		job.Error = &msg
+	}
+}
+
+func (s *mServer) CreateProjectPrecomputeJob(ctx context.Context, request nutshapi.CreateProjectPrecomputeJobRequestObject) (nutshapi.CreateProjectPrecomputeJobResponseObject, error) {
+	if s.segmentation == nil {
+		return &nutshapi.CreateProjectPrecomputeJob400JSONResponse{
+			ErrorCode: ErrOnlineSegmentationDisabled().Error(),
+		}, nil
+	}
+
+	pid := request.ProjectId
+	decoderUuid := request.Body.DecoderUuid
+	if _, err := s.options.storageProject.Get(ctx, pid); err != nil {
+		if storage.IsErrNotFound(err) {
+			return &nutshapi.CreateProjectPrecomputeJob404Response{}, nil
+		}
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+	if _, err := s.segmentation.decoder(ctx, decoderUuid); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return &nutshapi.CreateProjectPrecomputeJob400JSONResponse{
+				ErrorCode: ErrUnknownDecoder().Error(),
+			}, nil
+		}
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+
+	// at most one job runs for the same project and decoder, which is enforced by the storage
+	store := s.options.storagePrecompute
+	job, err := store.Create(ctx, pid, decoderUuid)
+	if err != nil {
+		if storage.IsErrUniqueFieldConflict(err) {
+			return &nutshapi.CreateProjectPrecomputeJob400JSONResponse{
+				ErrorCode: ErrPrecomputeJobRunning().Error(),
+			}, nil
+		}
+		if bad, ok := err.(*storage.Error); ok {
+			return &nutshapi.CreateProjectPrecomputeJob400JSONResponse{
+				ErrorCode: bad.Error(),
+			}, nil
+		}
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+	s.startPrecomputeJob(trace.LinkFromContext(ctx), job, &storage.PrecomputeProgress{})
+
+	return &nutshapi.CreateProjectPrecomputeJob200JSONResponse{
+		Job: *job,
+	}, nil
+}
+
+func (s *mServer) ListProjectPrecomputeJobs(ctx context.Context, request nutshapi.ListProjectPrecomputeJobsRequestObject) (nutshapi.ListProjectPrecomputeJobsResponseObject, error) {
+	jobs, err := s.options.storagePrecompute.List(ctx, request.ProjectId)
+	if err != nil {
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+	resp := nutshapi.ListProjectPrecomputeJobs200JSONResponse{
+		Jobs: make([]nutshapi.PrecomputeJob, 0, len(jobs)),
+	}
+	for _, j := range jobs {
+		if run := s.precomputeRun(j.Id); run != nil {
+			run.overlay(j)
+		}
+		resp.Jobs = append(resp.Jobs, *j)
+	}
+	return resp, nil
+}
+
+func (s *mServer) GetPrecomputeJob(ctx context.Context, request nutshapi.GetPrecomputeJobRequestObject) (nutshapi.GetPrecomputeJobResponseObject, error) {
+	job, err := s.options.storagePrecompute.Get(ctx, request.PrecomputeJobId)
+	if err != nil {
+		if storage.IsErrNotFound(err) || storage.IsErrInvalidId(err) {
+			return &nutshapi.GetPrecomputeJob404Response{}, nil
+		}
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+	if run := s.precomputeRun(job.Id); run != nil {
+		run.overlay(job)
+	}
+	return &nutshapi.GetPrecomputeJob200JSONResponse{
+		Job: *job,
+	}, nil
+}
+
+func (s *mServer) CancelPrecomputeJob(ctx context.Context, request nutshapi.CancelPrecomputeJobRequestObject) (nutshapi.CancelPrecomputeJobResponseObject, error) {
+	id := request.PrecomputeJobId
+	store := s.options.storagePrecompute
+	job, err := store.Get(ctx, id)
+	if err != nil {
+		if storage.IsErrNotFound(err) || storage.IsErrInvalidId(err) {
+			return &nutshapi.CancelPrecomputeJob404Response{}, nil
+		}
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+	if job.State != storage.PrecomputeJobRunning {
+		return &nutshapi.CancelPrecomputeJob400JSONResponse{
+			ErrorCode: ErrPrecomputeJobNotRunning().Error(),
+		}, nil
+	}
+
+	run := s.precomputeRun(id)
+	if run != nil {
+		run.cancelled.Store(true)
+		run.cancel()
+	}
+	job, err = store.SetState(ctx, id, storage.PrecomputeJobCancelled)
+	if err != nil {
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+	if run != nil {
+		run.overlay(job)
+	}
+	return &nutshapi.CancelPrecomputeJob200JSONResponse{
+		Job: *job,
+	}, nil
+}
+
+// waitPrecomputeJobs waits for jobs running after the background context is cancelled to persist their progress,
+// until the timeout.
+func (s *mServer) waitPrecomputeJobs(timeout time.Duration) {
+	// no job starts after the lock is taken
+	s.precomputeMu.Lock()
+	s.precomputeMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.precomputeWg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		zap.L().Warn("precompute jobs are still running after closing", zap.Duration("timeout", timeout))
+	}
+}
+
+func (s *mServer) precomputeRun(id storage.PrecomputeJobId) *precomputeRun {
+	s.precomputeMu.Lock()
+	defer s.precomputeMu.Unlock()
+	return s.precomputeRuns[id]
+}
+
+// resumePrecomputeJobs resumes jobs interrupted by the last shutdown.
+func (s *mServer) resumePrecomputeJobs() {
+	ctx := s.background
+	store := s.options.storagePrecompute
+	jobs, err := store.ListRunning(ctx)
+	if err != nil {
+		zap.L().Error("failed to list running precompute jobs", zap.Error(err))
+		return
+	}
+	for _, job := range jobs {
+		progress, err := store.GetProgress(ctx, job.Id)
+		if err != nil {
+			zap.L().Error("failed to get the progress of precompute job", zap.String("job", job.Id), zap.Error(err))
+			continue
+		}
+		zap.L().Info("resuming precompute job", zap.String("job", job.Id), zap.Int("cursor", progress.Cursor))
+		s.startPrecomputeJob(trace.Link{}, job, progress)
+	}
+}
+
+// startPrecomputeJob runs the job in the background from the progress, until it finishes, is cancelled or the server is
+// closed.
+func (s *mServer) startPrecomputeJob(link trace.Link, job *nutshapi.PrecomputeJob, progress *storage.PrecomputeProgress) {
+	ctx, cancel := context.WithCancel(s.background)
+	run := &precomputeRun{
+		cancel:    cancel,
+		live:      *progress,
+		persisted: *progress,
+		chunks:    make(map[int]*storage.PrecomputeProgress),
+	}
+
+	s.precomputeMu.Lock()
+	if s.background.Err() != nil {
+		// the server is closing, and the job is resumed after restart
+		s.precomputeMu.Unlock()
+		cancel()
+		return
+	}
+	s.precomputeRuns[job.Id] = run
+	s.precomputeWg.Add(1)
+	s.precomputeMu.Unlock()
+
+	go func() {
+		defer s.precomputeWg.Done()
+		defer func() {
+			s.precomputeMu.Lock()
+			delete(s.precomputeRuns, job.Id)
+			s.precomputeMu.Unlock()
+		}()
+		defer cancel()
+		s.runPrecomputeJob(ctx, link, job, run)
+	}()
+}
+
+func (s *mServer) runPrecomputeJob(ctx context.Context, link trace.Link, job *nutshapi.PrecomputeJob, run *precomputeRun) {
+	ctx, span := tracer.Start(ctx, "precompute_embeddings",
+		trace.WithLinks(link),
+		trace.WithAttributes(
+			attribute.String("job", job.Id),
+			attribute.String("project", job.ProjectId),
+		),
+	)
+	defer span.End()
+	logger := zap.L().With(zap.String("job", job.Id))
+
+	frameUrls, err := s.projectFrameUrls(ctx, job.ProjectId)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Error("failed to list frames", zap.Error(err))
+			run.report(err)
+			s.finishPrecomputeJob(job, run, storage.PrecomputeJobFailed)
+		}
+		return
+	}
+
+	// videos may have changed since the job is interrupted
+	run.mu.Lock()
+	run.live.TotalFrames = len(frameUrls)
+	run.persisted.TotalFrames = len(frameUrls)
+	if run.persisted.Cursor > len(frameUrls) {
+		run.persisted.Cursor = len(frameUrls)
+	}
+	cursor := run.persisted.Cursor
+	run.mu.Unlock()
+	logger.Info("started precomputing embeddings", zap.Int("frames", len(frameUrls)), zap.Int("cursor", cursor))
+
+	// checkpoint periodically
+	stopCheckpoint := make(chan struct{})
+	checkpointDone := make(chan struct{})
+	go func() {
+		defer close(checkpointDone)
+		ticker := time.NewTicker(precomputeCheckpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.checkpointPrecomputeJob(job, run)
+			case <-stopCheckpoint:
+				return
+			}
+		}
+	}()
+
+	numChunk := (len(frameUrls) - cursor + precomputeChunkSize - 1) / precomputeChunkSize
+	var next int64 = -1
+	var wg sync.WaitGroup
+	for w := 0; w < s.options.precomputeConcurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				c := int(atomic.AddInt64(&next, 1))
+				if c >= numChunk || ctx.Err() != nil {
+					return
+				}
+				start := cursor + c*precomputeChunkSize
+				end := start + precomputeChunkSize
+				if end > len(frameUrls) {
+					end = len(frameUrls)
+				}
+
+				chunk := &storage.PrecomputeProgress{Cursor: end}
+				var mu sync.Mutex
+				s.embedFrames(ctx, job.DecoderUuid, frameUrls[start:end], func(i int, _ string, err error) {
+					if ctx.Err() != nil {
+						// frames left by cancellation are neither ready nor failed
+						return
+					}
+					mu.Lock()
+					defer mu.Unlock()
+					if err != nil {
+						chunk.FailedFrames++
+						chunk.Error = err.Error()
+					} else {
+						chunk.ReadyFrames++
+					}
+					run.report(err)
+				})
+				if ctx.Err() != nil {
+					return
+				}
+				run.completeChunk(c, chunk)
+			}
+		}()
+	}
+	wg.Wait()
+	close(stopCheckpoint)
+	<-checkpointDone
+
+	switch {
+	case run.cancelled.Load():
+		s.checkpointPrecomputeJob(job, run)
+		logger.Info("cancelled precomputing embeddings")
+	case ctx.Err() != nil:
+		// to be resumed after restart
+		s.checkpointPrecomputeJob(job, run)
+		logger.Info("interrupted precomputing embeddings")
+	default:
+		state := storage.PrecomputeJobSucceeded
+		if run.checkpoint().FailedFrames > 0 {
+			state = storage.PrecomputeJobFailed
+		}
+		s.finishPrecomputeJob(job, run, state)
+		logger.Info("finished precomputing embeddings", zap.String("state", state))
+	}
+}
+
+func (s *mServer) checkpointPrecomputeJob(job *nutshapi.PrecomputeJob, run *precomputeRun) {
+	// the job context may have been cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), precomputeCheckpointInterval)
+	defer cancel()
+	progress := run.checkpoint()
+	if err := s.options.storagePrecompute.UpdateProgress(ctx, job.Id, &progress); err != nil {
+		zap.L().Error("failed to persist the progress of precompute job", zap.String("job", job.Id), zap.Error(err))
+	}
+}
+
+func (s *mServer) finishPrecomputeJob(job *nutshapi.PrecomputeJob, run *precomputeRun, state string) {
+	run.mu.Lock()
+	run.persisted.Error = run.live.Error
+	run.mu.Unlock()
+	s.checkpointPrecomputeJob(job, run)
+
+	ctx, cancel := context.WithTimeout(context.Background(), precomputeCheckpointInterval)
+	defer cancel()
+	if _, err := s.options.storagePrecompute.SetState(ctx, job.Id, state); err != nil {
+		zap.L().Error("failed to finish precompute job", zap.String("job", job.Id), zap.Error(err))
+	}
+}
+
+// projectFrameUrls lists frames of all videos in the project, in an order which is stable across restarts.
+func (s *mServer) projectFrameUrls(ctx context.Context, pid storage.ProjectId) ([]string, error) {
+	store := s.options.storageVideo
+	videos, err := store.List(ctx, pid)
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, v := range videos {
+		// frames are not included in the list
+		video, err := store.Get(ctx, v.Id)
+		if err != nil {
+			return nil, err
+		}
+		if video.FrameUrls != nil {
+			urls = append(urls, *video.FrameUrls...)
+		}
+	}
+	return urls, nil
+}
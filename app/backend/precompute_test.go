@@ -0,0 +1,96 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"nutsh/app/grpcclient"
+	"nutsh/app/storage"
+	"nutsh/app/storage/localfs"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+func TestPrecomputeRunCheckpoint(t *testing.T) {
+	resumed := storage.PrecomputeProgress{TotalFrames: 40, ReadyFrames: 8, Cursor: 8}
+	run := &precomputeRun{
+		live:      resumed,
+		persisted: resumed,
+		chunks:    make(map[int]*storage.PrecomputeProgress),
+	}
+
+	// the second chunk finishes first, which is not persisted until the first one finishes
+	for i := 0; i < 16; i++ {
+		run.report(nil)
+	}
+	run.completeChunk(1, &storage.PrecomputeProgress{Cursor: 32, ReadyFrames: 16})
+	require.Equal(t, resumed, run.checkpoint())
+
+	for i := 0; i < 15; i++ {
+		run.report(nil)
+	}
+	run.report(errors.New("bad image"))
+	run.completeChunk(0, &storage.PrecomputeProgress{Cursor: 24, ReadyFrames: 15, FailedFrames: 1, Error: "bad image"})
+	require.Equal(t, storage.PrecomputeProgress{
+		TotalFrames:  40,
+		ReadyFrames:  39,
+		FailedFrames: 1,
+		Cursor:       32,
+		Error:        "bad image",
+	}, run.checkpoint())
+	require.Equal(t, 39, run.live.ReadyFrames)
+	require.Equal(t, 1, run.live.FailedFrames)
+}
+
+func TestClosePersistsInterruptedPrecomputeJob(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "0.jpg"), []byte("frame0"), 0644))
+	s, project := requireTestServer(t, WithDataDir(dataDir), WithPublicStorage(localfs.NewPublic(t.TempDir(), "/public/")))
+	segmentation := &fakeSegmentationServer{decoderUuid: "vit_b", blocking: true}
+	s.segmentation = newSegmentationRouter([]*grpcclient.Client{requireSegmentationClient(t, segmentation)})
+
+	_, err := s.options.storageVideo.Create(ctx, &nutshapi.CreateVideoReq{
+		ProjectId: project.Id,
+		Name:      "video",
+		FrameUrls: []string{dataProtocol + "0.jpg"},
+	})
+	require.NoError(t, err)
+	resp, err := s.CreateProjectPrecomputeJob(ctx, nutshapi.CreateProjectPrecomputeJobRequestObject{
+		ProjectId: project.Id,
+		Body:      &nutshapi.CreateProjectPrecomputeJobJSONRequestBody{DecoderUuid: "vit_b"},
+	})
+	require.NoError(t, err)
+	job := resp.(*nutshapi.CreateProjectPrecomputeJob200JSONResponse).Job
+	require.Eventually(t, func() bool {
+		segmentation.mu.Lock()
+		defer segmentation.mu.Unlock()
+		return segmentation.blocked > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the interrupted job has checkpointed its progress once closed
+	require.NoError(t, s.Close())
+	require.Nil(t, s.precomputeRun(job.Id))
+	progress, err := s.options.storagePrecompute.GetProgress(ctx, job.Id)
+	require.NoError(t, err)
+	require.Equal(t, 1, progress.TotalFrames)
+	require.Zero(t, progress.Cursor)
+}
+
+func TestPrecomputeJobInvalidId(t *testing.T) {
+	ctx := context.Background()
+	s, _ := requireTestServer(t)
+
+	resp, err := s.GetPrecomputeJob(ctx, nutshapi.GetPrecomputeJobRequestObject{PrecomputeJobId: "invalid"})
+	require.NoError(t, err)
+	require.IsType(t, &nutshapi.GetPrecomputeJob404Response{}, resp)
+
+	cancelResp, err := s.CancelPrecomputeJob(ctx, nutshapi.CancelPrecomputeJobRequestObject{PrecomputeJobId: "invalid"})
+	require.NoError(t, err)
+	require.IsType(t, &nutshapi.CancelPrecomputeJob404Response{}, cancelResp)
+}
@@ -0,0 +1,205 @@
+package backend
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"nutsh/app/storage"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+
+	frameReady   = "ready"
+	framePending = "pending"
+	frameFailed  = "failed"
+)
+
+// Finished prefetching jobs are kept for a while for the status to be queried.
+const prefetchJobRetention = time.Hour
+
+type prefetchJob struct {
+	mu         sync.Mutex
+	job        nutshapi.OnlineSegmentationJob
+	finishTime time.Time
+}
+
+// prefetchJobs keeps prefetching jobs in memory, since they are cheap to restart.
+type prefetchJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*prefetchJob
+}
+
+func newPrefetchJobs() *prefetchJobs {
+	return &prefetchJobs{
+		jobs: make(map[string]*prefetchJob),
+	}
+}
+
+func (js *prefetchJobs) create(videoId string, decoderUuid string, start, end int) *prefetchJob {
+	frames := make([]nutshapi.OnlineSegmentationJobFrame, 0, end-start)
+	for i := start; i < end; i++ {
+		frames = append(frames, nutshapi.OnlineSegmentationJobFrame{
+			Index: i,
+			State: framePending,
+		})
+	}
+	j := &prefetchJob{
+		job: nutshapi.OnlineSegmentationJob{
+			Id:          uuid.NewString(),
+			VideoId:     videoId,
+			DecoderUuid: decoderUuid,
+			State:       jobRunning,
+			Frames:      frames,
+		},
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	for id, old := range js.jobs {
+		if t := old.finished(); !t.IsZero() && time.Since(t) > prefetchJobRetention {
+			delete(js.jobs, id)
+		}
+	}
+	js.jobs[j.job.Id] = j
+	return j
+}
+
+func (js *prefetchJobs) get(id string) (*prefetchJob, bool) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j, ok := js.jobs[id]
+	return j, ok
+}
+
+func (j *prefetchJob) snapshot() nutshapi.OnlineSegmentationJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job := j.job
+	job.Frames = append([]nutshapi.OnlineSegmentationJobFrame{}, j.job.Frames...)
+	return job
+}
+
+func (j *prefetchJob) finished() time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.finishTime
+}
+
+// report updates the frame at the position i of the job.
+func (j *prefetchJob) report(i int, embeddingUrl string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	f := &j.job.Frames[i]
+	if err != nil {
+		msg := err.Error()
+		f.State = frameFailed
+		f.Error = &msg
+		return
+	}
+	f.State = frameReady
+	f.EmbeddingUrl = &embeddingUrl
+}
+
+func (j *prefetchJob) finish() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.finishTime = time.Now()
+	j.job.State = jobSucceeded
+	for _, f := range j.job.Frames {
+		if f.State != frameReady {
+			j.job.State = jobFailed
+			break
+		}
+	}
+}
+
+func (s *mServer) PrefetchOnlineSegmentationEmbeddings(ctx context.Context, request nutshapi.PrefetchOnlineSegmentationEmbeddingsRequestObject) (nutshapi.PrefetchOnlineSegmentationEmbeddingsResponseObject, error) {
+	if s.segmentation == nil {
+		return &nutshapi.PrefetchOnlineSegmentationEmbeddings400JSONResponse{
+			ErrorCode: ErrOnlineSegmentationDisabled().Error(),
+		}, nil
+	}
+
+	body := request.Body
+	video, err := s.options.storageVideo.Get(ctx, body.VideoId)
+	if err != nil {
+		if storage.IsErrNotFound(err) {
+			return &nutshapi.PrefetchOnlineSegmentationEmbeddings404Response{}, nil
+		}
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+	if _, err := s.segmentation.decoder(ctx, body.DecoderUuid); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return &nutshapi.PrefetchOnlineSegmentationEmbeddings400JSONResponse{
+				ErrorCode: ErrUnknownDecoder().Error(),
+			}, nil
+		}
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+
+	var frameUrls []string
+	if video.FrameUrls != nil {
+		frameUrls = *video.FrameUrls
+	}
+	start, end := 0, len(frameUrls)
+	if body.StartFrame != nil {
+		start = *body.StartFrame
+	}
+	if body.EndFrame != nil {
+		end = *body.EndFrame
+	}
+	if start < 0 || start > end || end > len(frameUrls) {
+		return &nutshapi.PrefetchOnlineSegmentationEmbeddings400JSONResponse{
+			ErrorCode: ErrInvalidFrameRange().Error(),
+		}, nil
+	}
+
+	job := s.prefetchJobs.create(video.Id, body.DecoderUuid, start, end)
+	go s.runPrefetchJob(trace.LinkFromContext(ctx), job, frameUrls[start:end])
+
+	return &nutshapi.PrefetchOnlineSegmentationEmbeddings200JSONResponse{
+		Job: job.snapshot(),
+	}, nil
+}
+
+// runPrefetchJob runs the job in the background beyond the request which creates it, until the server is closed.
+func (s *mServer) runPrefetchJob(link trace.Link, job *prefetchJob, frameUrls []string) {
+	ctx, span := tracer.Start(s.background, "prefetch_embeddings",
+		trace.WithLinks(link),
+		trace.WithAttributes(
+			attribute.String("job", job.job.Id),
+			attribute.Int("frames", len(frameUrls)),
+		),
+	)
+	defer span.End()
+
+	logger := zap.L().With(zap.String("job", job.job.Id))
+	logger.Info("started prefetching embeddings", zap.String("video", job.job.VideoId), zap.Int("frames", len(frameUrls)))
+	s.embedFrames(ctx, job.job.DecoderUuid, frameUrls, job.report)
+	job.finish()
+	logger.Info("finished prefetching embeddings", zap.String("state", job.snapshot().State))
+}
+
+func (s *mServer) GetOnlineSegmentationJob(ctx context.Context, request nutshapi.GetOnlineSegmentationJobRequestObject) (nutshapi.GetOnlineSegmentationJobResponseObject, error) {
+	job, ok := s.prefetchJobs.get(request.JobId)
+	if !ok {
+		return &nutshapi.GetOnlineSegmentationJob404Response{}, nil
+	}
+	return &nutshapi.GetOnlineSegmentationJob200JSONResponse{
+		Job: job.snapshot(),
+	}, nil
+}
@@ -5,6 +5,7 @@ import (
 
 	"go.uber.org/zap"
 
+	"nutsh/app/event"
 	"nutsh/app/storage"
 	"nutsh/openapi/gen/nutshapi"
 )
@@ -104,6 +105,9 @@ func (s *mServer) ExportProject(ctx context.Context, request nutshapi.ExportProj
 		zap.L().Error(err.Error())
 		return nil, err
 	}
+	s.options.bus.Publish(event.New(event.ProjectExported, request.ProjectId, map[string]interface{}{
+		"project": out.Project,
+	}))
 
 	return nutshapi.ExportProject200JSONResponse(*out), nil
 }
@@ -3,14 +3,18 @@ package backend
 import (
 	"context"
 	"fmt"
+	"time"
+
+	"nutsh/app/storage"
 	"nutsh/openapi/gen/nutshapi"
+	schemav1 "nutsh/proto/gen/go/schema/v1"
 
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
 func (s *mServer) CreateProjectSample(ctx context.Context, request nutshapi.CreateProjectSampleRequestObject) (nutshapi.CreateProjectSampleResponseObject, error) {
-	pid := request.ProjectId
-	key := fmt.Sprintf("project_%s", pid)
+	key := sampleKey(request.ProjectId)
 	err := s.options.storageSample.Create(ctx, key, request.Body)
 	if err != nil {
 		zap.L().Error(err.Error())
@@ -18,3 +22,100 @@ func (s *mServer) CreateProjectSample(ctx context.Context, request nutshapi.Crea
 	}
 	return &nutshapi.CreateProjectSample200JSONResponse{}, nil
 }
+
+func (s *mServer) ListProjectSamples(ctx context.Context, request nutshapi.ListProjectSamplesRequestObject) (nutshapi.ListProjectSamplesResponseObject, error) {
+	recs, err := s.options.storageSample.List(ctx, sampleKey(request.ProjectId))
+	if err != nil {
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+
+	samples := make([]nutshapi.Sample, 0)
+	for _, r := range recs {
+		samples = append(samples, sampleToOpenApi(r))
+	}
+	return &nutshapi.ListProjectSamples200JSONResponse{
+		Samples: samples,
+	}, nil
+}
+
+func (s *mServer) GetProjectSample(ctx context.Context, request nutshapi.GetProjectSampleRequestObject) (nutshapi.GetProjectSampleResponseObject, error) {
+	rec, err := s.options.storageSample.Get(ctx, sampleKey(request.ProjectId), request.SampleId)
+	if err != nil {
+		if storage.IsErrNotFound(err) {
+			return &nutshapi.GetProjectSample404Response{}, nil
+		}
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+	return &nutshapi.GetProjectSample200JSONResponse{
+		Sample: sampleToOpenApi(rec),
+	}, nil
+}
+
+func (s *mServer) DeleteProjectSample(ctx context.Context, request nutshapi.DeleteProjectSampleRequestObject) (nutshapi.DeleteProjectSampleResponseObject, error) {
+	rec, err := s.options.storageSample.Delete(ctx, sampleKey(request.ProjectId), request.SampleId)
+	if err != nil {
+		if storage.IsErrNotFound(err) {
+			return &nutshapi.DeleteProjectSample404Response{}, nil
+		}
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+	return &nutshapi.DeleteProjectSample200JSONResponse{
+		Sample: sampleToOpenApi(rec),
+	}, nil
+}
+
+// sampleKey is the key under which the samples of a project are stored.
+func sampleKey(pid string) string {
+	return fmt.Sprintf("project_%s", pid)
+}
+
+// sampleToOpenApi decodes a stored sample, leaving the decoded fields empty if it is not a valid `schema.v1.Sample`
+// since samples are stored as sent by the frontend.
+func sampleToOpenApi(rec *storage.StoredSample) nutshapi.Sample {
+	sample := nutshapi.Sample{
+		Id:         rec.Id,
+		SampleJson: rec.Json,
+		CreateTime: rec.CreateTime.UTC().Format(time.RFC3339),
+	}
+
+	var pb schemav1.Sample
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal([]byte(rec.Json), &pb); err != nil {
+		zap.L().Warn("failed to decode sample", zap.String("id", rec.Id), zap.Error(err))
+		return sample
+	}
+	sample.ImageUrl = pb.GetImageUrl()
+
+	if seg := pb.GetSegmentation(); seg != nil {
+		var crop *nutshapi.GridRect
+		if c := seg.GetInput().GetCrop(); c != nil {
+			crop = &nutshapi.GridRect{
+				X:      int(c.GetX()),
+				Y:      int(c.GetY()),
+				Width:  int(c.GetWidth()),
+				Height: int(c.GetHeight()),
+			}
+		}
+		prompts := make([]nutshapi.PointPrompt, 0)
+		for _, p := range seg.GetPrompt().GetPointPrompts() {
+			prompts = append(prompts, nutshapi.PointPrompt{
+				X:          p.GetX(),
+				Y:          p.GetY(),
+				IsPositive: p.GetIsPositive(),
+			})
+		}
+		mask := seg.GetOutput().GetMask()
+		sample.Segmentation = &nutshapi.SegmentationSample{
+			Crop:         crop,
+			PointPrompts: prompts,
+			Mask: nutshapi.Mask{
+				CocoEncodedRle: mask.GetCocoEncodedRle(),
+				Width:          int(mask.GetSize().GetWidth()),
+				Height:         int(mask.GetSize().GetHeight()),
+			},
+		}
+	}
+	return sample
+}
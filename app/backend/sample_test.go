@@ -0,0 +1,77 @@
+package backend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"nutsh/app/storage"
+	"nutsh/app/storage/localfs"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+func TestProjectSamples(t *testing.T) {
+	ctx := context.Background()
+	s := &mServer{
+		options: &Options{storageSample: localfs.NewSample(t.TempDir())},
+	}
+
+	sampleJson := `{
+		"imageUrl": "data://image.jpg",
+		"segmentation": {
+			"input": {"crop": {"x": 1, "y": 2, "width": 3, "height": 4}},
+			"prompt": {"pointPrompts": [{"x": 1.5, "y": 2.5, "isPositive": true}]},
+			"output": {"mask": {"cocoEncodedRle": "12", "size": {"width": 3, "height": 4}}}
+		}
+	}`
+	_, err := s.CreateProjectSample(ctx, nutshapi.CreateProjectSampleRequestObject{
+		ProjectId: "1",
+		Body:      &nutshapi.CreateProjectSampleReq{SampleJson: sampleJson},
+	})
+	require.NoError(t, err)
+
+	listed, err := s.ListProjectSamples(ctx, nutshapi.ListProjectSamplesRequestObject{ProjectId: "1"})
+	require.NoError(t, err)
+	samples := listed.(*nutshapi.ListProjectSamples200JSONResponse).Samples
+	require.Len(t, samples, 1)
+	sample := samples[0]
+	require.Equal(t, sampleJson, sample.SampleJson)
+	require.Equal(t, "data://image.jpg", sample.ImageUrl)
+	require.Equal(t, &nutshapi.SegmentationSample{
+		Crop:         &nutshapi.GridRect{X: 1, Y: 2, Width: 3, Height: 4},
+		PointPrompts: []nutshapi.PointPrompt{{X: 1.5, Y: 2.5, IsPositive: true}},
+		Mask:         nutshapi.Mask{CocoEncodedRle: "12", Width: 3, Height: 4},
+	}, sample.Segmentation)
+
+	// samples are per project
+	other, err := s.ListProjectSamples(ctx, nutshapi.ListProjectSamplesRequestObject{ProjectId: "2"})
+	require.NoError(t, err)
+	require.Empty(t, other.(*nutshapi.ListProjectSamples200JSONResponse).Samples)
+	got, err := s.GetProjectSample(ctx, nutshapi.GetProjectSampleRequestObject{ProjectId: "2", SampleId: sample.Id})
+	require.NoError(t, err)
+	require.IsType(t, &nutshapi.GetProjectSample404Response{}, got)
+
+	got, err = s.GetProjectSample(ctx, nutshapi.GetProjectSampleRequestObject{ProjectId: "1", SampleId: sample.Id})
+	require.NoError(t, err)
+	require.Equal(t, sample, got.(*nutshapi.GetProjectSample200JSONResponse).Sample)
+
+	deleted, err := s.DeleteProjectSample(ctx, nutshapi.DeleteProjectSampleRequestObject{ProjectId: "1", SampleId: sample.Id})
+	require.NoError(t, err)
+	require.Equal(t, sample.Id, deleted.(*nutshapi.DeleteProjectSample200JSONResponse).Sample.Id)
+	deleted, err = s.DeleteProjectSample(ctx, nutshapi.DeleteProjectSampleRequestObject{ProjectId: "1", SampleId: sample.Id})
+	require.NoError(t, err)
+	require.IsType(t, &nutshapi.DeleteProjectSample404Response{}, deleted)
+
+	// ids not generated by the storage are never found
+	got, err = s.GetProjectSample(ctx, nutshapi.GetProjectSampleRequestObject{ProjectId: "1", SampleId: "../../secret"})
+	require.NoError(t, err)
+	require.IsType(t, &nutshapi.GetProjectSample404Response{}, got)
+}
+
+func TestSampleToOpenApiInvalid(t *testing.T) {
+	sample := sampleToOpenApi(&storage.StoredSample{Id: "id", Json: "not json"})
+	require.Equal(t, "not json", sample.SampleJson)
+	require.Empty(t, sample.ImageUrl)
+	require.Nil(t, sample.Segmentation)
+}
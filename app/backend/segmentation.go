@@ -12,15 +12,19 @@ import (
 	"path/filepath"
 	"strings"
 
+	"nutsh/app/metrics"
 	"nutsh/openapi/gen/nutshapi"
 	schemav1 "nutsh/proto/gen/go/schema/v1"
 	servicev1 "nutsh/proto/gen/go/service/v1"
 
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func (s *mServer) GetOnlineSegmentation(ctx context.Context, request nutshapi.GetOnlineSegmentationRequestObject) (nutshapi.GetOnlineSegmentationResponseObject, error) {
@@ -33,73 +37,71 @@ func (s *mServer) GetOnlineSegmentation(ctx context.Context, request nutshapi.Ge
 }
 
 func (s *mServer) getOnlineSegmentation(ctx context.Context, _ nutshapi.GetOnlineSegmentationRequestObject) (nutshapi.GetOnlineSegmentationResponseObject, error) {
-	opts := s.options
-	store := opts.storagePublic
-
-	addr := opts.onlineSegmentationServerAddr
-	if addr == "" {
+	if s.segmentation == nil {
 		return &nutshapi.GetOnlineSegmentation200JSONResponse{}, nil
 	}
 
 	// introspect
-	conn, err := grpc.Dial(addr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(16*1024*1024 /* 16M */)),
-	)
+	decoders, err := s.segmentation.refresh(ctx)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
-	defer conn.Close()
 
-	client := servicev1.NewOnlineSegmentationServiceClient(conn)
-	introspectResp, err := client.Introspect(ctx, &servicev1.IntrospectRequest{})
-	if err != nil {
-		return nil, err
+	var published []nutshapi.OnlineSegmentationDecoder
+	for _, d := range decoders {
+		url, err := s.publishDecoder(ctx, d)
+		if err != nil {
+			return nil, err
+		}
+		decoder := nutshapi.OnlineSegmentationDecoder{
+			Url:    url,
+			Uuid:   d.uuid,
+			FeedJs: d.feedJs,
+		}
+		if d.name != "" {
+			name := d.name
+			decoder.Name = &name
+		}
+		published = append(published, decoder)
+	}
+
+	resp := &nutshapi.GetOnlineSegmentation200JSONResponse{}
+	if len(published) > 0 {
+		resp.Decoder = &published[0]
+		resp.Decoders = &published
 	}
+	return resp, nil
+}
 
-	decoderUuid := introspectResp.GetDecoderUuid()
-	decoderFeedJs := introspectResp.GetDecoderFeedJs()
+// publishDecoder saves the decoder to the public store if not yet, and returns its url.
+func (s *mServer) publishDecoder(ctx context.Context, d *segmentationDecoder) (string, error) {
+	store := s.options.storagePublic
 
 	// check if the modal has already been saved
-	relPath := path.Join("model", "online_segmentation", fmt.Sprintf("%s.onnx", decoderUuid))
-	if url, err := store.Check(ctx, relPath); err != nil {
-		return nil, err
-	} else if url != "" {
+	relPath := path.Join("model", "online_segmentation", fmt.Sprintf("%s.onnx", d.uuid))
+	url, err := store.Check(ctx, relPath)
+	if err != nil {
+		return "", err
+	}
+	metrics.ObservePublicStoreLookup("decoder", url != "")
+	if url != "" {
 		zap.L().Info("object already exists in the public store", zap.String("key", relPath))
-		return &nutshapi.GetOnlineSegmentation200JSONResponse{
-			Decoder: &nutshapi.OnlineSegmentationDecoder{
-				Url:    url,
-				Uuid:   decoderUuid,
-				FeedJs: decoderFeedJs,
-			},
-		}, nil
+		return url, nil
 	}
 
 	// retrieve and save the model for the first time
-	resp, err := client.GetDecoder(ctx, &servicev1.GetDecoderRequest{})
+	resp, err := s.segmentation.GetDecoder(ctx, d.uuid)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return "", errors.WithStack(err)
 	}
 
-	if resp.GetUuid() != decoderUuid {
+	if resp.GetUuid() != d.uuid {
 		// unexpected inconsistency
-		return nil, errors.Errorf("expect to get decoder with UUID %s, but got %s", decoderUuid, resp.GetUuid())
+		return "", errors.Errorf("expect to get decoder with UUID %s, but got %s", d.uuid, resp.GetUuid())
 	}
 
 	// save the decoder to the public folder
-	decoder := resp.GetDecoderOnnx()
-	url, err := store.Put(ctx, relPath, decoder)
-	if err != nil {
-		return nil, err
-	}
-
-	return &nutshapi.GetOnlineSegmentation200JSONResponse{
-		Decoder: &nutshapi.OnlineSegmentationDecoder{
-			Url:    url,
-			Uuid:   decoderUuid,
-			FeedJs: decoderFeedJs,
-		},
-	}, nil
+	return store.Put(ctx, relPath, resp.GetDecoderOnnx())
 }
 
 func (s *mServer) GetOnlineSegmentationEmbedding(ctx context.Context, request nutshapi.GetOnlineSegmentationEmbeddingRequestObject) (nutshapi.GetOnlineSegmentationEmbeddingResponseObject, error) {
@@ -115,8 +117,7 @@ func (s *mServer) onlineSegmentationEmbed(ctx context.Context, request nutshapi.
 	opts := s.options
 	store := opts.storagePublic
 
-	addr := opts.onlineSegmentationServerAddr
-	if addr == "" {
+	if s.segmentation == nil {
 		return &nutshapi.GetOnlineSegmentationEmbedding400JSONResponse{
 			ErrorCode: ErrOnlineSegmentationDisabled().Error(),
 		}, nil
@@ -140,7 +141,7 @@ func (s *mServer) onlineSegmentationEmbed(ctx context.Context, request nutshapi.
 	hasCrop := (w > 0 && h > 0)
 
 	imUrl := request.Params.ImageUrl
-	im, err := s.loadImage(ctx, imUrl)
+	im, err := s.loadImageTraced(ctx, imUrl)
 	if err != nil {
 		return nil, err
 	}
@@ -149,11 +150,13 @@ func (s *mServer) onlineSegmentationEmbed(ctx context.Context, request nutshapi.
 	// check cache if no cropping is presented
 	var key string
 	if !hasCrop {
-		imd5 := md5String(im)
-		key = fmt.Sprintf("embed/%s.%s.npy", imd5, decoderUuid)
-		if url, err := store.Check(ctx, key); err != nil {
+		key = embeddingKey(im, decoderUuid)
+		url, err := store.Check(ctx, key)
+		if err != nil {
 			return nil, err
-		} else if url != "" {
+		}
+		metrics.ObservePublicStoreLookup("embedding", url != "")
+		if url != "" {
 			zap.L().Info("object already exists in the public store", zap.String("key", key))
 			return &nutshapi.GetOnlineSegmentationEmbedding200JSONResponse{
 				EmbeddingUrl: url,
@@ -162,18 +165,8 @@ func (s *mServer) onlineSegmentationEmbed(ctx context.Context, request nutshapi.
 	}
 
 	// call the grpc server
-	conn, err := grpc.Dial(addr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(16*1024*1024 /* 16M */)),
-	)
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-	defer conn.Close()
-
 	zap.L().Info("sending embed image request")
-	client := servicev1.NewOnlineSegmentationServiceClient(conn)
-	resp, err := client.EmbedImage(ctx, &servicev1.EmbedImageRequest{
+	resp, err := s.segmentation.EmbedImage(ctx, &servicev1.EmbedImageRequest{
 		OriginalImage: im,
 		DecoderUuid:   decoderUuid,
 		Crop: &schemav1.GridRect{
@@ -183,11 +176,22 @@ func (s *mServer) onlineSegmentationEmbed(ctx context.Context, request nutshapi.
 			Height: h,
 		},
 	})
-	if err != nil {
+	switch status.Code(err) {
+	case grpccodes.OK:
+	case grpccodes.NotFound:
+		return &nutshapi.GetOnlineSegmentationEmbedding400JSONResponse{
+			ErrorCode: ErrUnknownDecoder().Error(),
+		}, nil
+	case grpccodes.ResourceExhausted:
+		// all servers are too busy to queue more requests
+		return &nutshapi.GetOnlineSegmentationEmbedding429Response{}, nil
+	default:
 		return nil, err
 	}
 	embedded := resp.GetEmbeddedImageNpy()
 
+	ctx, span := tracer.Start(ctx, "public_store.put", trace.WithAttributes(attribute.Int("size", len(embedded))))
+	defer span.End()
 	var url string
 	if key != "" {
 		url, err = store.Put(ctx, key, embedded)
@@ -206,6 +210,109 @@ func (s *mServer) onlineSegmentationEmbed(ctx context.Context, request nutshapi.
 	}, nil
 }
 
+func (s *mServer) SegmentImage(ctx context.Context, request nutshapi.SegmentImageRequestObject) (nutshapi.SegmentImageResponseObject, error) {
+	resp, err := s.segmentImage(ctx, request)
+	if err != nil {
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *mServer) segmentImage(ctx context.Context, request nutshapi.SegmentImageRequestObject) (nutshapi.SegmentImageResponseObject, error) {
+	if s.segmentation == nil {
+		return &nutshapi.SegmentImage400JSONResponse{
+			ErrorCode: ErrOnlineSegmentationDisabled().Error(),
+		}, nil
+	}
+
+	body := request.Body
+	if body.DecoderUuid == "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "missing decoder uuid")
+	}
+	box, err := gridRectToProto(body.Box)
+	if err != nil {
+		return nil, err
+	}
+	crop, err := gridRectToProto(body.Crop)
+	if err != nil {
+		return nil, err
+	}
+	if len(body.PointPrompts) == 0 && (box == nil || box.Width == 0 || box.Height == 0) {
+		return &nutshapi.SegmentImage400JSONResponse{
+			ErrorCode: ErrMissingSegmentationPrompt().Error(),
+		}, nil
+	}
+
+	im, err := s.loadImageTraced(ctx, body.ImageUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	var prompts []*schemav1.PointPrompt
+	for _, p := range body.PointPrompts {
+		prompts = append(prompts, &schemav1.PointPrompt{
+			X:          p.X,
+			Y:          p.Y,
+			IsPositive: p.IsPositive,
+		})
+	}
+	resp, err := s.segmentation.Segment(ctx, &servicev1.SegmentRequest{
+		OriginalImage: im,
+		DecoderUuid:   body.DecoderUuid,
+		PointPrompts:  prompts,
+		Box:           box,
+		Crop:          crop,
+	})
+	switch status.Code(err) {
+	case grpccodes.OK:
+	case grpccodes.NotFound:
+		return &nutshapi.SegmentImage400JSONResponse{
+			ErrorCode: ErrUnknownDecoder().Error(),
+		}, nil
+	case grpccodes.InvalidArgument:
+		return nil, echo.NewHTTPError(http.StatusBadRequest, status.Convert(err).Message())
+	case grpccodes.ResourceExhausted:
+		return &nutshapi.SegmentImage429Response{}, nil
+	default:
+		return nil, err
+	}
+
+	return &nutshapi.SegmentImage200JSONResponse{
+		Mask:  maskProtoToOpenApi(resp.GetMask()),
+		Score: resp.GetScore(),
+	}, nil
+}
+
+func gridRectToProto(r *nutshapi.GridRect) (*schemav1.GridRect, error) {
+	if r == nil {
+		return nil, nil
+	}
+	if r.X < 0 || r.Y < 0 || r.Width < 0 || r.Height < 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid rect")
+	}
+	return &schemav1.GridRect{
+		X:      uint32(r.X),
+		Y:      uint32(r.Y),
+		Width:  uint32(r.Width),
+		Height: uint32(r.Height),
+	}, nil
+}
+
+func (s *mServer) loadImageTraced(ctx context.Context, url string) ([]byte, error) {
+	ctx, span := tracer.Start(ctx, "load_image")
+	defer span.End()
+
+	im, err := s.loadImage(ctx, url)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("size", len(im)))
+	return im, nil
+}
+
 func (s *mServer) loadImage(ctx context.Context, url string) ([]byte, error) {
 	if strings.HasPrefix(url, dataProtocol) {
 		// the image should be loaded from data dir
@@ -247,6 +354,11 @@ func downloadImage(ctx context.Context, url string) ([]byte, error) {
 	return im, nil
 }
 
+// embeddingKey is the key of the embedding of an uncropped image in the public store.
+func embeddingKey(im []byte, decoderUuid string) string {
+	return fmt.Sprintf("embed/%s.%s.npy", md5String(im), decoderUuid)
+}
+
 func md5String(data []byte) string {
 	h := md5.New()
 	h.Write(data)
@@ -0,0 +1,275 @@
+package backend
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+
+	"nutsh/app/grpcclient"
+	servicev1 "nutsh/proto/gen/go/service/v1"
+)
+
+// Minimum interval between introspections triggered by requests for unknown decoders, such that requests for a decoder
+// no server serves do not introspect every server each.
+const segmentationRefreshInterval = 5 * time.Second
+
+// segmentationDecoder is a decoder served by one or more online segmentation servers.
+type segmentationDecoder struct {
+	uuid    string
+	name    string
+	feedJs  string
+	servers []*grpcclient.Client
+
+	// the index of the server to try first for the next call, to balance load among servers
+	next uint32
+}
+
+// segmentationRouter routes calls to the online segmentation servers serving the requested decoder, failing over to
+// the other servers of the same decoder.
+type segmentationRouter struct {
+	clients []*grpcclient.Client
+
+	mu sync.RWMutex
+	// decoders in the order of the servers
+	decoders []*segmentationDecoder
+
+	// refreshMu serializes refreshes on demand, such that concurrent requests share one
+	refreshMu   sync.Mutex
+	refreshedAt time.Time
+	refreshErr  error
+}
+
+func newSegmentationRouter(clients []*grpcclient.Client) *segmentationRouter {
+	return &segmentationRouter{
+		clients: clients,
+	}
+}
+
+// refresh introspects all servers to rebuild the routes, ignoring servers which are not reachable unless none is.
+func (r *segmentationRouter) refresh(ctx context.Context) ([]*segmentationDecoder, error) {
+	resps := make([]*servicev1.IntrospectResponse, len(r.clients))
+	errs := make([]error, len(r.clients))
+	var wg sync.WaitGroup
+	for i, c := range r.clients {
+		wg.Add(1)
+		go func(i int, c *grpcclient.Client) {
+			defer wg.Done()
+			client := servicev1.NewOnlineSegmentationServiceClient(c.Conn())
+			resps[i], errs[i] = client.Introspect(ctx, &servicev1.IntrospectRequest{})
+		}(i, c)
+	}
+	wg.Wait()
+
+	var decoders []*segmentationDecoder
+	byUuid := make(map[string]*segmentationDecoder)
+	var failures []string
+	for i, resp := range resps {
+		c := r.clients[i]
+		if err := errs[i]; err != nil {
+			zap.L().Warn("failed to introspect online segmentation server", zap.String("server", c.Name()), zap.Error(err))
+			failures = append(failures, c.Name()+": "+err.Error())
+			continue
+		}
+		for _, info := range servedDecoders(resp) {
+			d, ok := byUuid[info.GetUuid()]
+			if !ok {
+				d = &segmentationDecoder{
+					uuid:   info.GetUuid(),
+					name:   info.GetName(),
+					feedJs: info.GetFeedJs(),
+				}
+				byUuid[d.uuid] = d
+				decoders = append(decoders, d)
+			}
+			d.servers = append(d.servers, c)
+		}
+	}
+	if len(decoders) == 0 && len(failures) > 0 {
+		return nil, errors.Errorf("no online segmentation server is available: %s", strings.Join(failures, "; "))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.decoders = decoders
+	return decoders, nil
+}
+
+// servedDecoders lists the decoders of a server, which are described only by the default one if the server is unaware
+// of serving multiple decoders.
+func servedDecoders(resp *servicev1.IntrospectResponse) []*servicev1.DecoderInfo {
+	if decoders := resp.GetDecoders(); len(decoders) > 0 {
+		return decoders
+	}
+	return []*servicev1.DecoderInfo{{
+		Uuid:   resp.GetDecoderUuid(),
+		FeedJs: resp.GetDecoderFeedJs(),
+	}}
+}
+
+func (r *segmentationRouter) decoder(ctx context.Context, uuid string) (*segmentationDecoder, error) {
+	find := func() *segmentationDecoder {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		for _, d := range r.decoders {
+			if d.uuid == uuid {
+				return d
+			}
+		}
+		return nil
+	}
+	if d := find(); d != nil {
+		return d, nil
+	}
+
+	// servers may have been started or changed since the last introspection
+	if err := r.refreshOnDemand(ctx); err != nil {
+		return nil, err
+	}
+	if d := find(); d != nil {
+		return d, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "no online segmentation server serves decoder %s", uuid)
+}
+
+// refreshOnDemand refreshes the routes unless they have been refreshed within the interval, in which case the result of
+// that refresh is reused.
+func (r *segmentationRouter) refreshOnDemand(ctx context.Context) error {
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+	if !r.refreshedAt.IsZero() && time.Since(r.refreshedAt) < segmentationRefreshInterval {
+		return r.refreshErr
+	}
+
+	_, err := r.refresh(ctx)
+	if ctx.Err() != nil {
+		// the failure is of the caller rather than the servers
+		return err
+	}
+	r.refreshedAt = time.Now()
+	r.refreshErr = err
+	return err
+}
+
+// orderedServers lists the servers of the decoder in the order to try, which rotates among calls and puts servers known
+// to be unreachable last.
+func (d *segmentationDecoder) orderedServers() []*grpcclient.Client {
+	n := len(d.servers)
+	start := int(atomic.AddUint32(&d.next, 1)-1) % n
+
+	var healthy, unhealthy []*grpcclient.Client
+	for i := 0; i < n; i++ {
+		c := d.servers[(start+i)%n]
+		if c.State() == connectivity.TransientFailure {
+			unhealthy = append(unhealthy, c)
+		} else {
+			healthy = append(healthy, c)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// call invokes the function on servers of the decoder in turn until one succeeds or fails with an error which other
+// servers will not recover from.
+func (r *segmentationRouter) call(ctx context.Context, uuid string, f func(servicev1.OnlineSegmentationServiceClient) error) error {
+	d, err := r.decoder(ctx, uuid)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range d.orderedServers() {
+		err = f(servicev1.NewOnlineSegmentationServiceClient(c.Conn()))
+		if err == nil || !shouldFailover(ctx, err) {
+			return err
+		}
+		zap.L().Warn("online segmentation server failed, trying the next one", zap.String("server", c.Name()), zap.String("decoder", uuid), zap.Error(err))
+	}
+	return err
+}
+
+func (r *segmentationRouter) EmbedImage(ctx context.Context, req *servicev1.EmbedImageRequest) (*servicev1.EmbedImageResponse, error) {
+	var resp *servicev1.EmbedImageResponse
+	err := r.call(ctx, req.GetDecoderUuid(), func(client servicev1.OnlineSegmentationServiceClient) error {
+		var err error
+		resp, err = client.EmbedImage(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+func (r *segmentationRouter) GetDecoder(ctx context.Context, uuid string) (*servicev1.GetDecoderResponse, error) {
+	var resp *servicev1.GetDecoderResponse
+	err := r.call(ctx, uuid, func(client servicev1.OnlineSegmentationServiceClient) error {
+		var err error
+		resp, err = client.GetDecoder(ctx, &servicev1.GetDecoderRequest{Uuid: uuid})
+		return err
+	})
+	return resp, err
+}
+
+func (r *segmentationRouter) Segment(ctx context.Context, req *servicev1.SegmentRequest) (*servicev1.SegmentResponse, error) {
+	var resp *servicev1.SegmentResponse
+	err := r.call(ctx, req.GetDecoderUuid(), func(client servicev1.OnlineSegmentationServiceClient) error {
+		var err error
+		resp, err = client.Segment(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// EmbedImages opens a stream to embed images on one of the servers of the decoder, preferring the same server as unary
+// calls would. It fails over only if the stream cannot be opened, since a server failing afterwards is noticed by the
+// first message sent or received, when images may have been sent already; the caller is expected to embed the images
+// left by a failed stream through unary calls instead, which fail over.
+func (r *segmentationRouter) EmbedImages(ctx context.Context, uuid string) (servicev1.OnlineSegmentationService_EmbedImagesClient, error) {
+	var stream servicev1.OnlineSegmentationService_EmbedImagesClient
+	err := r.call(ctx, uuid, func(client servicev1.OnlineSegmentationServiceClient) error {
+		var err error
+		stream, err = client.EmbedImages(ctx)
+		return err
+	})
+	return stream, err
+}
+
+// shouldFailover tells if another server may succeed where one failed, which is not the case if the call is cancelled
+// by the caller or the request itself is invalid.
+func shouldFailover(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Canceled, codes.InvalidArgument, codes.NotFound, codes.PermissionDenied, codes.Unauthenticated:
+		return false
+	}
+	return true
+}
+
+// check passes if at least one server is ready, since the others can be failed over to.
+func (r *segmentationRouter) check(ctx context.Context) error {
+	errs := make([]error, len(r.clients))
+	var wg sync.WaitGroup
+	for i, c := range r.clients {
+		wg.Add(1)
+		go func(i int, c *grpcclient.Client) {
+			defer wg.Done()
+			errs[i] = c.Check(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	var failures []string
+	for i, err := range errs {
+		if err == nil {
+			return nil
+		}
+		failures = append(failures, r.clients[i].Name()+": "+err.Error())
+	}
+	return errors.New(strings.Join(failures, "; "))
+}
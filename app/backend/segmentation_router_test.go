@@ -0,0 +1,221 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"nutsh/app/grpcclient"
+	servicev1 "nutsh/proto/gen/go/service/v1"
+)
+
+type fakeSegmentationServer struct {
+	servicev1.UnimplementedOnlineSegmentationServiceServer
+
+	decoderUuid string
+	decoders    []*servicev1.DecoderInfo
+	embedErr    error
+	noStream    bool
+	// blocking holds streams until they are cancelled
+	blocking bool
+
+	mu           sync.Mutex
+	embedded     int
+	streamed     int
+	introspected int
+	blocked      int
+}
+
+func (s *fakeSegmentationServer) Introspect(ctx context.Context, req *servicev1.IntrospectRequest) (*servicev1.IntrospectResponse, error) {
+	s.mu.Lock()
+	s.introspected++
+	s.mu.Unlock()
+	return &servicev1.IntrospectResponse{DecoderUuid: s.decoderUuid, Decoders: s.decoders}, nil
+}
+
+func (s *fakeSegmentationServer) EmbedImage(ctx context.Context, req *servicev1.EmbedImageRequest) (*servicev1.EmbedImageResponse, error) {
+	if s.embedErr != nil {
+		return nil, s.embedErr
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.embedded++
+	return &servicev1.EmbedImageResponse{EmbeddedImageNpy: []byte(req.GetDecoderUuid())}, nil
+}
+
+// EmbedImages embeds images in the reverse order once all are received, failing images named `bad`.
+func (s *fakeSegmentationServer) EmbedImages(stream servicev1.OnlineSegmentationService_EmbedImagesServer) error {
+	if s.noStream {
+		return status.Error(codes.Unimplemented, "no stream")
+	}
+	var reqs []*servicev1.EmbedImagesRequest
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		reqs = append(reqs, req)
+	}
+	if s.blocking {
+		s.mu.Lock()
+		s.blocked++
+		s.mu.Unlock()
+		<-stream.Context().Done()
+		return stream.Context().Err()
+	}
+	for i := len(reqs) - 1; i >= 0; i-- {
+		req := reqs[i]
+		resp := &servicev1.EmbedImagesResponse{Id: req.GetId()}
+		if string(req.GetImage().GetOriginalImage()) == "bad" {
+			resp.Error = "bad image"
+		} else {
+			s.mu.Lock()
+			s.streamed++
+			s.mu.Unlock()
+			resp.Result = &servicev1.EmbedImageResponse{EmbeddedImageNpy: req.GetImage().GetOriginalImage()}
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func requireSegmentationClient(t *testing.T, server servicev1.OnlineSegmentationServiceServer) *grpcclient.Client {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := grpc.NewServer()
+	servicev1.RegisterOnlineSegmentationServiceServer(s, server)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	c, err := grpcclient.New("online_segmentation@"+lis.Addr().String(), lis.Addr().String(), grpcclient.WithMaxAttempts(1))
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestSegmentationRouter(t *testing.T) {
+	ctx := context.Background()
+
+	gpu := &fakeSegmentationServer{decoderUuid: "vit_h"}
+	cpu1 := &fakeSegmentationServer{decoderUuid: "vit_b"}
+	cpu2 := &fakeSegmentationServer{decoderUuid: "vit_b"}
+	r := newSegmentationRouter([]*grpcclient.Client{
+		requireSegmentationClient(t, gpu),
+		requireSegmentationClient(t, cpu1),
+		requireSegmentationClient(t, cpu2),
+	})
+
+	decoders, err := r.refresh(ctx)
+	require.NoError(t, err)
+	require.Len(t, decoders, 2)
+	require.Equal(t, "vit_h", decoders[0].uuid)
+	require.Equal(t, "vit_b", decoders[1].uuid)
+	require.Len(t, decoders[1].servers, 2)
+
+	t.Run("route by decoder", func(t *testing.T) {
+		resp, err := r.EmbedImage(ctx, &servicev1.EmbedImageRequest{DecoderUuid: "vit_h"})
+		require.NoError(t, err)
+		require.Equal(t, "vit_h", string(resp.GetEmbeddedImageNpy()))
+
+		_, err = r.EmbedImage(ctx, &servicev1.EmbedImageRequest{DecoderUuid: "unknown"})
+		require.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("balance among servers", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			_, err := r.EmbedImage(ctx, &servicev1.EmbedImageRequest{DecoderUuid: "vit_b"})
+			require.NoError(t, err)
+		}
+		require.Equal(t, 2, cpu1.embedded)
+		require.Equal(t, 2, cpu2.embedded)
+	})
+
+	t.Run("fail over", func(t *testing.T) {
+		cpu1.embedErr = status.Error(codes.ResourceExhausted, "out of memory")
+		defer func() { cpu1.embedErr = nil }()
+		for i := 0; i < 2; i++ {
+			_, err := r.EmbedImage(ctx, &servicev1.EmbedImageRequest{DecoderUuid: "vit_b"})
+			require.NoError(t, err)
+		}
+		require.Equal(t, 4, cpu2.embedded)
+	})
+
+	t.Run("no fail over for invalid requests", func(t *testing.T) {
+		cpu1.embedErr = status.Error(codes.InvalidArgument, "invalid crop")
+		cpu2.embedErr = cpu1.embedErr
+		defer func() { cpu1.embedErr, cpu2.embedErr = nil, nil }()
+		_, err := r.EmbedImage(ctx, &servicev1.EmbedImageRequest{DecoderUuid: "vit_b"})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestSegmentationRouterUnknownDecoder(t *testing.T) {
+	ctx := context.Background()
+
+	server := &fakeSegmentationServer{decoderUuid: "vit_b"}
+	r := newSegmentationRouter([]*grpcclient.Client{requireSegmentationClient(t, server)})
+
+	// concurrent requests for unknown decoders share a single introspection within the interval
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.EmbedImage(ctx, &servicev1.EmbedImageRequest{DecoderUuid: "unknown"})
+			require.Equal(t, codes.NotFound, status.Code(err))
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 1, server.introspected)
+
+	// a decoder found by that introspection is routed without another one
+	_, err := r.EmbedImage(ctx, &servicev1.EmbedImageRequest{DecoderUuid: "vit_b"})
+	require.NoError(t, err)
+	require.Equal(t, 1, server.introspected)
+}
+
+func TestSegmentationRouterMultiModelServer(t *testing.T) {
+	ctx := context.Background()
+
+	multi := &fakeSegmentationServer{
+		decoderUuid: "vit_h",
+		decoders: []*servicev1.DecoderInfo{
+			{Uuid: "vit_h", Name: "vit_h"},
+			{Uuid: "vit_b.finetuned", Name: "vit_b-finetuned"},
+		},
+	}
+	single := &fakeSegmentationServer{decoderUuid: "vit_b.finetuned"}
+	r := newSegmentationRouter([]*grpcclient.Client{
+		requireSegmentationClient(t, multi),
+		requireSegmentationClient(t, single),
+	})
+
+	decoders, err := r.refresh(ctx)
+	require.NoError(t, err)
+	require.Len(t, decoders, 2)
+	require.Equal(t, "vit_h", decoders[0].uuid)
+	require.Len(t, decoders[0].servers, 1)
+	require.Equal(t, "vit_b-finetuned", decoders[1].name)
+	require.Len(t, decoders[1].servers, 2)
+
+	for i := 0; i < 2; i++ {
+		resp, err := r.EmbedImage(ctx, &servicev1.EmbedImageRequest{DecoderUuid: "vit_b.finetuned"})
+		require.NoError(t, err)
+		require.Equal(t, "vit_b.finetuned", string(resp.GetEmbeddedImageNpy()))
+	}
+	require.Equal(t, 1, multi.embedded)
+	require.Equal(t, 1, single.embedded)
+}
@@ -0,0 +1,52 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"nutsh/app/fake"
+	"nutsh/app/grpcclient"
+	"nutsh/openapi/gen/nutshapi"
+	schemav1 "nutsh/proto/gen/go/schema/v1"
+	servicev1 "nutsh/proto/gen/go/service/v1"
+)
+
+func TestSegmentImage(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "image.jpg"), []byte("image"), 0644))
+	s := &mServer{
+		options:      &Options{dataDir: dataDir},
+		segmentation: newSegmentationRouter([]*grpcclient.Client{requireSegmentationClient(t, fake.NewOnlineSegmentationServer())}),
+	}
+	segment := func(req nutshapi.SegmentImageReq) nutshapi.SegmentImageResponseObject {
+		// the image is ignored when cropped
+		req.ImageUrl = dataProtocol + "image.jpg"
+		req.Crop = &nutshapi.GridRect{X: 0, Y: 0, Width: 8, Height: 8}
+		resp, err := s.SegmentImage(ctx, nutshapi.SegmentImageRequestObject{Body: &req})
+		require.NoError(t, err)
+		return resp
+	}
+
+	box := &nutshapi.GridRect{X: 2, Y: 2, Width: 4, Height: 4}
+	resp := segment(nutshapi.SegmentImageReq{DecoderUuid: fake.DecoderUuid, Box: box})
+	expected, err := fake.Segment(&servicev1.SegmentRequest{
+		Box:  &schemav1.GridRect{X: 2, Y: 2, Width: 4, Height: 4},
+		Crop: &schemav1.GridRect{Width: 8, Height: 8},
+	})
+	require.NoError(t, err)
+	require.Equal(t, &nutshapi.SegmentImage200JSONResponse{
+		Mask:  maskProtoToOpenApi(expected.Mask),
+		Score: 1,
+	}, resp)
+
+	resp = segment(nutshapi.SegmentImageReq{DecoderUuid: "unknown", Box: box})
+	require.Equal(t, &nutshapi.SegmentImage400JSONResponse{ErrorCode: ErrUnknownDecoder().Error()}, resp)
+
+	resp = segment(nutshapi.SegmentImageReq{DecoderUuid: fake.DecoderUuid})
+	require.Equal(t, &nutshapi.SegmentImage400JSONResponse{ErrorCode: ErrMissingSegmentationPrompt().Error()}, resp)
+}
@@ -2,20 +2,44 @@ package backend
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 
 	"nutsh/app/buildtime"
+	"nutsh/app/grpcclient"
+	"nutsh/app/supervisor"
 	"nutsh/openapi/gen/nutshapi"
 
 	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc"
 )
 
 const dataProtocol = "data://"
 
+var tracer = otel.Tracer("nutsh/app/backend")
+
 type Server interface {
 	nutshapi.StrictServerInterface
 
 	// stream
 	TrackStream(c echo.Context) error
+	ProjectStream(c echo.Context) error
+
+	// internal
+	GetYjsAnnotation(c echo.Context) error
+	PutYjsAnnotation(c echo.Context) error
+
+	// health
+	Healthz(c echo.Context) error
+	Readyz(c echo.Context) error
+
+	// Drain makes the server report not ready such that no more traffic is routed to it before shutting down, and ends
+	// open event streams, which would otherwise hold the shutdown until its timeout.
+	Drain()
+
+	// Close stops background jobs and releases connections to the model servers.
+	Close() error
 }
 
 func New(opts ...Option) (Server, error) {
@@ -26,9 +50,47 @@ func New(opts ...Option) (Server, error) {
 	if err := o.Validate(); err != nil {
 		return nil, err
 	}
+	if o.precomputeConcurrency <= 0 {
+		o.precomputeConcurrency = 1
+	}
 
+	background, stopBackground := context.WithCancel(context.Background())
 	s := &mServer{
-		options: o,
+		options:        o,
+		drained:        make(chan struct{}),
+		background:     background,
+		stopBackground: stopBackground,
+		prefetchJobs:   newPrefetchJobs(),
+		precomputeRuns: make(map[string]*precomputeRun),
+	}
+
+	if addrs := o.onlineSegmentationServerAddrs; len(addrs) > 0 {
+		var clients []*grpcclient.Client
+		for _, addr := range addrs {
+			c, err := newGrpcClient(o, "online_segmentation@"+addr, addr,
+				grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxSegmentationMessageSize)),
+			)
+			if err != nil {
+				for _, c := range clients {
+					c.Close()
+				}
+				return nil, err
+			}
+			clients = append(clients, c)
+		}
+		s.segmentation = newSegmentationRouter(clients)
+	}
+	if addr := o.trackServerAddr; addr != "" {
+		c, err := newGrpcClient(o, "track", addr)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.trackGrpc = c
+	}
+
+	if s.segmentation != nil {
+		go s.resumePrecomputeJobs()
 	}
 
 	return s, nil
@@ -36,6 +98,35 @@ func New(opts ...Option) (Server, error) {
 
 type mServer struct {
 	options *Options
+
+	draining  atomic.Bool
+	drainOnce sync.Once
+	// drained is closed on draining
+	drained chan struct{}
+
+	// background is the context of jobs outliving requests, which is cancelled on closing
+	background     context.Context
+	stopBackground context.CancelFunc
+	prefetchJobs   *prefetchJobs
+	precomputeMu   sync.Mutex
+	precomputeRuns map[string]*precomputeRun
+	precomputeWg   sync.WaitGroup
+
+	segmentation *segmentationRouter
+	trackGrpc    *grpcclient.Client
+}
+
+func (s *mServer) Close() error {
+	s.stopBackground()
+	s.waitPrecomputeJobs(precomputeCloseTimeout)
+
+	var err error
+	for _, c := range s.grpcClients() {
+		if e := c.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
 }
 
 func (s *mServer) GetMetadata(ctx context.Context, request nutshapi.GetMetadataRequestObject) (nutshapi.GetMetadataResponseObject, error) {
@@ -47,7 +138,25 @@ func (s *mServer) GetMetadata(ctx context.Context, request nutshapi.GetMetadataR
 }
 
 func (s *mServer) GetConfig(ctx context.Context, request nutshapi.GetConfigRequestObject) (nutshapi.GetConfigResponseObject, error) {
+	config := *s.options.config
+	if yjs := s.options.yjs; yjs != nil {
+		config.YjsStatus = processStatus(yjs.Status())
+	}
+	config.GrpcConnections = s.grpcConnections()
 	return &nutshapi.GetConfig200JSONResponse{
-		Config: *s.options.config,
+		Config: config,
 	}, nil
 }
+
+func processStatus(status supervisor.Status) *nutshapi.ProcessStatus {
+	s := &nutshapi.ProcessStatus{
+		State:    string(status.State),
+		Healthy:  status.Healthy,
+		Restarts: status.Restarts,
+	}
+	if status.LastError != nil {
+		msg := status.LastError.Error()
+		s.Error = &msg
+	}
+	return s
+}
@@ -0,0 +1,42 @@
+package backend
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"nutsh/app/event"
+	"nutsh/app/storage/sqlite3"
+	"nutsh/app/webhook"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+// requireTestServer returns a server backed by a fresh database, which is overridden by the options, along with a
+// project created in it.
+func requireTestServer(t *testing.T, opts ...Option) (*mServer, *nutshapi.Project) {
+	db, err := sqlite3.New(filepath.Join(t.TempDir(), "db.sqlite3"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	bus := event.NewBus()
+	dispatcher, err := webhook.New(webhook.WithStorage(db.WebhookStorage()), webhook.WithEventBus(bus))
+	require.NoError(t, err)
+	t.Cleanup(dispatcher.Close)
+
+	s, err := New(append([]Option{
+		WithProjectStorage(db.ProjectStorage()),
+		WithVideoStorage(db.VideoStorage()),
+		WithWebhookStorage(db.WebhookStorage()),
+		WithPrecomputeJobStorage(db.PrecomputeJobStorage()),
+		WithEventBus(bus),
+		WithWebhookDispatcher(dispatcher),
+	}, opts...)...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	project, err := db.ProjectStorage().Create(context.Background(), &nutshapi.CreateProjectReq{Name: "project", SpecJson: "{}"})
+	require.NoError(t, err)
+	return s.(*mServer), project
+}
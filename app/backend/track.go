@@ -10,7 +10,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"nutsh/app/metrics"
 	"nutsh/openapi/gen/nutshapi"
 	schemav1 "nutsh/proto/gen/go/schema/v1"
 	servicev1 "nutsh/proto/gen/go/service/v1"
@@ -18,8 +20,6 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 func (s *mServer) Track(ctx context.Context, request nutshapi.TrackRequestObject) (nutshapi.TrackResponseObject, error) {
@@ -32,15 +32,15 @@ func (s *mServer) Track(ctx context.Context, request nutshapi.TrackRequestObject
 }
 
 func (s *mServer) track(ctx context.Context, request nutshapi.TrackRequestObject) (nutshapi.TrackResponseObject, error) {
-	conn, err := s.makeTrackGrpcConnection()
+	client, err := s.trackClient()
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
-
-	client := servicev1.NewTrackServiceClient(conn)
 	req, err := s.makeTrackGrpcRequest(request)
 	if err != nil {
+		if bad, ok := err.(*Error); ok {
+			return &nutshapi.Track400JSONResponse{ErrorCode: bad.Error()}, nil
+		}
 		return nil, err
 	}
 
@@ -49,25 +49,28 @@ func (s *mServer) track(ctx context.Context, request nutshapi.TrackRequestObject
 		return nil, err
 	}
 
-	var masks []nutshapi.Mask
+	masks := make([]nutshapi.Mask, 0, len(resp.GetSubsequentImageMasks()))
 	for _, m := range resp.GetSubsequentImageMasks() {
 		masks = append(masks, maskProtoToOpenApi(m))
 	}
+	frameMasks := make([]nutshapi.FrameMask, 0, len(resp.GetFrameMasks()))
+	for _, m := range resp.GetFrameMasks() {
+		frameMasks = append(frameMasks, frameMaskProtoToOpenApi(m))
+	}
 
 	return &nutshapi.Track200JSONResponse{
 		SubsequentFrameMasks: masks,
+		FrameMasks:           frameMasks,
 	}, nil
 }
 
-type FrameMask struct {
-	FrameIndex uint32        `json:"frame_index"`
-	Mask       nutshapi.Mask `json:"mask"`
-}
-
 // For streaming response, check
 // https://echo.labstack.com/docs/cookbook/streaming-response
 func (s *mServer) TrackStream(c echo.Context) error {
-	if err := s.trackStream(c); err != nil {
+	start := time.Now()
+	err := s.trackStream(c)
+	metrics.ObserveTrackStream(err, time.Since(start))
+	if err != nil {
 		zap.L().Error(err.Error())
 		return err
 	}
@@ -81,15 +84,15 @@ func (s *mServer) trackStream(c echo.Context) error {
 	}
 	request := nutshapi.TrackRequestObject{Body: &body}
 
-	conn, err := s.makeTrackGrpcConnection()
+	client, err := s.trackClient()
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
-
-	client := servicev1.NewTrackServiceClient(conn)
 	req, err := s.makeTrackGrpcRequest(request)
 	if err != nil {
+		if bad, ok := err.(*Error); ok {
+			return c.JSON(http.StatusBadRequest, nutshapi.Track400JSONResponse{ErrorCode: bad.Error()})
+		}
 		return err
 	}
 
@@ -98,6 +101,7 @@ func (s *mServer) trackStream(c echo.Context) error {
 		return err
 	}
 
+	// each mask is encoded as a JSON object of `FrameMask` in the OpenAPI definition
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	c.Response().WriteHeader(http.StatusOK)
 	enc := json.NewEncoder(c.Response())
@@ -110,70 +114,119 @@ func (s *mServer) trackStream(c echo.Context) error {
 			return errors.WithStack(err)
 		}
 
-		frameMask := FrameMask{
-			FrameIndex: mask.FrameIndex,
-			Mask:       maskProtoToOpenApi(mask.Mask),
-		}
-		if err := enc.Encode(frameMask); err != nil {
+		if err := enc.Encode(frameMaskProtoToOpenApi(mask)); err != nil {
 			return errors.WithStack(err)
 		}
 		c.Response().Flush()
-		zap.L().Info("streamed a mask", zap.Uint32("frame", mask.FrameIndex))
+		metrics.IncTrackStreamMask()
+		zap.L().Info("streamed a mask", zap.Uint32("frame", mask.FrameIndex), zap.String("entity", mask.EntityId))
 	}
 	return nil
 }
 
-func (s *mServer) makeTrackGrpcConnection() (*grpc.ClientConn, error) {
-	opts := s.options
-
-	addr := opts.trackServerAddr
-	if addr == "" {
+func (s *mServer) trackClient() (servicev1.TrackServiceClient, error) {
+	if s.trackGrpc == nil {
 		return nil, errors.Errorf("missing track server addr")
 	}
+	return servicev1.NewTrackServiceClient(s.trackGrpc.Conn()), nil
+}
 
-	// call grpc server
-	conn, err := grpc.Dial(addr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-
-	return conn, nil
+var trackDirections = map[string]servicev1.TrackDirection{
+	"":         servicev1.TrackDirection_TRACK_DIRECTION_FORWARD,
+	"forward":  servicev1.TrackDirection_TRACK_DIRECTION_FORWARD,
+	"backward": servicev1.TrackDirection_TRACK_DIRECTION_BACKWARD,
+	"both":     servicev1.TrackDirection_TRACK_DIRECTION_BOTH,
 }
 
+// makeTrackGrpcRequest validates the request in either form, returning ErrInvalidTrackRequest if it is invalid. A
+// request in the legacy form is passed on in the same form, such that track servers not knowing ranges still serve it.
 func (s *mServer) makeTrackGrpcRequest(request nutshapi.TrackRequestObject) (*servicev1.TrackRequest, error) {
 	body := request.Body
 
-	var firstUri string
-	var restUris []string
-
-	if uri, err := s.makeImageUri(body.FirstFrameUrl); err != nil {
-		return nil, err
-	} else {
-		firstUri = uri
+	legacy := body.FirstFrameUrl != nil || body.FirstFrameMask != nil || body.SubsequentFrameUrls != nil
+	ranged := body.FrameUrls != nil || body.KeyframeIndex != nil || body.KeyframeMasks != nil || body.Direction != nil
+	if legacy == ranged {
+		return nil, ErrInvalidTrackRequest()
 	}
-	for _, url := range body.SubsequentFrameUrls {
-		if uri, err := s.makeImageUri(url); err != nil {
+
+	if legacy {
+		if body.FirstFrameUrl == nil || body.FirstFrameMask == nil {
+			return nil, ErrInvalidTrackRequest()
+		}
+		firstUri, err := s.makeImageUri(*body.FirstFrameUrl)
+		if err != nil {
 			return nil, err
-		} else {
-			restUris = append(restUris, uri)
 		}
+		var restUris []string
+		if body.SubsequentFrameUrls != nil {
+			if restUris, err = s.makeImageUris(*body.SubsequentFrameUrls); err != nil {
+				return nil, err
+			}
+		}
+		return &servicev1.TrackRequest{
+			FirstImageUri:       firstUri,
+			SubsequentImageUris: restUris,
+			FirstImageMask:      maskOpenApiToProto(*body.FirstFrameMask),
+		}, nil
 	}
 
+	if body.FrameUrls == nil || len(*body.FrameUrls) == 0 || body.KeyframeMasks == nil || len(*body.KeyframeMasks) == 0 {
+		return nil, ErrInvalidTrackRequest()
+	}
+	keyframe := 0
+	if body.KeyframeIndex != nil {
+		keyframe = *body.KeyframeIndex
+	}
+	if keyframe < 0 || keyframe >= len(*body.FrameUrls) {
+		return nil, ErrInvalidTrackRequest()
+	}
+	direction := ""
+	if body.Direction != nil {
+		direction = *body.Direction
+	}
+	dir, ok := trackDirections[direction]
+	if !ok {
+		return nil, ErrInvalidTrackRequest()
+	}
+
+	// each entity is tracked at most once
+	entities := make(map[string]bool)
+	var masks []*servicev1.EntityMask
+	for _, m := range *body.KeyframeMasks {
+		if m.EntityId == "" || entities[m.EntityId] {
+			return nil, ErrInvalidTrackRequest()
+		}
+		entities[m.EntityId] = true
+		masks = append(masks, &servicev1.EntityMask{
+			EntityId: m.EntityId,
+			Mask:     maskOpenApiToProto(m.Mask),
+		})
+	}
+
+	uris, err := s.makeImageUris(*body.FrameUrls)
+	if err != nil {
+		return nil, err
+	}
 	return &servicev1.TrackRequest{
-		FirstImageUri:       firstUri,
-		SubsequentImageUris: restUris,
-		FirstImageMask: &schemav1.Mask{
-			CocoEncodedRle: body.FirstFrameMask.CocoEncodedRle,
-			Size: &schemav1.GridSize{
-				Width:  uint32(body.FirstFrameMask.Width),
-				Height: uint32(body.FirstFrameMask.Height),
-			},
-		},
+		ImageUris:     uris,
+		KeyframeIndex: uint32(keyframe),
+		KeyframeMasks: masks,
+		Direction:     dir,
 	}, nil
 }
 
+func (s *mServer) makeImageUris(imUrls []string) ([]string, error) {
+	uris := make([]string, 0, len(imUrls))
+	for _, url := range imUrls {
+		uri, err := s.makeImageUri(url)
+		if err != nil {
+			return nil, err
+		}
+		uris = append(uris, uri)
+	}
+	return uris, nil
+}
+
 func (s *mServer) makeImageUri(imUrl string) (string, error) {
 	if !strings.HasPrefix(imUrl, dataProtocol) {
 		// regard this image as a remote one.
@@ -200,6 +253,24 @@ func (s *mServer) makeImageUri(imUrl string) (string, error) {
 	return fmt.Sprintf("data:%s;base64,%s", contentType, imBase64), nil
 }
 
+func maskOpenApiToProto(m nutshapi.Mask) *schemav1.Mask {
+	return &schemav1.Mask{
+		CocoEncodedRle: m.CocoEncodedRle,
+		Size: &schemav1.GridSize{
+			Width:  uint32(m.Width),
+			Height: uint32(m.Height),
+		},
+	}
+}
+
+func frameMaskProtoToOpenApi(m *servicev1.FrameMask) nutshapi.FrameMask {
+	return nutshapi.FrameMask{
+		FrameIndex: int(m.FrameIndex),
+		EntityId:   m.EntityId,
+		Mask:       maskProtoToOpenApi(m.Mask),
+	}
+}
+
 func maskProtoToOpenApi(m *schemav1.Mask) nutshapi.Mask {
 	return nutshapi.Mask{
 		CocoEncodedRle: m.CocoEncodedRle,
@@ -0,0 +1,137 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"nutsh/app/fake"
+	"nutsh/app/grpcclient"
+	"nutsh/openapi/gen/nutshapi"
+	servicev1 "nutsh/proto/gen/go/service/v1"
+)
+
+func requireTrackClient(t *testing.T, server servicev1.TrackServiceServer) *grpcclient.Client {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := grpc.NewServer()
+	servicev1.RegisterTrackServiceServer(s, server)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	c, err := grpcclient.New("track@"+lis.Addr().String(), lis.Addr().String(), grpcclient.WithMaxAttempts(1))
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func trackStream(t *testing.T, s *mServer, body nutshapi.TrackJSONRequestBody) *httptest.ResponseRecorder {
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/track", bytes.NewReader(data))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	require.NoError(t, s.TrackStream(echo.New().NewContext(req, rec)))
+	return rec
+}
+
+func decodeFrameMasks(t *testing.T, rec *httptest.ResponseRecorder) []nutshapi.FrameMask {
+	var masks []nutshapi.FrameMask
+	dec := json.NewDecoder(rec.Body)
+	for dec.More() {
+		var m nutshapi.FrameMask
+		require.NoError(t, dec.Decode(&m))
+		masks = append(masks, m)
+	}
+	return masks
+}
+
+func TestTrack(t *testing.T) {
+	s := &mServer{
+		options:   &Options{},
+		trackGrpc: requireTrackClient(t, fake.NewTrackServer()),
+	}
+	mask := nutshapi.Mask{CocoEncodedRle: "12", Width: 3, Height: 4}
+	body := nutshapi.TrackJSONRequestBody{
+		FirstFrameUrl:       ptr("https://example.com/0.jpg"),
+		FirstFrameMask:      &mask,
+		SubsequentFrameUrls: &[]string{"https://example.com/1.jpg", "https://example.com/2.jpg"},
+	}
+
+	resp, err := s.Track(context.Background(), nutshapi.TrackRequestObject{Body: &body})
+	require.NoError(t, err)
+	require.Equal(t, &nutshapi.Track200JSONResponse{
+		SubsequentFrameMasks: []nutshapi.Mask{mask, mask},
+		FrameMasks:           []nutshapi.FrameMask{},
+	}, resp)
+
+	// the masks of subsequent frames start from 1
+	rec := trackStream(t, s, body)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, []nutshapi.FrameMask{{FrameIndex: 1, Mask: mask}, {FrameIndex: 2, Mask: mask}}, decodeFrameMasks(t, rec))
+}
+
+func TestTrackRange(t *testing.T) {
+	s := &mServer{
+		options:   &Options{},
+		trackGrpc: requireTrackClient(t, fake.NewTrackServer()),
+	}
+	mask1 := nutshapi.Mask{CocoEncodedRle: "12", Width: 3, Height: 4}
+	mask2 := nutshapi.Mask{CocoEncodedRle: "3", Width: 3, Height: 4}
+	body := nutshapi.TrackJSONRequestBody{
+		FrameUrls:     &[]string{"https://example.com/0.jpg", "https://example.com/1.jpg", "https://example.com/2.jpg"},
+		KeyframeIndex: ptr(1),
+		KeyframeMasks: &[]nutshapi.EntityMask{{EntityId: "a", Mask: mask1}, {EntityId: "b", Mask: mask2}},
+		Direction:     ptr("both"),
+	}
+
+	resp, err := s.Track(context.Background(), nutshapi.TrackRequestObject{Body: &body})
+	require.NoError(t, err)
+	require.Equal(t, &nutshapi.Track200JSONResponse{
+		SubsequentFrameMasks: []nutshapi.Mask{},
+		FrameMasks: []nutshapi.FrameMask{
+			{FrameIndex: 2, EntityId: "a", Mask: mask1},
+			{FrameIndex: 2, EntityId: "b", Mask: mask2},
+			{FrameIndex: 0, EntityId: "a", Mask: mask1},
+			{FrameIndex: 0, EntityId: "b", Mask: mask2},
+		},
+	}, resp)
+
+	body.Direction = ptr("backward")
+	rec := trackStream(t, s, body)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, []nutshapi.FrameMask{
+		{FrameIndex: 0, EntityId: "a", Mask: mask1},
+		{FrameIndex: 0, EntityId: "b", Mask: mask2},
+	}, decodeFrameMasks(t, rec))
+
+	for _, invalid := range []nutshapi.TrackJSONRequestBody{
+		// mixing both forms
+		{FrameUrls: body.FrameUrls, KeyframeMasks: body.KeyframeMasks, FirstFrameUrl: ptr("https://example.com/0.jpg")},
+		{FrameUrls: body.FrameUrls},
+		{FrameUrls: body.FrameUrls, KeyframeMasks: body.KeyframeMasks, KeyframeIndex: ptr(3)},
+		{FrameUrls: body.FrameUrls, KeyframeMasks: body.KeyframeMasks, Direction: ptr("sideways")},
+		{FrameUrls: body.FrameUrls, KeyframeMasks: &[]nutshapi.EntityMask{{EntityId: "a", Mask: mask1}, {EntityId: "a", Mask: mask2}}},
+	} {
+		invalid := invalid
+		resp, err := s.Track(context.Background(), nutshapi.TrackRequestObject{Body: &invalid})
+		require.NoError(t, err)
+		require.Equal(t, &nutshapi.Track400JSONResponse{ErrorCode: ErrInvalidTrackRequest().Error()}, resp)
+
+		rec := trackStream(t, s, invalid)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -5,6 +5,8 @@ import (
 
 	"go.uber.org/zap"
 
+	"nutsh/app/event"
+	"nutsh/app/metrics"
 	"nutsh/app/storage"
 	"nutsh/openapi/gen/nutshapi"
 )
@@ -15,6 +17,9 @@ func (s *mServer) DeleteVideo(ctx context.Context, request nutshapi.DeleteVideoR
 		zap.L().Error(err.Error())
 		return nil, err
 	}
+	s.options.bus.Publish(event.New(event.VideoDeleted, rec.ProjectId, map[string]interface{}{
+		"video": rec,
+	}))
 	return &nutshapi.DeleteVideo200JSONResponse{
 		Video: *rec,
 	}, nil
@@ -60,6 +65,7 @@ func (s *mServer) UpdateVideo(ctx context.Context, request nutshapi.UpdateVideoR
 		zap.L().Error(err.Error())
 		return nil, err
 	}
+	s.publishVideoUpdated(ctx, rec)
 	return &nutshapi.UpdateVideo200JSONResponse{
 		Video: *rec,
 	}, nil
@@ -76,6 +82,9 @@ func (s *mServer) CreateVideo(ctx context.Context, request nutshapi.CreateVideoR
 		zap.L().Error(err.Error())
 		return nil, err
 	}
+	s.options.bus.Publish(event.New(event.VideoCreated, request.Body.ProjectId, map[string]interface{}{
+		"video": rec,
+	}))
 	return &nutshapi.CreateVideo200JSONResponse{
 		Video: *rec,
 	}, nil
@@ -109,6 +118,7 @@ func (s *mServer) PatchVideoAnnotation(ctx context.Context, request nutshapi.Pat
 	newVersion, err := s.options.storageVideo.PatchAnnotationJsonMergePatch(ctx, request.VideoId, patch, version)
 	if err != nil {
 		if storage.IsErrNotFound(err) {
+			metrics.IncAnnotationVersionConflict()
 			return &nutshapi.PatchVideoAnnotation409Response{}, nil
 		}
 		zap.L().Error(err.Error())
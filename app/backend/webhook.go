@@ -0,0 +1,118 @@
+package backend
+
+import (
+	"context"
+	"net/url"
+
+	"go.uber.org/zap"
+
+	"nutsh/app/storage"
+	"nutsh/app/webhook"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+func (s *mServer) ListProjectWebhooks(ctx context.Context, request nutshapi.ListProjectWebhooksRequestObject) (nutshapi.ListProjectWebhooksResponseObject, error) {
+	recs, err := s.options.storageWebhook.List(ctx, request.ProjectId)
+	if err != nil {
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+
+	recs_ := make([]nutshapi.Webhook, 0)
+	for _, r := range recs {
+		recs_ = append(recs_, *r)
+	}
+	return &nutshapi.ListProjectWebhooks200JSONResponse{
+		Webhooks: recs_,
+	}, nil
+}
+
+func (s *mServer) CreateProjectWebhook(ctx context.Context, request nutshapi.CreateProjectWebhookRequestObject) (nutshapi.CreateProjectWebhookResponseObject, error) {
+	if _, err := s.options.storageProject.Get(ctx, request.ProjectId); err != nil {
+		if storage.IsErrNotFound(err) || storage.IsErrInvalidId(err) {
+			return &nutshapi.CreateProjectWebhook404Response{}, nil
+		}
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+	if !isWebhookUrl(request.Body.Url) {
+		return &nutshapi.CreateProjectWebhook400JSONResponse{
+			ErrorCode: ErrInvalidWebhookUrl().Error(),
+		}, nil
+	}
+	for _, event := range request.Body.Events {
+		if !webhook.IsEvent(event) {
+			return &nutshapi.CreateProjectWebhook400JSONResponse{
+				ErrorCode: ErrUnknownWebhookEvent().Error(),
+			}, nil
+		}
+	}
+
+	rec, err := s.options.storageWebhook.Create(ctx, request.ProjectId, request.Body)
+	if err != nil {
+		if bad, ok := err.(*storage.Error); ok {
+			return &nutshapi.CreateProjectWebhook400JSONResponse{
+				ErrorCode: bad.Error(),
+			}, nil
+		}
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+	return &nutshapi.CreateProjectWebhook200JSONResponse{
+		Webhook: *rec,
+	}, nil
+}
+
+func (s *mServer) DeleteWebhook(ctx context.Context, request nutshapi.DeleteWebhookRequestObject) (nutshapi.DeleteWebhookResponseObject, error) {
+	rec, err := s.options.storageWebhook.Delete(ctx, request.WebhookId)
+	if err != nil {
+		// an id which can never exist is not found either
+		if storage.IsErrNotFound(err) || storage.IsErrInvalidId(err) {
+			return &nutshapi.DeleteWebhook404Response{}, nil
+		}
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+	return &nutshapi.DeleteWebhook200JSONResponse{
+		Webhook: *rec,
+	}, nil
+}
+
+func (s *mServer) ListWebhookDeliveries(ctx context.Context, request nutshapi.ListWebhookDeliveriesRequestObject) (nutshapi.ListWebhookDeliveriesResponseObject, error) {
+	recs, err := s.options.storageWebhook.ListDeliveries(ctx, request.WebhookId)
+	if err != nil {
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+
+	recs_ := make([]nutshapi.WebhookDelivery, 0)
+	for _, r := range recs {
+		recs_ = append(recs_, *r)
+	}
+	return &nutshapi.ListWebhookDeliveries200JSONResponse{
+		Deliveries: recs_,
+	}, nil
+}
+
+func (s *mServer) TestWebhook(ctx context.Context, request nutshapi.TestWebhookRequestObject) (nutshapi.TestWebhookResponseObject, error) {
+	rec, err := s.options.webhook.Test(ctx, request.WebhookId)
+	if err != nil {
+		if storage.IsErrNotFound(err) || storage.IsErrInvalidId(err) {
+			return &nutshapi.TestWebhook404Response{}, nil
+		}
+		zap.L().Error(err.Error())
+		return nil, err
+	}
+	return &nutshapi.TestWebhook200JSONResponse{
+		Delivery: *rec,
+	}, nil
+}
+
+// isWebhookUrl tells if events can be delivered to the url, which must be an absolute one in HTTP(S).
+func isWebhookUrl(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
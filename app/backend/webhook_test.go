@@ -0,0 +1,63 @@
+package backend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"nutsh/app/webhook"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+func TestDeleteWebhook(t *testing.T) {
+	ctx := context.Background()
+	s, project := requireTestServer(t)
+	created, err := s.CreateProjectWebhook(ctx, nutshapi.CreateProjectWebhookRequestObject{
+		ProjectId: project.Id,
+		Body:      &nutshapi.CreateProjectWebhookReq{Url: "https://example.com/hook", Events: []string{webhook.EventVideoCreated}},
+	})
+	require.NoError(t, err)
+	id := created.(*nutshapi.CreateProjectWebhook200JSONResponse).Webhook.Id
+
+	resp, err := s.DeleteWebhook(ctx, nutshapi.DeleteWebhookRequestObject{WebhookId: id})
+	require.NoError(t, err)
+	require.Equal(t, id, resp.(*nutshapi.DeleteWebhook200JSONResponse).Webhook.Id)
+
+	for _, missing := range []string{id, "invalid"} {
+		resp, err := s.DeleteWebhook(ctx, nutshapi.DeleteWebhookRequestObject{WebhookId: missing})
+		require.NoError(t, err)
+		require.Equal(t, &nutshapi.DeleteWebhook404Response{}, resp)
+	}
+}
+
+func TestCreateProjectWebhookBadRequest(t *testing.T) {
+	ctx := context.Background()
+	s, project := requireTestServer(t)
+	create := func(pid string, url string) nutshapi.CreateProjectWebhookResponseObject {
+		resp, err := s.CreateProjectWebhook(ctx, nutshapi.CreateProjectWebhookRequestObject{
+			ProjectId: pid,
+			Body:      &nutshapi.CreateProjectWebhookReq{Url: url, Events: []string{webhook.EventVideoCreated}},
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	for _, missing := range []string{"0", "invalid"} {
+		require.Equal(t, &nutshapi.CreateProjectWebhook404Response{}, create(missing, "https://example.com/hook"))
+	}
+	for _, url := range []string{"", "example.com/hook", "ftp://example.com/hook", "https://"} {
+		require.Equal(t, &nutshapi.CreateProjectWebhook400JSONResponse{
+			ErrorCode: ErrInvalidWebhookUrl().Error(),
+		}, create(project.Id, url), url)
+	}
+}
+
+func TestTestWebhookNotFound(t *testing.T) {
+	s, _ := requireTestServer(t)
+	for _, missing := range []string{"1", "invalid"} {
+		resp, err := s.TestWebhook(context.Background(), nutshapi.TestWebhookRequestObject{WebhookId: missing})
+		require.NoError(t, err)
+		require.Equal(t, &nutshapi.TestWebhook404Response{}, resp)
+	}
+}
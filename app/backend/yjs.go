@@ -0,0 +1,83 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"nutsh/app/metrics"
+	"nutsh/app/storage"
+)
+
+// The yjs-server does not access the database on its own but loads and stores annotations through the following
+// internal API, such that collaborative edits are versioned and published like any other change. An annotation is
+// stored on top of the version it was loaded or last stored at, and a conflicting change in between, e.g. a patch
+// through the public API, is reported with 409 for the yjs-server to merge it into the document and store again.
+
+type YjsAnnotation struct {
+	AnnotationJson    *string `json:"annotation_json,omitempty"`
+	AnnotationVersion string  `json:"annotation_version,omitempty"`
+}
+
+func (s *mServer) GetYjsAnnotation(c echo.Context) error {
+	anno, version, err := s.options.storageVideo.GetAnnotation(c.Request().Context(), c.Param("videoId"))
+	if err != nil {
+		if storage.IsErrNotFound(err) {
+			return echo.NewHTTPError(http.StatusNotFound)
+		}
+		zap.L().Error(err.Error())
+		return err
+	}
+	return c.JSON(http.StatusOK, &YjsAnnotation{
+		AnnotationJson:    anno,
+		AnnotationVersion: version,
+	})
+}
+
+func (s *mServer) PutYjsAnnotation(c echo.Context) error {
+	var body YjsAnnotation
+	if err := c.Bind(&body); err != nil {
+		return errors.WithStack(err)
+	}
+	if body.AnnotationJson == nil || !isAnnotationJson(*body.AnnotationJson) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error_code": ErrInvalidAnnotationJson().Error(),
+		})
+	}
+
+	version, err := s.options.storageVideo.SetAnnotation(c.Request().Context(), c.Param("videoId"), *body.AnnotationJson, body.AnnotationVersion)
+	if err != nil {
+		if storage.IsErrNotFound(err) || storage.IsErrInvalidId(err) {
+			return echo.NewHTTPError(http.StatusNotFound)
+		}
+		if storage.IsErrVersionConflict(err) {
+			metrics.IncAnnotationVersionConflict()
+			return echo.NewHTTPError(http.StatusConflict)
+		}
+		zap.L().Error(err.Error())
+		return err
+	}
+	return c.JSON(http.StatusOK, &YjsAnnotation{
+		AnnotationVersion: version,
+	})
+}
+
+// isAnnotationJson checks the shape which patches through the public API assume, namely an object whose entities are
+// objects keyed by their ids.
+func isAnnotationJson(s string) bool {
+	var anno struct {
+		Entities map[string]map[string]json.RawMessage `json:"entities"`
+	}
+	if err := json.Unmarshal([]byte(s), &anno); err != nil || anno.Entities == nil {
+		return false
+	}
+	for _, e := range anno.Entities {
+		if e == nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"nutsh/openapi/gen/nutshapi"
+)
+
+func TestPutYjsAnnotation(t *testing.T) {
+	ctx := context.Background()
+	s, project := requireTestServer(t)
+	video, err := s.options.storageVideo.Create(ctx, &nutshapi.CreateVideoReq{ProjectId: project.Id, Name: "video"})
+	require.NoError(t, err)
+
+	put := func(videoId string, body *YjsAnnotation) (int, *YjsAnnotation) {
+		b, err := json.Marshal(body)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(string(b)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("videoId")
+		c.SetParamValues(videoId)
+
+		if err := s.PutYjsAnnotation(c); err != nil {
+			var he *echo.HTTPError
+			require.ErrorAs(t, err, &he)
+			return he.Code, nil
+		}
+		if rec.Code != http.StatusOK {
+			return rec.Code, nil
+		}
+		var resp YjsAnnotation
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, &resp
+	}
+	str := func(s string) *string { return &s }
+
+	// a never annotated video has an empty version
+	code, v1 := put(video.Id, &YjsAnnotation{AnnotationJson: str(`{"entities":{}}`)})
+	require.Equal(t, http.StatusOK, code)
+
+	// a change in between the load and the store
+	patched, err := s.PatchVideoAnnotation(ctx, nutshapi.PatchVideoAnnotationRequestObject{
+		VideoId: video.Id,
+		Body: &nutshapi.PatchVideoAnnotationJSONRequestBody{
+			JsonMergePatch:    `{"entities":{"foo":{"id":"foo"}}}`,
+			AnnotationVersion: v1.AnnotationVersion,
+		},
+	})
+	require.NoError(t, err)
+	v2 := patched.(*nutshapi.PatchVideoAnnotation200JSONResponse).AnnotationVersion
+
+	code, _ = put(video.Id, &YjsAnnotation{AnnotationJson: str(`{"entities":{}}`), AnnotationVersion: v1.AnnotationVersion})
+	require.Equal(t, http.StatusConflict, code)
+
+	code, _ = put(video.Id, &YjsAnnotation{AnnotationJson: str(`{"entities":{"foo":{"id":"foo"}}}`), AnnotationVersion: v2})
+	require.Equal(t, http.StatusOK, code)
+
+	for _, bad := range []string{`[]`, `{}`, `{"entities":"foo"}`, `{"entities":{"foo":1}}`} {
+		code, _ = put(video.Id, &YjsAnnotation{AnnotationJson: str(bad)})
+		require.Equal(t, http.StatusBadRequest, code, bad)
+	}
+
+	code, _ = put("0", &YjsAnnotation{AnnotationJson: str(`{"entities":{}}`)})
+	require.Equal(t, http.StatusNotFound, code)
+	code, _ = put("invalid", &YjsAnnotation{AnnotationJson: str(`{"entities":{}}`)})
+	require.Equal(t, http.StatusNotFound, code)
+}
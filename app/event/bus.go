@@ -0,0 +1,196 @@
+package event
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"nutsh/app/metrics"
+)
+
+const (
+	VideoCreated      = "video.created"
+	VideoUpdated      = "video.updated"
+	VideoDeleted      = "video.deleted"
+	AnnotationUpdated = "annotation.updated"
+	ProjectExported   = "project.exported"
+)
+
+// The number of events buffered for each subscriber. Events are dropped for subscribers which fall behind rather than
+// blocking publishers, unless they subscribe losslessly.
+const subscriberBufferSize = 64
+
+// The number of events queued at most for each lossless subscriber, which bounds the memory held for one stuck for
+// good, while those which only fall behind in bursts never reach it.
+const losslessQueueSize = 10000
+
+type Event struct {
+	Id        string      `json:"id"`
+	Type      string      `json:"type"`
+	ProjectId string      `json:"project_id"`
+	Time      string      `json:"time"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+func New(typ string, pid string, data interface{}) *Event {
+	return &Event{
+		Id:        uuid.NewString(),
+		Type:      typ,
+		ProjectId: pid,
+		Time:      time.Now().UTC().Format(time.RFC3339),
+		Data:      data,
+	}
+}
+
+type Bus interface {
+	Publish(*Event)
+
+	// Subscribe receives events of a project, or of all projects if the project id is empty, until the returned
+	// function is called.
+	Subscribe(pid string) (<-chan *Event, func())
+
+	// SubscribeLossless is like Subscribe, except that events are queued for a subscriber which falls behind rather
+	// than dropped, for those which must not miss any, e.g. webhooks. Events are only dropped once the queue is full,
+	// which is counted in the metrics. Events still queued are discarded once the returned function is called.
+	SubscribeLossless(pid string) (<-chan *Event, func())
+}
+
+func NewBus() Bus {
+	return &mBus{
+		subscribers: make(map[*mSubscriber]struct{}),
+	}
+}
+
+type mBus struct {
+	mu          sync.RWMutex
+	subscribers map[*mSubscriber]struct{}
+}
+
+type mSubscriber struct {
+	pid string
+	ch  chan *Event
+
+	// a lossless subscriber queues events to be forwarded to its channel
+	lossless bool
+	mu       sync.Mutex
+	queue    []*Event
+	queued   chan struct{}
+	done     chan struct{}
+}
+
+func (b *mBus) Publish(e *Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subscribers {
+		if sub.pid != "" && sub.pid != e.ProjectId {
+			continue
+		}
+		if sub.lossless {
+			sub.enqueue(e)
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			zap.L().Warn("dropped event for a slow subscriber", zap.String("type", e.Type), zap.String("project", e.ProjectId))
+			metrics.IncEventDropped(false)
+		}
+	}
+}
+
+func (b *mBus) Subscribe(pid string) (<-chan *Event, func()) {
+	sub := &mSubscriber{
+		pid: pid,
+		ch:  make(chan *Event, subscriberBufferSize),
+	}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, sub)
+			b.mu.Unlock()
+			close(sub.ch)
+		})
+	}
+}
+
+func (b *mBus) SubscribeLossless(pid string) (<-chan *Event, func()) {
+	sub := &mSubscriber{
+		pid:      pid,
+		ch:       make(chan *Event),
+		lossless: true,
+		queued:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go sub.forward()
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, sub)
+			b.mu.Unlock()
+			close(sub.done)
+		})
+	}
+}
+
+func (s *mSubscriber) enqueue(e *Event) {
+	s.mu.Lock()
+	full := len(s.queue) >= losslessQueueSize
+	if !full {
+		s.queue = append(s.queue, e)
+	}
+	s.mu.Unlock()
+	if full {
+		zap.L().Error("dropped event for a lossless subscriber with a full queue", zap.String("type", e.Type), zap.String("project", e.ProjectId))
+		metrics.IncEventDropped(true)
+		return
+	}
+
+	select {
+	case s.queued <- struct{}{}:
+	default:
+	}
+}
+
+// forward sends the queued events in order to the channel, which is closed once unsubscribed.
+func (s *mSubscriber) forward() {
+	defer close(s.ch)
+	for {
+		s.mu.Lock()
+		var e *Event
+		if len(s.queue) > 0 {
+			e = s.queue[0]
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+		}
+		s.mu.Unlock()
+
+		if e == nil {
+			select {
+			case <-s.queued:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		select {
+		case s.ch <- e:
+		case <-s.done:
+			return
+		}
+	}
+}
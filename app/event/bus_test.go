@@ -0,0 +1,88 @@
+package event
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeProject(t *testing.T) {
+	bus := NewBus()
+
+	one, unsubscribeOne := bus.Subscribe("1")
+	defer unsubscribeOne()
+	all, unsubscribeAll := bus.Subscribe("")
+	defer unsubscribeAll()
+
+	bus.Publish(New(VideoCreated, "1", nil))
+	bus.Publish(New(VideoDeleted, "2", nil))
+
+	require.Equal(t, VideoCreated, (<-one).Type)
+	require.Equal(t, 0, len(one))
+	require.Equal(t, VideoCreated, (<-all).Type)
+	require.Equal(t, VideoDeleted, (<-all).Type)
+}
+
+func TestUnsubscribe(t *testing.T) {
+	bus := NewBus()
+
+	ch, unsubscribe := bus.Subscribe("1")
+	unsubscribe()
+	unsubscribe()
+
+	bus.Publish(New(VideoCreated, "1", nil))
+	_, ok := <-ch
+	require.False(t, ok)
+}
+
+func TestSubscribeLossless(t *testing.T) {
+	bus := NewBus()
+
+	lossy, unsubscribeLossy := bus.Subscribe("")
+	defer unsubscribeLossy()
+	lossless, unsubscribeLossless := bus.SubscribeLossless("1")
+
+	// none is received until all are published
+	n := 10 * subscriberBufferSize
+	for i := 0; i < n; i++ {
+		bus.Publish(New(VideoCreated, "1", i))
+		bus.Publish(New(VideoCreated, "2", i))
+	}
+	require.Equal(t, subscriberBufferSize, len(lossy))
+	for i := 0; i < n; i++ {
+		e := <-lossless
+		require.Equal(t, "1", e.ProjectId)
+		require.Equal(t, i, e.Data)
+	}
+
+	unsubscribeLossless()
+	unsubscribeLossless()
+	bus.Publish(New(VideoCreated, "1", nil))
+	_, ok := <-lossless
+	require.False(t, ok)
+}
+
+func TestSubscribeLosslessFull(t *testing.T) {
+	bus := NewBus()
+	lossless, unsubscribe := bus.SubscribeLossless("")
+	defer unsubscribe()
+
+	for i := 0; i < losslessQueueSize+10; i++ {
+		bus.Publish(New(VideoCreated, "1", i))
+	}
+
+	// the event being forwarded has left the queue already
+	received := 0
+	for {
+		select {
+		case <-lossless:
+			received++
+			continue
+		case <-time.After(100 * time.Millisecond):
+		}
+		break
+	}
+	require.GreaterOrEqual(t, received, losslessQueueSize)
+	require.LessOrEqual(t, received, losslessQueueSize+1)
+}
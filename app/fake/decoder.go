@@ -0,0 +1,173 @@
+package fake
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The fields and values used from the ONNX protobuf schema, see
+// https://github.com/onnx/onnx/blob/v1.14.0/onnx/onnx.proto
+const (
+	onnxIrVersion = 7
+	onnxOpset     = 13
+
+	onnxTypeFloat = 1
+	onnxTypeInt64 = 7
+
+	onnxAttributeInt = 2
+)
+
+// Decoder returns a decoder in the ONNX format taking the same input as SAM decoders, which passes the mask input
+// through as its output resized to the original image, and predicts an IoU of 1.
+func Decoder() []byte {
+	var graph []byte
+
+	// nodes
+	graph = onnxAppendMessage(graph, 1, onnxNode("Cast", []string{"orig_im_size"}, []string{"orig_im_size_int"},
+		onnxIntAttribute("to", onnxTypeInt64)))
+	graph = onnxAppendMessage(graph, 1, onnxNode("Concat", []string{"leading_dims", "orig_im_size_int"}, []string{"masks_size"},
+		onnxIntAttribute("axis", 0)))
+	graph = onnxAppendMessage(graph, 1, onnxNode("Resize", []string{"mask_input", "", "", "masks_size"}, []string{"masks"}))
+	graph = onnxAppendMessage(graph, 1, onnxNode("Identity", []string{"iou"}, []string{"iou_predictions"}))
+	graph = onnxAppendMessage(graph, 1, onnxNode("Identity", []string{"mask_input"}, []string{"low_res_masks"}))
+	graph = protowire.AppendTag(graph, 2, protowire.BytesType)
+	graph = protowire.AppendString(graph, "fake_decoder")
+
+	// constants
+	graph = onnxAppendMessage(graph, 5, onnxInt64Tensor("leading_dims", []int64{2}, []int64{1, 1}))
+	graph = onnxAppendMessage(graph, 5, onnxFloatTensor("iou", []int64{1, 1}, []float32{1}))
+
+	// the same inputs as SAM decoders, some of which are unused
+	for _, input := range []struct {
+		name string
+		dims []interface{}
+	}{
+		{"image_embeddings", []interface{}{1, 256, 64, 64}},
+		{"point_coords", []interface{}{1, "num_points", 2}},
+		{"point_labels", []interface{}{1, "num_points"}},
+		{"mask_input", []interface{}{1, 1, 256, 256}},
+		{"has_mask_input", []interface{}{1}},
+		{"orig_im_size", []interface{}{2}},
+	} {
+		graph = onnxAppendMessage(graph, 11, onnxValueInfo(input.name, input.dims))
+	}
+	for _, output := range []struct {
+		name string
+		dims []interface{}
+	}{
+		{"masks", []interface{}{1, 1, "height", "width"}},
+		{"iou_predictions", []interface{}{1, 1}},
+		{"low_res_masks", []interface{}{1, 1, 256, 256}},
+	} {
+		graph = onnxAppendMessage(graph, 12, onnxValueInfo(output.name, output.dims))
+	}
+
+	var model []byte
+	model = protowire.AppendTag(model, 1, protowire.VarintType)
+	model = protowire.AppendVarint(model, onnxIrVersion)
+	model = protowire.AppendTag(model, 2, protowire.BytesType)
+	model = protowire.AppendString(model, "nutsh-fake")
+	model = onnxAppendMessage(model, 7, graph)
+	var opset []byte
+	opset = protowire.AppendTag(opset, 2, protowire.VarintType)
+	opset = protowire.AppendVarint(opset, onnxOpset)
+	model = onnxAppendMessage(model, 8, opset)
+	return model
+}
+
+func onnxAppendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func onnxNode(op string, inputs []string, outputs []string, attrs ...[]byte) []byte {
+	var b []byte
+	for _, input := range inputs {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, input)
+	}
+	for _, output := range outputs {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, output)
+	}
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendString(b, outputs[0])
+	b = protowire.AppendTag(b, 4, protowire.BytesType)
+	b = protowire.AppendString(b, op)
+	for _, attr := range attrs {
+		b = onnxAppendMessage(b, 5, attr)
+	}
+	return b
+}
+
+func onnxIntAttribute(name string, v int64) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, name)
+	b = protowire.AppendTag(b, 3, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(v))
+	b = protowire.AppendTag(b, 20, protowire.VarintType)
+	b = protowire.AppendVarint(b, onnxAttributeInt)
+	return b
+}
+
+func onnxTensorHeader(name string, dataType uint64, dims []int64) []byte {
+	var b []byte
+	for _, d := range dims {
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(d))
+	}
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, dataType)
+	b = protowire.AppendTag(b, 8, protowire.BytesType)
+	b = protowire.AppendString(b, name)
+	return b
+}
+
+func onnxInt64Tensor(name string, dims []int64, values []int64) []byte {
+	b := onnxTensorHeader(name, onnxTypeInt64, dims)
+	raw := make([]byte, 8*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(raw[8*i:], uint64(v))
+	}
+	b = protowire.AppendTag(b, 9, protowire.BytesType)
+	return protowire.AppendBytes(b, raw)
+}
+
+func onnxFloatTensor(name string, dims []int64, values []float32) []byte {
+	b := onnxTensorHeader(name, onnxTypeFloat, dims)
+	var raw bytes.Buffer
+	binary.Write(&raw, binary.LittleEndian, values)
+	b = protowire.AppendTag(b, 9, protowire.BytesType)
+	return protowire.AppendBytes(b, raw.Bytes())
+}
+
+// onnxValueInfo describes a float tensor, whose dimensions are either numbers or names of dynamic ones.
+func onnxValueInfo(name string, dims []interface{}) []byte {
+	var shape []byte
+	for _, d := range dims {
+		var dim []byte
+		switch d := d.(type) {
+		case int:
+			dim = protowire.AppendTag(dim, 1, protowire.VarintType)
+			dim = protowire.AppendVarint(dim, uint64(d))
+		case string:
+			dim = protowire.AppendTag(dim, 2, protowire.BytesType)
+			dim = protowire.AppendString(dim, d)
+		}
+		shape = onnxAppendMessage(shape, 1, dim)
+	}
+
+	var tensor []byte
+	tensor = protowire.AppendTag(tensor, 1, protowire.VarintType)
+	tensor = protowire.AppendVarint(tensor, onnxTypeFloat)
+	tensor = onnxAppendMessage(tensor, 2, shape)
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, name)
+	b = onnxAppendMessage(b, 2, onnxAppendMessage(nil, 1, tensor))
+	return b
+}
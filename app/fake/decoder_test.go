@@ -0,0 +1,92 @@
+package fake
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// parseFields parses a protobuf message into its fields by number, keeping the raw bytes of those of the bytes type.
+func parseFields(t *testing.T, b []byte) map[protowire.Number][]interface{} {
+	fields := make(map[protowire.Number][]interface{})
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.GreaterOrEqual(t, n, 0)
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			require.GreaterOrEqual(t, n, 0)
+			fields[num] = append(fields[num], v)
+			b = b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			require.GreaterOrEqual(t, n, 0)
+			fields[num] = append(fields[num], v)
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %v", typ)
+		}
+	}
+	return fields
+}
+
+func TestDecoder(t *testing.T) {
+	model := parseFields(t, Decoder())
+	require.Equal(t, []interface{}{uint64(onnxIrVersion)}, model[1])
+	require.Equal(t, []interface{}{uint64(onnxOpset)}, parseFields(t, model[8][0].([]byte))[2])
+
+	graph := parseFields(t, model[7][0].([]byte))
+	names := func(num protowire.Number) []string {
+		// the name of a tensor is its 8th field while that of a value info is the first
+		nameNum := protowire.Number(1)
+		if num == 5 {
+			nameNum = 8
+		}
+		var names []string
+		for _, v := range graph[num] {
+			names = append(names, string(parseFields(t, v.([]byte))[nameNum][0].([]byte)))
+		}
+		return names
+	}
+	require.Equal(t, []string{"image_embeddings", "point_coords", "point_labels", "mask_input", "has_mask_input", "orig_im_size"}, names(11))
+	require.Equal(t, []string{"masks", "iou_predictions", "low_res_masks"}, names(12))
+
+	// every input of a node is either an input of the graph, an initializer, or an output of a preceding node
+	defined := map[string]bool{"": true}
+	for _, name := range append(names(11), names(5)...) {
+		defined[name] = true
+	}
+	var ops []string
+	for _, v := range graph[1] {
+		node := parseFields(t, v.([]byte))
+		for _, input := range node[1] {
+			require.True(t, defined[string(input.([]byte))], string(input.([]byte)))
+		}
+		for _, output := range node[2] {
+			defined[string(output.([]byte))] = true
+		}
+		ops = append(ops, string(node[4][0].([]byte)))
+	}
+	require.Equal(t, []string{"Cast", "Concat", "Resize", "Identity", "Identity"}, ops)
+	for _, name := range names(12) {
+		require.True(t, defined[name], name)
+	}
+}
+
+func TestEmbedding(t *testing.T) {
+	npy := Embedding()
+	require.Equal(t, "\x93NUMPY\x01\x00", string(npy[:8]))
+
+	headerLen := int(binary.LittleEndian.Uint16(npy[8:10]))
+	require.Zero(t, (10+headerLen)%64)
+	header := string(npy[10 : 10+headerLen])
+	require.True(t, strings.HasPrefix(header, "{'descr': '<f4', 'fortran_order': False, 'shape': (1, 256, 64, 64), }"))
+	require.True(t, strings.HasSuffix(header, "\n"))
+
+	require.True(t, bytes.Equal(make([]byte, 4*256*64*64), npy[10+headerLen:]))
+}
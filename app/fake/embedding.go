@@ -0,0 +1,88 @@
+package fake
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	servicev1 "nutsh/proto/gen/go/service/v1"
+)
+
+// The fake embedding has the shape of those of SAM, i.e. 256 channels of 64x64.
+var embeddingShape = []int{1, 256, 64, 64}
+
+// Embedding returns an all-zero float32 embedding in the NPY format.
+func Embedding() []byte {
+	n := 1
+	for _, d := range embeddingShape {
+		n *= d
+	}
+
+	// https://numpy.org/doc/stable/reference/generated/numpy.lib.format.html
+	header := fmt.Sprintf("{'descr': '<f4', 'fortran_order': False, 'shape': (%d, %d, %d, %d), }",
+		embeddingShape[0], embeddingShape[1], embeddingShape[2], embeddingShape[3])
+	// the magic, the version and the length of the header precede it, all of which are padded with spaces to a
+	// multiple of 64 bytes ending with a newline
+	preamble := 6 + 2 + 2
+	if pad := (64 - (preamble+len(header)+1)%64) % 64; pad > 0 {
+		header += string(bytes.Repeat([]byte{' '}, pad))
+	}
+	header += "\n"
+
+	var buf bytes.Buffer
+	buf.WriteString("\x93NUMPY")
+	buf.Write([]byte{1, 0})
+	binary.Write(&buf, binary.LittleEndian, uint16(len(header)))
+	buf.WriteString(header)
+	buf.Write(make([]byte, 4*n))
+	return buf.Bytes()
+}
+
+func (s *mOnlineSegmentationServer) EmbedImage(ctx context.Context, req *servicev1.EmbedImageRequest) (*servicev1.EmbedImageResponse, error) {
+	if err := checkDecoderUuid(req.GetDecoderUuid()); err != nil {
+		return nil, err
+	}
+	return &servicev1.EmbedImageResponse{EmbeddedImageNpy: s.embedding}, nil
+}
+
+func (s *mOnlineSegmentationServer) EmbedImages(stream servicev1.OnlineSegmentationService_EmbedImagesServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		resp := &servicev1.EmbedImagesResponse{Id: req.GetId()}
+		if err := checkDecoderUuid(req.GetImage().GetDecoderUuid()); err != nil {
+			resp.Error = status.Convert(err).Message()
+		} else {
+			resp.Result = &servicev1.EmbedImageResponse{EmbeddedImageNpy: s.embedding}
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *mOnlineSegmentationServer) GetDecoder(ctx context.Context, req *servicev1.GetDecoderRequest) (*servicev1.GetDecoderResponse, error) {
+	if err := checkDecoderUuid(req.GetUuid()); err != nil {
+		return nil, err
+	}
+	return &servicev1.GetDecoderResponse{DecoderOnnx: s.decoder, Uuid: DecoderUuid}, nil
+}
+
+// checkDecoderUuid accepts the fake decoder, or none which stands for the default one.
+func checkDecoderUuid(uuid string) error {
+	if uuid != "" && uuid != DecoderUuid {
+		return status.Errorf(codes.NotFound, "unaccepted decoder uuid %s", uuid)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package fake
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	servicev1 "nutsh/proto/gen/go/service/v1"
+)
+
+// ServerOptions returns the options of a gRPC server which inject latency and errors into the services it serves,
+// except for the health checks and the introspection of the segmentation service.
+func ServerOptions(opts ...Option) []grpc.ServerOption {
+	o := &Options{errorCode: codes.Unavailable}
+	for _, opt := range opts {
+		opt(o)
+	}
+	in := &injector{
+		Options: *o,
+		rand:    rand.New(rand.NewSource(o.seed)),
+	}
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(in.unary),
+		grpc.ChainStreamInterceptor(in.stream),
+	}
+}
+
+type injector struct {
+	Options
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func (in *injector) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if isExempt(info.FullMethod) {
+		return handler(ctx, req)
+	}
+	if err := in.inject(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (in *injector) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if isExempt(info.FullMethod) {
+		return handler(srv, ss)
+	}
+	if err := in.inject(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, &delayedStream{ServerStream: ss, in: in})
+}
+
+// isExempt tells whether the method is one clients probe to decide whether the server is usable at all, which would
+// otherwise be taken out of rotation instead of exercising how the services are handled.
+func isExempt(method string) bool {
+	return strings.HasPrefix(method, "/"+healthpb.Health_ServiceDesc.ServiceName+"/") ||
+		method == "/"+servicev1.OnlineSegmentationService_ServiceDesc.ServiceName+"/Introspect"
+}
+
+// inject waits for the latency and then decides whether the request fails.
+func (in *injector) inject(ctx context.Context, method string) error {
+	if err := in.delay(ctx); err != nil {
+		return err
+	}
+	if in.errorRate <= 0 {
+		return nil
+	}
+	in.mu.Lock()
+	fail := in.rand.Float64() < in.errorRate
+	in.mu.Unlock()
+	if fail {
+		return status.Errorf(in.errorCode, "injected failure of %s", method)
+	}
+	return nil
+}
+
+func (in *injector) delay(ctx context.Context) error {
+	d := in.latency
+	if in.jitter > 0 {
+		in.mu.Lock()
+		d += time.Duration(in.rand.Int63n(int64(in.jitter)))
+		in.mu.Unlock()
+	}
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// delayedStream delays each message sent.
+type delayedStream struct {
+	grpc.ServerStream
+	in *injector
+}
+
+func (s *delayedStream) SendMsg(m interface{}) error {
+	if err := s.in.delay(s.Context()); err != nil {
+		return err
+	}
+	return s.ServerStream.SendMsg(m)
+}
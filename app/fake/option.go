@@ -0,0 +1,44 @@
+package fake
+
+import (
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+type Options struct {
+	latency time.Duration
+	jitter  time.Duration
+
+	errorRate float64
+	errorCode codes.Code
+
+	seed int64
+}
+
+type Option func(*Options)
+
+// WithLatency delays each request, and each message streamed back, by the latency plus a random duration up to the
+// jitter.
+func WithLatency(latency, jitter time.Duration) Option {
+	return func(o *Options) {
+		o.latency = latency
+		o.jitter = jitter
+	}
+}
+
+// WithErrorRate fails the fraction of requests with the code before they reach the services.
+func WithErrorRate(rate float64, code codes.Code) Option {
+	return func(o *Options) {
+		o.errorRate = rate
+		o.errorCode = code
+	}
+}
+
+// WithSeed seeds the randomness of the jitter and the failures, which are the same across runs with the same seed
+// given the same order of requests.
+func WithSeed(seed int64) Option {
+	return func(o *Options) {
+		o.seed = seed
+	}
+}
@@ -0,0 +1,65 @@
+package fake
+
+// This function is translated from the `encodeRLE function in `app/frontend/src/common/algorithm/rle.ts`.
+func encodeRLE(mask []bool) []int {
+	var counts []int
+
+	N := len(mask)
+	n := 0
+	for i := 0; i < N; i++ {
+		if !mask[i] {
+			n++
+			continue
+		}
+		counts = append(counts, n)
+		n = 1
+		for i+1 < N && mask[i] == mask[i+1] {
+			n++
+			i++
+		}
+		counts = append(counts, n)
+		n = 0
+	}
+	if n > 0 {
+		counts = append(counts, n)
+	}
+
+	return counts
+}
+
+// This function is translated from the `rleCountsToStringCOCO` function in `app/frontend/src/common/algorithm/rle.ts`.
+func rleCountsToStringCOCO(counts []int) string {
+	m := len(counts)
+	s := make([]int, m*6)
+	p := 0
+	for i := 0; i < m; i++ {
+		x := counts[i]
+		if i > 2 {
+			x -= counts[i-2]
+		}
+		more := true
+		for more {
+			c := x & 0x1f
+			x >>= 5
+			if c&0x10 > 0 {
+				more = x != -1
+			} else {
+				more = x != 0
+			}
+			if more {
+				c |= 0x20
+			}
+			c += 48
+			s[p] = c
+			p++
+		}
+	}
+	str := ""
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0 {
+			break
+		}
+		str += string(rune(s[i]))
+	}
+	return str
+}
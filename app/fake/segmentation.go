@@ -0,0 +1,168 @@
+// Package fake implements the model services deterministically without running any model, for tests.
+package fake
+
+import (
+	"bytes"
+	"context"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	schemav1 "nutsh/proto/gen/go/schema/v1"
+	servicev1 "nutsh/proto/gen/go/service/v1"
+)
+
+// DecoderUuid identifies the decoder of the fake online segmentation server.
+const DecoderUuid = "fake"
+
+// decoderFeedJs constructs the input to the fake decoder in the same way as SAM, except that the clicks are painted as
+// discs on the mask input, which the decoder passes through as the predicted mask.
+const decoderFeedJs = `(input, Tensor) => {
+  const { embedding, size, clicks } = input;
+  const [w, h] = size;
+  const n = clicks.length;
+
+  const coords = new Float32Array(2 * (n + 1));
+  const labels = new Float32Array(n + 1);
+  labels[n] = -1;
+
+  // the mask input is stretched from the image, and a click covers an eighth of the shorter side
+  const S = 256;
+  const mask = new Float32Array(S * S).fill(-1);
+  const r = Math.max(1, Math.min(w, h) / 8);
+  const paint = (x, y, value) => {
+    for (let i = 0; i < S; i++) {
+      for (let j = 0; j < S; j++) {
+        const dx = ((j + 0.5) * w) / S - x;
+        const dy = ((i + 0.5) * h) / S - y;
+        if (dx * dx + dy * dy <= r * r) {
+          mask[i * S + j] = value;
+        }
+      }
+    }
+  };
+  clicks.forEach(({ x, y, isPositive }, i) => {
+    coords[2 * i] = x;
+    coords[2 * i + 1] = y;
+    labels[i] = isPositive ? 1 : 0;
+    if (isPositive) {
+      paint(x, y, 1);
+    }
+  });
+  clicks.forEach(({ x, y, isPositive }) => {
+    if (!isPositive) {
+      paint(x, y, -1);
+    }
+  });
+
+  return {
+    image_embeddings: embedding,
+    point_coords: new Tensor("float32", coords, [1, n + 1, 2]),
+    point_labels: new Tensor("float32", labels, [1, n + 1]),
+    orig_im_size: new Tensor("float32", [h, w]),
+    mask_input: new Tensor("float32", mask, [1, 1, S, S]),
+    has_mask_input: new Tensor("float32", [1]),
+  };
+};
+`
+
+// NewOnlineSegmentationServer returns a server whose embeddings are all zeros, and whose decoder in the browser predicts
+// discs around the clicks, similar to those predicted by Segment on the server.
+func NewOnlineSegmentationServer() servicev1.OnlineSegmentationServiceServer {
+	return &mOnlineSegmentationServer{
+		embedding: Embedding(),
+		decoder:   Decoder(),
+	}
+}
+
+type mOnlineSegmentationServer struct {
+	servicev1.UnimplementedOnlineSegmentationServiceServer
+
+	embedding []byte
+	decoder   []byte
+}
+
+func (s *mOnlineSegmentationServer) Introspect(ctx context.Context, req *servicev1.IntrospectRequest) (*servicev1.IntrospectResponse, error) {
+	return &servicev1.IntrospectResponse{
+		DecoderUuid:   DecoderUuid,
+		DecoderFeedJs: decoderFeedJs,
+		Decoders: []*servicev1.DecoderInfo{
+			{Uuid: DecoderUuid, FeedJs: decoderFeedJs, Name: "fake"},
+		},
+	}, nil
+}
+
+func (s *mOnlineSegmentationServer) Segment(ctx context.Context, req *servicev1.SegmentRequest) (*servicev1.SegmentResponse, error) {
+	if err := checkDecoderUuid(req.GetDecoderUuid()); err != nil {
+		return nil, err
+	}
+	return Segment(req)
+}
+
+// Segment predicts the union of the box and discs around positive points, excluding discs around negative points. The
+// radius of the discs is an eighth of the shorter side of the image.
+func Segment(req *servicev1.SegmentRequest) (*servicev1.SegmentResponse, error) {
+	box := req.GetBox()
+	hasBox := box != nil && box.Width > 0 && box.Height > 0
+	if len(req.GetPointPrompts()) == 0 && !hasBox {
+		return nil, status.Error(codes.InvalidArgument, "missing point prompts or box")
+	}
+
+	var w, h int
+	if crop := req.GetCrop(); crop != nil && crop.Width > 0 && crop.Height > 0 {
+		w, h = int(crop.Width), int(crop.Height)
+	} else {
+		config, _, err := image.DecodeConfig(bytes.NewReader(req.GetOriginalImage()))
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "failed to decode image: %v", err)
+		}
+		w, h = config.Width, config.Height
+	}
+
+	r := float32(min(w, h)) / 8
+	if r < 1 {
+		r = 1
+	}
+	near := func(x, y int, positive bool) bool {
+		for _, p := range req.GetPointPrompts() {
+			if p.IsPositive != positive {
+				continue
+			}
+			dx, dy := float32(x)+0.5-p.X, float32(y)+0.5-p.Y
+			if dx*dx+dy*dy <= r*r {
+				return true
+			}
+		}
+		return false
+	}
+
+	// column major
+	mask := make([]bool, w*h)
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			in := hasBox && x >= int(box.X) && x < int(box.X+box.Width) && y >= int(box.Y) && y < int(box.Y+box.Height)
+			mask[x*h+y] = (in || near(x, y, true)) && !near(x, y, false)
+		}
+	}
+
+	return &servicev1.SegmentResponse{
+		Mask: &schemav1.Mask{
+			CocoEncodedRle: rleCountsToStringCOCO(encodeRLE(mask)),
+			Size: &schemav1.GridSize{
+				Width:  uint32(w),
+				Height: uint32(h),
+			},
+		},
+		Score: 1,
+	}, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,58 @@
+package fake
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	schemav1 "nutsh/proto/gen/go/schema/v1"
+	servicev1 "nutsh/proto/gen/go/service/v1"
+)
+
+func TestSegmentBox(t *testing.T) {
+	resp, err := Segment(&servicev1.SegmentRequest{
+		Box:  &schemav1.GridRect{X: 1, Y: 1, Width: 2, Height: 2},
+		Crop: &schemav1.GridRect{X: 10, Y: 10, Width: 4, Height: 4},
+	})
+	require.NoError(t, err)
+	require.Equal(t, &schemav1.GridSize{Width: 4, Height: 4}, resp.Mask.Size)
+
+	// column major
+	mask := make([]bool, 16)
+	for _, i := range []int{5, 6, 9, 10} {
+		mask[i] = true
+	}
+	require.Equal(t, []int{5, 2, 2, 2, 5}, encodeRLE(mask))
+	require.Equal(t, rleCountsToStringCOCO(encodeRLE(mask)), resp.Mask.CocoEncodedRle)
+}
+
+func TestSegmentPoints(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 8))))
+
+	// a positive point on the left whose disc is cut by a negative point
+	resp, err := Segment(&servicev1.SegmentRequest{
+		OriginalImage: buf.Bytes(),
+		PointPrompts: []*schemav1.PointPrompt{
+			{X: 2, Y: 4, IsPositive: true},
+			{X: 3, Y: 4},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, &schemav1.GridSize{Width: 16, Height: 8}, resp.Mask.Size)
+
+	again, err := Segment(&servicev1.SegmentRequest{
+		OriginalImage: buf.Bytes(),
+		PointPrompts: []*schemav1.PointPrompt{
+			{X: 2, Y: 4, IsPositive: true},
+		},
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, again.Mask.CocoEncodedRle, resp.Mask.CocoEncodedRle)
+
+	_, err = Segment(&servicev1.SegmentRequest{OriginalImage: buf.Bytes()})
+	require.Error(t, err)
+}
@@ -0,0 +1,124 @@
+package fake
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	schemav1 "nutsh/proto/gen/go/schema/v1"
+	servicev1 "nutsh/proto/gen/go/service/v1"
+)
+
+// NewTrackServer returns a server which propagates the mask of each object on the keyframe by copying it to each
+// tracked frame, without ever fetching the images.
+func NewTrackServer() servicev1.TrackServiceServer {
+	return &mTrackServer{}
+}
+
+type mTrackServer struct {
+	servicev1.UnimplementedTrackServiceServer
+}
+
+func (s *mTrackServer) Track(ctx context.Context, req *servicev1.TrackRequest) (*servicev1.TrackResponse, error) {
+	masks, err := Track(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.GetImageUris()) > 0 {
+		return &servicev1.TrackResponse{FrameMasks: masks}, nil
+	}
+
+	resp := &servicev1.TrackResponse{}
+	for _, m := range masks {
+		resp.SubsequentImageMasks = append(resp.SubsequentImageMasks, m.Mask)
+	}
+	return resp, nil
+}
+
+func (s *mTrackServer) TrackStream(req *servicev1.TrackRequest, stream servicev1.TrackService_TrackStreamServer) error {
+	masks, err := Track(req)
+	if err != nil {
+		return err
+	}
+	for _, m := range masks {
+		if err := stream.Send(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Track returns a copy of the mask of each object on the keyframe for each tracked frame, in the order of tracking,
+// i.e. away from the keyframe, and forward before backward when tracking in both directions.
+func Track(req *servicev1.TrackRequest) ([]*servicev1.FrameMask, error) {
+	n, keyframe, masks, direction, err := trackRange(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []int
+	if direction != servicev1.TrackDirection_TRACK_DIRECTION_BACKWARD {
+		for i := keyframe + 1; i < n; i++ {
+			frames = append(frames, i)
+		}
+	}
+	if direction == servicev1.TrackDirection_TRACK_DIRECTION_BACKWARD || direction == servicev1.TrackDirection_TRACK_DIRECTION_BOTH {
+		for i := keyframe - 1; i >= 0; i-- {
+			frames = append(frames, i)
+		}
+	}
+
+	var results []*servicev1.FrameMask
+	for _, i := range frames {
+		for _, m := range masks {
+			results = append(results, &servicev1.FrameMask{
+				FrameIndex: uint32(i),
+				Mask:       proto.Clone(m.Mask).(*schemav1.Mask),
+				EntityId:   m.EntityId,
+			})
+		}
+	}
+	return results, nil
+}
+
+// trackRange returns the number of images, the keyframe, the masks on it and the direction of a request in either form.
+func trackRange(req *servicev1.TrackRequest) (int, int, []*servicev1.EntityMask, servicev1.TrackDirection, error) {
+	forward := servicev1.TrackDirection_TRACK_DIRECTION_FORWARD
+	if len(req.GetImageUris()) == 0 {
+		mask := req.GetFirstImageMask()
+		if !validMask(mask) {
+			return 0, 0, nil, 0, status.Error(codes.InvalidArgument, "missing the mask of the first image")
+		}
+		return 1 + len(req.GetSubsequentImageUris()), 0, []*servicev1.EntityMask{{Mask: mask}}, forward, nil
+	}
+
+	n := len(req.GetImageUris())
+	keyframe := int(req.GetKeyframeIndex())
+	if keyframe >= n {
+		return 0, 0, nil, 0, status.Errorf(codes.InvalidArgument, "keyframe %d out of %d images", keyframe, n)
+	}
+	if len(req.GetKeyframeMasks()) == 0 {
+		return 0, 0, nil, 0, status.Error(codes.InvalidArgument, "missing masks of the keyframe")
+	}
+	for _, m := range req.GetKeyframeMasks() {
+		if !validMask(m.GetMask()) {
+			return 0, 0, nil, 0, status.Errorf(codes.InvalidArgument, "invalid mask of entity %s", m.GetEntityId())
+		}
+	}
+
+	direction := req.GetDirection()
+	switch direction {
+	case servicev1.TrackDirection_TRACK_DIRECTION_UNSPECIFIED:
+		direction = forward
+	case servicev1.TrackDirection_TRACK_DIRECTION_FORWARD, servicev1.TrackDirection_TRACK_DIRECTION_BACKWARD, servicev1.TrackDirection_TRACK_DIRECTION_BOTH:
+	default:
+		return 0, 0, nil, 0, status.Errorf(codes.InvalidArgument, "unknown direction %d", direction)
+	}
+	return n, keyframe, req.GetKeyframeMasks(), direction, nil
+}
+
+func validMask(mask *schemav1.Mask) bool {
+	return mask.GetCocoEncodedRle() != "" && mask.GetSize() != nil
+}
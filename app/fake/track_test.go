@@ -0,0 +1,141 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	schemav1 "nutsh/proto/gen/go/schema/v1"
+	servicev1 "nutsh/proto/gen/go/service/v1"
+)
+
+func requireConn(t *testing.T, opts ...Option) *grpc.ClientConn {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := grpc.NewServer(ServerOptions(opts...)...)
+	servicev1.RegisterOnlineSegmentationServiceServer(s, NewOnlineSegmentationServer())
+	servicev1.RegisterTrackServiceServer(s, NewTrackServer())
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestTrackStream(t *testing.T) {
+	ctx := context.Background()
+	client := servicev1.NewTrackServiceClient(requireConn(t))
+	mask := &schemav1.Mask{CocoEncodedRle: "12", Size: &schemav1.GridSize{Width: 3, Height: 4}}
+
+	stream, err := client.TrackStream(ctx, &servicev1.TrackRequest{
+		FirstImageUri:       "https://example.com/0.jpg",
+		FirstImageMask:      mask,
+		SubsequentImageUris: []string{"https://example.com/1.jpg", "https://example.com/2.jpg"},
+	})
+	require.NoError(t, err)
+	var indices []uint32
+	for {
+		m, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.True(t, proto.Equal(mask, m.Mask))
+		indices = append(indices, m.FrameIndex)
+	}
+	// the first image has index 0
+	require.Equal(t, []uint32{1, 2}, indices)
+
+	_, err = client.Track(ctx, &servicev1.TrackRequest{SubsequentImageUris: []string{"https://example.com/1.jpg"}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestTrackRange(t *testing.T) {
+	mask := &schemav1.Mask{CocoEncodedRle: "12", Size: &schemav1.GridSize{Width: 3, Height: 4}}
+	req := &servicev1.TrackRequest{
+		ImageUris:     []string{"0.jpg", "1.jpg", "2.jpg", "3.jpg"},
+		KeyframeIndex: 2,
+		KeyframeMasks: []*servicev1.EntityMask{{EntityId: "a", Mask: mask}, {EntityId: "b", Mask: mask}},
+	}
+	frames := func(direction servicev1.TrackDirection) []string {
+		req.Direction = direction
+		masks, err := Track(req)
+		require.NoError(t, err)
+		var frames []string
+		for _, m := range masks {
+			require.True(t, proto.Equal(mask, m.Mask))
+			frames = append(frames, fmt.Sprintf("%s%d", m.EntityId, m.FrameIndex))
+		}
+		return frames
+	}
+	require.Equal(t, []string{"a3", "b3"}, frames(servicev1.TrackDirection_TRACK_DIRECTION_UNSPECIFIED))
+	require.Equal(t, []string{"a1", "b1", "a0", "b0"}, frames(servicev1.TrackDirection_TRACK_DIRECTION_BACKWARD))
+	require.Equal(t, []string{"a3", "b3", "a1", "b1", "a0", "b0"}, frames(servicev1.TrackDirection_TRACK_DIRECTION_BOTH))
+
+	req.KeyframeIndex = 4
+	_, err := Track(req)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestInject(t *testing.T) {
+	ctx := context.Background()
+	failures := func(seed int64) []bool {
+		client := servicev1.NewOnlineSegmentationServiceClient(requireConn(t,
+			WithErrorRate(0.5, codes.ResourceExhausted),
+			WithSeed(seed),
+		))
+		var failures []bool
+		for i := 0; i < 20; i++ {
+			_, err := client.GetDecoder(ctx, &servicev1.GetDecoderRequest{})
+			if err != nil {
+				require.Equal(t, codes.ResourceExhausted, status.Code(err))
+			}
+			failures = append(failures, err != nil)
+		}
+		return failures
+	}
+	first := failures(1)
+	require.Contains(t, first, true)
+	require.Contains(t, first, false)
+	require.Equal(t, first, failures(1))
+
+	client := servicev1.NewOnlineSegmentationServiceClient(requireConn(t, WithLatency(50*time.Millisecond, 0)))
+	start := time.Now()
+	resp, err := client.GetDecoder(ctx, &servicev1.GetDecoderRequest{})
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	require.Equal(t, Decoder(), resp.DecoderOnnx)
+
+	c, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = client.EmbedImage(c, &servicev1.EmbedImageRequest{})
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func TestInjectExemptProbes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	conn := requireConn(t, WithLatency(time.Second, 0), WithErrorRate(1, codes.Unavailable))
+
+	_, err := servicev1.NewOnlineSegmentationServiceClient(conn).Introspect(ctx, &servicev1.IntrospectRequest{})
+	require.NoError(t, err)
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+}
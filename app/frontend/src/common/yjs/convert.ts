@@ -76,6 +76,68 @@ export function writeAnnotationToYjs(anno: Annotation, doc: Y.Doc): void {
   });
 }
 
+// Applies the changes from the base to the latest version of an annotation onto the doc, keeping the edits made in the
+// doc since the base to everything else, such that concurrent changes of different components or categories are merged.
+export function mergeAnnotationIntoYjs(base: Annotation, latest: Annotation, doc: Y.Doc): void {
+  const baseComps = flattenComponents(base);
+  const latestComps = flattenComponents(latest);
+  const baseCats = flattenEntityCategories(base);
+  const latestCats = flattenEntityCategories(latest);
+
+  doc.transact(() => {
+    latestComps.forEach((c, cid) => {
+      const old = baseComps.get(cid);
+      if (!old || JSON.stringify(old) !== JSON.stringify(c)) {
+        writeComponent(doc, c.comp, c.eid, c.sidx);
+      }
+    });
+    baseComps.forEach((c, cid) => {
+      if (!latestComps.has(cid)) {
+        deleteComponent(doc, cid, c.comp.type);
+      }
+    });
+
+    const cats = yjsEntityCategoriesMap(doc);
+    latestCats.forEach((entries, key) => {
+      if (JSON.stringify(baseCats.get(key)) !== JSON.stringify(entries)) {
+        cats.set(key, entries);
+      }
+    });
+    baseCats.forEach((_, key) => {
+      if (!latestCats.has(key)) {
+        cats.delete(key);
+      }
+    });
+  });
+}
+
+function flattenComponents(anno: Annotation): Map<ComponentId, {comp: Component; eid: EntityId; sidx: SliceIndex}> {
+  const comps = new Map<ComponentId, {comp: Component; eid: EntityId; sidx: SliceIndex}>();
+  Object.entries(anno.entities).forEach(([eid, entity]) => {
+    Object.entries(entity.geometry.slices).forEach(([sidx, slice]) => {
+      Object.values(slice).forEach(comp => {
+        comps.set(comp.id, {comp, eid, sidx: parseInt(sidx)});
+      });
+    });
+  });
+  return comps;
+}
+
+function flattenEntityCategories(anno: Annotation): Map<string, string[]> {
+  const cats = new Map<string, string[]>();
+  Object.entries(anno.entities).forEach(([eid, entity]) => {
+    Object.entries(entity.globalCategories || {}).forEach(([category, entries]) => {
+      cats.set(encodeEntityCategoryMapKey({category, eid}), Object.keys(entries));
+    });
+    Object.entries(entity.sliceCategories || {}).forEach(([sidx, scats]) => {
+      Object.entries(scats).forEach(([category, entries]) => {
+        cats.set(encodeEntityCategoryMapKey({category, eid, sidx: parseInt(sidx)}), Object.keys(entries));
+      });
+    });
+  });
+  return cats;
+}
+
 export function readComponent(doc: Y.Doc, cid: ComponentId, info: YjsComponent): Component | undefined {
   const comps = yjsComponentMap(doc);
   const anchors = yjsRectangleAnchorsMap(doc);
@@ -148,6 +210,21 @@ function writeComponent(doc: Y.Doc, comp: Component, eid: EntityId, sidx: SliceI
   }
 }
 
+function deleteComponent(doc: Y.Doc, cid: ComponentId, type: Component['type']): void {
+  yjsComponentMap(doc).delete(cid);
+  switch (type) {
+    case 'mask':
+      yjsMaskMap(doc).delete(cid);
+      break;
+    case 'polychain':
+      yjsPolychainVerticesMap(doc).delete(cid);
+      break;
+    case 'rectangle':
+      yjsRectangleAnchorsMap(doc).delete(cid);
+      break;
+  }
+}
+
 function writeEntityCategory(doc: Y.Doc, entries: string[], category: string, eid: EntityId, sidx?: SliceIndex): void {
   const map = yjsEntityCategoriesMap(doc);
   const key = encodeEntityCategoryMapKey({category, eid, sidx});
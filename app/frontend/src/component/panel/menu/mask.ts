@@ -64,7 +64,7 @@ export function useActions(mask: MaskComponent, eid: EntityId): Action[] {
               return;
             }
 
-            const total = req.subsequent_frame_urls.length;
+            const total = subsequentSliceUrls.length;
             let maxFrameIndex = 0;
 
             // one or more results might be concatecated together
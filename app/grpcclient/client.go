@@ -0,0 +1,206 @@
+package grpcclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+)
+
+// Client is a long-lived connection to a gRPC server, which is shared by all calls and reconnects automatically.
+type Client struct {
+	name   string
+	target string
+	conn   *grpc.ClientConn
+}
+
+func New(name string, target string, opts ...Option) (*Client, error) {
+	o := &Options{
+		timeout:     2 * time.Minute,
+		maxAttempts: 3,
+		keepalive:   5 * time.Minute,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	dialOpts, err := dialOptions(o)
+	if err != nil {
+		return nil, err
+	}
+
+	// dialing does not block, thus an unavailable server does not fail the creation
+	conn, err := grpc.Dial(target, dialOpts...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &Client{
+		name:   name,
+		target: target,
+		conn:   conn,
+	}, nil
+}
+
+func (c *Client) Name() string {
+	return c.name
+}
+
+func (c *Client) Conn() *grpc.ClientConn {
+	return c.conn
+}
+
+func (c *Client) State() connectivity.State {
+	return c.conn.GetState()
+}
+
+// Check waits until the connection is ready, or fails if the connection fails or the context is done.
+func (c *Client) Check(ctx context.Context) error {
+	c.conn.Connect()
+	for {
+		state := c.conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			return errors.Errorf("connection to %s is %s", c.target, state)
+		}
+		if !c.conn.WaitForStateChange(ctx, state) {
+			return errors.Errorf("connection to %s is %s: %s", c.target, state, ctx.Err())
+		}
+	}
+}
+
+func (c *Client) Close() error {
+	return errors.WithStack(c.conn.Close())
+}
+
+func dialOptions(o *Options) ([]grpc.DialOption, error) {
+	var opts []grpc.DialOption
+
+	// transport
+	if o.tlsEnabled() {
+		config, err := tlsConfig(o)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	if o.token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(&tokenCredentials{
+			token:  o.token,
+			secure: o.tlsEnabled(),
+		}))
+	}
+
+	// retry
+	if o.maxAttempts > 1 {
+		opts = append(opts, grpc.WithDefaultServiceConfig(retryServiceConfig(o.maxAttempts)))
+	}
+
+	// keepalive
+	if o.keepalive > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    o.keepalive,
+			Timeout: 20 * time.Second,
+		}))
+	}
+
+	// deadline
+	if o.timeout > 0 {
+		opts = append(opts, grpc.WithChainUnaryInterceptor(timeoutInterceptor(o.timeout)))
+	}
+
+	return append(opts, o.dialOptions...), nil
+}
+
+func tlsConfig(o *Options) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: o.tlsServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if o.tlsCaFile != "" {
+		pem, err := os.ReadFile(o.tlsCaFile)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificate found in %s", o.tlsCaFile)
+		}
+		config.RootCAs = pool
+	}
+	if o.tlsCertFile != "" || o.tlsKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.tlsCertFile, o.tlsKeyFile)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// retryServiceConfig retries all methods failing with `UNAVAILABLE`, which is returned when the server is unreachable
+// and thus safe to retry.
+// https://github.com/grpc/grpc/blob/master/doc/service_config.md
+func retryServiceConfig(maxAttempts int) string {
+	config := map[string]interface{}{
+		"methodConfig": []interface{}{
+			map[string]interface{}{
+				"name": []interface{}{map[string]interface{}{}},
+				"retryPolicy": map[string]interface{}{
+					"maxAttempts":          maxAttempts,
+					"initialBackoff":       "0.2s",
+					"maxBackoff":           "2s",
+					"backoffMultiplier":    2,
+					"retryableStatusCodes": []string{"UNAVAILABLE"},
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		// not expected to happen
+		panic(fmt.Sprintf("invalid service config: %v", err))
+	}
+	return string(data)
+}
+
+func timeoutInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+type tokenCredentials struct {
+	token  string
+	secure bool
+}
+
+func (c *tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{
+		"authorization": "Bearer " + c.token,
+	}, nil
+}
+
+// RequireTransportSecurity allows sending the token in plaintext only if TLS is not configured at all, e.g. when the
+// server is in a trusted network.
+func (c *tokenCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
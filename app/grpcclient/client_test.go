@@ -0,0 +1,92 @@
+package grpcclient
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// requireServer starts a server which passes calls to the interceptor before the health service.
+func requireServer(t *testing.T, interceptor grpc.UnaryServerInterceptor) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := grpc.NewServer(grpc.UnaryInterceptor(interceptor))
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	return lis.Addr().String()
+}
+
+func TestTokenAndTimeout(t *testing.T) {
+	var auth string
+	var deadline time.Time
+	addr := requireServer(t, func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		auth = md.Get("authorization")[0]
+		deadline, _ = ctx.Deadline()
+		return handler(ctx, req)
+	})
+
+	c, err := New("test", addr, WithToken("secret"), WithTimeout(time.Minute))
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, err = healthpb.NewHealthClient(c.Conn()).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "Bearer secret", auth)
+	require.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+}
+
+func TestRetryUnavailable(t *testing.T) {
+	var count int32
+	addr := requireServer(t, func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if atomic.AddInt32(&count, 1) < 3 {
+			return nil, status.Error(codes.Unavailable, "not yet")
+		}
+		return handler(ctx, req)
+	})
+
+	c, err := New("test", addr, WithMaxAttempts(3))
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, err = healthpb.NewHealthClient(c.Conn()).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(&count))
+}
+
+func TestCheck(t *testing.T) {
+	addr := requireServer(t, func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(ctx, req)
+	})
+
+	c, err := New("test", addr)
+	require.NoError(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, c.Check(ctx))
+
+	// nothing is listening
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, lis.Close())
+
+	c, err = New("test", lis.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	require.Error(t, c.Check(ctx))
+}
@@ -0,0 +1,77 @@
+package grpcclient
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+type Options struct {
+	// timeout is the deadline of unary calls whose context has none
+	timeout time.Duration
+
+	// maxAttempts of calls failing with `UNAVAILABLE`, including the first one
+	maxAttempts int
+
+	// keepalive is the interval to ping the server when there is no activity, which is disabled if zero
+	keepalive time.Duration
+
+	tlsCaFile     string
+	tlsCertFile   string
+	tlsKeyFile    string
+	tlsServerName string
+
+	token string
+
+	dialOptions []grpc.DialOption
+}
+
+type Option func(*Options)
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.timeout = timeout
+	}
+}
+
+func WithMaxAttempts(n int) Option {
+	return func(o *Options) {
+		o.maxAttempts = n
+	}
+}
+
+// WithKeepalive sets the interval of pings, which should be no less than the minimum interval enforced by the
+// server, otherwise the server will close the connection.
+func WithKeepalive(interval time.Duration) Option {
+	return func(o *Options) {
+		o.keepalive = interval
+	}
+}
+
+// WithTls connects to the server through TLS, verifying the server against the CA if given, and presenting the client
+// certificate for mTLS if given.
+func WithTls(caFile, certFile, keyFile, serverName string) Option {
+	return func(o *Options) {
+		o.tlsCaFile = caFile
+		o.tlsCertFile = certFile
+		o.tlsKeyFile = keyFile
+		o.tlsServerName = serverName
+	}
+}
+
+// WithToken sends the token as a bearer token in the `authorization` metadata of every call.
+func WithToken(token string) Option {
+	return func(o *Options) {
+		o.token = token
+	}
+}
+
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *Options) {
+		o.dialOptions = append(o.dialOptions, opts...)
+	}
+}
+
+func (o *Options) tlsEnabled() bool {
+	return o.tlsCaFile != "" || o.tlsCertFile != "" || o.tlsServerName != ""
+}
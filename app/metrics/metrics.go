@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "nutsh"
+
+// Metrics are registered to a dedicated registry rather than the global one, such that only metrics of nutsh and the
+// runtime are exposed.
+var registry = prometheus.NewRegistry()
+
+var (
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of HTTP requests by OpenAPI operation, or by route for other requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "method", "status"})
+
+	annotationVersionConflicts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "annotation",
+		Name:      "version_conflicts_total",
+		Help:      "Annotation patches rejected since the annotation has been changed by others.",
+	})
+
+	publicStoreLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "public_store",
+		Name:      "lookups_total",
+		Help:      "Lookups of cached objects, e.g. image embeddings and decoders, in the public store.",
+	}, []string{"kind", "result"})
+
+	grpcClientDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpc_client",
+		Name:      "call_duration_seconds",
+		Help:      "Latency of gRPC calls to the model servers, which for streams lasts until the stream ends.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"method", "code"})
+
+	trackStreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "track",
+		Name:      "stream_duration_seconds",
+		Help:      "Duration of track streams.",
+		Buckets:   []float64{.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"result"})
+
+	trackStreamMasks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "track",
+		Name:      "masks_total",
+		Help:      "Masks streamed to clients.",
+	})
+
+	eventDrops = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "event",
+		Name:      "drops_total",
+		Help:      "Events dropped for subscribers of the event bus which fall behind, by whether they subscribe losslessly.",
+	}, []string{"lossless"})
+
+	sqliteConnOpenDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "sqlite",
+		Name:      "connection_open_duration_seconds",
+		Help:      "Time to open and prepare a SQLite connection.",
+		Buckets:   []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1},
+	})
+
+	sqliteConnHoldDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "sqlite",
+		Name:      "connection_hold_duration_seconds",
+		Help:      "Time a SQLite connection is held before being released.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequestDuration,
+		annotationVersionConflicts,
+		publicStoreLookups,
+		grpcClientDuration,
+		trackStreamDuration,
+		trackStreamMasks,
+		eventDrops,
+		sqliteConnOpenDuration,
+		sqliteConnHoldDuration,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+func ObserveHttpRequest(operation string, method string, status int, d time.Duration) {
+	httpRequestDuration.WithLabelValues(operation, method, strconv.Itoa(status)).Observe(d.Seconds())
+}
+
+func IncAnnotationVersionConflict() {
+	annotationVersionConflicts.Inc()
+}
+
+// ObservePublicStoreLookup counts a lookup of an object of the kind in the public store.
+func ObservePublicStoreLookup(kind string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	publicStoreLookups.WithLabelValues(kind, result).Inc()
+}
+
+func ObserveTrackStream(err error, d time.Duration) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	trackStreamDuration.WithLabelValues(result).Observe(d.Seconds())
+}
+
+func IncTrackStreamMask() {
+	trackStreamMasks.Inc()
+}
+
+// IncEventDropped counts an event dropped for a subscriber of the event bus.
+func IncEventDropped(lossless bool) {
+	eventDrops.WithLabelValues(strconv.FormatBool(lossless)).Inc()
+}
+
+func ObserveSqliteConnOpen(d time.Duration) {
+	sqliteConnOpenDuration.Observe(d.Seconds())
+}
+
+func ObserveSqliteConnHold(d time.Duration) {
+	sqliteConnHoldDuration.Observe(d.Seconds())
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"nutsh/openapi/gen/nutshapi"
+)
+
+const operationKey = "metrics.operation"
+
+// StrictMiddleware labels requests served by the OpenAPI handlers with their operation ids.
+func StrictMiddleware(f nutshapi.StrictHandlerFunc, operationID string) nutshapi.StrictHandlerFunc {
+	return func(ctx echo.Context, request interface{}) (interface{}, error) {
+		ctx.Set(operationKey, operationID)
+		return f(ctx, request)
+	}
+}
+
+// Middleware observes the latency of requests, which are labeled with the OpenAPI operation id if any, or otherwise
+// the matched route.
+func Middleware(skipper func(echo.Context) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skipper != nil && skipper(c) {
+				return next(c)
+			}
+
+			start := time.Now()
+			err := next(c)
+
+			operation, ok := c.Get(operationKey).(string)
+			if !ok {
+				operation = c.Path()
+			}
+			code := c.Response().Status
+			if err != nil {
+				// the error has not been handled by echo yet
+				code = http.StatusInternalServerError
+				if he, ok := err.(*echo.HTTPError); ok {
+					code = he.Code
+				}
+			}
+			ObserveHttpRequest(operation, c.Request().Method, code, time.Since(start))
+			return err
+		}
+	}
+}
+
+// GrpcDialOptions makes a gRPC client connection observe the latency of its calls.
+func GrpcDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(unaryClientInterceptor),
+		grpc.WithChainStreamInterceptor(streamClientInterceptor),
+	}
+}
+
+func unaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	grpcClientDuration.WithLabelValues(method, status.Code(err).String()).Observe(time.Since(start).Seconds())
+	return err
+}
+
+func streamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	start := time.Now()
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		grpcClientDuration.WithLabelValues(method, status.Code(err).String()).Observe(time.Since(start).Seconds())
+		return nil, err
+	}
+	return &observedClientStream{ClientStream: stream, method: method, start: start}, nil
+}
+
+// observedClientStream observes the latency once the stream ends, i.e. when receiving fails with either `io.EOF` or
+// an error.
+type observedClientStream struct {
+	grpc.ClientStream
+
+	method string
+	start  time.Time
+	once   sync.Once
+}
+
+func (s *observedClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.once.Do(func() {
+			code := codes.OK
+			if err != io.EOF {
+				code = status.Code(err)
+			}
+			grpcClientDuration.WithLabelValues(s.method, code.String()).Observe(time.Since(s.start).Seconds())
+		})
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddlewareLabels(t *testing.T) {
+	e := echo.New()
+	e.Use(Middleware(nil))
+
+	// an OpenAPI operation
+	handler := StrictMiddleware(func(ctx echo.Context, request interface{}) (interface{}, error) {
+		return nil, ctx.NoContent(http.StatusConflict)
+	}, "PatchSomething")
+	e.PATCH("/api/something/:id", func(c echo.Context) error {
+		_, err := handler(c, nil)
+		return err
+	})
+
+	// a plain route
+	e.GET("/stream/:id", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusNotFound)
+	})
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPatch, "/api/something/1", nil),
+		httptest.NewRequest(http.MethodPatch, "/api/something/2", nil),
+		httptest.NewRequest(http.MethodGet, "/stream/1", nil),
+	} {
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	require.Equal(t, 2, testutil.CollectAndCount(httpRequestDuration))
+	count := func(operation, method, status string) int {
+		var m dto.Metric
+		h := httpRequestDuration.WithLabelValues(operation, method, status).(prometheus.Histogram)
+		require.NoError(t, h.Write(&m))
+		return int(m.GetHistogram().GetSampleCount())
+	}
+	require.Equal(t, 2, count("PatchSomething", http.MethodPatch, "409"))
+	require.Equal(t, 1, count("/stream/:id", http.MethodGet, "404"))
+}
@@ -18,6 +18,7 @@ const (
 	errMissingField        = "ErrMissingField"
 	errInvalidId           = "ErrInvalidId"
 	errNotFound            = "ErrNotFound"
+	errVersionConflict     = "ErrVersionConflict"
 )
 
 func ErrUniqueFieldConflict(field string) error {
@@ -46,9 +47,36 @@ func ErrNotFound() error {
 	}
 }
 
+func ErrVersionConflict() error {
+	return &Error{
+		Code: errVersionConflict,
+	}
+}
+
 func IsErrNotFound(err error) bool {
 	if bad, ok := err.(*Error); ok {
 		return bad.Code == errNotFound
 	}
 	return false
 }
+
+func IsErrInvalidId(err error) bool {
+	if bad, ok := err.(*Error); ok {
+		return bad.Code == errInvalidId
+	}
+	return false
+}
+
+func IsErrVersionConflict(err error) bool {
+	if bad, ok := err.(*Error); ok {
+		return bad.Code == errVersionConflict
+	}
+	return false
+}
+
+func IsErrUniqueFieldConflict(err error) bool {
+	if bad, ok := err.(*Error); ok {
+		return bad.Code == errUniqueFieldConflict
+	}
+	return false
+}
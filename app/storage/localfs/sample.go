@@ -4,11 +4,18 @@ import (
 	"context"
 	"nutsh/app/storage"
 	"nutsh/openapi/gen/nutshapi"
+	"os"
 	"path"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
+// samples are named by the MD5 of their content
+var sampleIdPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
 func NewSample(root string) storage.Sample {
 	return &mSample{
 		root: root,
@@ -37,3 +44,80 @@ func (s *mSample) Create(ctx context.Context, pid storage.ProjectId, req *nutsha
 
 	return nil
 }
+
+func (s *mSample) List(ctx context.Context, pid storage.ProjectId) ([]*storage.StoredSample, error) {
+	entries, err := os.ReadDir(path.Join(s.root, pid))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var samples []*storage.StoredSample
+	for _, entry := range entries {
+		id := strings.TrimSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !sampleIdPattern.MatchString(id) || entry.Name() != id+".json" {
+			continue
+		}
+		sample, err := s.read(pid, id)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		a, b := samples[i], samples[j]
+		if !a.CreateTime.Equal(b.CreateTime) {
+			return a.CreateTime.Before(b.CreateTime)
+		}
+		return a.Id < b.Id
+	})
+	return samples, nil
+}
+
+func (s *mSample) Get(ctx context.Context, pid storage.ProjectId, id storage.SampleId) (*storage.StoredSample, error) {
+	if !sampleIdPattern.MatchString(id) {
+		return nil, storage.ErrNotFound()
+	}
+	return s.read(pid, id)
+}
+
+func (s *mSample) Delete(ctx context.Context, pid storage.ProjectId, id storage.SampleId) (*storage.StoredSample, error) {
+	sample, err := s.Get(ctx, pid, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(s.path(pid, id)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, storage.ErrNotFound()
+		}
+		return nil, errors.WithStack(err)
+	}
+	return sample, nil
+}
+
+func (s *mSample) read(pid storage.ProjectId, id storage.SampleId) (*storage.StoredSample, error) {
+	p := s.path(pid, id)
+	info, err := os.Stat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, storage.ErrNotFound()
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &storage.StoredSample{
+		Id:         id,
+		Json:       string(data),
+		CreateTime: info.ModTime(),
+	}, nil
+}
+
+func (s *mSample) path(pid storage.ProjectId, id storage.SampleId) string {
+	return path.Join(s.root, pid, id+".json")
+}
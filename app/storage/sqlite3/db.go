@@ -1,27 +1,39 @@
 package sqlite3
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
 
 	"nutsh/app/storage"
 	"nutsh/app/storage/sqlite3/exec"
 )
 
 type Database struct {
+	options  *Options
 	connPool *connPool
 }
 
-func New(path string) (*Database, error) {
+func New(path string, opts ...Option) (*Database, error) {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
 	if err := initializeDatabaseIfNecessary(path); err != nil {
 		return nil, err
 	}
+	if err := migrateDatabase(path); err != nil {
+		return nil, err
+	}
 
 	db := &Database{
+		options:  o,
 		connPool: &connPool{path: path},
 	}
 
@@ -32,6 +44,21 @@ func (d *Database) Close() error {
 	return d.connPool.Close()
 }
 
+// Ping checks that the database can be opened and queried.
+func (d *Database) Ping(ctx context.Context) error {
+	conn, err := d.connPool.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer d.connPool.Put(conn)
+
+	conn.SetInterrupt(ctx.Done())
+	if err := sqlitex.ExecuteTransient(conn, "SELECT 1", nil); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
 func (d *Database) ProjectStorage() storage.Project {
 	return &mProjectStorage{
 		connPool: d.connPool,
@@ -42,6 +69,19 @@ func (d *Database) VideoStorage() storage.Video {
 	return &mVideoStorage{
 		connPool:             d.connPool,
 		patchAnnotationMutex: &mPatchVideoAnnotationMutex{},
+		bus:                  d.options.bus,
+	}
+}
+
+func (d *Database) WebhookStorage() storage.Webhook {
+	return &mWebhookStorage{
+		connPool: d.connPool,
+	}
+}
+
+func (d *Database) PrecomputeJobStorage() storage.PrecomputeJob {
+	return &mPrecomputeJobStorage{
+		connPool: d.connPool,
 	}
 }
 
@@ -76,3 +116,16 @@ func initializeDatabaseIfNecessary(path string) error {
 
 	return nil
 }
+
+func migrateDatabase(path string) error {
+	conn, err := sqlite.OpenConn(path, 0)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer conn.Close()
+
+	if err := exec.MigrateSchema(conn); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+-- Tables introduced after the initial schema. Every statement must be idempotent since the script runs on each start
+-- to upgrade existing databases.
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	project_id INTEGER NOT NULL REFERENCES projects(id) ON UPDATE CASCADE ON DELETE CASCADE,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL DEFAULT '',
+	events TEXT NOT NULL,
+	create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON UPDATE CASCADE ON DELETE CASCADE,
+	event TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL,
+	succeeded BOOLEAN NOT NULL,
+	status_code INTEGER,
+	error TEXT,
+	create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS precompute_jobs (
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	project_id INTEGER NOT NULL REFERENCES projects(id) ON UPDATE CASCADE ON DELETE CASCADE,
+	decoder_uuid TEXT NOT NULL,
+	state TEXT NOT NULL,
+	total_frames INTEGER NOT NULL DEFAULT 0,
+	ready_frames INTEGER NOT NULL DEFAULT 0,
+	failed_frames INTEGER NOT NULL DEFAULT 0,
+	cursor INTEGER NOT NULL DEFAULT 0,
+	error TEXT NOT NULL DEFAULT '',
+	create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- At most one job runs for the same project and decoder. Duplicates created before the constraint are cancelled except
+-- the latest one, such that the index can be created.
+UPDATE precompute_jobs SET state='cancelled'
+WHERE state='running' AND id NOT IN (
+	SELECT MAX(id) FROM precompute_jobs WHERE state='running' GROUP BY project_id, decoder_uuid
+);
+CREATE UNIQUE INDEX IF NOT EXISTS precompute_jobs_running ON precompute_jobs(project_id, decoder_uuid) WHERE state='running';
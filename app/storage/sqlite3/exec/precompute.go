@@ -0,0 +1,234 @@
+package exec
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+
+	"nutsh/app/storage"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+const precomputeJobColumns = `
+	id,
+	project_id,
+	decoder_uuid,
+	state,
+	total_frames,
+	ready_frames,
+	failed_frames,
+	error,
+	create_time
+`
+
+func scanPrecomputeJob(stmt *sqlite.Stmt) *nutshapi.PrecomputeJob {
+	j := &nutshapi.PrecomputeJob{
+		Id:           strconv.FormatInt(stmt.ColumnInt64(0), 10),
+		ProjectId:    strconv.FormatInt(stmt.ColumnInt64(1), 10),
+		DecoderUuid:  stmt.ColumnText(2),
+		State:        stmt.ColumnText(3),
+		TotalFrames:  stmt.ColumnInt(4),
+		ReadyFrames:  stmt.ColumnInt(5),
+		FailedFrames: stmt.ColumnInt(6),
+		CreateTime:   stmt.ColumnText(8),
+	}
+	if msg := stmt.ColumnText(7); msg != "" {
+		j.Error = &msg
+	}
+	return j
+}
+
+func CreatePrecomputeJob(ctx context.Context, conn *sqlite.Conn, projectId int, decoderUuid string) (*nutshapi.PrecomputeJob, error) {
+	createTime := time.Now().UTC().Format(time.RFC3339)
+	if err := sqlitex.ExecuteTransient(conn, `
+		INSERT INTO precompute_jobs
+			(project_id, decoder_uuid, state, create_time)
+		VALUES
+			(:project_id, :decoder_uuid, :state, :create_time)
+	`, &sqlitex.ExecOptions{
+		Named: map[string]interface{}{
+			":project_id":   projectId,
+			":decoder_uuid": decoderUuid,
+			":state":        storage.PrecomputeJobRunning,
+			":create_time":  createTime,
+		},
+	}); err != nil {
+		if bad := checkPrecomputeJobBadRequest(err); bad != nil {
+			return nil, bad
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	id := conn.LastInsertRowID()
+	return &nutshapi.PrecomputeJob{
+		Id:          strconv.FormatInt(id, 10),
+		ProjectId:   strconv.Itoa(projectId),
+		DecoderUuid: decoderUuid,
+		State:       storage.PrecomputeJobRunning,
+		CreateTime:  createTime,
+	}, nil
+}
+
+func GetPrecomputeJob(ctx context.Context, conn *sqlite.Conn, id int) (*nutshapi.PrecomputeJob, error) {
+	var j *nutshapi.PrecomputeJob
+	if err := sqlitex.ExecuteTransient(conn, `
+		SELECT `+precomputeJobColumns+`
+		FROM precompute_jobs
+		WHERE id = :id
+	`, &sqlitex.ExecOptions{
+		Named: map[string]interface{}{
+			":id": id,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			j = scanPrecomputeJob(stmt)
+			return nil
+		},
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if j == nil {
+		return nil, storage.ErrNotFound()
+	}
+
+	return j, nil
+}
+
+func ListPrecomputeJobs(ctx context.Context, conn *sqlite.Conn, projectId int) ([]*nutshapi.PrecomputeJob, error) {
+	var js []*nutshapi.PrecomputeJob
+	if err := sqlitex.ExecuteTransient(conn, `
+		SELECT `+precomputeJobColumns+`
+		FROM precompute_jobs
+		WHERE project_id = :project_id
+		ORDER BY id DESC
+	`, &sqlitex.ExecOptions{
+		Named: map[string]interface{}{
+			":project_id": projectId,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			js = append(js, scanPrecomputeJob(stmt))
+			return nil
+		},
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return js, nil
+}
+
+func ListRunningPrecomputeJobs(ctx context.Context, conn *sqlite.Conn) ([]*nutshapi.PrecomputeJob, error) {
+	var js []*nutshapi.PrecomputeJob
+	if err := sqlitex.ExecuteTransient(conn, `
+		SELECT `+precomputeJobColumns+`
+		FROM precompute_jobs
+		WHERE state = :state
+		ORDER BY id ASC
+	`, &sqlitex.ExecOptions{
+		Named: map[string]interface{}{
+			":state": storage.PrecomputeJobRunning,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			js = append(js, scanPrecomputeJob(stmt))
+			return nil
+		},
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return js, nil
+}
+
+func GetPrecomputeJobProgress(ctx context.Context, conn *sqlite.Conn, id int) (*storage.PrecomputeProgress, error) {
+	var p *storage.PrecomputeProgress
+	if err := sqlitex.ExecuteTransient(conn, `
+		SELECT
+			total_frames,
+			ready_frames,
+			failed_frames,
+			cursor,
+			error
+		FROM precompute_jobs
+		WHERE id = :id
+	`, &sqlitex.ExecOptions{
+		Named: map[string]interface{}{
+			":id": id,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			p = &storage.PrecomputeProgress{
+				TotalFrames:  stmt.ColumnInt(0),
+				ReadyFrames:  stmt.ColumnInt(1),
+				FailedFrames: stmt.ColumnInt(2),
+				Cursor:       stmt.ColumnInt(3),
+				Error:        stmt.ColumnText(4),
+			}
+			return nil
+		},
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if p == nil {
+		return nil, storage.ErrNotFound()
+	}
+
+	return p, nil
+}
+
+func UpdatePrecomputeJobProgress(ctx context.Context, conn *sqlite.Conn, id int, p *storage.PrecomputeProgress) error {
+	if err := sqlitex.ExecuteTransient(conn, `
+		UPDATE precompute_jobs
+		SET
+			total_frames = :total_frames,
+			ready_frames = :ready_frames,
+			failed_frames = :failed_frames,
+			cursor = :cursor,
+			error = :error
+		WHERE id = :id
+	`, &sqlitex.ExecOptions{
+		Named: map[string]interface{}{
+			":id":            id,
+			":total_frames":  p.TotalFrames,
+			":ready_frames":  p.ReadyFrames,
+			":failed_frames": p.FailedFrames,
+			":cursor":        p.Cursor,
+			":error":         p.Error,
+		},
+	}); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if conn.Changes() == 0 {
+		return storage.ErrNotFound()
+	}
+
+	return nil
+}
+
+func SetPrecomputeJobState(ctx context.Context, conn *sqlite.Conn, id int, state string) (*nutshapi.PrecomputeJob, error) {
+	if err := sqlitex.ExecuteTransient(conn, `
+		UPDATE precompute_jobs
+		SET state = :state
+		WHERE id = :id
+	`, &sqlitex.ExecOptions{
+		Named: map[string]interface{}{
+			":id":    id,
+			":state": state,
+		},
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return GetPrecomputeJob(ctx, conn, id)
+}
+
+func checkPrecomputeJobBadRequest(err error) error {
+	if strings.Contains(err.Error(), "UNIQUE constraint failed: precompute_jobs.project_id, precompute_jobs.decoder_uuid") {
+		return storage.ErrUniqueFieldConflict("precompute_jobs.decoder_uuid")
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package exec
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"zombiezen.com/go/sqlite/sqlitex"
+
+	"nutsh/app/storage"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+func TestPrecomputeJobProgressOk(t *testing.T) {
+	ctx := context.Background()
+	conn := requireInitializeDatabase(t)
+
+	p, err := CreateProject(ctx, conn, &nutshapi.CreateProjectReq{Name: "foo"})
+	require.NoError(t, err)
+	pid := requireInteger(t, p.Id)
+
+	j, err := CreatePrecomputeJob(ctx, conn, pid, "sam.vit_b")
+	require.NoError(t, err)
+	require.Equal(t, storage.PrecomputeJobRunning, j.State)
+	id := requireInteger(t, j.Id)
+
+	progress := &storage.PrecomputeProgress{
+		TotalFrames:  10,
+		ReadyFrames:  3,
+		FailedFrames: 1,
+		Cursor:       4,
+		Error:        "bad image",
+	}
+	require.NoError(t, UpdatePrecomputeJobProgress(ctx, conn, id, progress))
+
+	got, err := GetPrecomputeJobProgress(ctx, conn, id)
+	require.NoError(t, err)
+	require.Equal(t, progress, got)
+
+	j, err = GetPrecomputeJob(ctx, conn, id)
+	require.NoError(t, err)
+	require.Equal(t, 10, j.TotalFrames)
+	require.Equal(t, 3, j.ReadyFrames)
+	require.Equal(t, 1, j.FailedFrames)
+	require.Equal(t, "bad image", *j.Error)
+
+	running, err := ListRunningPrecomputeJobs(ctx, conn)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(running))
+
+	j, err = SetPrecomputeJobState(ctx, conn, id, storage.PrecomputeJobCancelled)
+	require.NoError(t, err)
+	require.Equal(t, storage.PrecomputeJobCancelled, j.State)
+
+	running, err = ListRunningPrecomputeJobs(ctx, conn)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(running))
+
+	js, err := ListPrecomputeJobs(ctx, conn, pid)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(js))
+}
+
+func TestUpdatePrecomputeJobProgressNotFound(t *testing.T) {
+	ctx := context.Background()
+	conn := requireInitializeDatabase(t)
+
+	err := UpdatePrecomputeJobProgress(ctx, conn, 1, &storage.PrecomputeProgress{})
+	require.True(t, storage.IsErrNotFound(err))
+}
+
+func TestCreatePrecomputeJobRunningConflict(t *testing.T) {
+	ctx := context.Background()
+	conn := requireInitializeDatabase(t)
+
+	p, err := CreateProject(ctx, conn, &nutshapi.CreateProjectReq{Name: "foo"})
+	require.NoError(t, err)
+	pid := requireInteger(t, p.Id)
+
+	j, err := CreatePrecomputeJob(ctx, conn, pid, "sam.vit_b")
+	require.NoError(t, err)
+
+	_, err = CreatePrecomputeJob(ctx, conn, pid, "sam.vit_b")
+	require.True(t, storage.IsErrUniqueFieldConflict(err))
+
+	_, err = CreatePrecomputeJob(ctx, conn, pid, "sam.vit_h")
+	require.NoError(t, err)
+
+	_, err = SetPrecomputeJobState(ctx, conn, requireInteger(t, j.Id), storage.PrecomputeJobCancelled)
+	require.NoError(t, err)
+	_, err = CreatePrecomputeJob(ctx, conn, pid, "sam.vit_b")
+	require.NoError(t, err)
+}
+
+func TestMigrateDuplicateRunningPrecomputeJobs(t *testing.T) {
+	ctx := context.Background()
+	conn := requireInitializeDatabase(t)
+
+	p, err := CreateProject(ctx, conn, &nutshapi.CreateProjectReq{Name: "foo"})
+	require.NoError(t, err)
+	pid := requireInteger(t, p.Id)
+
+	// duplicates created before the constraint
+	require.NoError(t, sqlitex.ExecuteTransient(conn, "DROP INDEX precompute_jobs_running", nil))
+	for i := 0; i < 2; i++ {
+		_, err := CreatePrecomputeJob(ctx, conn, pid, "sam.vit_b")
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, MigrateSchema(conn))
+	running, err := ListRunningPrecomputeJobs(ctx, conn)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(running))
+	require.Equal(t, "2", running[0].Id)
+}
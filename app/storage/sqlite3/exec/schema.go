@@ -11,9 +11,20 @@ import (
 //go:embed schema.sql
 var schema string
 
+//go:embed migrate.sql
+var migration string
+
 func InitializeSchema(conn *sqlite.Conn) error {
 	if err := sqlitex.ExecScript(conn, schema); err != nil {
 		return errors.WithStack(err)
 	}
+	return MigrateSchema(conn)
+}
+
+// MigrateSchema brings an existing database up to date with tables added after the initial schema.
+func MigrateSchema(conn *sqlite.Conn) error {
+	if err := sqlitex.ExecScript(conn, migration); err != nil {
+		return errors.WithStack(err)
+	}
 	return nil
 }
@@ -203,6 +203,36 @@ func PatchVideoAnnotationJsonMergePatch(ctx context.Context, conn *sqlite.Conn,
 	return storage.AnnotationVersion(newVersion), nil
 }
 
+func SetVideoAnnotation(ctx context.Context, conn *sqlite.Conn, id int, annoJson string, version storage.AnnotationVersion) (storage.AnnotationVersion, error) {
+	newVersion := uuid.NewString()
+	if err := sqlitex.ExecuteTransient(conn, `
+		UPDATE videos SET
+			annotation_json=:annotation_json,
+			annotation_version=:new_version
+		WHERE id=:id AND annotation_version=:old_version
+	`, &sqlitex.ExecOptions{
+		Named: map[string]interface{}{
+			":id":              id,
+			":annotation_json": annoJson,
+			":old_version":     version,
+			":new_version":     newVersion,
+		},
+	}); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	numChange := conn.Changes()
+	if numChange == 0 {
+		// tell a stale version apart from a missing video
+		if _, _, err := GetVideoAnnotation(ctx, conn, id); err != nil {
+			return "", err
+		}
+		return "", storage.ErrVersionConflict()
+	}
+
+	return storage.AnnotationVersion(newVersion), nil
+}
+
 func checkVideoBadRequest(err error) error {
 	if strings.Contains(err.Error(), "UNIQUE constraint failed: videos.project_id, videos.name") {
 		return storage.ErrUniqueFieldConflict("videos.name")
@@ -167,3 +167,34 @@ func TestPatchVideoAnnotationVersinMismatch(t *testing.T) {
 	_, err = PatchVideoAnnotationJsonMergePatch(ctx, conn, id, `{"entities":"bar"}`, "helloworld")
 	require.True(t, storage.IsErrNotFound(err))
 }
+
+func TestSetVideoAnnotationOk(t *testing.T) {
+	ctx := context.Background()
+	conn := requireInitializeDatabase(t)
+
+	p, err := CreateVideo(ctx, conn, &nutshapi.CreateVideoReq{
+		ProjectId: "1",
+		Name:      "foo",
+	})
+	require.NoError(t, err)
+
+	id := requireInteger(t, p.Id)
+	_, v0, err := GetVideoAnnotation(ctx, conn, id)
+	require.NoError(t, err)
+
+	v1, err := SetVideoAnnotation(ctx, conn, id, `{"entities":"foo"}`, v0)
+	require.NoError(t, err)
+	require.NotEqual(t, v0, v1)
+
+	_, err = SetVideoAnnotation(ctx, conn, id, `{"entities":"bar"}`, v0)
+	require.True(t, storage.IsErrVersionConflict(err))
+
+	anno, v, err := GetVideoAnnotation(ctx, conn, id)
+	require.NoError(t, err)
+	require.Equal(t, v1, v)
+	require.NotNil(t, anno)
+	require.Equal(t, `{"entities":"foo"}`, *anno)
+
+	_, err = SetVideoAnnotation(ctx, conn, id+1, `{"entities":"bar"}`, v1)
+	require.True(t, storage.IsErrNotFound(err))
+}
@@ -0,0 +1,293 @@
+package exec
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+
+	"nutsh/app/storage"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+// The number of most recent deliveries to list for a webhook.
+const webhookDeliveryListLimit = 100
+
+func CreateWebhook(ctx context.Context, conn *sqlite.Conn, projectId int, req *nutshapi.CreateProjectWebhookReq) (*nutshapi.Webhook, error) {
+	secret := ""
+	if req.Secret != nil {
+		secret = *req.Secret
+	}
+	if err := sqlitex.ExecuteTransient(conn, `
+		INSERT INTO webhooks
+			(project_id, url, secret, events)
+		VALUES
+			(:project_id, :url, :secret, :events)
+	`, &sqlitex.ExecOptions{
+		Named: map[string]interface{}{
+			":project_id": projectId,
+			":url":        req.Url,
+			":secret":     secret,
+			":events":     strings.Join(req.Events, ","),
+		},
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	id := conn.LastInsertRowID()
+	return &nutshapi.Webhook{
+		Id:        strconv.FormatInt(id, 10),
+		ProjectId: strconv.Itoa(projectId),
+		Url:       req.Url,
+		Events:    req.Events,
+	}, nil
+}
+
+func ListWebhooks(ctx context.Context, conn *sqlite.Conn, projectId int) ([]*nutshapi.Webhook, error) {
+	var ws []*nutshapi.Webhook
+	if err := sqlitex.ExecuteTransient(conn, `
+		SELECT
+			id,
+			project_id,
+			url,
+			events
+		FROM webhooks
+		WHERE project_id = :project_id
+		ORDER BY id ASC
+	`, &sqlitex.ExecOptions{
+		Named: map[string]interface{}{
+			":project_id": projectId,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			ws = append(ws, &nutshapi.Webhook{
+				Id:        strconv.FormatInt(stmt.ColumnInt64(0), 10),
+				ProjectId: strconv.FormatInt(stmt.ColumnInt64(1), 10),
+				Url:       stmt.ColumnText(2),
+				Events:    strings.Split(stmt.ColumnText(3), ","),
+			})
+			return nil
+		},
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return ws, nil
+}
+
+func GetWebhook(ctx context.Context, conn *sqlite.Conn, id int) (*nutshapi.Webhook, error) {
+	var w *nutshapi.Webhook
+	if err := sqlitex.ExecuteTransient(conn, `
+		SELECT
+			id,
+			project_id,
+			url,
+			events
+		FROM webhooks
+		WHERE id = :id
+	`, &sqlitex.ExecOptions{
+		Named: map[string]interface{}{
+			":id": id,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			w = &nutshapi.Webhook{
+				Id:        strconv.FormatInt(stmt.ColumnInt64(0), 10),
+				ProjectId: strconv.FormatInt(stmt.ColumnInt64(1), 10),
+				Url:       stmt.ColumnText(2),
+				Events:    strings.Split(stmt.ColumnText(3), ","),
+			}
+			return nil
+		},
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if w == nil {
+		return nil, storage.ErrNotFound()
+	}
+
+	return w, nil
+}
+
+func DeleteWebhook(ctx context.Context, conn *sqlite.Conn, id int) (*nutshapi.Webhook, error) {
+	webhook, err := GetWebhook(ctx, conn, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sqlitex.ExecuteTransient(conn, `DELETE FROM webhooks WHERE id=:id`, &sqlitex.ExecOptions{
+		Named: map[string]interface{}{
+			":id": id,
+		},
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return webhook, nil
+}
+
+func ListWebhookSubscribers(ctx context.Context, conn *sqlite.Conn, projectId int, event string) ([]*storage.WebhookSubscriber, error) {
+	var ss []*storage.WebhookSubscriber
+	if err := sqlitex.ExecuteTransient(conn, `
+		SELECT
+			id,
+			url,
+			secret,
+			events
+		FROM webhooks
+		WHERE project_id = :project_id
+		ORDER BY id ASC
+	`, &sqlitex.ExecOptions{
+		Named: map[string]interface{}{
+			":project_id": projectId,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			for _, e := range strings.Split(stmt.ColumnText(3), ",") {
+				if e == event {
+					ss = append(ss, &storage.WebhookSubscriber{
+						Id:     strconv.FormatInt(stmt.ColumnInt64(0), 10),
+						Url:    stmt.ColumnText(1),
+						Secret: stmt.ColumnText(2),
+					})
+					break
+				}
+			}
+			return nil
+		},
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return ss, nil
+}
+
+func GetWebhookSubscriber(ctx context.Context, conn *sqlite.Conn, id int) (*storage.WebhookSubscriber, error) {
+	var s *storage.WebhookSubscriber
+	if err := sqlitex.ExecuteTransient(conn, `
+		SELECT
+			id,
+			url,
+			secret
+		FROM webhooks
+		WHERE id = :id
+	`, &sqlitex.ExecOptions{
+		Named: map[string]interface{}{
+			":id": id,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			s = &storage.WebhookSubscriber{
+				Id:     strconv.FormatInt(stmt.ColumnInt64(0), 10),
+				Url:    stmt.ColumnText(1),
+				Secret: stmt.ColumnText(2),
+			}
+			return nil
+		},
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if s == nil {
+		return nil, storage.ErrNotFound()
+	}
+
+	return s, nil
+}
+
+func CreateWebhookDelivery(ctx context.Context, conn *sqlite.Conn, webhookId int, d *nutshapi.WebhookDelivery) (*nutshapi.WebhookDelivery, error) {
+	createTime := time.Now().UTC().Format(time.RFC3339)
+
+	// optional columns are bound as NULL when missing
+	var statusCode, errMsg interface{}
+	if d.StatusCode != nil {
+		statusCode = *d.StatusCode
+	}
+	if d.Error != nil {
+		errMsg = *d.Error
+	}
+
+	if err := sqlitex.ExecuteTransient(conn, `
+		INSERT INTO webhook_deliveries
+			(webhook_id, event, payload, attempts, succeeded, status_code, error, create_time)
+		VALUES
+			(:webhook_id, :event, :payload, :attempts, :succeeded, :status_code, :error, :create_time)
+	`, &sqlitex.ExecOptions{
+		Named: map[string]interface{}{
+			":webhook_id":  webhookId,
+			":event":       d.Event,
+			":payload":     d.Payload,
+			":attempts":    d.Attempts,
+			":succeeded":   d.Succeeded,
+			":status_code": statusCode,
+			":error":       errMsg,
+			":create_time": createTime,
+		},
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	id := conn.LastInsertRowID()
+	return &nutshapi.WebhookDelivery{
+		Id:         strconv.FormatInt(id, 10),
+		WebhookId:  strconv.Itoa(webhookId),
+		Event:      d.Event,
+		Payload:    d.Payload,
+		Attempts:   d.Attempts,
+		Succeeded:  d.Succeeded,
+		StatusCode: d.StatusCode,
+		Error:      d.Error,
+		CreateTime: createTime,
+	}, nil
+}
+
+func ListWebhookDeliveries(ctx context.Context, conn *sqlite.Conn, webhookId int) ([]*nutshapi.WebhookDelivery, error) {
+	var ds []*nutshapi.WebhookDelivery
+	if err := sqlitex.ExecuteTransient(conn, `
+		SELECT
+			id,
+			webhook_id,
+			event,
+			payload,
+			attempts,
+			succeeded,
+			status_code,
+			error,
+			create_time
+		FROM webhook_deliveries
+		WHERE webhook_id = :webhook_id
+		ORDER BY id DESC
+		LIMIT :limit
+	`, &sqlitex.ExecOptions{
+		Named: map[string]interface{}{
+			":webhook_id": webhookId,
+			":limit":      webhookDeliveryListLimit,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			d := &nutshapi.WebhookDelivery{
+				Id:         strconv.FormatInt(stmt.ColumnInt64(0), 10),
+				WebhookId:  strconv.FormatInt(stmt.ColumnInt64(1), 10),
+				Event:      stmt.ColumnText(2),
+				Payload:    stmt.ColumnText(3),
+				Attempts:   stmt.ColumnInt(4),
+				Succeeded:  stmt.ColumnBool(5),
+				CreateTime: stmt.ColumnText(8),
+			}
+			if stmt.ColumnType(6) != sqlite.TypeNull {
+				code := stmt.ColumnInt(6)
+				d.StatusCode = &code
+			}
+			if stmt.ColumnType(7) != sqlite.TypeNull {
+				msg := stmt.ColumnText(7)
+				d.Error = &msg
+			}
+			ds = append(ds, d)
+			return nil
+		},
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return ds, nil
+}
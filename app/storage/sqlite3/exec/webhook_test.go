@@ -0,0 +1,122 @@
+package exec
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"nutsh/app/storage"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+func TestCreateWebhookOk(t *testing.T) {
+	ctx := context.Background()
+	conn := requireInitializeDatabase(t)
+
+	secret := "secret"
+	req := &nutshapi.CreateProjectWebhookReq{
+		Url:    "http://localhost:8080/hook",
+		Secret: &secret,
+		Events: []string{"video.created", "annotation.updated"},
+	}
+	w, err := CreateWebhook(ctx, conn, 1, req)
+	require.NoError(t, err)
+	require.Equal(t, req.Url, w.Url)
+	require.Equal(t, req.Events, w.Events)
+
+	ws, err := ListWebhooks(ctx, conn, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(ws))
+	require.Equal(t, w.Id, ws[0].Id)
+}
+
+func TestListWebhookSubscribersOk(t *testing.T) {
+	ctx := context.Background()
+	conn := requireInitializeDatabase(t)
+
+	secret := "secret"
+	_, err := CreateWebhook(ctx, conn, 1, &nutshapi.CreateProjectWebhookReq{
+		Url:    "http://localhost:8080/a",
+		Secret: &secret,
+		Events: []string{"video.created"},
+	})
+	require.NoError(t, err)
+	_, err = CreateWebhook(ctx, conn, 1, &nutshapi.CreateProjectWebhookReq{
+		Url:    "http://localhost:8080/b",
+		Events: []string{"video.deleted"},
+	})
+	require.NoError(t, err)
+
+	ss, err := ListWebhookSubscribers(ctx, conn, 1, "video.created")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(ss))
+	require.Equal(t, "http://localhost:8080/a", ss[0].Url)
+	require.Equal(t, secret, ss[0].Secret)
+
+	ss, err = ListWebhookSubscribers(ctx, conn, 2, "video.created")
+	require.NoError(t, err)
+	require.Equal(t, 0, len(ss))
+}
+
+func TestDeleteWebhookOk(t *testing.T) {
+	ctx := context.Background()
+	conn := requireInitializeDatabase(t)
+
+	w, err := CreateWebhook(ctx, conn, 1, &nutshapi.CreateProjectWebhookReq{
+		Url:    "http://localhost:8080/hook",
+		Events: []string{"video.created"},
+	})
+	require.NoError(t, err)
+	id := requireInteger(t, w.Id)
+
+	_, err = DeleteWebhook(ctx, conn, id)
+	require.NoError(t, err)
+
+	_, err = GetWebhook(ctx, conn, id)
+	require.True(t, storage.IsErrNotFound(err))
+}
+
+func TestCreateWebhookDeliveryOk(t *testing.T) {
+	ctx := context.Background()
+	conn := requireInitializeDatabase(t)
+
+	w, err := CreateWebhook(ctx, conn, 1, &nutshapi.CreateProjectWebhookReq{
+		Url:    "http://localhost:8080/hook",
+		Events: []string{"video.created"},
+	})
+	require.NoError(t, err)
+	id := requireInteger(t, w.Id)
+
+	code := 500
+	msg := "unexpected HTTP status code 500"
+	_, err = CreateWebhookDelivery(ctx, conn, id, &nutshapi.WebhookDelivery{
+		Event:      "video.created",
+		Payload:    "{}",
+		Attempts:   3,
+		StatusCode: &code,
+		Error:      &msg,
+	})
+	require.NoError(t, err)
+	_, err = CreateWebhookDelivery(ctx, conn, id, &nutshapi.WebhookDelivery{
+		Event:     "video.created",
+		Payload:   "{}",
+		Attempts:  1,
+		Succeeded: true,
+	})
+	require.NoError(t, err)
+
+	ds, err := ListWebhookDeliveries(ctx, conn, id)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(ds))
+
+	// most recent first
+	require.True(t, ds[0].Succeeded)
+	require.Nil(t, ds[0].StatusCode)
+	require.False(t, ds[1].Succeeded)
+	require.Equal(t, 3, ds[1].Attempts)
+	require.NotNil(t, ds[1].StatusCode)
+	require.Equal(t, code, *ds[1].StatusCode)
+	require.NotNil(t, ds[1].Error)
+	require.Equal(t, msg, *ds[1].Error)
+}
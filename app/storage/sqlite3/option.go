@@ -0,0 +1,16 @@
+package sqlite3
+
+import "nutsh/app/event"
+
+type Options struct {
+	bus event.Bus
+}
+
+type Option func(*Options)
+
+// WithEventBus publishes changes made through the storage, e.g. annotation updates, on the bus.
+func WithEventBus(bus event.Bus) Option {
+	return func(o *Options) {
+		o.bus = bus
+	}
+}
@@ -2,14 +2,21 @@ package sqlite3
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"zombiezen.com/go/sqlite"
 	"zombiezen.com/go/sqlite/sqlitex"
+
+	"nutsh/app/metrics"
 )
 
 type connPool struct {
 	path string
+
+	// the time when each connection in use was got, to observe how long connections are held
+	acquired sync.Map
 }
 
 func (c *connPool) Get(ctx context.Context) (*sqlite.Conn, error) {
@@ -19,6 +26,7 @@ func (c *connPool) Get(ctx context.Context) (*sqlite.Conn, error) {
 	// the reason behind the blocking, given that I have carefully checked that all connections attained by `Get` are
 	// returned by `Put`. Therefore, we comprise to abandon using a pool but create a new connection every time, and
 	// let's see if the blocking issue will occur again or not.
+	start := time.Now()
 	conn, err := sqlite.OpenConn(c.path, 0)
 	if err != nil {
 		return nil, errors.WithStack(ErrFailedToGetConnection())
@@ -32,10 +40,16 @@ func (c *connPool) Get(ctx context.Context) (*sqlite.Conn, error) {
 	}
 
 	// good
+	now := time.Now()
+	metrics.ObserveSqliteConnOpen(now.Sub(start))
+	c.acquired.Store(conn, now)
 	return conn, nil
 }
 
 func (c *connPool) Put(conn *sqlite.Conn) {
+	if t, ok := c.acquired.LoadAndDelete(conn); ok {
+		metrics.ObserveSqliteConnHold(time.Since(t.(time.Time)))
+	}
 	conn.Close()
 }
 
@@ -0,0 +1,118 @@
+package sqlite3
+
+import (
+	"context"
+	"strconv"
+
+	"nutsh/app/storage"
+	"nutsh/app/storage/sqlite3/exec"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+type mPrecomputeJobStorage struct {
+	connPool *connPool
+}
+
+func (s *mPrecomputeJobStorage) Create(ctx context.Context, pid storage.ProjectId, decoderUuid string) (*nutshapi.PrecomputeJob, error) {
+	pid_, err := strconv.Atoi(pid)
+	if err != nil {
+		return nil, storage.ErrInvalidId()
+	}
+
+	if decoderUuid == "" {
+		return nil, storage.ErrMissingField("decoder_uuid")
+	}
+
+	conn, err := s.connPool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.connPool.Put(conn)
+
+	return exec.CreatePrecomputeJob(ctx, conn, pid_, decoderUuid)
+}
+
+func (s *mPrecomputeJobStorage) Get(ctx context.Context, id storage.PrecomputeJobId) (*nutshapi.PrecomputeJob, error) {
+	id_, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, storage.ErrInvalidId()
+	}
+
+	conn, err := s.connPool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.connPool.Put(conn)
+
+	return exec.GetPrecomputeJob(ctx, conn, id_)
+}
+
+func (s *mPrecomputeJobStorage) List(ctx context.Context, pid storage.ProjectId) ([]*nutshapi.PrecomputeJob, error) {
+	pid_, err := strconv.Atoi(pid)
+	if err != nil {
+		return nil, storage.ErrInvalidId()
+	}
+
+	conn, err := s.connPool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.connPool.Put(conn)
+
+	return exec.ListPrecomputeJobs(ctx, conn, pid_)
+}
+
+func (s *mPrecomputeJobStorage) ListRunning(ctx context.Context) ([]*nutshapi.PrecomputeJob, error) {
+	conn, err := s.connPool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.connPool.Put(conn)
+
+	return exec.ListRunningPrecomputeJobs(ctx, conn)
+}
+
+func (s *mPrecomputeJobStorage) GetProgress(ctx context.Context, id storage.PrecomputeJobId) (*storage.PrecomputeProgress, error) {
+	id_, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, storage.ErrInvalidId()
+	}
+
+	conn, err := s.connPool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.connPool.Put(conn)
+
+	return exec.GetPrecomputeJobProgress(ctx, conn, id_)
+}
+
+func (s *mPrecomputeJobStorage) UpdateProgress(ctx context.Context, id storage.PrecomputeJobId, p *storage.PrecomputeProgress) error {
+	id_, err := strconv.Atoi(id)
+	if err != nil {
+		return storage.ErrInvalidId()
+	}
+
+	conn, err := s.connPool.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.connPool.Put(conn)
+
+	return exec.UpdatePrecomputeJobProgress(ctx, conn, id_, p)
+}
+
+func (s *mPrecomputeJobStorage) SetState(ctx context.Context, id storage.PrecomputeJobId, state string) (*nutshapi.PrecomputeJob, error) {
+	id_, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, storage.ErrInvalidId()
+	}
+
+	conn, err := s.connPool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.connPool.Put(conn)
+
+	return exec.SetPrecomputeJobState(ctx, conn, id_, state)
+}
@@ -4,6 +4,10 @@ import (
 	"context"
 	"strconv"
 
+	"go.uber.org/zap"
+	"zombiezen.com/go/sqlite"
+
+	"nutsh/app/event"
 	"nutsh/app/storage"
 	"nutsh/app/storage/sqlite3/exec"
 	"nutsh/openapi/gen/nutshapi"
@@ -13,6 +17,7 @@ type mVideoStorage struct {
 	connPool *connPool
 
 	patchAnnotationMutex *mPatchVideoAnnotationMutex
+	bus                  event.Bus
 }
 
 func (s *mVideoStorage) Create(ctx context.Context, req *nutshapi.CreateVideoReq) (*nutshapi.Video, error) {
@@ -125,5 +130,53 @@ func (s *mVideoStorage) PatchAnnotationJsonMergePatch(ctx context.Context, id st
 	}
 	defer s.connPool.Put(conn)
 
-	return exec.PatchVideoAnnotationJsonMergePatch(ctx, conn, id_, patch, version)
+	newVersion, err := exec.PatchVideoAnnotationJsonMergePatch(ctx, conn, id_, patch, version)
+	if err != nil {
+		return "", err
+	}
+
+	s.publishAnnotationUpdated(ctx, conn, id_, newVersion)
+	return newVersion, nil
+}
+
+func (s *mVideoStorage) SetAnnotation(ctx context.Context, id storage.VideoId, annoJson string, version storage.AnnotationVersion) (storage.AnnotationVersion, error) {
+	id_, err := strconv.Atoi(id)
+	if err != nil {
+		return "", storage.ErrInvalidId()
+	}
+
+	// share the lock with patching to keep versions consistent
+	unlock := s.patchAnnotationMutex.Lock(id_)
+	defer unlock()
+
+	conn, err := s.connPool.Get(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer s.connPool.Put(conn)
+
+	newVersion, err := exec.SetVideoAnnotation(ctx, conn, id_, annoJson, version)
+	if err != nil {
+		return "", err
+	}
+
+	s.publishAnnotationUpdated(ctx, conn, id_, newVersion)
+	return newVersion, nil
+}
+
+func (s *mVideoStorage) publishAnnotationUpdated(ctx context.Context, conn *sqlite.Conn, id int, version storage.AnnotationVersion) {
+	if s.bus == nil {
+		return
+	}
+
+	// the annotation has been saved, thus a failed lookup only skips the event
+	video, err := exec.GetVideo(ctx, conn, id)
+	if err != nil {
+		zap.L().Error("failed to get video for the annotation event", zap.Int("video", id), zap.Error(err))
+		return
+	}
+	s.bus.Publish(event.New(event.AnnotationUpdated, video.ProjectId, map[string]interface{}{
+		"video_id":           video.Id,
+		"annotation_version": version,
+	}))
 }
@@ -0,0 +1,126 @@
+package sqlite3
+
+import (
+	"context"
+	"strconv"
+
+	"nutsh/app/storage"
+	"nutsh/app/storage/sqlite3/exec"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+type mWebhookStorage struct {
+	connPool *connPool
+}
+
+func (s *mWebhookStorage) Create(ctx context.Context, pid storage.ProjectId, req *nutshapi.CreateProjectWebhookReq) (*nutshapi.Webhook, error) {
+	pid_, err := strconv.Atoi(pid)
+	if err != nil {
+		return nil, storage.ErrInvalidId()
+	}
+
+	if req.Url == "" {
+		return nil, storage.ErrMissingField("url")
+	}
+	if len(req.Events) == 0 {
+		return nil, storage.ErrMissingField("events")
+	}
+
+	conn, err := s.connPool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.connPool.Put(conn)
+
+	return exec.CreateWebhook(ctx, conn, pid_, req)
+}
+
+func (s *mWebhookStorage) List(ctx context.Context, pid storage.ProjectId) ([]*nutshapi.Webhook, error) {
+	pid_, err := strconv.Atoi(pid)
+	if err != nil {
+		return nil, storage.ErrInvalidId()
+	}
+
+	conn, err := s.connPool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.connPool.Put(conn)
+
+	return exec.ListWebhooks(ctx, conn, pid_)
+}
+
+func (s *mWebhookStorage) Delete(ctx context.Context, id storage.WebhookId) (*nutshapi.Webhook, error) {
+	id_, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, storage.ErrInvalidId()
+	}
+
+	conn, err := s.connPool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.connPool.Put(conn)
+
+	return exec.DeleteWebhook(ctx, conn, id_)
+}
+
+func (s *mWebhookStorage) Subscribers(ctx context.Context, pid storage.ProjectId, event string) ([]*storage.WebhookSubscriber, error) {
+	pid_, err := strconv.Atoi(pid)
+	if err != nil {
+		return nil, storage.ErrInvalidId()
+	}
+
+	conn, err := s.connPool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.connPool.Put(conn)
+
+	return exec.ListWebhookSubscribers(ctx, conn, pid_, event)
+}
+
+func (s *mWebhookStorage) GetSubscriber(ctx context.Context, id storage.WebhookId) (*storage.WebhookSubscriber, error) {
+	id_, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, storage.ErrInvalidId()
+	}
+
+	conn, err := s.connPool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.connPool.Put(conn)
+
+	return exec.GetWebhookSubscriber(ctx, conn, id_)
+}
+
+func (s *mWebhookStorage) CreateDelivery(ctx context.Context, d *nutshapi.WebhookDelivery) (*nutshapi.WebhookDelivery, error) {
+	wid, err := strconv.Atoi(d.WebhookId)
+	if err != nil {
+		return nil, storage.ErrInvalidId()
+	}
+
+	conn, err := s.connPool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.connPool.Put(conn)
+
+	return exec.CreateWebhookDelivery(ctx, conn, wid, d)
+}
+
+func (s *mWebhookStorage) ListDeliveries(ctx context.Context, id storage.WebhookId) ([]*nutshapi.WebhookDelivery, error) {
+	id_, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, storage.ErrInvalidId()
+	}
+
+	conn, err := s.connPool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.connPool.Put(conn)
+
+	return exec.ListWebhookDeliveries(ctx, conn, id_)
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"nutsh/openapi/gen/nutshapi"
 )
@@ -9,6 +10,8 @@ import (
 type idType = string
 type ProjectId = idType
 type VideoId = idType
+type WebhookId = idType
+type SampleId = idType
 
 type JsonMergePatch = string
 type AnnotationVersion = string
@@ -32,10 +35,46 @@ type Video interface {
 
 	GetAnnotation(context.Context, VideoId) (*string, AnnotationVersion, error)
 	PatchAnnotationJsonMergePatch(context.Context, VideoId, JsonMergePatch, AnnotationVersion) (AnnotationVersion, error)
+
+	// SetAnnotation replaces the whole annotation if its current version is still the given one, which is used to
+	// persist collaborative edits of the shared document. It returns ErrVersionConflict if the annotation has changed
+	// since, such that the caller can merge the change into the document before trying again.
+	SetAnnotation(context.Context, VideoId, string, AnnotationVersion) (AnnotationVersion, error)
 }
 
 type Sample interface {
 	Create(context.Context, ProjectId, *nutshapi.CreateProjectSampleReq) error
+	List(context.Context, ProjectId) ([]*StoredSample, error)
+	Get(context.Context, ProjectId, SampleId) (*StoredSample, error)
+	Delete(context.Context, ProjectId, SampleId) (*StoredSample, error)
+}
+
+// StoredSample is a sample as it was created, whose JSON is a serialized `schema.v1.Sample`.
+type StoredSample struct {
+	Id         SampleId
+	Json       string
+	CreateTime time.Time
+}
+
+type Webhook interface {
+	Create(context.Context, ProjectId, *nutshapi.CreateProjectWebhookReq) (*nutshapi.Webhook, error)
+	List(context.Context, ProjectId) ([]*nutshapi.Webhook, error)
+	Delete(context.Context, WebhookId) (*nutshapi.Webhook, error)
+
+	// Subscribers returns the webhooks of a project which subscribe to the event.
+	Subscribers(context.Context, ProjectId, string /* event */) ([]*WebhookSubscriber, error)
+	GetSubscriber(context.Context, WebhookId) (*WebhookSubscriber, error)
+
+	CreateDelivery(context.Context, *nutshapi.WebhookDelivery) (*nutshapi.WebhookDelivery, error)
+	ListDeliveries(context.Context, WebhookId) ([]*nutshapi.WebhookDelivery, error)
+}
+
+// WebhookSubscriber carries what is needed to deliver an event to a webhook, including the secret which is never
+// exposed through the API.
+type WebhookSubscriber struct {
+	Id     WebhookId
+	Url    string
+	Secret string
 }
 
 type Public interface {
@@ -43,3 +82,39 @@ type Public interface {
 	PutTemp(context.Context, []byte) (string /* url */, error)
 	Check(context.Context, string /* key */) (string /* url */, error)
 }
+
+type PrecomputeJobId = idType
+
+const (
+	PrecomputeJobRunning   = "running"
+	PrecomputeJobSucceeded = "succeeded"
+	PrecomputeJobFailed    = "failed"
+	PrecomputeJobCancelled = "cancelled"
+)
+
+// PrecomputeProgress is persisted while a precompute job runs, such that the job resumes from it after a restart.
+type PrecomputeProgress struct {
+	TotalFrames  int
+	ReadyFrames  int
+	FailedFrames int
+
+	// Cursor is the number of leading frames which have all been processed.
+	Cursor int
+
+	// Error is the last error of failed frames.
+	Error string
+}
+
+type PrecomputeJob interface {
+	// Create returns ErrUniqueFieldConflict if a job for the same decoder is already running in the project.
+	Create(context.Context, ProjectId, string /* decoder uuid */) (*nutshapi.PrecomputeJob, error)
+	Get(context.Context, PrecomputeJobId) (*nutshapi.PrecomputeJob, error)
+	List(context.Context, ProjectId) ([]*nutshapi.PrecomputeJob, error)
+
+	// ListRunning lists jobs of all projects which are still running, including those interrupted by a restart.
+	ListRunning(context.Context) ([]*nutshapi.PrecomputeJob, error)
+
+	GetProgress(context.Context, PrecomputeJobId) (*PrecomputeProgress, error)
+	UpdateProgress(context.Context, PrecomputeJobId, *PrecomputeProgress) error
+	SetState(context.Context, PrecomputeJobId, string) (*nutshapi.PrecomputeJob, error)
+}
@@ -0,0 +1,77 @@
+package supervisor
+
+import (
+	"errors"
+	"time"
+)
+
+type Options struct {
+	name string
+	path string
+	args []string
+	env  []string
+
+	healthUrl      string
+	healthInterval time.Duration
+
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	stopTimeout time.Duration
+}
+
+func (o *Options) Validate() error {
+	if o.path == "" {
+		return errors.New("missing command")
+	}
+	if o.minBackoff <= 0 || o.maxBackoff < o.minBackoff {
+		return errors.New("invalid backoff")
+	}
+	return nil
+}
+
+type Option func(*Options)
+
+// WithName sets the name used to prefix logs of the process.
+func WithName(name string) Option {
+	return func(o *Options) {
+		o.name = name
+	}
+}
+
+func WithCommand(path string, args ...string) Option {
+	return func(o *Options) {
+		o.path = path
+		o.args = args
+	}
+}
+
+// WithEnv sets the variables overriding the environment inherited from the current process, each as `KEY=VALUE`.
+func WithEnv(env []string) Option {
+	return func(o *Options) {
+		o.env = env
+	}
+}
+
+// WithHealthUrl makes the supervisor regularly probe the URL, which is regarded healthy when responding 200.
+func WithHealthUrl(url string, interval time.Duration) Option {
+	return func(o *Options) {
+		o.healthUrl = url
+		o.healthInterval = interval
+	}
+}
+
+// WithBackoff sets the delay before restarting a crashed process, which doubles after each consecutive crash up to
+// the maximum.
+func WithBackoff(min, max time.Duration) Option {
+	return func(o *Options) {
+		o.minBackoff = min
+		o.maxBackoff = max
+	}
+}
+
+// WithStopTimeout sets how long to wait for the process to exit after SIGTERM before killing it.
+func WithStopTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.stopTimeout = timeout
+	}
+}
@@ -0,0 +1,264 @@
+package supervisor
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zapio"
+
+	"nutsh/module/common"
+)
+
+type State string
+
+const (
+	StateStarting   State = "starting"
+	StateRunning    State = "running"
+	StateRestarting State = "restarting"
+	StateStopped    State = "stopped"
+)
+
+// A process which has been running for this long is regarded stable, thus its next crash restarts it without delay
+// accumulated from earlier crashes.
+const stableDuration = time.Minute
+
+type Status struct {
+	State State
+
+	// Healthy is true if the process is running and its latest health probe, if any, succeeded.
+	Healthy bool
+
+	// Restarts counts how many times the process has been restarted after exiting unexpectedly.
+	Restarts int
+
+	// LastError is the latest reason of the process exiting or failing the health probe.
+	LastError error
+}
+
+// Supervisor keeps a child process running, restarting it with backoff when it exits unexpectedly.
+type Supervisor interface {
+	Start()
+
+	// Stop sends SIGTERM to the process, kills it if it does not exit in time, and waits until it is gone.
+	Stop()
+
+	Status() Status
+}
+
+func New(opts ...Option) (Supervisor, error) {
+	o := &Options{
+		name:           "process",
+		healthInterval: 5 * time.Second,
+		minBackoff:     time.Second,
+		maxBackoff:     30 * time.Second,
+		stopTimeout:    10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &mSupervisor{
+		options: o,
+		logger:  zap.L().With(zap.String("process", o.name)),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		status: Status{
+			State: StateStarting,
+		},
+	}, nil
+}
+
+type mSupervisor struct {
+	options *Options
+	logger  *zap.Logger
+
+	// the context is cancelled once stopped, which terminates the running process
+	ctx       context.Context
+	cancel    context.CancelFunc
+	startOnce sync.Once
+	done      chan struct{}
+
+	mu      sync.Mutex
+	status  Status
+	probeOk bool
+}
+
+func (s *mSupervisor) Start() {
+	s.startOnce.Do(func() {
+		go s.run()
+		if s.options.healthUrl != "" {
+			go s.probe()
+		}
+	})
+}
+
+func (s *mSupervisor) Stop() {
+	if s.ctx.Err() == nil {
+		s.logger.Info("terminating")
+	}
+	s.cancel()
+
+	// a supervisor which was never started has nothing to wait for
+	started := true
+	s.startOnce.Do(func() { started = false })
+	if !started {
+		s.setState(StateStopped, nil)
+		return
+	}
+	<-s.done
+}
+
+func (s *mSupervisor) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.status
+	status.Healthy = status.State == StateRunning && (s.options.healthUrl == "" || s.probeOk)
+	return status
+}
+
+func (s *mSupervisor) run() {
+	defer close(s.done)
+
+	backoff := s.options.minBackoff
+	for {
+		begin := time.Now()
+		err := s.runOnce()
+
+		if s.ctx.Err() != nil {
+			s.logger.Info("stopped")
+			s.setState(StateStopped, nil)
+			return
+		}
+
+		if err == nil {
+			err = errors.New("exited unexpectedly")
+		}
+		if time.Since(begin) > stableDuration {
+			backoff = s.options.minBackoff
+		}
+		s.logger.Error("process exited, will restart", zap.Error(err), zap.Duration("backoff", backoff))
+
+		s.mu.Lock()
+		s.status.State = StateRestarting
+		s.status.LastError = err
+		s.status.Restarts++
+		s.mu.Unlock()
+
+		select {
+		case <-s.ctx.Done():
+			s.setState(StateStopped, nil)
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > s.options.maxBackoff {
+			backoff = s.options.maxBackoff
+		}
+	}
+}
+
+func (s *mSupervisor) runOnce() error {
+	// the process group is sent SIGTERM once stopped, and is killed if it does not exit within the stop timeout
+	stdout := &zapio.Writer{Log: s.logger.With(zap.String("stream", "stdout")), Level: zapcore.InfoLevel}
+	defer stdout.Close()
+	cmd := &common.Command{
+		Bin:         s.options.path,
+		Args:        s.options.args,
+		Name:        s.options.name,
+		Env:         s.options.env,
+		Stdout:      stdout,
+		GracePeriod: s.options.stopTimeout,
+		Logger:      zap.L(),
+	}
+
+	s.mu.Lock()
+	if s.ctx.Err() != nil {
+		// do not spawn a process which nobody will terminate
+		s.mu.Unlock()
+		return nil
+	}
+	p, err := cmd.Start(s.ctx)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.status.State = StateRunning
+	s.probeOk = false
+	s.mu.Unlock()
+	s.logger.Info("started", zap.Int("pid", p.Pid()))
+
+	return p.Wait()
+}
+
+func (s *mSupervisor) probe() {
+	client := &http.Client{Timeout: s.options.healthInterval}
+	ticker := time.NewTicker(s.options.healthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := s.probeOnce(client)
+
+		s.mu.Lock()
+		if s.status.State != StateRunning {
+			s.mu.Unlock()
+			continue
+		}
+		if err != nil && s.probeOk {
+			s.logger.Warn("health probe failed", zap.Error(err))
+		}
+		s.probeOk = err == nil
+		if err != nil {
+			s.status.LastError = err
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *mSupervisor) probeOnce(client *http.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.options.healthInterval)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.options.healthUrl, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *mSupervisor) setState(state State, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.State = state
+	if err != nil {
+		s.status.LastError = err
+	}
+}
@@ -0,0 +1,124 @@
+package supervisor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func requireEventually(t *testing.T, cond func() bool) {
+	require.Eventually(t, cond, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestRestartWithBackoff(t *testing.T) {
+	s, err := New(
+		WithCommand("sh", "-c", "exit 1"),
+		WithBackoff(time.Millisecond, 4*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	s.Start()
+	requireEventually(t, func() bool { return s.Status().Restarts >= 3 })
+	s.Stop()
+
+	status := s.Status()
+	require.Equal(t, StateStopped, status.State)
+	require.False(t, status.Healthy)
+	require.Error(t, status.LastError)
+}
+
+func TestStopGracefully(t *testing.T) {
+	// the trap proves that the process receives SIGTERM rather than being killed
+	s, err := New(
+		WithCommand("sh", "-c", "trap 'exit 0' TERM; while true; do sleep 0.01; done"),
+		WithStopTimeout(5*time.Second),
+	)
+	require.NoError(t, err)
+
+	s.Start()
+	requireEventually(t, func() bool { return s.Status().State == StateRunning })
+
+	begin := time.Now()
+	s.Stop()
+	require.Less(t, time.Since(begin), 5*time.Second)
+	require.Equal(t, StateStopped, s.Status().State)
+	require.Zero(t, s.Status().Restarts)
+}
+
+func TestStopKillAfterTimeout(t *testing.T) {
+	s, err := New(
+		WithCommand("sh", "-c", "trap '' TERM; while true; do sleep 0.01; done"),
+		WithStopTimeout(50*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	s.Start()
+	requireEventually(t, func() bool { return s.Status().State == StateRunning })
+	s.Stop()
+	require.Equal(t, StateStopped, s.Status().State)
+}
+
+func TestForwardLongLines(t *testing.T) {
+	// the output after the long line overflows the pipe unless it is read
+	s, err := New(
+		WithCommand("sh", "-c", "head -c 100000 /dev/zero | tr '\\0' a; echo; head -c 1000000 /dev/zero | tr '\\0' b; echo; exit 1"),
+		WithBackoff(time.Millisecond, time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	s.Start()
+	requireEventually(t, func() bool { return s.Status().Restarts >= 1 })
+	s.Stop()
+}
+
+func TestRestartDespiteOrphanHoldingOutput(t *testing.T) {
+	// the orphan keeps the outputs open long after the process has exited
+	s, err := New(
+		WithCommand("sh", "-c", "sleep 10 & exit 1"),
+		WithBackoff(time.Millisecond, time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	s.Start()
+	requireEventually(t, func() bool { return s.Status().Restarts >= 2 })
+	s.Stop()
+}
+
+func TestHealthProbe(t *testing.T) {
+	healthy := make(chan bool, 1)
+	healthy <- true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok := <-healthy
+		healthy <- ok
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	s, err := New(
+		WithCommand("sh", "-c", "sleep 10"),
+		WithHealthUrl(ts.URL, 10*time.Millisecond),
+		WithStopTimeout(time.Second),
+	)
+	require.NoError(t, err)
+	defer s.Stop()
+
+	s.Start()
+	requireEventually(t, func() bool { return s.Status().Healthy })
+
+	<-healthy
+	healthy <- false
+	requireEventually(t, func() bool { return !s.Status().Healthy })
+	require.Error(t, s.Status().LastError)
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	s, err := New(WithCommand("sh"))
+	require.NoError(t, err)
+	s.Stop()
+	require.Equal(t, StateStopped, s.Status().State)
+}
@@ -0,0 +1,274 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"nutsh/app/event"
+	"nutsh/app/storage"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+// Events which can be subscribed by webhooks.
+const (
+	EventVideoCreated      = event.VideoCreated
+	EventVideoDeleted      = event.VideoDeleted
+	EventAnnotationUpdated = event.AnnotationUpdated
+	EventProjectExported   = event.ProjectExported
+
+	// EventPing is only sent when testing a webhook and can not be subscribed.
+	EventPing = "ping"
+)
+
+const (
+	HeaderEvent     = "X-Nutsh-Event"
+	HeaderDelivery  = "X-Nutsh-Delivery"
+	HeaderSignature = "X-Nutsh-Signature-256"
+)
+
+func IsEvent(typ string) bool {
+	switch typ {
+	case EventVideoCreated, EventVideoDeleted, EventAnnotationUpdated, EventProjectExported:
+		return true
+	}
+	return false
+}
+
+type Dispatcher interface {
+	// Dispatch delivers an event to the subscribing webhooks of its project in the background.
+	Dispatch(ctx context.Context, e *event.Event)
+
+	// Test sends a ping event to a webhook in a single attempt and waits for the delivery to finish.
+	Test(ctx context.Context, id storage.WebhookId) (*nutshapi.WebhookDelivery, error)
+
+	// Close stops dispatching events published on the bus, and cancels the deliveries in progress and waits for them to
+	// be recorded.
+	Close()
+}
+
+func New(opts ...Option) (Dispatcher, error) {
+	o := &Options{
+		client:         &http.Client{Timeout: 10 * time.Second},
+		maxAttempts:    5,
+		initialBackoff: 1 * time.Second,
+		maxBackoff:     1 * time.Minute,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &mDispatcher{options: o, ctx: ctx, cancel: cancel}
+	if bus := o.bus; bus != nil {
+		// no event is dropped however many are published at once, since each is delivered with retries
+		events, unsubscribe := bus.SubscribeLossless("")
+		d.unsubscribe = unsubscribe
+		go d.listen(events)
+	}
+
+	return d, nil
+}
+
+type mDispatcher struct {
+	options     *Options
+	unsubscribe func()
+
+	// ctx is the context of deliveries, which is cancelled on closing
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	closed     bool
+	deliveries sync.WaitGroup
+}
+
+// listen dispatches the subscribable events published on the bus until unsubscribed.
+func (d *mDispatcher) listen(events <-chan *event.Event) {
+	for e := range events {
+		if IsEvent(e.Type) {
+			d.Dispatch(context.Background(), e)
+		}
+	}
+}
+
+func (d *mDispatcher) Dispatch(_ context.Context, e *event.Event) {
+	// The delivery outlives the request which triggers the event, thus the request context is not used.
+	d.goDeliver(func() {
+		ctx := d.ctx
+		logger := zap.L().With(zap.String("project", e.ProjectId), zap.String("event", e.Type))
+
+		subs, err := d.options.storage.Subscribers(ctx, e.ProjectId, e.Type)
+		if err != nil {
+			logger.Error("failed to list webhook subscribers", zap.Error(err))
+			return
+		}
+		if len(subs) == 0 {
+			return
+		}
+
+		body, err := json.Marshal(e)
+		if err != nil {
+			logger.Error("failed to marshal webhook payload", zap.Error(err))
+			return
+		}
+
+		for _, sub := range subs {
+			sub := sub
+			d.goDeliver(func() {
+				d.deliver(ctx, sub, e.Type, body, d.options.maxAttempts)
+			})
+		}
+	})
+}
+
+// goDeliver runs the function in the background unless the dispatcher is closed, such that closing waits for it.
+func (d *mDispatcher) goDeliver(f func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	d.deliveries.Add(1)
+	go func() {
+		defer d.deliveries.Done()
+		f()
+	}()
+}
+
+func (d *mDispatcher) Close() {
+	if d.unsubscribe != nil {
+		d.unsubscribe()
+	}
+
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+	d.cancel()
+	d.deliveries.Wait()
+}
+
+func (d *mDispatcher) Test(ctx context.Context, id storage.WebhookId) (*nutshapi.WebhookDelivery, error) {
+	sub, err := d.options.storage.GetSubscriber(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(event.New(EventPing, "", nil))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return d.deliver(ctx, sub, EventPing, body, 1), nil
+}
+
+// deliver sends the payload until it succeeds or runs out of attempts, and records the outcome in the delivery log.
+func (d *mDispatcher) deliver(ctx context.Context, sub *storage.WebhookSubscriber, event string, body []byte, maxAttempts int) *nutshapi.WebhookDelivery {
+	logger := zap.L().With(zap.String("webhook", sub.Id), zap.String("event", event))
+
+	delivery := &nutshapi.WebhookDelivery{
+		WebhookId: sub.Id,
+		Event:     event,
+		Payload:   string(body),
+	}
+	backoff := d.options.initialBackoff
+	for attempt := 1; ; attempt++ {
+		delivery.Attempts = attempt
+
+		code, err := d.post(ctx, sub, event, body)
+		delivery.StatusCode = nil
+		if code != 0 {
+			delivery.StatusCode = &code
+		}
+		if err == nil {
+			delivery.Succeeded = true
+			delivery.Error = nil
+			break
+		}
+		msg := err.Error()
+		delivery.Error = &msg
+		logger.Warn("failed to deliver webhook", zap.Int("attempt", attempt), zap.Error(err))
+
+		if attempt == maxAttempts || !retryable(code) || !sleep(ctx, backoff) {
+			break
+		}
+		if backoff *= 2; backoff > d.options.maxBackoff {
+			backoff = d.options.maxBackoff
+		}
+	}
+
+	// record the delivery even if the caller has gone
+	rec, err := d.options.storage.CreateDelivery(context.Background(), delivery)
+	if err != nil {
+		logger.Error("failed to record webhook delivery", zap.Error(err))
+		return delivery
+	}
+	return rec
+}
+
+func (d *mDispatcher) post(ctx context.Context, sub *storage.WebhookSubscriber, event string, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", sub.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, event)
+	req.Header.Set(HeaderDelivery, uuid.NewString())
+	if sub.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(sub.Secret, body))
+	}
+
+	resp, err := d.options.client.Do(req)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, errors.Errorf("unexpected HTTP status code %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign computes the value of the signature header, which receivers can recompute with the shared secret to verify
+// that a payload is sent by nutsh.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return fmt.Sprintf("sha256=%s", hex.EncodeToString(mac.Sum(nil)))
+}
+
+// sleep waits for the duration and returns false if the context is done earlier.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Client errors other than timeout and throttling will not be resolved by retrying.
+func retryable(code int) bool {
+	if code >= 400 && code < 500 {
+		return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+	}
+	return true
+}
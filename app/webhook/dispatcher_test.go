@@ -0,0 +1,204 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"nutsh/app/event"
+	"nutsh/app/storage"
+	"nutsh/openapi/gen/nutshapi"
+)
+
+// mStorage is an in-memory webhook storage holding a single subscriber.
+type mStorage struct {
+	storage.Webhook
+
+	sub        *storage.WebhookSubscriber
+	mu         sync.Mutex
+	deliveries []*nutshapi.WebhookDelivery
+	delivered  chan struct{}
+}
+
+func (s *mStorage) Subscribers(ctx context.Context, pid storage.ProjectId, event string) ([]*storage.WebhookSubscriber, error) {
+	return []*storage.WebhookSubscriber{s.sub}, nil
+}
+
+func (s *mStorage) GetSubscriber(ctx context.Context, id storage.WebhookId) (*storage.WebhookSubscriber, error) {
+	if id != s.sub.Id {
+		return nil, storage.ErrNotFound()
+	}
+	return s.sub, nil
+}
+
+func (s *mStorage) CreateDelivery(ctx context.Context, d *nutshapi.WebhookDelivery) (*nutshapi.WebhookDelivery, error) {
+	s.mu.Lock()
+	s.deliveries = append(s.deliveries, d)
+	s.mu.Unlock()
+	s.delivered <- struct{}{}
+	return d, nil
+}
+
+func requireDispatcher(t *testing.T, url string) (Dispatcher, *mStorage) {
+	store := &mStorage{
+		sub: &storage.WebhookSubscriber{
+			Id:     "1",
+			Url:    url,
+			Secret: "secret",
+		},
+		delivered: make(chan struct{}, 1),
+	}
+	d, err := New(
+		WithStorage(store),
+		WithMaxAttempts(3),
+		WithBackoff(time.Millisecond, 4*time.Millisecond),
+	)
+	require.NoError(t, err)
+	return d, store
+}
+
+func requireDelivery(t *testing.T, store *mStorage) *nutshapi.WebhookDelivery {
+	select {
+	case <-store.delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery timeout")
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.deliveries[len(store.deliveries)-1]
+}
+
+func TestDispatchSigned(t *testing.T) {
+	var received event.Event
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, Sign("secret", body), r.Header.Get(HeaderSignature))
+		require.Equal(t, EventVideoCreated, r.Header.Get(HeaderEvent))
+		require.NoError(t, json.Unmarshal(body, &received))
+	}))
+	defer ts.Close()
+
+	d, store := requireDispatcher(t, ts.URL)
+	d.Dispatch(context.Background(), event.New(EventVideoCreated, "1", map[string]string{"foo": "bar"}))
+
+	delivery := requireDelivery(t, store)
+	require.True(t, delivery.Succeeded)
+	require.Equal(t, 1, delivery.Attempts)
+	require.Equal(t, EventVideoCreated, received.Type)
+	require.Equal(t, "1", received.ProjectId)
+}
+
+func TestDispatchRetry(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	d, store := requireDispatcher(t, ts.URL)
+	d.Dispatch(context.Background(), event.New(EventVideoDeleted, "1", nil))
+
+	delivery := requireDelivery(t, store)
+	require.True(t, delivery.Succeeded)
+	require.Equal(t, 3, delivery.Attempts)
+}
+
+func TestDispatchNoRetryOnClientError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	d, store := requireDispatcher(t, ts.URL)
+	d.Dispatch(context.Background(), event.New(EventProjectExported, "1", nil))
+
+	delivery := requireDelivery(t, store)
+	require.False(t, delivery.Succeeded)
+	require.Equal(t, 1, delivery.Attempts)
+	require.NotNil(t, delivery.StatusCode)
+	require.Equal(t, http.StatusBadRequest, *delivery.StatusCode)
+}
+
+func TestPing(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, EventPing, r.Header.Get(HeaderEvent))
+	}))
+	defer ts.Close()
+
+	d, store := requireDispatcher(t, ts.URL)
+	go func() { <-store.delivered }()
+
+	delivery, err := d.Test(context.Background(), "1")
+	require.NoError(t, err)
+	require.True(t, delivery.Succeeded)
+
+	_, err = d.Test(context.Background(), "2")
+	require.True(t, storage.IsErrNotFound(err))
+}
+
+func TestDispatchBurst(t *testing.T) {
+	var received int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+	}))
+	defer ts.Close()
+
+	// far more events than buffered for a lossy subscriber are published at once
+	n := 500
+	store := &mStorage{
+		sub:       &storage.WebhookSubscriber{Id: "1", Url: ts.URL},
+		delivered: make(chan struct{}, n),
+	}
+	bus := event.NewBus()
+	d, err := New(WithStorage(store), WithEventBus(bus))
+	require.NoError(t, err)
+	defer d.Close()
+	for i := 0; i < n; i++ {
+		bus.Publish(event.New(EventVideoCreated, "1", nil))
+	}
+
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&received) == int32(n) }, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestCloseCancelsDeliveries(t *testing.T) {
+	requested := make(chan struct{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		select {
+		case requested <- struct{}{}:
+		default:
+		}
+	}))
+	defer ts.Close()
+
+	store := &mStorage{
+		sub:       &storage.WebhookSubscriber{Id: "1", Url: ts.URL},
+		delivered: make(chan struct{}, 1),
+	}
+	d, err := New(WithStorage(store), WithBackoff(time.Minute, time.Minute))
+	require.NoError(t, err)
+	d.Dispatch(context.Background(), event.New(EventVideoCreated, "1", nil))
+	<-requested
+
+	// the delivery waiting for its retry is recorded before closing returns
+	begin := time.Now()
+	d.Close()
+	require.Less(t, time.Since(begin), 5*time.Second)
+	require.Len(t, store.deliveries, 1)
+	require.False(t, store.deliveries[0].Succeeded)
+	require.Equal(t, 1, store.deliveries[0].Attempts)
+
+	d.Dispatch(context.Background(), event.New(EventVideoCreated, "1", nil))
+	require.Len(t, store.deliveries, 1)
+}
@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"nutsh/app/event"
+	"nutsh/app/storage"
+)
+
+type Options struct {
+	storage storage.Webhook
+	client  *http.Client
+	bus     event.Bus
+
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+func (o *Options) Validate() error {
+	if o.storage == nil {
+		return errors.New("missing webhook storage")
+	}
+	if o.maxAttempts < 1 {
+		return errors.New("max attempts must be positive")
+	}
+	return nil
+}
+
+type Option func(*Options)
+
+func WithStorage(s storage.Webhook) Option {
+	return func(o *Options) {
+		o.storage = s
+	}
+}
+
+// WithEventBus makes the dispatcher deliver events published on the bus.
+func WithEventBus(bus event.Bus) Option {
+	return func(o *Options) {
+		o.bus = bus
+	}
+}
+
+func WithClient(client *http.Client) Option {
+	return func(o *Options) {
+		o.client = client
+	}
+}
+
+func WithMaxAttempts(n int) Option {
+	return func(o *Options) {
+		o.maxAttempts = n
+	}
+}
+
+// WithBackoff sets the delay before the first retry, which doubles after each failed attempt up to the maximum.
+func WithBackoff(initial, max time.Duration) Option {
+	return func(o *Options) {
+		o.initialBackoff = initial
+		o.maxBackoff = max
+	}
+}
@@ -3,7 +3,6 @@
 
 import http from "node:http";
 import { WebSocketServer } from "ws";
-import { verbose, Database } from "sqlite3";
 // If I install and import `yjs` directly from this package, a warning will show:
 // - Yjs was already imported. This breaks constructor checks and will lead to issues!
 // - https://github.com/yjs/yjs/issues/438
@@ -11,18 +10,112 @@ import { verbose, Database } from "sqlite3";
 // It is not elegant, but only as a temporary workaround.
 // TODO(xu): introduce workspace to better organize projects.
 import { setupWSConnection, setPersistence } from "../../frontend/src/common/yjs";
-import { writeAnnotationToYjs, readAnnotationFromYjs } from "../../frontend/src/common/yjs/convert";
+import {
+  writeAnnotationToYjs,
+  readAnnotationFromYjs,
+  mergeAnnotationIntoYjs,
+} from "../../frontend/src/common/yjs/convert";
 import { mustDecodeJsonStr as mustDecodeAnnotationJsonStr } from "../../frontend/src/type/annotation";
-
-const databasePath = process.env.DATABASE_PATH;
-if (!databasePath) {
-  throw new Error("missing DATABASE_PATH");
+import type { Annotation } from "../../frontend/src/type/annotation";
+
+// Annotations are loaded and stored through the internal API of the nutsh server rather than the database, such that
+// collaborative edits are versioned and published like any other change. Each document is stored on top of the version
+// it was loaded or last stored at, and a change made through the API in between is merged into the document.
+const apiUrl = process.env.NUTSH_API_URL;
+if (!apiUrl) {
+  throw new Error("missing NUTSH_API_URL");
 }
+const apiHeaders = {
+  Authorization: `Bearer ${process.env.NUTSH_INTERNAL_TOKEN || ""}`,
+  "Content-Type": "application/json",
+};
 
 const readOnly = process.env.READ_ONLY;
 
-verbose();
-const db = new Database(databasePath);
+// Updates of a document arrive in bursts while editing, thus they are coalesced before being persisted.
+const persistDelayMs = 500;
+
+interface StoredAnnotation {
+  annoJsonStr?: string;
+  version: string;
+}
+
+// The last loaded or stored annotation keyed by the video id, which is the base of the next store.
+const storedAnnotations = new Map<string, StoredAnnotation>();
+
+async function loadAnnotation(videoId: string): Promise<StoredAnnotation> {
+  const resp = await fetch(`${apiUrl}/video/${videoId}/annotation`, { headers: apiHeaders });
+  if (!resp.ok) {
+    throw new Error(`unexpected status ${resp.status}`);
+  }
+  const { annotation_json: annoJsonStr, annotation_version: version } = (await resp.json()) as {
+    annotation_json?: string;
+    annotation_version?: string;
+  };
+  return { annoJsonStr, version: version || "" };
+}
+
+// Returns the new version, or undefined if the annotation has changed since the given version.
+async function storeAnnotation(videoId: string, annoJsonStr: string, version: string): Promise<string | undefined> {
+  const resp = await fetch(`${apiUrl}/video/${videoId}/annotation`, {
+    method: "PUT",
+    headers: apiHeaders,
+    body: JSON.stringify({ annotation_json: annoJsonStr, annotation_version: version }),
+  });
+  if (resp.status === 409) {
+    return undefined;
+  }
+  if (!resp.ok) {
+    throw new Error(`unexpected status ${resp.status}`);
+  }
+  const { annotation_version: newVersion } = (await resp.json()) as { annotation_version?: string };
+  return newVersion || "";
+}
+
+function decodeStoredAnnotation({ annoJsonStr }: StoredAnnotation): Annotation {
+  return annoJsonStr ? mustDecodeAnnotationJsonStr(annoJsonStr) : { entities: {} };
+}
+
+// Pending writes keyed by the video id.
+const pendingWrites = new Map<string, { timer: NodeJS.Timeout; flush: () => Promise<void> }>();
+
+function schedulePersist(videoId: string, doc: Parameters<typeof readAnnotationFromYjs>[0]): void {
+  const pending = pendingWrites.get(videoId);
+  if (pending) {
+    clearTimeout(pending.timer);
+  }
+  const flush = async (): Promise<void> => {
+    pendingWrites.delete(videoId);
+    const annoJsonStr = JSON.stringify(readAnnotationFromYjs(doc));
+    const base = storedAnnotations.get(videoId) || { version: "" };
+    try {
+      const version = await storeAnnotation(videoId, annoJsonStr, base.version);
+      if (version !== undefined) {
+        storedAnnotations.set(videoId, { annoJsonStr, version });
+        console.log(`persisted annotation for video ${videoId}`);
+        return;
+      }
+
+      // Merge the change made in between into the document and store it again on top of the latest version.
+      console.log(`annotation of video ${videoId} changed since version ${base.version}, merging`);
+      const latest = await loadAnnotation(videoId);
+      mergeAnnotationIntoYjs(decodeStoredAnnotation(base), decodeStoredAnnotation(latest), doc);
+      storedAnnotations.set(videoId, latest);
+      schedulePersist(videoId, doc);
+    } catch (e) {
+      console.error(`failed to save annotation for video ${videoId}`, e);
+    }
+  };
+  pendingWrites.set(videoId, { timer: setTimeout(() => void flush(), persistDelayMs), flush });
+}
+
+async function flushPersist(videoId: string): Promise<void> {
+  // A store conflicting with a change made in between schedules another one after merging.
+  for (let pending = pendingWrites.get(videoId); pending; pending = pendingWrites.get(videoId)) {
+    clearTimeout(pending.timer);
+    await pending.flush();
+  }
+}
 
 const server = http.createServer((_, response) => {
   response.writeHead(200, { "Content-Type": "text/plain" });
@@ -55,79 +148,58 @@ server.on("upgrade", (request, socket, head) => {
 // https://github.com/yjs/y-websocket/issues/76
 setPersistence({
   bindState: async (videoId, doc): Promise<void> => {
-    return new Promise<void>((resolve, reject) => {
-      db.get<{ anno: string | null } | undefined>(
-        "SELECT annotation_json AS anno FROM videos WHERE id = ?",
-        [videoId],
-        (err, row) => {
-          if (err) {
-            console.error(`failed to fetch annotation for video ${videoId}`, err.message);
-            reject(err);
-            return;
-          }
-
-          const annoJsonStr = row?.anno;
-          if (!annoJsonStr) {
-            // no annotation
-            console.log(`initializing a new document for ${videoId}`);
-            resolve();
-            return;
-          }
-
-          // convert annotation to yjs doc
-          try {
-            const anno = mustDecodeAnnotationJsonStr(annoJsonStr);
-            writeAnnotationToYjs(anno, doc);
-          } catch (e) {
-            console.log(`failed to decode annotation json for ${videoId}`, e);
-            reject(new Error("failed to decode annotation json"));
-            return;
-          }
-
-          // It is IMPORTANT to start listenning the `update` event AFTER the conversion,
-          // since the conversion itself will trigger the update event.
-          if (readOnly) {
-            console.log("will NOT persist data in read-only mode");
-          } else {
-            doc.on("update", () => {
-              console.log(`doc updated for video ${videoId}`);
-
-              const a = readAnnotationFromYjs(doc);
-              db.run("UPDATE videos SET annotation_json = ? WHERE id = ?", [JSON.stringify(a), videoId], (e) => {
-                if (e) {
-                  console.error(`failed to save annotation for video ${videoId}`, e.message);
-                } else {
-                  console.log(`persisted annotation for video ${videoId}`);
-                }
-              });
-            });
-          }
-
-          resolve();
-        }
-      );
-    });
-  },
-  writeState: async () => {
-    // This is called when all connections to the document are closed.
-    // Do nothing for now.
-    return new Promise<void>((resolve) => {
-      resolve();
-    });
-  },
-});
+    let stored: StoredAnnotation;
+    try {
+      stored = await loadAnnotation(videoId);
+    } catch (e) {
+      console.error(`failed to fetch annotation for video ${videoId}`, e);
+      throw e;
+    }
+    storedAnnotations.set(videoId, stored);
+    const { annoJsonStr } = stored;
 
-server.on("close", () => {
-  db.close((err) => {
-    if (err) {
-      console.error("failed to close sqlite3", err.message);
+    if (!annoJsonStr) {
+      // no annotation
+      console.log(`initializing a new document for ${videoId}`);
       return;
     }
-    console.log("Closed the database connection.");
-  });
+
+    // convert annotation to yjs doc
+    try {
+      const anno = mustDecodeAnnotationJsonStr(annoJsonStr);
+      writeAnnotationToYjs(anno, doc);
+    } catch (e) {
+      console.log(`failed to decode annotation json for ${videoId}`, e);
+      throw new Error("failed to decode annotation json");
+    }
+
+    // It is IMPORTANT to start listenning the `update` event AFTER the conversion,
+    // since the conversion itself will trigger the update event.
+    if (readOnly) {
+      console.log("will NOT persist data in read-only mode");
+    } else {
+      doc.on("update", () => {
+        console.log(`doc updated for video ${videoId}`);
+        schedulePersist(videoId, doc);
+      });
+    }
+  },
+  writeState: async (videoId): Promise<void> => {
+    // This is called when all connections to the document are closed.
+    await flushPersist(videoId);
+    storedAnnotations.delete(videoId);
+  },
 });
 
 const port = process.env.PORT || 7777;
 server.listen(port, () => {
-  console.log("running", { port, databasePath });
+  console.log("running", { port, apiUrl });
+});
+
+// The nutsh server stops the yjs-server before itself on shutdown, such that pending writes can still be persisted
+// through its internal API.
+process.on("SIGTERM", () => {
+  console.log("flushing pending writes before exiting");
+  server.close();
+  void Promise.all([...pendingWrites.keys()].map((videoId) => flushPersist(videoId))).finally(() => process.exit(0));
 });
@@ -0,0 +1,121 @@
+// Command nutsh-fake serves the online segmentation and the track services without running any model, such that the
+// application can be developed and tested end to end without GPUs.
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+
+	"nutsh/app/fake"
+	servicev1 "nutsh/proto/gen/go/service/v1"
+)
+
+func main() {
+	logger, err := zap.NewProduction()
+	mustOk(err)
+	zap.ReplaceGlobals(logger)
+
+	app := &cli.App{
+		Name:   "nutsh-fake",
+		Usage:  "Serve deterministic stand-ins of the online segmentation and track services",
+		Action: runServe,
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:    "port",
+				Aliases: []string{"p"},
+				Usage:   "port to listen",
+				Value:   12345,
+				EnvVars: []string{"NUTSH_FAKE_PORT"},
+			},
+			&cli.DurationFlag{
+				Name:    "latency",
+				Usage:   "latency added to each request and each streamed message",
+				EnvVars: []string{"NUTSH_FAKE_LATENCY"},
+			},
+			&cli.DurationFlag{
+				Name:    "jitter",
+				Usage:   "maximum random latency added on top of --latency",
+				EnvVars: []string{"NUTSH_FAKE_JITTER"},
+			},
+			&cli.Float64Flag{
+				Name:    "error-rate",
+				Usage:   "fraction of requests failed on purpose",
+				EnvVars: []string{"NUTSH_FAKE_ERROR_RATE"},
+			},
+			&cli.StringFlag{
+				Name:    "error-code",
+				Usage:   "gRPC code of the failed requests, e.g. UNAVAILABLE or RESOURCE_EXHAUSTED",
+				Value:   "UNAVAILABLE",
+				EnvVars: []string{"NUTSH_FAKE_ERROR_CODE"},
+			},
+			&cli.Int64Flag{
+				Name:    "seed",
+				Usage:   "seed of the random jitter and failures",
+				EnvVars: []string{"NUTSH_FAKE_SEED"},
+			},
+		},
+	}
+	mustOk(app.Run(os.Args))
+}
+
+func runServe(ctx *cli.Context) error {
+	rate := ctx.Float64("error-rate")
+	if rate < 0 || rate > 1 {
+		return errors.Errorf("error rate should be in [0, 1] but got %v", rate)
+	}
+	code, err := parseCode(ctx.String("error-code"))
+	if err != nil {
+		return err
+	}
+
+	grpcServer := grpc.NewServer(fake.ServerOptions(
+		fake.WithLatency(ctx.Duration("latency"), ctx.Duration("jitter")),
+		fake.WithErrorRate(rate, code),
+		fake.WithSeed(ctx.Int64("seed")),
+	)...)
+	servicev1.RegisterOnlineSegmentationServiceServer(grpcServer, fake.NewOnlineSegmentationServer())
+	servicev1.RegisterTrackServiceServer(grpcServer, fake.NewTrackServer())
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+	reflection.Register(grpcServer)
+
+	addr := fmt.Sprintf(":%d", ctx.Int("port"))
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	zap.L().Info("listening on "+addr, zap.String("decoder", fake.DecoderUuid))
+	return errors.WithStack(grpcServer.Serve(lis))
+}
+
+func parseCode(s string) (codes.Code, error) {
+	var code codes.Code
+	if err := code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(s)))); err != nil {
+		return 0, errors.Errorf("invalid gRPC code %s", s)
+	}
+	return code, nil
+}
+
+func mustOk(err error) {
+	if err == nil {
+		return
+	}
+
+	if os.Getenv("DEBUG") != "" {
+		fmt.Printf("%+v\n", err)
+	} else {
+		fmt.Printf("%v\n", err)
+	}
+	os.Exit(1)
+}
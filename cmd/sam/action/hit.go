@@ -4,7 +4,10 @@ import (
 	"context"
 	"io"
 	"net/http"
-	"time"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
@@ -15,80 +18,68 @@ import (
 	servicev1 "nutsh/proto/gen/go/service/v1"
 )
 
-func Hit(ctx *cli.Context) error {
-	// introspect to get uuid
-	introspectResp, err := introspect(ctx)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	decoderUuid := introspectResp.GetDecoderUuid()
+// the extensions of images loaded from a directory
+var imageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".bmp": true, ".webp": true}
 
-	imageUrl := ctx.String("image")
-	zap.L().Info("downloading image", zap.String("url", imageUrl))
-	image, err := downloadImage(ctx.Context, imageUrl)
+// Hit loads the SAM server with embedding requests and reports their latency, throughput and errors. Since the server
+// caches embeddings, requests of the same image after the first one measure the cache unless it is disabled.
+func Hit(ctx *cli.Context) error {
+	images, err := loadImages(ctx)
 	if err != nil {
 		return err
 	}
 
-	req := &servicev1.EmbedImageRequest{
-		OriginalImage: image,
-		DecoderUuid:   decoderUuid,
-	}
-
-	// concurrently embed
-	type mResult struct {
-		Cost  time.Duration
-		Error error
+	// the requests are spread over the connections
+	var clients []servicev1.OnlineSegmentationServiceClient
+	for i := 0; i < ctx.Int("connections"); i++ {
+		conn, err := dial(ctx.String("address"))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		clients = append(clients, servicev1.NewOnlineSegmentationServiceClient(conn))
 	}
-	ch := make(chan *mResult)
-	n := ctx.Int("count")
-	for i := 0; i < n; i++ {
-		go func() {
-			start := time.Now()
-			_, err := embedImage(ctx, req)
-			ch <- &mResult{
-				Cost:  time.Since(start),
-				Error: err,
-			}
-		}()
+	if len(clients) == 0 {
+		return errors.New("at least one connection is required")
 	}
 
-	for i := 0; i < n; i++ {
-		res := <-ch
-		if err := res.Error; err != nil {
-			zap.L().Info("failed", zap.Duration("cost", res.Cost), zap.Error(err))
-		} else {
-			zap.L().Info("succeeded", zap.Duration("cost", res.Cost))
+	decoderUuid := ctx.String("decoder")
+	if decoderUuid == "" {
+		resp, err := clients[0].Introspect(ctx.Context, &servicev1.IntrospectRequest{})
+		if err != nil {
+			return errors.WithStack(err)
 		}
+		decoderUuid = resp.GetDecoderUuid()
 	}
 
-	return nil
-}
-
-func introspect(ctx *cli.Context) (*servicev1.IntrospectResponse, error) {
-	// gRPC client
-	addr := ctx.String("address")
-	conn, err := grpc.Dial(addr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
+	timeout := ctx.Duration("timeout")
+	zap.L().Info("start hitting", zap.String("decoder", decoderUuid), zap.Int("images", len(images)))
+	report, err := runLoad(ctx.Context, loadOptions{
+		concurrency: ctx.Int("concurrency"),
+		rate:        ctx.Float64("rate"),
+		count:       ctx.Int("count"),
+		duration:    ctx.Duration("duration"),
+		warmup:      ctx.Duration("warmup"),
+	}, func(c context.Context, i int) error {
+		c, cancel := context.WithTimeout(c, timeout)
+		defer cancel()
+		_, err := clients[i%len(clients)].EmbedImage(c, &servicev1.EmbedImageRequest{
+			OriginalImage: images[i%len(images)],
+			DecoderUuid:   decoderUuid,
+		})
+		return err
+	})
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return err
 	}
-	defer conn.Close()
-	client := servicev1.NewOnlineSegmentationServiceClient(conn)
 
-	// introspect
-	resp, err := client.Introspect(ctx.Context, &servicev1.IntrospectRequest{})
-	if err != nil {
-		return nil, errors.WithStack(err)
+	if ctx.String("format") == "json" {
+		return report.printJson(os.Stdout)
 	}
-
-	return resp, nil
+	return report.printTable(os.Stdout)
 }
 
-func embedImage(ctx *cli.Context, req *servicev1.EmbedImageRequest) (*servicev1.EmbedImageResponse, error) {
-	// gRPC client
-	addr := ctx.String("address")
+func dial(addr string) (*grpc.ClientConn, error) {
 	conn, err := grpc.Dial(addr,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(8*1024*1024 /* 8M */)),
@@ -96,20 +87,46 @@ func embedImage(ctx *cli.Context, req *servicev1.EmbedImageRequest) (*servicev1.
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	defer conn.Close()
-	client := servicev1.NewOnlineSegmentationServiceClient(conn)
-
-	// set timeout
-	ctx2, cancel := context.WithTimeout(ctx.Context, ctx.Duration("timeout"))
-	defer cancel()
+	return conn, nil
+}
 
-	// embed
-	resp, err := client.EmbedImage(ctx2, req)
-	if err != nil {
-		return nil, errors.WithStack(err)
+// loadImages loads the images in the directory if given, otherwise downloads those of the urls.
+func loadImages(ctx *cli.Context) ([][]byte, error) {
+	var images [][]byte
+	if dir := ctx.String("image-dir"); dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		for _, entry := range entries {
+			if entry.IsDir() || !imageExts[strings.ToLower(filepath.Ext(entry.Name()))] {
+				continue
+			}
+			im, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			images = append(images, im)
+		}
+		if len(images) == 0 {
+			return nil, errors.Errorf("no image found in %s", dir)
+		}
+		return images, nil
 	}
 
-	return resp, nil
+	for _, url := range ctx.StringSlice("image") {
+		zap.L().Info("downloading image", zap.String("url", url))
+		im, err := downloadImage(ctx.Context, url)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, im)
+	}
+	if len(images) == 0 {
+		return nil, errors.New("at least one image is required")
+	}
+	return images, nil
 }
 
 func downloadImage(ctx context.Context, url string) ([]byte, error) {
@@ -0,0 +1,251 @@
+package sam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/status"
+)
+
+type loadOptions struct {
+	// the number of requests in flight at most
+	concurrency int
+
+	// the number of requests started per second, unlimited if not positive
+	rate float64
+
+	// the run ends once either the number of requests are started or the duration has passed, whichever is set
+	count    int
+	duration time.Duration
+
+	// the results of requests started during the warmup are not reported
+	warmup time.Duration
+}
+
+// loadCall sends the i-th request.
+type loadCall func(ctx context.Context, i int) error
+
+// loadJob is a request to send, which is scheduled to start at a time if sent at a rate.
+type loadJob struct {
+	i         int
+	scheduled time.Time
+}
+
+type loadResult struct {
+	// the scheduled start at a rate, or else the actual one
+	start time.Time
+	cost  time.Duration
+	err   error
+
+	// whether the request started later than scheduled by more than the interval
+	late bool
+}
+
+// runLoad calls concurrently at the rate until the count or the duration is reached, and waits for the requests in
+// flight to finish. At a rate, the latency of a request is measured from its scheduled start rather than when a worker
+// picks it up, such that the time spent waiting for a busy server is not omitted, and the requests left behind by the
+// schedule are reported as late.
+func runLoad(ctx context.Context, opt loadOptions, call loadCall) (*loadReport, error) {
+	if opt.concurrency <= 0 {
+		return nil, errors.Errorf("concurrency should be positive but got %d", opt.concurrency)
+	}
+	if opt.count <= 0 && opt.duration <= 0 {
+		return nil, errors.New("either the count or the duration should be set")
+	}
+
+	begin := time.Now()
+	measureFrom := begin.Add(opt.warmup)
+	dispatchCtx := ctx
+	if opt.duration > 0 {
+		var cancel context.CancelFunc
+		dispatchCtx, cancel = context.WithDeadline(ctx, measureFrom.Add(opt.duration))
+		defer cancel()
+	}
+
+	var interval time.Duration
+	if opt.rate > 0 {
+		interval = time.Duration(float64(time.Second) / opt.rate)
+	}
+
+	jobs := make(chan loadJob)
+	go func() {
+		defer close(jobs)
+		next := time.Now()
+		for i := 0; opt.count <= 0 || i < opt.count; i++ {
+			job := loadJob{i: i}
+			if interval > 0 {
+				if d := time.Until(next); d > 0 {
+					select {
+					case <-time.After(d):
+					case <-dispatchCtx.Done():
+						return
+					}
+				}
+				// the schedule does not slip when workers are busy, such that the backlog is sent once they are free
+				job.scheduled = next
+				next = next.Add(interval)
+			}
+			select {
+			case jobs <- job:
+			case <-dispatchCtx.Done():
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	var results []*loadResult
+	var wg sync.WaitGroup
+	for w := 0; w < opt.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				// requests in flight are not cancelled when the duration has passed
+				start := time.Now()
+				r := &loadResult{start: start}
+				if !job.scheduled.IsZero() {
+					r.late = start.Sub(job.scheduled) > interval
+					r.start = job.scheduled
+				}
+				r.err = call(ctx, job.i)
+				r.cost = time.Since(r.start)
+				mu.Lock()
+				results = append(results, r)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	end := time.Now()
+
+	var measured []*loadResult
+	for _, r := range results {
+		if !r.start.Before(measureFrom) {
+			measured = append(measured, r)
+		}
+	}
+	elapsed := time.Duration(0)
+	if end.After(measureFrom) {
+		elapsed = end.Sub(measureFrom)
+	}
+	return newLoadReport(measured, elapsed), nil
+}
+
+// loadReport summarizes the measured requests, whose latency are in milliseconds.
+type loadReport struct {
+	Requests  int `json:"requests"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+
+	// the number of requests started later than scheduled by more than the interval of the rate, because all workers
+	// were busy
+	Late int `json:"late"`
+
+	// the number of failed requests by their gRPC codes
+	Errors map[string]int `json:"errors"`
+
+	DurationSeconds float64 `json:"duration_seconds"`
+
+	// the number of succeeded requests per second
+	Throughput float64 `json:"throughput"`
+
+	// the latency of succeeded requests
+	Latency latencyStats `json:"latency_ms"`
+}
+
+type latencyStats struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+func newLoadReport(results []*loadResult, elapsed time.Duration) *loadReport {
+	report := &loadReport{
+		Requests:        len(results),
+		Errors:          make(map[string]int),
+		DurationSeconds: elapsed.Seconds(),
+	}
+
+	var costs []float64
+	for _, r := range results {
+		if r.late {
+			report.Late++
+		}
+		if r.err != nil {
+			report.Failed++
+			report.Errors[status.Code(r.err).String()]++
+			continue
+		}
+		report.Succeeded++
+		costs = append(costs, float64(r.cost)/float64(time.Millisecond))
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(report.Succeeded) / elapsed.Seconds()
+	}
+
+	if len(costs) > 0 {
+		sort.Float64s(costs)
+		sum := 0.0
+		for _, c := range costs {
+			sum += c
+		}
+		report.Latency = latencyStats{
+			Min:  costs[0],
+			Mean: sum / float64(len(costs)),
+			P50:  percentile(costs, 50),
+			P90:  percentile(costs, 90),
+			P99:  percentile(costs, 99),
+			Max:  costs[len(costs)-1],
+		}
+	}
+	return report
+}
+
+// percentile picks the nearest rank of the sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func (r *loadReport) printJson(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.WithStack(enc.Encode(r))
+}
+
+func (r *loadReport) printTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "requests\t%d\n", r.Requests)
+	fmt.Fprintf(tw, "succeeded\t%d\n", r.Succeeded)
+	fmt.Fprintf(tw, "failed\t%d\n", r.Failed)
+	codes := make([]string, 0, len(r.Errors))
+	for code := range r.Errors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Fprintf(tw, "  %s\t%d\n", code, r.Errors[code])
+	}
+	fmt.Fprintf(tw, "late\t%d\n", r.Late)
+	fmt.Fprintf(tw, "duration\t%.2fs\n", r.DurationSeconds)
+	fmt.Fprintf(tw, "throughput\t%.2f/s\n", r.Throughput)
+	l := r.Latency
+	fmt.Fprintf(tw, "latency\tmin %.1fms\tmean %.1fms\tp50 %.1fms\tp90 %.1fms\tp99 %.1fms\tmax %.1fms\n",
+		l.Min, l.Mean, l.P50, l.P90, l.P99, l.Max)
+	return errors.WithStack(tw.Flush())
+}
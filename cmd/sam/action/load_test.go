@@ -0,0 +1,103 @@
+package sam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRunLoadCount(t *testing.T) {
+	var inflight, maxInflight atomic.Int32
+	report, err := runLoad(context.Background(), loadOptions{concurrency: 4, count: 100}, func(ctx context.Context, i int) error {
+		n := inflight.Add(1)
+		defer inflight.Add(-1)
+		for {
+			m := maxInflight.Load()
+			if n <= m || maxInflight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		switch i % 10 {
+		case 0:
+			return status.Error(codes.ResourceExhausted, "full")
+		case 1:
+			return status.Error(codes.DeadlineExceeded, "slow")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.LessOrEqual(t, maxInflight.Load(), int32(4))
+	require.Equal(t, 100, report.Requests)
+	require.Equal(t, 80, report.Succeeded)
+	require.Equal(t, map[string]int{"ResourceExhausted": 10, "DeadlineExceeded": 10}, report.Errors)
+	require.Greater(t, report.Throughput, 0.0)
+	require.GreaterOrEqual(t, report.Latency.P50, 1.0)
+
+	var buf bytes.Buffer
+	require.NoError(t, report.printJson(&buf))
+	var decoded loadReport
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, *report, decoded)
+	buf.Reset()
+	require.NoError(t, report.printTable(&buf))
+	require.Contains(t, buf.String(), "ResourceExhausted")
+}
+
+func TestRunLoadDurationRateWarmup(t *testing.T) {
+	var calls atomic.Int32
+	report, err := runLoad(context.Background(), loadOptions{
+		concurrency: 2,
+		rate:        100,
+		duration:    200 * time.Millisecond,
+		warmup:      100 * time.Millisecond,
+	}, func(ctx context.Context, i int) error {
+		calls.Add(1)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// about 30 requests are sent in total, the first third of which are in the warmup
+	require.InDelta(t, 30, calls.Load(), 8)
+	require.InDelta(t, 20, report.Requests, 6)
+	require.Less(t, report.Requests, int(calls.Load()))
+	require.InDelta(t, 100, report.Throughput, 30)
+}
+
+func TestRunLoadRateLatencyFromSchedule(t *testing.T) {
+	// a server slower than the rate falls behind the schedule, whose delay counts in the latency
+	report, err := runLoad(context.Background(), loadOptions{
+		concurrency: 1,
+		rate:        100,
+		count:       10,
+	}, func(ctx context.Context, i int) error {
+		time.Sleep(30 * time.Millisecond)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 10, report.Requests)
+	require.Equal(t, 9, report.Late)
+
+	// the last request is scheduled at 90ms but starts at 270ms
+	require.GreaterOrEqual(t, report.Latency.Max, 200.0)
+	require.GreaterOrEqual(t, report.Latency.Min, 30.0)
+}
+
+func TestPercentile(t *testing.T) {
+	values := make([]float64, 100)
+	for i := range values {
+		values[i] = float64(i + 1)
+	}
+	require.Equal(t, 50.0, percentile(values, 50))
+	require.Equal(t, 90.0, percentile(values, 90))
+	require.Equal(t, 99.0, percentile(values, 99))
+	require.Equal(t, 1.0, percentile(values, 0))
+	require.Equal(t, 7.0, percentile([]float64{7}, 99))
+}
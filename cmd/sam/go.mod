@@ -4,6 +4,7 @@ go 1.19
 
 require (
 	github.com/pkg/errors v0.9.1
+	github.com/stretchr/testify v1.8.2
 	github.com/urfave/cli/v2 v2.25.3
 	go.uber.org/zap v1.24.0
 	google.golang.org/grpc v1.55.0
@@ -13,7 +14,6 @@ require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.2 // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
-	github.com/stretchr/testify v1.8.2 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
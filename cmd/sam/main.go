@@ -19,7 +19,7 @@ func main() {
 		Commands: []*cli.Command{
 			{
 				Name:        "hit",
-				Description: "Hit the SAM module for developing and testing",
+				Description: "Load the SAM module with embedding requests and report their latency, throughput and errors",
 				Flags: []cli.Flag{
 					&cli.StringFlag{
 						Name:     "address",
@@ -27,23 +27,58 @@ func main() {
 						Required: true,
 						Usage:    "address to the SAM server",
 					},
+					&cli.StringFlag{
+						Name:  "decoder",
+						Usage: "uuid of the decoder to embed for, which defaults to the default one of the server",
+					},
 					&cli.IntFlag{
-						Name:     "count",
-						Aliases:  []string{"c"},
-						Required: true,
-						Usage:    "number of requests to send",
+						Name:    "count",
+						Aliases: []string{"c"},
+						Usage:   "number of requests to send including the warmup, unlimited if a duration is set",
 					},
-					&cli.StringFlag{
+					&cli.DurationFlag{
+						Name:    "duration",
+						Aliases: []string{"d"},
+						Usage:   "duration to send requests for after the warmup",
+					},
+					&cli.DurationFlag{
+						Name:  "warmup",
+						Usage: "duration to send requests for before measuring",
+					},
+					&cli.IntFlag{
+						Name:  "concurrency",
+						Value: 1,
+						Usage: "number of requests in flight at most",
+					},
+					&cli.Float64Flag{
+						Name:  "rate",
+						Usage: "number of requests to start per second, unlimited if not positive; at a rate, latencies count from the scheduled starts",
+					},
+					&cli.IntFlag{
+						Name:  "connections",
+						Value: 1,
+						Usage: "number of connections to spread the requests over",
+					},
+					&cli.StringSliceFlag{
 						Name:    "image",
 						Aliases: []string{"i"},
-						Value:   "https://segment-anything.com/assets/gallery/farmhouse_in_provence_1970.17.34.jpg",
-						Usage:   "the url of the image used to test",
+						Value:   cli.NewStringSlice("https://segment-anything.com/assets/gallery/farmhouse_in_provence_1970.17.34.jpg"),
+						Usage:   "urls of the images to send in turn",
+					},
+					&cli.StringFlag{
+						Name:  "image-dir",
+						Usage: "directory of the images to send in turn instead of the urls",
 					},
 					&cli.DurationFlag{
 						Name:  "timeout",
 						Value: 15 * time.Second,
 						Usage: "embed timeout",
 					},
+					&cli.StringFlag{
+						Name:  "format",
+						Value: "table",
+						Usage: "format of the report in [table, json]",
+					},
 				},
 				Action: sam.Hit,
 			},
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/urfave/cli/v2"
@@ -59,11 +60,10 @@ func main() {
 				EnvVars:     []string{"NUTSH_READONLY"},
 				Destination: &action.StartOption.Readonly,
 			},
-			&cli.StringFlag{
-				Name:        "online-segmentation",
-				Usage:       "address to a online segmenation server",
-				EnvVars:     []string{"NUTSH_ONLINE_SEGMENTATION"},
-				Destination: &action.StartOption.OnlineSegmentationAddr,
+			&cli.StringSliceFlag{
+				Name:    "online-segmentation",
+				Usage:   "addresses to online segmenation servers, which can be repeated or separated by commas",
+				EnvVars: []string{"NUTSH_ONLINE_SEGMENTATION"},
 			},
 			&cli.StringFlag{
 				Name:        "track",
@@ -71,12 +71,95 @@ func main() {
 				EnvVars:     []string{"NUTSH_TRACK"},
 				Destination: &action.StartOption.TrackAddr,
 			},
+			&cli.IntFlag{
+				Name:        "precompute-concurrency",
+				Usage:       "number of chunks of frames embedded concurrently by each precompute job",
+				Value:       2,
+				EnvVars:     []string{"NUTSH_PRECOMPUTE_CONCURRENCY"},
+				Destination: &action.StartOption.PrecomputeConcurrency,
+			},
 			&cli.StringFlag{
 				Name:        "data-dir",
 				Usage:       "data directory to serve local files",
 				EnvVars:     []string{"NUTSH_DATA_DIR"},
 				Destination: &action.StartOption.DataDir,
 			},
+			&cli.DurationFlag{
+				Name:        "shutdown-timeout",
+				Usage:       "time to wait for in-flight requests to finish when shutting down",
+				Value:       20 * time.Second,
+				EnvVars:     []string{"NUTSH_SHUTDOWN_TIMEOUT"},
+				Destination: &action.StartOption.ShutdownTimeout,
+			},
+			&cli.StringFlag{
+				Name:        "trace-exporter",
+				Usage:       "exporter of traces in [otlp, stdout], or disable exporting if empty",
+				EnvVars:     []string{"NUTSH_TRACE_EXPORTER"},
+				Destination: &action.StartOption.TraceExporter,
+			},
+			&cli.StringFlag{
+				Name:        "trace-endpoint",
+				Usage:       "address of the OTLP collector, which defaults to OTEL_EXPORTER_OTLP_ENDPOINT",
+				EnvVars:     []string{"NUTSH_TRACE_ENDPOINT"},
+				Destination: &action.StartOption.TraceEndpoint,
+			},
+			&cli.BoolFlag{
+				Name:        "trace-insecure",
+				Usage:       "connect to the OTLP collector without TLS",
+				EnvVars:     []string{"NUTSH_TRACE_INSECURE"},
+				Destination: &action.StartOption.TraceInsecure,
+			},
+			&cli.DurationFlag{
+				Name:        "grpc-timeout",
+				Usage:       "deadline of calls to the online segmentation and track servers, excluding streams",
+				Value:       2 * time.Minute,
+				EnvVars:     []string{"NUTSH_GRPC_TIMEOUT"},
+				Destination: &action.StartOption.GrpcTimeout,
+			},
+			&cli.IntFlag{
+				Name:        "grpc-max-attempts",
+				Usage:       "maximum attempts of calls to the online segmentation and track servers when they are unavailable",
+				Value:       3,
+				EnvVars:     []string{"NUTSH_GRPC_MAX_ATTEMPTS"},
+				Destination: &action.StartOption.GrpcMaxAttempts,
+			},
+			&cli.DurationFlag{
+				Name:        "grpc-keepalive",
+				Usage:       "interval to ping idle connections to the online segmentation and track servers, or 0 to disable",
+				Value:       5 * time.Minute,
+				EnvVars:     []string{"NUTSH_GRPC_KEEPALIVE"},
+				Destination: &action.StartOption.GrpcKeepalive,
+			},
+			&cli.StringFlag{
+				Name:        "grpc-tls-ca",
+				Usage:       "CA certificate to verify the online segmentation and track servers through TLS",
+				EnvVars:     []string{"NUTSH_GRPC_TLS_CA"},
+				Destination: &action.StartOption.GrpcTlsCa,
+			},
+			&cli.StringFlag{
+				Name:        "grpc-tls-cert",
+				Usage:       "client certificate for mTLS",
+				EnvVars:     []string{"NUTSH_GRPC_TLS_CERT"},
+				Destination: &action.StartOption.GrpcTlsCert,
+			},
+			&cli.StringFlag{
+				Name:        "grpc-tls-key",
+				Usage:       "client key for mTLS",
+				EnvVars:     []string{"NUTSH_GRPC_TLS_KEY"},
+				Destination: &action.StartOption.GrpcTlsKey,
+			},
+			&cli.StringFlag{
+				Name:        "grpc-tls-server-name",
+				Usage:       "server name to verify, which also enables TLS with the system CAs",
+				EnvVars:     []string{"NUTSH_GRPC_TLS_SERVER_NAME"},
+				Destination: &action.StartOption.GrpcTlsServerName,
+			},
+			&cli.StringFlag{
+				Name:        "grpc-token",
+				Usage:       "bearer token sent to the online segmentation and track servers",
+				EnvVars:     []string{"NUTSH_GRPC_TOKEN"},
+				Destination: &action.StartOption.GrpcToken,
+			},
 		},
 		Commands: []*cli.Command{
 			{
@@ -99,6 +182,69 @@ func main() {
 					},
 				},
 			},
+			{
+				Name:   "precompute",
+				Usage:  "Precompute embeddings of all frames in a project on a running server",
+				Action: runPrecompute,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "server",
+						Usage:       "url of the running nutsh server",
+						Value:       "http://localhost:12346",
+						EnvVars:     []string{"NUTSH_SERVER"},
+						Destination: &action.PrecomputeOption.ServerUrl,
+					},
+					&cli.StringFlag{
+						Name:        "project",
+						Usage:       "id of the project",
+						Required:    true,
+						Destination: &action.PrecomputeOption.ProjectId,
+					},
+					&cli.StringFlag{
+						Name:        "decoder",
+						Usage:       "uuid of the decoder, which defaults to the default one of the server",
+						Destination: &action.PrecomputeOption.DecoderUuid,
+					},
+					&cli.BoolFlag{
+						Name:        "detach",
+						Usage:       "return once the job is created, leaving it running on the server",
+						Destination: &action.PrecomputeOption.Detach,
+					},
+				},
+			},
+			{
+				Name:   "export-samples",
+				Usage:  "Export the samples of a project on a running server as a fine-tuning dataset",
+				Action: runExportSamples,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "server",
+						Usage:       "url of the running nutsh server",
+						Value:       "http://localhost:12346",
+						EnvVars:     []string{"NUTSH_SERVER"},
+						Destination: &action.ExportSamplesOption.ServerUrl,
+					},
+					&cli.StringFlag{
+						Name:        "project",
+						Usage:       "id of the project",
+						Required:    true,
+						Destination: &action.ExportSamplesOption.ProjectId,
+					},
+					&cli.StringFlag{
+						Name:        "output",
+						Aliases:     []string{"o"},
+						Usage:       "directory to export to, which can be used as the workspace of `nutsh-sam finetune`",
+						Required:    true,
+						Destination: &action.ExportSamplesOption.Output,
+					},
+					&cli.Float64Flag{
+						Name:        "val-ratio",
+						Usage:       "ratio of images whose samples are held out for validation",
+						Value:       0.1,
+						Destination: &action.ExportSamplesOption.ValRatio,
+					},
+				},
+			},
 		},
 	}
 
@@ -109,6 +255,7 @@ func runStart(ctx *cli.Context) error {
 	action.StartOption.Frontend = echo.MustSubFS(frontend, "app/frontend/build")
 	action.StartOption.Doc = echo.MustSubFS(docs, "docs/build")
 	action.StartOption.YJSServer = yjsServer
+	action.StartOption.OnlineSegmentationAddrs = ctx.StringSlice("online-segmentation")
 	return action.Start(ctx.Context)
 }
 
@@ -121,6 +268,14 @@ func runImport(ctx *cli.Context) error {
 	return action.Import(ctx.Context)
 }
 
+func runPrecompute(ctx *cli.Context) error {
+	return action.Precompute(ctx.Context)
+}
+
+func runExportSamples(ctx *cli.Context) error {
+	return action.ExportSamples(ctx.Context)
+}
+
 func mustSetupLogger() *zap.Logger {
 	logger, err := zap.NewProduction()
 	mustOk(err)
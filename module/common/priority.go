@@ -0,0 +1,36 @@
+package common
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Requests to the model servers are prioritized by their class carried in the gRPC metadata, such that bulk work does
+// not starve interactive users.
+const PriorityMetadataKey = "nutsh-priority"
+
+const (
+	// PriorityInteractive is the default class, of requests a user is waiting for.
+	PriorityInteractive = "interactive"
+
+	// PriorityBatch is the class of requests in bulk, e.g. prefetching or precomputing embeddings.
+	PriorityBatch = "batch"
+)
+
+// WithPriority sets the priority class of outgoing calls.
+func WithPriority(ctx context.Context, priority string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, PriorityMetadataKey, priority)
+}
+
+// IncomingPriority gets the priority class of an incoming call, which defaults to the interactive one.
+func IncomingPriority(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return PriorityInteractive
+	}
+	if vs := md.Get(PriorityMetadataKey); len(vs) > 0 && vs[0] == PriorityBatch {
+		return PriorityBatch
+	}
+	return PriorityInteractive
+}
@@ -0,0 +1,404 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultStderrLimit is the default number of trailing bytes of stderr kept to explain a failure.
+const DefaultStderrLimit = 64 * 1024
+
+// lines of stderr longer than this are truncated when forwarded to the logger
+const maxLogLineLength = 16 * 1024
+
+// Command describes a subprocess, which runs in its own process group such that killing it also kills any process it
+// has spawned. Its stderr is forwarded line by line to the logger, and its tail is kept to explain a failure.
+type Command struct {
+	Bin  string
+	Args []string
+
+	// Name identifies the process in logs and errors, which defaults to the base name of the binary.
+	Name string
+
+	// Env overrides the environment inherited from the current process, each in the form of `KEY=VALUE`.
+	Env []string
+	Dir string
+
+	// Stdin is read till its end unless it is an *os.File, and Stdout is discarded if nil.
+	Stdin  io.Reader
+	Stdout io.Writer
+
+	// StderrLimit is the number of trailing bytes of stderr kept, which defaults to DefaultStderrLimit.
+	StderrLimit int
+
+	// GracePeriod is how long the process group is given to exit after SIGTERM once the context is done, before it
+	// is killed by SIGKILL. It is killed right away if zero.
+	GracePeriod time.Duration
+
+	// Logger receives the lines of stderr, which defaults to the global logger.
+	Logger *zap.Logger
+}
+
+// Process is a started Command.
+type Process struct {
+	name   string
+	cmd    *exec.Cmd
+	stderr *ringBuffer
+
+	// the copying of the outputs of the process, which ends once all processes in the group have closed them
+	copying sync.WaitGroup
+
+	done    chan struct{}
+	err     error
+	stopped chan struct{}
+	stop    sync.Once
+
+	// whether the process is terminated since the context is done
+	terminated atomic.Bool
+}
+
+// ExitError reports a process which did not exit successfully.
+type ExitError struct {
+	Name string
+
+	// Code is the exit code of the process, or -1 if it is killed by a signal.
+	Code   int
+	Signal syscall.Signal
+
+	// Stderr is the tail of the stderr of the process.
+	Stderr string
+}
+
+func (e *ExitError) Error() string {
+	var msg string
+	if e.Code < 0 {
+		msg = fmt.Sprintf("%s killed by signal %s", e.Name, e.Signal)
+	} else {
+		msg = fmt.Sprintf("%s exited with code %d", e.Name, e.Code)
+	}
+	if tail := strings.TrimSpace(e.Stderr); tail != "" {
+		msg += ": " + tail
+	}
+	return msg
+}
+
+// Run starts the command and waits for it.
+func (c *Command) Run(ctx context.Context) error {
+	p, err := c.Start(ctx)
+	if err != nil {
+		return err
+	}
+	return p.Wait()
+}
+
+// Start starts the command, whose process group is killed once the context is done.
+func (c *Command) Start(ctx context.Context) (*Process, error) {
+	name := c.Name
+	if name == "" {
+		name = filepath.Base(c.Bin)
+	}
+	limit := c.StderrLimit
+	if limit <= 0 {
+		limit = DefaultStderrLimit
+	}
+
+	cmd := exec.Command(c.Bin, c.Args...)
+	cmd.Env = mergeEnv(os.Environ(), c.Env)
+	cmd.Dir = c.Dir
+	cmd.Stdin = c.Stdin
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	p := &Process{
+		name:    name,
+		cmd:     cmd,
+		stderr:  newRingBuffer(limit),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	// pipes are created explicitly rather than by the command, whose Wait would otherwise block until every process
+	// in the group has closed them
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cmd.Stderr = stderrW
+	var stdoutR, stdoutW *os.File
+	switch stdout := c.Stdout.(type) {
+	case nil:
+	case *os.File:
+		cmd.Stdout = stdout
+	default:
+		stdoutR, stdoutW, err = os.Pipe()
+		if err != nil {
+			stderrR.Close()
+			stderrW.Close()
+			return nil, errors.WithStack(err)
+		}
+		cmd.Stdout = stdoutW
+	}
+
+	err = cmd.Start()
+	stderrW.Close()
+	if stdoutW != nil {
+		stdoutW.Close()
+	}
+	if err != nil {
+		stderrR.Close()
+		if stdoutR != nil {
+			stdoutR.Close()
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	logger := c.Logger
+	if logger == nil {
+		logger = zap.L()
+	}
+	p.copying.Add(1)
+	go func() {
+		defer p.copying.Done()
+		defer stderrR.Close()
+		p.forwardStderr(stderrR, logger)
+	}()
+	if stdoutR != nil {
+		p.copying.Add(1)
+		go func() {
+			defer p.copying.Done()
+			defer stdoutR.Close()
+			io.Copy(c.Stdout, stdoutR)
+		}()
+	}
+	logger.Debug("started process", zap.String("process", name), zap.Int("pid", cmd.Process.Pid), zap.String("cmd", cmd.String()))
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.terminate(c.GracePeriod)
+		case <-p.stopped:
+		}
+	}()
+	go p.wait(ctx)
+	return p, nil
+}
+
+func (p *Process) wait(ctx context.Context) {
+	err := p.cmd.Wait()
+
+	// no process is left behind in the group, which also ends the copying of its outputs
+	p.signalGroup(syscall.SIGKILL)
+	p.copying.Wait()
+	p.stop.Do(func() { close(p.stopped) })
+
+	switch {
+	case p.terminated.Load():
+		p.err = errors.WithStack(ctx.Err())
+	case err == nil:
+	default:
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			p.err = errors.WithStack(err)
+			break
+		}
+		e := &ExitError{
+			Name:   p.name,
+			Code:   exitErr.ExitCode(),
+			Stderr: p.stderr.String(),
+		}
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			e.Signal = status.Signal()
+		}
+		p.err = e
+	}
+	close(p.done)
+}
+
+// terminate asks the process group to exit, and kills it if it does not within the grace period.
+func (p *Process) terminate(grace time.Duration) {
+	p.terminated.Store(true)
+	if grace > 0 {
+		p.signalGroup(syscall.SIGTERM)
+		select {
+		case <-p.stopped:
+			return
+		case <-time.After(grace):
+		}
+	}
+	p.signalGroup(syscall.SIGKILL)
+}
+
+func (p *Process) signalGroup(sig syscall.Signal) {
+	// the process group has the same id as its leader
+	syscall.Kill(-p.cmd.Process.Pid, sig)
+}
+
+func (p *Process) forwardStderr(r io.Reader, logger *zap.Logger) {
+	logger = logger.With(zap.String("process", p.name), zap.Int("pid", p.cmd.Process.Pid))
+	reader := bufio.NewReader(r)
+	for {
+		line, err := readLine(reader)
+		if line != nil {
+			p.stderr.Write(line)
+			p.stderr.Write([]byte{'\n'})
+			if len(line) > maxLogLineLength {
+				line = line[:maxLogLineLength]
+			}
+			if ce := logger.Check(stderrLevel(line), string(line)); ce != nil {
+				ce.Write()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readLine reads a line without its ending regardless of its length.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		line = append(line, chunk...)
+		if err != nil {
+			if len(line) == 0 {
+				return nil, err
+			}
+			return line, err
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+// stderrLevel infers the level of a line logged by Python, whose format usually has the name of the level in one of
+// its leading fields, while any other line is logged as info.
+func stderrLevel(line []byte) zapcore.Level {
+	fields := strings.Fields(string(line[:minInt(len(line), 64)]))
+	for i, f := range fields {
+		if i >= 4 {
+			break
+		}
+		switch strings.Trim(f, "[]:") {
+		case "DEBUG":
+			return zapcore.DebugLevel
+		case "WARNING", "WARN":
+			return zapcore.WarnLevel
+		case "ERROR", "CRITICAL":
+			return zapcore.ErrorLevel
+		case "INFO":
+			return zapcore.InfoLevel
+		}
+	}
+	return zapcore.InfoLevel
+}
+
+// Pid returns the id of the process, which is also the id of its process group.
+func (p *Process) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+// Kill kills the process group without waiting for it to exit.
+func (p *Process) Kill() {
+	p.signalGroup(syscall.SIGKILL)
+}
+
+// Done is closed once the process has exited and its outputs have been copied.
+func (p *Process) Done() <-chan struct{} {
+	return p.done
+}
+
+// Wait waits for the process to exit, and returns an *ExitError if it exits unsuccessfully, or the error of the
+// context if it is killed because the context is done.
+func (p *Process) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// Stderr returns the tail of stderr so far.
+func (p *Process) Stderr() string {
+	return p.stderr.String()
+}
+
+// mergeEnv overrides the variables of the base environment, appending those not in it.
+func mergeEnv(base []string, overrides []string) []string {
+	index := make(map[string]int, len(base))
+	env := make([]string, 0, len(base)+len(overrides))
+	for _, kv := range append(append([]string(nil), base...), overrides...) {
+		key := kv
+		if i := strings.Index(kv, "="); i >= 0 {
+			key = kv[:i]
+		}
+		if i, ok := index[key]; ok {
+			env[i] = kv
+			continue
+		}
+		index[key] = len(env)
+		env = append(env, kv)
+	}
+	return env
+}
+
+// ringBuffer keeps the last bytes written to it up to its capacity.
+type ringBuffer struct {
+	mu   sync.Mutex
+	data []byte
+	next int
+	full bool
+}
+
+func newRingBuffer(capacity int) *ringBuffer {
+	return &ringBuffer{data: make([]byte, capacity)}
+}
+
+func (b *ringBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(p)
+	if len(p) >= len(b.data) {
+		copy(b.data, p[len(p)-len(b.data):])
+		b.next, b.full = 0, true
+		return n, nil
+	}
+	copied := copy(b.data[b.next:], p)
+	if copied < len(p) {
+		copy(b.data, p[copied:])
+		b.full = true
+	}
+	b.next = (b.next + len(p)) % len(b.data)
+	if b.next == 0 {
+		b.full = true
+	}
+	return n, nil
+}
+
+func (b *ringBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.full {
+		return string(b.data[:b.next])
+	}
+	return string(b.data[b.next:]) + string(b.data[:b.next])
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
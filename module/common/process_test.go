@@ -0,0 +1,153 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func shell(script string) *Command {
+	return &Command{Bin: "sh", Args: []string{"-c", script}, Logger: zap.NewNop()}
+}
+
+func TestCommandExitCode(t *testing.T) {
+	ctx := context.Background()
+	require.NoError(t, shell("echo ok >&2").Run(ctx))
+
+	err := shell("echo first >&2; echo last >&2; exit 3").Run(ctx)
+	var exitErr *ExitError
+	require.True(t, errors.As(err, &exitErr))
+	require.Equal(t, 3, exitErr.Code)
+	require.Equal(t, "first\nlast\n", exitErr.Stderr)
+	require.Equal(t, "sh exited with code 3: first\nlast", err.Error())
+
+	err = shell("kill -9 $$").Run(ctx)
+	require.True(t, errors.As(err, &exitErr))
+	require.Equal(t, -1, exitErr.Code)
+	require.Contains(t, err.Error(), "killed by signal killed")
+}
+
+func TestCommandStderrLimit(t *testing.T) {
+	c := shell("for i in $(seq 1 1000); do echo line$i >&2; done; exit 1")
+	c.StderrLimit = 16
+	err := c.Run(context.Background())
+	var exitErr *ExitError
+	require.True(t, errors.As(err, &exitErr))
+	require.Equal(t, "ine999\nline1000\n", exitErr.Stderr)
+}
+
+func TestCommandEnv(t *testing.T) {
+	t.Setenv("NUTSH_TEST_INHERITED", "inherited")
+	t.Setenv("NUTSH_TEST_OVERRIDDEN", "original")
+
+	var stdout bytes.Buffer
+	c := shell("echo $NUTSH_TEST_INHERITED $NUTSH_TEST_OVERRIDDEN $NUTSH_TEST_ADDED")
+	c.Env = []string{"NUTSH_TEST_OVERRIDDEN=overridden", "NUTSH_TEST_ADDED=added"}
+	c.Stdout = &stdout
+	require.NoError(t, c.Run(context.Background()))
+	require.Equal(t, "inherited overridden added\n", stdout.String())
+
+	require.Equal(t, []string{"A=3", "B=2", "C=4"}, mergeEnv([]string{"A=1", "B=2"}, []string{"A=3", "C=4"}))
+}
+
+// processAlive tells whether a process is running, regarding a zombie as dead since it may never be reaped in a
+// container.
+func processAlive(pid int) bool {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	// the state follows the command name in parentheses
+	fields := strings.Fields(string(data[bytes.LastIndexByte(data, ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestCommandKillGroup(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("procfs is not available")
+	}
+
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err := shell("sleep 100 & echo $! > " + pidFile + "; wait").Start(ctx)
+	require.NoError(t, err)
+
+	var pid int
+	require.Eventually(t, func() bool {
+		data, err := os.ReadFile(pidFile)
+		if err != nil {
+			return false
+		}
+		pid, err = strconv.Atoi(strings.TrimSpace(string(data)))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	require.True(t, processAlive(pid))
+
+	cancel()
+	require.ErrorIs(t, p.Wait(), context.Canceled)
+	require.Eventually(t, func() bool { return !processAlive(pid) }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCommandGracePeriod(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := shell(`trap "echo bye >&2; exit 0" TERM; echo ready >&2; while true; do sleep 0.01; done`)
+	c.GracePeriod = 5 * time.Second
+	p, err := c.Start(ctx)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return p.Stderr() != "" }, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.ErrorIs(t, p.Wait(), context.Canceled)
+	// the trap ran before the grace period
+	require.True(t, strings.HasSuffix(p.Stderr(), "bye\n"))
+}
+
+func TestCommandLogs(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	c := &Command{
+		Bin: python,
+		Args: []string{"-c", `
+import logging, sys
+logging.basicConfig(level=logging.DEBUG, format="%(asctime)s %(levelname)s %(message)s")
+logging.info("loading")
+logging.warning("careful")
+print("Traceback (most recent call last):", file=sys.stderr)
+logging.error("failed")
+sys.exit(2)
+`},
+		Name:   "stand-in",
+		Logger: zap.New(core),
+	}
+	err = c.Run(context.Background())
+	var exitErr *ExitError
+	require.True(t, errors.As(err, &exitErr))
+	require.Equal(t, 2, exitErr.Code)
+	require.Equal(t, "stand-in", exitErr.Name)
+
+	var levels []zapcore.Level
+	for _, entry := range logs.All() {
+		if entry.ContextMap()["process"] != "stand-in" || entry.Message == "started process" {
+			continue
+		}
+		levels = append(levels, entry.Level)
+	}
+	require.Equal(t, []zapcore.Level{zapcore.InfoLevel, zapcore.WarnLevel, zapcore.InfoLevel, zapcore.ErrorLevel}, levels)
+	require.True(t, strings.HasSuffix(logs.All()[len(logs.All())-1].Message, "ERROR failed"))
+}
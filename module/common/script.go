@@ -0,0 +1,34 @@
+package common
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// EjectScripts writes the top-level files of the scripts into the directory, including any module added to a
+// customized script dir which the others may import.
+func EjectScripts(scripts fs.FS, dir string) error {
+	entries, err := fs.ReadDir(scripts, ".")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := fs.ReadFile(scripts, entry.Name())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, entry.Name()), data, 0644); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
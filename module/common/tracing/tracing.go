@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+const (
+	// ExporterNone disables exporting, while the trace context is still propagated.
+	ExporterNone = ""
+
+	// ExporterOtlp exports spans to an OTLP collector through gRPC.
+	ExporterOtlp = "otlp"
+
+	// ExporterStdout prints spans to the stdout, which is handy for local debugging.
+	ExporterStdout = "stdout"
+)
+
+type Options struct {
+	exporter string
+	endpoint string
+	insecure bool
+}
+
+type Option func(*Options)
+
+func WithExporter(exporter string) Option {
+	return func(o *Options) {
+		o.exporter = exporter
+	}
+}
+
+// WithOtlpEndpoint sets the address of the OTLP collector, which otherwise is read from the standard
+// `OTEL_EXPORTER_OTLP_ENDPOINT` environment variable.
+func WithOtlpEndpoint(endpoint string, insecure bool) Option {
+	return func(o *Options) {
+		o.endpoint = endpoint
+		o.insecure = insecure
+	}
+}
+
+// Setup installs the global tracer provider and the W3C trace context propagator for the service, returning a
+// function to flush pending spans when the service stops.
+func Setup(ctx context.Context, service string, opts ...Option) (func(context.Context) error, error) {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	switch o.exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOtlp:
+		var clientOpts []otlptracegrpc.Option
+		if o.endpoint != "" {
+			clientOpts = append(clientOpts, otlptracegrpc.WithEndpoint(o.endpoint))
+		}
+		if o.insecure {
+			clientOpts = append(clientOpts, otlptracegrpc.WithInsecure())
+		}
+		exp, err := otlptracegrpc.New(ctx, clientOpts...)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		exporter = exp
+	case ExporterStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		exporter = exp
+	default:
+		return nil, errors.Errorf("unknown trace exporter [%s]", o.exporter)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(service),
+	))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	zap.L().Info("tracing enabled", zap.String("exporter", o.exporter), zap.String("service", service))
+
+	return func(ctx context.Context) error {
+		return errors.WithStack(provider.Shutdown(ctx))
+	}, nil
+}
+
+// GrpcDialOptions makes a gRPC client connection create spans for its calls and propagate the trace context through
+// the metadata.
+func GrpcDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(otelgrpc.StreamClientInterceptor()),
+	}
+}
+
+// GrpcServerOptions makes a gRPC server continue the trace propagated by clients.
+func GrpcServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(otelgrpc.StreamServerInterceptor()),
+	}
+}
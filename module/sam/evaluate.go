@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	"nutsh/module/common"
+)
+
+// evaluationMetrics are the metrics of the mask predicted by a decoder against the recorded one.
+type evaluationMetrics struct {
+	Iou       float64 `json:"iou"`
+	BoundaryF float64 `json:"boundary_f"`
+}
+
+// scriptEvaluation is the report written by `evaluate.py`, whose metrics of each sample follow the order of the
+// decoders.
+type scriptEvaluation struct {
+	Decoders []string                  `json:"decoders"`
+	Samples  []*scriptSampleEvaluation `json:"samples"`
+}
+
+type scriptSampleEvaluation struct {
+	File     string              `json:"file"`
+	ImageUrl string              `json:"image_url"`
+	Metrics  []evaluationMetrics `json:"metrics"`
+}
+
+// evaluationReport compares decoders against the first one as the baseline.
+type evaluationReport struct {
+	Decoders []*decoderEvaluation `json:"decoders"`
+	Samples  []*sampleEvaluation  `json:"samples"`
+}
+
+type decoderEvaluation struct {
+	Path           string  `json:"path"`
+	MeanIou        float64 `json:"mean_iou"`
+	BoundaryF      float64 `json:"boundary_f"`
+	DeltaMeanIou   float64 `json:"delta_mean_iou"`
+	DeltaBoundaryF float64 `json:"delta_boundary_f"`
+}
+
+type sampleEvaluation struct {
+	File     string              `json:"file"`
+	ImageUrl string              `json:"image_url"`
+	Metrics  []evaluationMetrics `json:"metrics"`
+
+	// The differences of the metrics of each decoder to those of the baseline.
+	Deltas []evaluationMetrics `json:"deltas"`
+}
+
+func runEvaluate(ctx *cli.Context) error {
+	decoders := ctx.StringSlice("decoder")
+	if len(decoders) == 0 {
+		return errors.New("at least one --decoder is required")
+	}
+
+	scripts, err := loadScripts(ctx, evaluateScripts)
+	if err != nil {
+		return err
+	}
+	workDir, err := ejectScriptPackage(scripts)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	workspace := ctx.String("workspace")
+	if workspace == "" {
+		workspace = filepath.Join(workDir, "workspace")
+	}
+	reportPath := filepath.Join(workDir, "report.json")
+	args := []string{
+		"-m", "script.evaluate",
+		"--model-type", ctx.String("model-type"),
+		"--checkpoint", ctx.String("model-checkpoint"),
+		"--device", common.FormatDevice(ctx.String("device")),
+		"--sample-dir", ctx.String("sample-dir"),
+		"--workspace", workspace,
+		"--report", reportPath,
+		"--decoder",
+	}
+	args = append(args, decoders...)
+
+	r := &common.PythonRuntime{
+		Env: []string{"PYTHONPATH=" + workDir},
+	}
+	if err := r.RunPython(ctx.Context, ctx.String("python"), args...); err != nil {
+		return errors.WithStack(err)
+	}
+
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var out scriptEvaluation
+	if err := json.Unmarshal(data, &out); err != nil {
+		return errors.WithStack(err)
+	}
+	report, err := newEvaluationReport(&out)
+	if err != nil {
+		return err
+	}
+
+	if path := ctx.String("output"); path != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return report.print(os.Stdout)
+}
+
+func newEvaluationReport(out *scriptEvaluation) (*evaluationReport, error) {
+	n := len(out.Decoders)
+	report := &evaluationReport{}
+	for _, path := range out.Decoders {
+		report.Decoders = append(report.Decoders, &decoderEvaluation{Path: path})
+	}
+	for _, s := range out.Samples {
+		if len(s.Metrics) != n {
+			return nil, errors.Errorf("sample %s has %d metrics for %d decoders", s.File, len(s.Metrics), n)
+		}
+		sample := &sampleEvaluation{File: s.File, ImageUrl: s.ImageUrl, Metrics: s.Metrics}
+		for i, m := range s.Metrics {
+			report.Decoders[i].MeanIou += m.Iou
+			report.Decoders[i].BoundaryF += m.BoundaryF
+			sample.Deltas = append(sample.Deltas, evaluationMetrics{
+				Iou:       m.Iou - s.Metrics[0].Iou,
+				BoundaryF: m.BoundaryF - s.Metrics[0].BoundaryF,
+			})
+		}
+		report.Samples = append(report.Samples, sample)
+	}
+	if len(report.Samples) > 0 {
+		for _, d := range report.Decoders {
+			d.MeanIou /= float64(len(report.Samples))
+			d.BoundaryF /= float64(len(report.Samples))
+			d.DeltaMeanIou = d.MeanIou - report.Decoders[0].MeanIou
+			d.DeltaBoundaryF = d.BoundaryF - report.Decoders[0].BoundaryF
+		}
+	}
+	return report, nil
+}
+
+// print writes the summary of each decoder followed by the IoU of each sample.
+func (r *evaluationReport) print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DECODER\tmIoU\tΔ\tBOUNDARY F\tΔ")
+	for _, d := range r.Decoders {
+		fmt.Fprintf(tw, "%s\t%.4f\t%+.4f\t%.4f\t%+.4f\n", d.Path, d.MeanIou, d.DeltaMeanIou, d.BoundaryF, d.DeltaBoundaryF)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprint(tw, "SAMPLE")
+	for i := range r.Decoders {
+		fmt.Fprintf(tw, "\tIoU #%d", i)
+		if i > 0 {
+			fmt.Fprint(tw, "\tΔ")
+		}
+	}
+	fmt.Fprintln(tw)
+	for _, s := range r.Samples {
+		fmt.Fprint(tw, s.File)
+		for i, m := range s.Metrics {
+			fmt.Fprintf(tw, "\t%.4f", m.Iou)
+			if i > 0 {
+				fmt.Fprintf(tw, "\t%+.4f", s.Deltas[i].Iou)
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	return errors.WithStack(tw.Flush())
+}
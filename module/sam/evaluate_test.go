@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluationReport(t *testing.T) {
+	out := &scriptEvaluation{
+		Decoders: []string{"base.onnx", "tuned.onnx"},
+		Samples: []*scriptSampleEvaluation{
+			{File: "a.json", Metrics: []evaluationMetrics{{Iou: 0.5, BoundaryF: 0.4}, {Iou: 0.7, BoundaryF: 0.6}}},
+			{File: "b.json", Metrics: []evaluationMetrics{{Iou: 0.9, BoundaryF: 0.8}, {Iou: 0.8, BoundaryF: 0.8}}},
+		},
+	}
+
+	report, err := newEvaluationReport(out)
+	require.NoError(t, err)
+	require.Len(t, report.Decoders, 2)
+	require.InDelta(t, 0.7, report.Decoders[0].MeanIou, 1e-9)
+	require.InDelta(t, 0.0, report.Decoders[0].DeltaMeanIou, 1e-9)
+	require.InDelta(t, 0.75, report.Decoders[1].MeanIou, 1e-9)
+	require.InDelta(t, 0.05, report.Decoders[1].DeltaMeanIou, 1e-9)
+	require.InDelta(t, 0.1, report.Decoders[1].DeltaBoundaryF, 1e-9)
+	require.InDelta(t, 0.2, report.Samples[0].Deltas[1].Iou, 1e-9)
+	require.InDelta(t, -0.1, report.Samples[1].Deltas[1].Iou, 1e-9)
+
+	var buf bytes.Buffer
+	require.NoError(t, report.print(&buf))
+	require.Contains(t, buf.String(), "tuned.onnx")
+	require.Contains(t, buf.String(), "-0.1000")
+
+	// metrics missing for some decoder
+	out.Samples[1].Metrics = out.Samples[1].Metrics[:1]
+	_, err = newEvaluationReport(out)
+	require.Error(t, err)
+}
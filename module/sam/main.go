@@ -1,18 +1,28 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
+	"io/fs"
 	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
 
 	"nutsh/module/common"
+	"nutsh/module/common/tracing"
 	"nutsh/module/sam/server"
 	servicev1 "nutsh/proto/gen/go/service/v1"
 )
@@ -23,31 +33,42 @@ var script embed.FS
 //go:embed requirements.txt
 var requirementsTxt embed.FS
 
-var commonFlags = []cli.Flag{
-	&cli.StringFlag{
-		Name:    "python",
-		Usage:   "command to run Python",
-		Value:   "python",
-		EnvVars: []string{"NUTSH_SAM_PYTHON"},
-	},
-	&cli.StringFlag{
-		Name:     "model-type",
-		Usage:    "type of the SAM encoder in [vit_b, vit_l, vit_h]",
-		Required: true,
-		EnvVars:  []string{"NUTSH_SAM_MODEL_TYPE"},
-	},
-	&cli.StringFlag{
-		Name:     "model-checkpoint",
-		Usage:    "path to the encoder checkpoint",
-		Required: true,
-		EnvVars:  []string{"NUTSH_SAM_MODEL_CHECKPOINT"},
-	},
-	&cli.StringFlag{
-		Name:     "decoder-path",
-		Usage:    "path to the decoder onnx file",
-		Required: true,
-		EnvVars:  []string{"NUTSH_SAM_DECODER_PATH"},
-	},
+// In-flight calls are given this long to finish on shutdown.
+const shutdownTimeout = 10 * time.Second
+
+var pythonFlag = &cli.StringFlag{
+	Name:    "python",
+	Usage:   "command to run Python",
+	Value:   "python",
+	EnvVars: []string{"NUTSH_SAM_PYTHON"},
+}
+
+// the flags of commands running Python scripts
+var pythonFlags = []cli.Flag{pythonFlag, scriptDirFlag}
+
+var commonFlags = append(pythonFlags, modelFlags(true)...)
+
+func modelFlags(required bool) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     "model-type",
+			Usage:    "type of the SAM encoder in [vit_b, vit_l, vit_h]",
+			Required: required,
+			EnvVars:  []string{"NUTSH_SAM_MODEL_TYPE"},
+		},
+		&cli.StringFlag{
+			Name:     "model-checkpoint",
+			Usage:    "path to the encoder checkpoint",
+			Required: required,
+			EnvVars:  []string{"NUTSH_SAM_MODEL_CHECKPOINT"},
+		},
+		&cli.StringFlag{
+			Name:     "decoder-path",
+			Usage:    "path to the decoder onnx file",
+			Required: required,
+			EnvVars:  []string{"NUTSH_SAM_DECODER_PATH"},
+		},
+	}
 }
 
 func main() {
@@ -60,7 +81,12 @@ func main() {
 			{
 				Name:  "start",
 				Usage: "Start the server",
-				Flags: append(commonFlags,
+				Flags: append(append(pythonFlags, modelFlags(false)...),
+					&cli.StringFlag{
+						Name:    "config",
+						Usage:   "path to a YAML file configuring multiple models to serve, instead of the model flags",
+						EnvVars: []string{"NUTSH_SAM_CONFIG"},
+					},
 					&cli.IntFlag{
 						Name:    "port",
 						Usage:   "gRPC port to listen",
@@ -69,9 +95,47 @@ func main() {
 					},
 					&cli.StringSliceFlag{
 						Name:    "devices",
-						Usage:   "devices to serve the encoder",
+						Usage:   "devices to serve the encoder, which models in the config can override",
 						Value:   cli.NewStringSlice("cpu"),
 						EnvVars: []string{"NUTSH_SAM_DEVICES"},
+					},
+					&cli.IntFlag{
+						Name:    "max-interactive-queue",
+						Usage:   "maximum number of interactive requests queued for each model before rejecting more, or unlimited if not positive",
+						Value:   64,
+						EnvVars: []string{"NUTSH_SAM_MAX_INTERACTIVE_QUEUE"},
+					},
+					&cli.IntFlag{
+						Name:    "max-batch-queue",
+						Usage:   "maximum number of batch requests queued for each model before rejecting more, or unlimited if not positive",
+						Value:   256,
+						EnvVars: []string{"NUTSH_SAM_MAX_BATCH_QUEUE"},
+					},
+					&cli.StringFlag{
+						Name:    "workspace",
+						Usage:   "directory to keep fine-tuning jobs started through the gRPC service and their artifacts, which disables fine-tuning if empty",
+						EnvVars: []string{"NUTSH_SAM_WORKSPACE"},
+					},
+					&cli.IntFlag{
+						Name:    "cache-size",
+						Usage:   "size in MiB of embeddings recently computed to keep in memory, or disable caching if not positive",
+						Value:   1024,
+						EnvVars: []string{"NUTSH_SAM_CACHE_SIZE"},
+					},
+					&cli.StringFlag{
+						Name:    "trace-exporter",
+						Usage:   "exporter of traces in [otlp, stdout], or disable exporting if empty",
+						EnvVars: []string{"NUTSH_SAM_TRACE_EXPORTER"},
+					},
+					&cli.StringFlag{
+						Name:    "trace-endpoint",
+						Usage:   "address of the OTLP collector, which defaults to OTEL_EXPORTER_OTLP_ENDPOINT",
+						EnvVars: []string{"NUTSH_SAM_TRACE_ENDPOINT"},
+					},
+					&cli.BoolFlag{
+						Name:    "trace-insecure",
+						Usage:   "connect to the OTLP collector without TLS",
+						EnvVars: []string{"NUTSH_SAM_TRACE_INSECURE"},
 					}),
 				Action: runStart,
 			},
@@ -124,6 +188,40 @@ func main() {
 				),
 				Action: runFinetune,
 			},
+			{
+				Name:  "evaluate",
+				Usage: "Evaluate decoders by replaying the prompts of samples",
+				// the decoder flag is replaced by the decoders to evaluate
+				Flags: append(append(pythonFlags, modelFlags(true)[:2]...),
+					&cli.StringSliceFlag{
+						Name:     "decoder",
+						Usage:    "paths to the decoder onnx files to evaluate, the first of which is the baseline",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:    "device",
+						Usage:   "device to run the encoder",
+						Value:   "cpu",
+						EnvVars: []string{"NUTSH_SAM_DEVICE"},
+					},
+					&cli.StringFlag{
+						Name:     "sample-dir",
+						Usage:    "path to the directory of sample JSONs",
+						Required: true,
+						EnvVars:  []string{"NUTSH_SAM_SAMPLE_DIR"},
+					},
+					&cli.StringFlag{
+						Name:    "workspace",
+						Usage:   "path to a directory for caching downloaded images, which defaults to a temporary one",
+						EnvVars: []string{"NUTSH_SAM_WORKSPACE"},
+					},
+					&cli.StringFlag{
+						Name:  "output",
+						Usage: "path to write the report as JSON",
+					},
+				),
+				Action: runEvaluate,
+			},
 			{
 				Name:   "requirements",
 				Usage:  "Display the runtime Python requirements",
@@ -144,51 +242,159 @@ func main() {
 		},
 	}
 
-	mustOk(app.Run(os.Args))
+	// on signals, the context is cancelled, which kills the Python processes and shuts the server down
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	mustOk(app.RunContext(ctx, os.Args))
 }
 
 func runStart(ctx *cli.Context) error {
-	decoderPath := ctx.String("decoder-path")
-	if _, err := os.Stat(decoderPath); err != nil {
-		if os.IsNotExist(err) {
-			// decoder does not exist
-			zap.L().Info("decoder not found and will generate one")
-			if err := runQuantize(ctx); err != nil {
-				return errors.WithStack(err)
-			}
-		} else {
-			// some other error
-			return errors.WithStack(err)
+	models, err := startModels(ctx)
+	if err != nil {
+		return err
+	}
+	entries := startScripts
+	if ctx.String("workspace") != "" {
+		entries = append(entries, finetuneScripts...)
+	}
+	scripts, err := loadScripts(ctx, entries)
+	if err != nil {
+		return err
+	}
+	for _, m := range models {
+		if err := ensureDecoder(ctx, scripts, m); err != nil {
+			return err
 		}
-	} else {
-		zap.L().Info("decoder found and will skip generating one")
 	}
 
+	shutdownTracing, err := tracing.Setup(ctx.Context, "nutsh-sam",
+		tracing.WithExporter(ctx.String("trace-exporter")),
+		tracing.WithOtlpEndpoint(ctx.String("trace-endpoint"), ctx.Bool("trace-insecure")),
+	)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			zap.L().Warn("failed to flush traces", zap.Error(err))
+		}
+	}()
+
+	// report NOT_SERVING until all workers have loaded their models
+	healthServer := health.NewServer()
 	ser, teardown := server.New(
 		server.WithDevices(ctx.StringSlice("devices")),
-		server.WithModel(ctx.String("model-type"), ctx.String("model-checkpoint"), ctx.String("decoder-path")),
+		server.WithModels(models...),
 		server.WithPython(ctx.String("python")),
-		server.WithScript(script),
+		server.WithScript(scripts),
+		server.WithQueueLimits(ctx.Int("max-interactive-queue"), ctx.Int("max-batch-queue")),
+		server.WithCacheSize(int64(ctx.Int("cache-size"))<<20),
+		server.WithHealth(healthServer),
+		server.WithWorkspace(ctx.String("workspace")),
 	)
 	defer teardown()
 
 	// start the server
 	grpcServer := grpc.NewServer(
-		grpc.MaxRecvMsgSize((16 * 1024 * 1024 /* 16M */)),
+		append(tracing.GrpcServerOptions(),
+			grpc.MaxRecvMsgSize((16 * 1024 * 1024 /* 16M */)),
+			// allow clients to keep idle connections alive with pings more frequent than the default 5 minutes
+			grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+				MinTime:             10 * time.Second,
+				PermitWithoutStream: true,
+			}),
+		)...,
 	)
 	servicev1.RegisterOnlineSegmentationServiceServer(grpcServer, ser)
+	servicev1.RegisterFinetuneServiceServer(grpcServer, ser)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	reflection.Register(grpcServer)
 
 	addr := fmt.Sprintf(":%d", ctx.Int("port"))
 	zap.L().Info("listening on " + addr)
 	lis, err := net.Listen("tcp", addr)
 	mustOk(err)
-	grpcServer.Serve(lis)
+	return serveGrpc(ctx.Context, grpcServer, lis, shutdownTimeout)
+}
 
+// serveGrpc serves until the context is done, after which in-flight calls are given the timeout to finish before being
+// aborted.
+func serveGrpc(ctx context.Context, grpcServer *grpc.Server, lis net.Listener, timeout time.Duration) error {
+	served := make(chan struct{})
+	defer close(served)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-served:
+			return
+		}
+		zap.L().Info("shutting down")
+
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(timeout):
+			zap.L().Warn("aborting in-flight calls after the shutdown timeout", zap.Duration("timeout", timeout))
+			grpcServer.Stop()
+		}
+	}()
+
+	if err := grpcServer.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// startModels reads the models to serve from the config file if given, otherwise from the model flags.
+func startModels(ctx *cli.Context) ([]server.ModelConfig, error) {
+	if path := ctx.String("config"); path != "" {
+		config, err := server.LoadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		return config.Models, nil
+	}
+
+	m := server.ModelConfig{
+		EncoderType:       ctx.String("model-type"),
+		EncoderCheckpoint: ctx.String("model-checkpoint"),
+		DecoderPath:       ctx.String("decoder-path"),
+	}
+	if m.EncoderType == "" || m.EncoderCheckpoint == "" || m.DecoderPath == "" {
+		return nil, errors.New("either --config or all of --model-type, --model-checkpoint and --decoder-path are required")
+	}
+	return []server.ModelConfig{m}, nil
+}
+
+// ensureDecoder generates the decoder of the model by quantizing the checkpoint if it does not exist.
+func ensureDecoder(ctx *cli.Context, scripts fs.FS, m server.ModelConfig) error {
+	if _, err := os.Stat(m.DecoderPath); err != nil {
+		if !os.IsNotExist(err) {
+			// some other error
+			return errors.WithStack(err)
+		}
+		// decoder does not exist
+		zap.L().Info("decoder not found and will generate one", zap.String("path", m.DecoderPath))
+		return quantize(ctx, scripts, m.EncoderType, m.EncoderCheckpoint, m.DecoderPath)
+	}
+	zap.L().Info("decoder found and will skip generating one", zap.String("path", m.DecoderPath))
 	return nil
 }
 
 func runQuantize(ctx *cli.Context) error {
-	data, err := script.ReadFile("script/quantize.py")
+	scripts, err := loadScripts(ctx, quantizeScripts)
+	if err != nil {
+		return err
+	}
+	return quantize(ctx, scripts, ctx.String("model-type"), ctx.String("model-checkpoint"), ctx.String("decoder-path"))
+}
+
+func quantize(ctx *cli.Context, scripts fs.FS, modelType, checkpoint, output string) error {
+	data, err := fs.ReadFile(scripts, "quantize.py")
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -196,9 +402,9 @@ func runQuantize(ctx *cli.Context) error {
 	err = common.RunPython(ctx.Context,
 		ctx.String("python"),
 		"-c", string(data),
-		"--model-type", ctx.String("model-type"),
-		"--checkpoint", ctx.String("model-checkpoint"),
-		"--output", ctx.String("decoder-path"),
+		"--model-type", modelType,
+		"--checkpoint", checkpoint,
+		"--output", output,
 	)
 	if err != nil {
 		return errors.WithStack(err)
@@ -208,26 +414,15 @@ func runQuantize(ctx *cli.Context) error {
 }
 
 func runFinetune(ctx *cli.Context) error {
-	// create a temporary folder to eject files and run
-	workDir, err := os.MkdirTemp("", "*")
+	scripts, err := loadScripts(ctx, finetuneScripts)
 	if err != nil {
-		return errors.WithStack(err)
-	}
-	defer os.RemoveAll(workDir)
-
-	scriptDir := filepath.Join(workDir, "script")
-	if err := os.MkdirAll(scriptDir, 0755); err != nil {
-		return errors.WithStack(err)
-	}
-	if err := ejectScript("finetune.py", scriptDir); err != nil {
 		return err
 	}
-	if err := ejectScript("quantize.py", scriptDir); err != nil {
-		return err
-	}
-	if err := ejectScript("__init__.py", scriptDir); err != nil {
+	workDir, err := ejectScriptPackage(scripts)
+	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(workDir)
 
 	r := &common.PythonRuntime{
 		Env: []string{"PYTHONPATH=" + workDir},
@@ -269,16 +464,9 @@ func runEject(ctx *cli.Context) error {
 		return errors.WithStack(err)
 	}
 
-	// python scripts
-	for _, f := range []string{
-		"__init__.py",
-		"embed_server.py",
-		"finetune.py",
-		"quantize.py",
-	} {
-		if err := ejectScript(f, dir); err != nil {
-			return err
-		}
+	// scripts
+	if err := common.EjectScripts(embeddedScripts(), dir); err != nil {
+		return err
 	}
 
 	// requirements.txt
@@ -294,19 +482,6 @@ func runEject(ctx *cli.Context) error {
 	return nil
 }
 
-func ejectScript(fname string, dir string) error {
-	data, err := script.ReadFile("script/" + fname)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	savePath := filepath.Join(dir, fname)
-	if err := os.WriteFile(savePath, data, 0644); err != nil {
-		return errors.WithStack(err)
-	}
-	return nil
-
-}
-
 func mustSetupLogger() *zap.Logger {
 	logger, err := zap.NewProduction()
 	mustOk(err)
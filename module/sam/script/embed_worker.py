@@ -0,0 +1,303 @@
+# type: ignore
+
+# A worker reads requests from stdin and writes responses to stdout one at a time. Each request or response is a
+# message of a JSON header followed by a binary payload, both prefixed with their length in a 4-byte big-endian integer.
+#
+# A request header contains the `method` to call with its `params`, and a response header contains either the `result`
+# or the `error` of the call. Logs are written to stderr.
+#
+# A `cancel` request with the `id` of a request in its params cancels it without any response of its own. The cancelled
+# request is stopped at the next step, and responded with an error marked as `cancelled`.
+
+import io
+import os
+import sys
+import json
+import time
+import queue
+import struct
+import logging
+import threading
+from typing import Optional, Tuple, Any, Dict
+from enum import Enum
+
+import cv2
+import numpy as np
+import onnxruntime
+from pycocotools import mask as coco_mask
+from segment_anything import SamPredictor, sam_model_registry
+from tap import Tap
+
+
+class ModelType(Enum):
+    """Different SAM model types."""
+
+    VIT_H = "vit_h"
+    VIT_L = "vit_l"
+    VIT_B = "vit_b"
+
+
+class Argument(Tap):
+    log_level: str = "INFO"  # Log level.
+    log_prefix: str = ""  # Log prefix.
+    model_type: ModelType = ModelType.VIT_H  # The SAM model type to use.
+    model_checkpoint: str  # Path to the SAM model checkpoint.
+    decoder_path: Optional[str] = None  # Path to the quantized decoder in ONNX format to run for segmentation.
+    device: Optional[str] = None  # Device to run the model.
+
+
+def init(args: Argument):
+    logging.info("loading model %s at %s", args.model_type.value, args.model_checkpoint)
+    sam = sam_model_registry[args.model_type.value](checkpoint=args.model_checkpoint)
+    if args.device is not None:
+        logging.info("moving model to %s", args.device)
+        sam.to(device=args.device)
+
+    predictor = SamPredictor(sam)
+
+    decoders = Decoders()
+    if args.decoder_path is not None:
+        decoders.get(args.decoder_path)
+    return predictor, decoders
+
+
+class Decoders:
+    """Decoders loaded by their paths, since fine-tuned decoders share the encoder of their base model."""
+
+    def __init__(self):
+        self.sessions = {}
+        self.default = None
+
+    def get(self, path: Optional[str]):
+        if path is None:
+            path = self.default
+        if path is None:
+            raise ValueError("no decoder is loaded")
+        if path not in self.sessions:
+            logging.info("loading decoder at %s", path)
+            self.sessions[path] = onnxruntime.InferenceSession(path)
+            if self.default is None:
+                self.default = path
+        return self.sessions[path]
+
+
+def read_frame(f) -> Optional[bytes]:
+    size = f.read(4)
+    if len(size) == 0:
+        return None
+    if len(size) < 4:
+        raise EOFError("truncated frame")
+    (n,) = struct.unpack(">I", size)
+    data = f.read(n)
+    if len(data) < n:
+        raise EOFError("truncated frame")
+    return data
+
+
+def read_message(f) -> Optional[Tuple[Dict[str, Any], bytes]]:
+    header = read_frame(f)
+    if header is None:
+        return None
+    payload = read_frame(f)
+    if payload is None:
+        raise EOFError("missing payload")
+    return json.loads(header), payload
+
+
+def write_message(f, header: Dict[str, Any], payload: bytes = b""):
+    data = json.dumps(header).encode("utf-8")
+    f.write(struct.pack(">I", len(data)))
+    f.write(data)
+    f.write(struct.pack(">I", len(payload)))
+    f.write(payload)
+    f.flush()
+
+
+class Cancelled(Exception):
+    pass
+
+
+class Requests:
+    """Reads requests in the background, such that a request can be cancelled while it is running."""
+
+    def __init__(self, reader):
+        self.reader = reader
+        self.queue = queue.Queue()
+        self.lock = threading.Lock()
+        self.pending = set()
+        self.cancelled = set()
+        threading.Thread(target=self.read, daemon=True).start()
+
+    def read(self):
+        try:
+            while True:
+                message = read_message(self.reader)
+                if message is None:
+                    break
+                header, _ = message
+                if header.get("method") == "cancel":
+                    rid = (header.get("params") or {}).get("id")
+                    with self.lock:
+                        if rid in self.pending:
+                            self.cancelled.add(rid)
+                    continue
+                with self.lock:
+                    self.pending.add(header.get("id"))
+                self.queue.put(message)
+        except Exception:  # pylint: disable=broad-except
+            logging.exception("failed to read request")
+        self.queue.put(None)
+
+    def get(self):
+        return self.queue.get()
+
+    def checkpoint(self, rid):
+        """Returns a function raising `Cancelled` if the request has been cancelled."""
+
+        def check():
+            with self.lock:
+                if rid in self.cancelled:
+                    raise Cancelled()
+
+        return check
+
+    def done(self, rid):
+        with self.lock:
+            self.pending.discard(rid)
+            self.cancelled.discard(rid)
+
+
+def decode_image(payload: bytes, crop):
+    image = cv2.imdecode(np.frombuffer(payload, dtype=np.uint8), cv2.IMREAD_COLOR)
+    if image is None:
+        raise ValueError("failed to decode image")
+    if crop is not None:
+        x, y, w, h = crop
+        image = image[y : y + h, x : x + w]
+        logging.info("cropped image at (x, y, w, h) = (%d, %d, %d, %d)", x, y, w, h)
+    return image
+
+
+def embed(predictor, params, payload: bytes, check):
+    image = decode_image(payload, params.get("crop"))
+    check()
+
+    start = time.time()
+    predictor.set_image(image)
+    check()
+    embedded = predictor.get_image_embedding().cpu().numpy()
+    logging.info("embeding finished and cost %fs", time.time() - start)
+
+    buf = io.BytesIO()
+    np.save(buf, embedded)
+    return {}, buf.getvalue()
+
+
+def segment(predictor, decoders, params, payload: bytes, check):
+    decoder = decoders.get(params.get("decoder_path"))
+    image = decode_image(payload, params.get("crop"))
+    check()
+
+    start = time.time()
+    predictor.set_image(image)
+    check()
+    embedded = predictor.get_image_embedding().cpu().numpy()
+
+    # The same input as constructed in the browser by `input.js`, except that a box replaces the padding point.
+    coords = [[x, y] for x, y, _ in params["points"]]
+    labels = [1 if is_positive else 0 for _, _, is_positive in params["points"]]
+    box = params.get("box")
+    if box is not None:
+        x, y, w, h = box
+        coords += [[x, y], [x + w, y + h]]
+        labels += [2, 3]
+    else:
+        coords.append([0.0, 0.0])
+        labels.append(-1)
+
+    image_size = image.shape[:2]
+    point_coords = predictor.transform.apply_coords(np.array(coords, dtype=np.float32), image_size)
+    masks, scores, _ = decoder.run(
+        None,
+        {
+            "image_embeddings": embedded,
+            "point_coords": point_coords[None, :, :].astype(np.float32),
+            "point_labels": np.array(labels, dtype=np.float32)[None, :],
+            "mask_input": np.zeros((1, 1, 256, 256), dtype=np.float32),
+            "has_mask_input": np.zeros(1, dtype=np.float32),
+            "orig_im_size": np.array(image_size, dtype=np.float32),
+        },
+    )
+    mask = masks[0, 0] > predictor.model.mask_threshold
+    logging.info("segmentation finished and cost %fs", time.time() - start)
+
+    # COCO encodes the mask in column-major order.
+    rle = coco_mask.encode(np.asfortranarray(mask.astype(np.uint8)))
+    h, w = rle["size"]
+    result = {
+        "coco_encoded_rle": rle["counts"].decode("utf-8"),
+        "width": w,
+        "height": h,
+        "score": float(scores[0, 0]),
+    }
+    return result, b""
+
+
+def serve(predictor, decoders, reader, writer):
+    requests = Requests(reader)
+    while True:
+        message = requests.get()
+        if message is None:
+            logging.info("input closed, exiting")
+            return
+        header, payload = message
+        rid = header.get("id")
+        method = header.get("method")
+        params = header.get("params") or {}
+        logging.info("calling %s (id=%s, traceparent=%s)", method, rid or "", header.get("traceparent", ""))
+
+        check = requests.checkpoint(rid)
+        try:
+            check()
+            if method == "ping":
+                result, output = {}, b""
+            elif method == "embed":
+                result, output = embed(predictor, params, payload, check)
+            elif method == "segment":
+                result, output = segment(predictor, decoders, params, payload, check)
+            else:
+                raise ValueError(f"unknown method {method}")
+        except Cancelled:
+            logging.info("cancelled %s (id=%s)", method, rid)
+            write_message(writer, {"error": "cancelled", "cancelled": True})
+            continue
+        except Exception as e:  # pylint: disable=broad-except
+            logging.exception("failed to call %s", method)
+            write_message(writer, {"error": str(e) or type(e).__name__})
+            continue
+        finally:
+            requests.done(rid)
+        write_message(writer, {"result": result}, output)
+
+
+def main():
+    args = Argument(underscores_to_dashes=True).parse_args()
+
+    logging.basicConfig(
+        level=getattr(logging, args.log_level.upper(), None),
+        datefmt="%Y-%m-%d %H:%M:%S",
+        format=f"%(asctime)s.%(msecs)03d %(levelname)s [{args.log_prefix}] %(message)s",
+        stream=sys.stderr,
+    )
+
+    # Keep stdout for messages, while anything else printed, e.g. by libraries, goes to stderr.
+    writer = os.fdopen(os.dup(sys.stdout.fileno()), "wb")
+    os.dup2(sys.stderr.fileno(), sys.stdout.fileno())
+    reader = sys.stdin.buffer
+
+    predictor, decoders = init(args)
+    serve(predictor, decoders, reader, writer)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,131 @@
+import os
+import sys
+import json
+import glob
+import logging
+from typing import List, Any, Optional, Dict
+
+import cv2
+import numpy as np
+import onnxruntime  # type: ignore
+from tap import Tap
+
+from segment_anything import SamPredictor, sam_model_registry  # type: ignore
+
+from .finetune import ModelType, Record, download_image, prepare_dataset  # type: ignore
+
+
+class Argument(Tap):
+    log_level: str = "INFO"  # Log level.
+    sample_dir: str  # Path to the samples directory.
+    workspace: str  # Path to a directory to store intermediate data.
+    model_type: ModelType  # The SAM model type to use.
+    checkpoint: str  # Path to the SAM model checkpoint.
+    decoder: List[str]  # Paths to the decoders in ONNX format to evaluate.
+    report: str  # Path to write the metrics of each sample to as JSON.
+    boundary_tolerance: float = 0.008  # Tolerance of boundary matching as a ratio of the image diagonal.
+    device: Optional[str] = None  # Device to run the model.
+
+
+def iou(pred: np.ndarray, gt: np.ndarray) -> float:
+    union = np.logical_or(pred, gt).sum()
+    if union == 0:
+        return 1.0
+    return float(np.logical_and(pred, gt).sum() / union)
+
+
+def boundary(mask: np.ndarray) -> np.ndarray:
+    m = mask.astype(np.uint8)
+    return np.logical_and(mask, cv2.erode(m, np.ones((3, 3), np.uint8)) == 0)
+
+
+def boundary_f(pred: np.ndarray, gt: np.ndarray, tolerance: float) -> float:
+    """The boundary F-measure of DAVIS, matching boundary pixels within a tolerance."""
+    pb, gb = boundary(pred), boundary(gt)
+    if not pb.any() and not gb.any():
+        return 1.0
+    if not pb.any() or not gb.any():
+        return 0.0
+
+    h, w = gt.shape
+    r = max(1, int(np.ceil(tolerance * np.hypot(h, w))))
+    disk = cv2.getStructuringElement(cv2.MORPH_ELLIPSE, (2 * r + 1, 2 * r + 1))
+    pd = cv2.dilate(pb.astype(np.uint8), disk) > 0
+    gd = cv2.dilate(gb.astype(np.uint8), disk) > 0
+
+    precision = np.logical_and(pb, gd).sum() / pb.sum()
+    recall = np.logical_and(gb, pd).sum() / gb.sum()
+    if precision + recall == 0:
+        return 0.0
+    return float(2 * precision * recall / (precision + recall))
+
+
+def predict(predictor: SamPredictor, decoder, rec: Record) -> np.ndarray:
+    # The same input as constructed in the browser by `input.js`, where the samples are recorded.
+    coords = [[p.x, p.y] for p in rec.point_prompts] + [[0.0, 0.0]]
+    labels = [p.type for p in rec.point_prompts] + [-1]
+    point_coords = predictor.transform.apply_coords(np.array(coords, dtype=np.float32), rec.image_size)
+    masks, _, _ = decoder.run(
+        None,
+        {
+            "image_embeddings": rec.embedding.cpu().numpy(),
+            "point_coords": point_coords[None, :, :].astype(np.float32),
+            "point_labels": np.array(labels, dtype=np.float32)[None, :],
+            "mask_input": np.zeros((1, 1, 256, 256), dtype=np.float32),
+            "has_mask_input": np.zeros(1, dtype=np.float32),
+            "orig_im_size": np.array(rec.image_size, dtype=np.float32),
+        },
+    )
+    return masks[0, 0] > predictor.model.mask_threshold
+
+
+def start(args: Argument, files: List[str], samples: List[Any]):
+    for image_url in {sample["imageUrl"] for sample in samples}:
+        download_image(args, image_url)
+
+    logging.info("loading model %s at %s", args.model_type.value, args.checkpoint)
+    sam = sam_model_registry[args.model_type.value](checkpoint=args.checkpoint)
+    if args.device is not None:
+        sam.to(device=args.device)  # type: ignore
+    predictor = SamPredictor(sam)
+
+    ds = prepare_dataset(args, sam, samples)  # type: ignore
+    results: List[Dict[str, Any]] = [
+        {"file": os.path.basename(f), "image_url": s["imageUrl"], "metrics": []} for f, s in zip(files, samples)
+    ]
+    for path in args.decoder:
+        logging.info("evaluating decoder %s", path)
+        decoder = onnxruntime.InferenceSession(path)
+        for rec, result in zip(ds.records, results):
+            pred = predict(predictor, decoder, rec)
+            gt = rec.mask[0, 0].cpu().numpy() > 0.5
+            result["metrics"].append(
+                {"iou": iou(pred, gt), "boundary_f": boundary_f(pred, gt, args.boundary_tolerance)}
+            )
+
+    with open(args.report, "w", encoding="utf-8") as f:
+        json.dump({"decoders": args.decoder, "samples": results}, f)
+
+
+def main():
+    args = Argument(underscores_to_dashes=True).parse_args()
+
+    logging.basicConfig(
+        level=getattr(logging, args.log_level.upper(), None),
+        datefmt="%Y-%m-%d %H:%M:%S",
+        format="%(asctime)s.%(msecs)03d %(levelname)s %(message)s",
+        stream=sys.stderr,
+    )
+
+    files = sorted(glob.glob(os.path.join(args.sample_dir, "*.json")))
+    samples: List[Any] = []
+    for file_path in files:
+        with open(file_path, encoding="utf-8") as f:
+            samples.append(json.load(f))
+    if len(samples) == 0:
+        raise ValueError(f"no samples found in {args.sample_dir}")
+    start(args, files, samples)
+
+
+if __name__ == "__main__":
+    main()
@@ -1,4 +1,5 @@
 import os
+import sys
 import json
 import glob
 import logging
@@ -47,7 +48,9 @@ class Argument(TrainArgument):
     model_type: ModelType  # The SAM model type to use.
     checkpoint: str  # Path to the SAM model checkpoint.
     output: str  # Path to the quantized checkpoint.
+    output_original: Optional[str] = None  # Path to also keep the checkpoint before quantization.
     device: Optional[str] = None  # Device to run the model.
+    report_metrics: bool = False  # Print the metrics of each epoch to stdout as a line of JSON.
 
 
 def image_save_path(args: Argument, url: str):
@@ -214,6 +217,8 @@ class Trainer:
         for eidx in range(self.args.num_epoch):
             loss = self._train_epoch()
             logging.info("finished epoch %d/%d with loss %f", eidx + 1, self.args.num_epoch, loss)
+            if self.args.report_metrics:
+                print(json.dumps({"epoch": eidx + 1, "num_epoch": self.args.num_epoch, "loss": loss}), flush=True)
 
     def _train_epoch(self) -> float:
         losses: List[float] = []
@@ -287,7 +292,7 @@ def start(args: Argument, samples: List[Any]):
     trainer.train()
 
     # save
-    save_quantized_onnx(sam.to("cpu"), args.output)
+    save_quantized_onnx(sam.to("cpu"), args.output, args.output_original)
 
 
 def main():
@@ -297,6 +302,7 @@ def main():
         level=getattr(logging, args.log_level.upper(), None),
         datefmt="%Y-%m-%d %H:%M:%S",
         format="%(asctime)s.%(msecs)03d %(levelname)s %(message)s",
+        stream=sys.stderr,
     )
 
     samples: List[Any] = []
@@ -6,6 +6,7 @@ import logging
 import warnings
 import tempfile
 from enum import Enum
+from typing import Optional
 
 import torch
 from tap import Tap
@@ -70,9 +71,12 @@ def export_decoder_onnx(sam, save_path: str):
             )
 
 
-def save_quantized_onnx(sam, save_path: str):
-    salt = uuid.uuid4()
-    onnx_path = os.path.join(tempfile.gettempdir(), f"sam_decoder_{salt}.onnx")
+def save_quantized_onnx(sam, save_path: str, onnx_path: Optional[str] = None):
+    # the original model is kept only if its path is given
+    keep_original = onnx_path is not None
+    if onnx_path is None:
+        salt = uuid.uuid4()
+        onnx_path = os.path.join(tempfile.gettempdir(), f"sam_decoder_{salt}.onnx")
 
     logging.info("exporting original model to ONNX at %s", onnx_path)
     export_decoder_onnx(sam, onnx_path)
@@ -86,7 +90,8 @@ def save_quantized_onnx(sam, save_path: str):
         reduce_range=False,
         weight_type=QuantType.QUInt8,
     )
-    os.remove(onnx_path)
+    if not keep_original:
+        os.remove(onnx_path)
     logging.info("quantized model saved at %s", save_path)
 
 
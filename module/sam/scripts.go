@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	"nutsh/module/common"
+)
+
+var scriptDirFlag = &cli.StringFlag{
+	Name:    "script-dir",
+	Usage:   "directory of the Python scripts to run instead of the embedded ones, such as one created by eject",
+	EnvVars: []string{"NUTSH_SAM_SCRIPT_DIR"},
+}
+
+// scriptEntry is a script required by a command along with the top-level functions it should define, since a command
+// either runs its main or imports the others.
+type scriptEntry struct {
+	name string
+	defs []string
+}
+
+var (
+	quantizeScripts = []scriptEntry{
+		{"quantize.py", []string{"main"}},
+	}
+	finetuneScripts = []scriptEntry{
+		{"__init__.py", nil},
+		{"finetune.py", []string{"main"}},
+		{"quantize.py", []string{"save_quantized_onnx"}},
+	}
+	evaluateScripts = []scriptEntry{
+		{"__init__.py", nil},
+		{"evaluate.py", []string{"main"}},
+		{"finetune.py", []string{"download_image", "prepare_dataset"}},
+	}
+	// the fine-tuning scripts are also required if fine-tuning is enabled
+	startScripts = append([]scriptEntry{
+		{"embed_worker.py", []string{"main"}},
+		{"input.js", nil},
+	}, quantizeScripts...)
+)
+
+// loadScripts returns the scripts to run, which are the embedded ones unless a script dir is given, in which case it
+// is checked to contain the entries, e.g. in case it is ejected by an older version.
+func loadScripts(ctx *cli.Context, entries []scriptEntry) (fs.FS, error) {
+	dir := ctx.String("script-dir")
+	if dir == "" {
+		return embeddedScripts(), nil
+	}
+
+	scripts := os.DirFS(dir)
+	if err := checkScripts(scripts, entries); err != nil {
+		return nil, errors.WithMessagef(err, "incompatible scripts in %s", dir)
+	}
+	return scripts, nil
+}
+
+func embeddedScripts() fs.FS {
+	scripts, err := fs.Sub(script, "script")
+	if err != nil {
+		panic(err)
+	}
+	return scripts
+}
+
+func checkScripts(scripts fs.FS, entries []scriptEntry) error {
+	var missing []string
+	for _, e := range entries {
+		data, err := fs.ReadFile(scripts, e.name)
+		if errors.Is(err, fs.ErrNotExist) {
+			missing = append(missing, e.name)
+			continue
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, def := range e.defs {
+			pattern := regexp.MustCompile(fmt.Sprintf(`(?m)^def %s\(`, regexp.QuoteMeta(def)))
+			if !pattern.Match(data) {
+				missing = append(missing, fmt.Sprintf("%s:%s", e.name, def))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return errors.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// ejectScriptPackage ejects the scripts as the `script` package into a temporary folder to run them as modules, which
+// should be removed by the caller.
+func ejectScriptPackage(scripts fs.FS) (string, error) {
+	workDir, err := os.MkdirTemp("", "*")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if err := common.EjectScripts(scripts, filepath.Join(workDir, "script")); err != nil {
+		os.RemoveAll(workDir)
+		return "", err
+	}
+	return workDir, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"nutsh/module/common"
+)
+
+func TestCheckScripts(t *testing.T) {
+	all := append(append(append(startScripts, finetuneScripts...), evaluateScripts...), quantizeScripts...)
+	require.NoError(t, checkScripts(embeddedScripts(), all))
+
+	// ejected scripts are compatible until modified
+	dir := t.TempDir()
+	require.NoError(t, common.EjectScripts(embeddedScripts(), dir))
+	require.NoError(t, checkScripts(os.DirFS(dir), all))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "finetune.py"), []byte("def start():\n    pass\n"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(dir, "input.js")))
+	err := checkScripts(os.DirFS(dir), all)
+	require.ErrorContains(t, err, "input.js")
+	require.ErrorContains(t, err, "finetune.py:main")
+	require.ErrorContains(t, err, "finetune.py:prepare_dataset")
+	require.NoError(t, checkScripts(os.DirFS(dir), quantizeScripts))
+}
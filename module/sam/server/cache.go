@@ -0,0 +1,107 @@
+package server
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	schemav1 "nutsh/proto/gen/go/schema/v1"
+)
+
+// embeddingCache keeps the most recently used embeddings up to a total size in bytes.
+type embeddingCache struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	entries  map[string]*list.Element
+	order    *list.List
+
+	hits   uint64
+	misses uint64
+}
+
+type embeddingCacheEntry struct {
+	key       string
+	embedding []byte
+}
+
+// newEmbeddingCache creates a cache of at most the given bytes, which caches nothing if not positive.
+func newEmbeddingCache(capacity int64) *embeddingCache {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &embeddingCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// embeddingCacheKey identifies the embedding of an image, optionally cropped, by the encoder of a decoder.
+func embeddingCacheKey(decoderUuid string, image []byte, crop *schemav1.GridRect) string {
+	hash := sha256.Sum256(image)
+	key := fmt.Sprintf("%s/%s", decoderUuid, hex.EncodeToString(hash[:]))
+	if crop != nil && crop.Width > 0 && crop.Height > 0 {
+		key += fmt.Sprintf("/%d,%d,%d,%d", crop.X, crop.Y, crop.Width, crop.Height)
+	}
+	return key
+}
+
+func (c *embeddingCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.order.MoveToFront(e)
+	return e.Value.(*embeddingCacheEntry).embedding, true
+}
+
+// put adds the embedding and evicts the least recently used ones beyond the capacity, while an embedding larger than
+// the capacity is not cached at all.
+func (c *embeddingCache) put(key string, embedding []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	size := int64(len(embedding))
+	if c.capacity == 0 || size > c.capacity {
+		return
+	}
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&embeddingCacheEntry{key: key, embedding: embedding})
+	c.size += size
+	for c.size > c.capacity {
+		e := c.order.Back()
+		entry := e.Value.(*embeddingCacheEntry)
+		c.order.Remove(e)
+		delete(c.entries, entry.key)
+		c.size -= int64(len(entry.embedding))
+	}
+}
+
+type embeddingCacheStats struct {
+	entries  int
+	size     int64
+	capacity int64
+	hits     uint64
+	misses   uint64
+}
+
+func (c *embeddingCache) stats() embeddingCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return embeddingCacheStats{
+		entries:  len(c.entries),
+		size:     c.size,
+		capacity: c.capacity,
+		hits:     c.hits,
+		misses:   c.misses,
+	}
+}
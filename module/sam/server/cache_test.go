@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	schemav1 "nutsh/proto/gen/go/schema/v1"
+	servicev1 "nutsh/proto/gen/go/service/v1"
+)
+
+func TestEmbeddingCache(t *testing.T) {
+	c := newEmbeddingCache(10)
+
+	c.put("a", []byte("aaaa"))
+	c.put("b", []byte("bbbb"))
+	_, ok := c.get("a")
+	require.True(t, ok)
+
+	// the least recently used one is evicted
+	c.put("c", []byte("cccc"))
+	_, ok = c.get("b")
+	require.False(t, ok)
+	embedding, ok := c.get("a")
+	require.True(t, ok)
+	require.Equal(t, []byte("aaaa"), embedding)
+
+	// embeddings larger than the capacity are not cached
+	c.put("d", make([]byte, 11))
+	_, ok = c.get("d")
+	require.False(t, ok)
+
+	stats := c.stats()
+	require.Equal(t, 2, stats.entries)
+	require.EqualValues(t, 8, stats.size)
+	require.EqualValues(t, 2, stats.hits)
+	require.EqualValues(t, 2, stats.misses)
+}
+
+func TestEmbeddingCacheKey(t *testing.T) {
+	image := []byte("image")
+	key := embeddingCacheKey("sam.vit_h.0", image, nil)
+	require.Equal(t, key, embeddingCacheKey("sam.vit_h.0", image, &schemav1.GridRect{}))
+	require.NotEqual(t, key, embeddingCacheKey("sam.vit_h.1", image, nil))
+	require.NotEqual(t, key, embeddingCacheKey("sam.vit_h.0", []byte("other"), nil))
+	require.NotEqual(t, key, embeddingCacheKey("sam.vit_h.0", image, &schemav1.GridRect{Width: 1, Height: 1}))
+}
+
+func TestEmbedImageCoalesced(t *testing.T) {
+	ctx := context.Background()
+	m, w := requireFakeWorker(t)
+	s := &mServer{
+		models: []*mModel{m},
+		cache:  newEmbeddingCache(1 << 20),
+	}
+
+	// concurrent identical requests share a single embedding
+	const n = 8
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := s.embedImage(ctx, &servicev1.EmbedImageRequest{OriginalImage: []byte("image")}, false)
+			require.NoError(t, err)
+			require.Equal(t, []byte("egami"), resp.GetEmbeddedImageNpy())
+		}()
+	}
+	wg.Wait()
+	require.EqualValues(t, 1, w.served.Load())
+
+	status := s.cacheStatus()
+	require.EqualValues(t, n-1, status.Hits+status.Coalesced)
+	require.EqualValues(t, 1, status.Entries)
+}
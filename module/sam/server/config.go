@@ -0,0 +1,73 @@
+package server
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Config lists the models served, for example
+//
+//	models:
+//	  - name: vit_h
+//	    encoder_type: vit_h
+//	    encoder_checkpoint: /models/sam_vit_h_4b8939.pth
+//	    decoder_path: /models/sam_vit_h_4b8939_decoder.onnx
+//	    devices: [cuda:0, cuda:1]
+//	  - name: vit_b-finetuned
+//	    encoder_type: vit_b
+//	    encoder_checkpoint: /models/sam_vit_b_01ec64.pth
+//	    decoder_path: /models/finetuned_decoder.onnx
+type Config struct {
+	Models []ModelConfig `yaml:"models"`
+}
+
+type ModelConfig struct {
+	// Name is a human-readable name of the model, which defaults to the encoder type.
+	Name string `yaml:"name"`
+
+	// EncoderType is the type of the SAM encoder in [vit_b, vit_l, vit_h].
+	EncoderType string `yaml:"encoder_type"`
+
+	EncoderCheckpoint string `yaml:"encoder_checkpoint"`
+
+	// DecoderPath is the path to the decoder in ONNX format, whose hash identifies the model.
+	DecoderPath string `yaml:"decoder_path"`
+
+	// Devices to serve the encoder, which default to the ones of the server.
+	Devices []string `yaml:"devices"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, errors.Wrapf(err, "invalid config %s", path)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid config %s", path)
+	}
+	return &c, nil
+}
+
+func (c *Config) Validate() error {
+	if len(c.Models) == 0 {
+		return errors.New("no model is configured")
+	}
+	for i, m := range c.Models {
+		if m.EncoderType == "" {
+			return errors.Errorf("missing encoder type of model %d", i)
+		}
+		if m.EncoderCheckpoint == "" {
+			return errors.Errorf("missing encoder checkpoint of model %d", i)
+		}
+		if m.DecoderPath == "" {
+			return errors.Errorf("missing decoder path of model %d", i)
+		}
+	}
+	return nil
+}
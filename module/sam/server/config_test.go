@@ -0,0 +1,53 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+models:
+  - name: vit_h
+    encoder_type: vit_h
+    encoder_checkpoint: /models/sam_vit_h.pth
+    decoder_path: /models/sam_vit_h_decoder.onnx
+    devices: [cuda:0, cuda:1]
+  - encoder_type: vit_b
+    encoder_checkpoint: /models/sam_vit_b.pth
+    decoder_path: /models/finetuned_decoder.onnx
+`), 0644))
+
+	c, err := LoadConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, []ModelConfig{
+		{
+			Name:              "vit_h",
+			EncoderType:       "vit_h",
+			EncoderCheckpoint: "/models/sam_vit_h.pth",
+			DecoderPath:       "/models/sam_vit_h_decoder.onnx",
+			Devices:           []string{"cuda:0", "cuda:1"},
+		},
+		{
+			EncoderType:       "vit_b",
+			EncoderCheckpoint: "/models/sam_vit_b.pth",
+			DecoderPath:       "/models/finetuned_decoder.onnx",
+		},
+	}, c.Models)
+}
+
+func TestLoadInvalidConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+models:
+  - encoder_type: vit_b
+    decoder_path: /models/finetuned_decoder.onnx
+`), 0644))
+
+	_, err := LoadConfig(path)
+	require.ErrorContains(t, err, "missing encoder checkpoint of model 0")
+}
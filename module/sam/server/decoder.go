@@ -19,14 +19,19 @@ func (s *mServer) GetDecoder(ctx context.Context, req *servicev1.GetDecoderReque
 }
 
 func (s *mServer) getDecoder(ctx context.Context, req *servicev1.GetDecoderRequest) (*servicev1.GetDecoderResponse, error) {
-	output, err := os.ReadFile(s.options.decoderPath)
+	m, err := s.model(req.GetUuid())
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := os.ReadFile(m.config.DecoderPath)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	resp := &servicev1.GetDecoderResponse{
 		DecoderOnnx: output,
-		Uuid:        s.decoderUuid,
+		Uuid:        m.decoderUuid,
 	}
 
 	return resp, nil
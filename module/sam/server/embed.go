@@ -1,24 +1,30 @@
 package server
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"os"
-	"strconv"
+	"io"
+	"io/fs"
+	"sync"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"nutsh/module/common"
 	servicev1 "nutsh/proto/gen/go/service/v1"
 )
 
+var tracer = otel.Tracer("nutsh/module/sam/server")
+
 func (s *mServer) EmbedImage(ctx context.Context, req *servicev1.EmbedImageRequest) (*servicev1.EmbedImageResponse, error) {
-	resp, err := s.embedImage(ctx, req)
+	resp, err := s.embedImage(ctx, req, false)
 	if err != nil {
 		zap.L().Error("error", zap.Error(err))
 		return nil, err
@@ -26,49 +32,93 @@ func (s *mServer) EmbedImage(ctx context.Context, req *servicev1.EmbedImageReque
 	return resp, nil
 }
 
-func (s *mServer) embedImage(ctx context.Context, req *servicev1.EmbedImageRequest) (*servicev1.EmbedImageResponse, error) {
-	decoderUuid := req.GetDecoderUuid()
-	if decoderUuid != s.decoderUuid {
-		return nil, errors.Errorf("unaccepted decoder uuid %s (availables are: [%s])", decoderUuid, s.decoderUuid)
+// EmbedImages embeds images received from the stream concurrently, bounded by the number of workers such that
+// all devices are kept busy without queueing too many images in memory.
+func (s *mServer) EmbedImages(stream servicev1.OnlineSegmentationService_EmbedImagesServer) error {
+	ctx := stream.Context()
+	sem := make(chan struct{}, s.workerCount())
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var sendErr error
+	send := func(resp *servicev1.EmbedImagesResponse) {
+		mu.Lock()
+		defer mu.Unlock()
+		if sendErr == nil {
+			sendErr = stream.Send(resp)
+		}
 	}
 
-	// create a temporary folder
-	workDir, err := os.MkdirTemp("", "*")
-	if err != nil {
-		return nil, errors.WithStack(err)
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			wg.Wait()
+			return err
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		}
+		wg.Add(1)
+		go func(req *servicev1.EmbedImagesRequest) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			resp := &servicev1.EmbedImagesResponse{Id: req.GetId()}
+			result, err := s.embedImage(ctx, req.GetImage(), true)
+			if err != nil {
+				zap.L().Error("failed to embed image in stream", zap.String("id", req.GetId()), zap.Error(err))
+				resp.Error = err.Error()
+			} else {
+				resp.Result = result
+			}
+			send(resp)
+		}(req)
 	}
-	defer os.RemoveAll(workDir)
 
-	// save the original file
-	f, err := os.CreateTemp(workDir, "*")
-	if err != nil {
-		return nil, errors.WithStack(err)
+	wg.Wait()
+	return sendErr
+}
+
+func (s *mServer) workerCount() int {
+	n := 0
+	for _, m := range s.servedModels() {
+		if m.base != nil {
+			continue
+		}
+		n += len(m.workers)
 	}
-	original := req.GetOriginalImage()
-	if err := saveFile(f, original); err != nil {
+	return n
+}
+
+// embedImage embeds an image, waiting for space in the queue if it is full when asked to instead of failing.
+func (s *mServer) embedImage(ctx context.Context, req *servicev1.EmbedImageRequest, wait bool) (*servicev1.EmbedImageResponse, error) {
+	m, err := s.model(req.GetDecoderUuid())
+	if err != nil {
 		return nil, err
 	}
 
-	// send the http reqeust
-	inPath := f.Name()
-	outPath := inPath + ".npy"
-	cropStr := ""
-	if crop := req.GetCrop(); crop != nil && crop.Width > 0 && crop.Height > 0 {
-		cropStr = fmt.Sprintf("%d,%d,%d,%d", crop.X, crop.Y, crop.Width, crop.Height)
+	key := embeddingCacheKey(m.decoderUuid, req.GetOriginalImage(), req.GetCrop())
+	if output, ok := s.cache.get(key); ok {
+		zap.L().Info("embedding cache hit", zap.String("key", key))
+		return &servicev1.EmbedImageResponse{
+			EmbeddedImageNpy: output,
+		}, nil
 	}
 
-	if err := s.sendEmbedImageRequest(ctx, map[string]string{
-		"input":  inPath,
-		"output": outPath,
-		"crop":   cropStr,
-	}); err != nil {
-		return nil, err
+	params := map[string]interface{}{}
+	if crop := req.GetCrop(); crop != nil && crop.Width > 0 && crop.Height > 0 {
+		params["crop"] = []uint32{crop.X, crop.Y, crop.Width, crop.Height}
 	}
-
-	// read output
-	output, err := os.ReadFile(outPath)
+	output, err := s.embedCoalesced(ctx, m, key, params, req.GetOriginalImage(), wait)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
 
 	resp := &servicev1.EmbedImageResponse{
@@ -78,143 +128,131 @@ func (s *mServer) embedImage(ctx context.Context, req *servicev1.EmbedImageReque
 	return resp, nil
 }
 
-func (s *mServer) sendEmbedImageRequest(ctx context.Context, body map[string]string) error {
-	respChan := make(chan *mEmbedResponse)
-	req := &mEmbedRequest{
-		Uuid:     uuid.NewString(),
-		Context:  ctx,
-		Body:     body,
-		RespChan: respChan,
-	}
-
-	logger := zap.L().With(zap.String("uuid", req.Uuid))
-	logger.Info("queued embed request")
-	select {
-	case s.embedReqQueue <- req:
-		logger.Info("sent embed request")
+// embedCoalesced embeds an image once for all concurrent requests of it and caches the embedding. Since the shared
+// request runs in the context of the first one, the others retry if it is cancelled while they are still alive. Only
+// requests of the same priority class and queueing behavior are coalesced, such that an interactive request is never
+// held behind a batch one waiting in its queue.
+func (s *mServer) embedCoalesced(ctx context.Context, m *mModel, key string, params map[string]interface{}, image []byte, wait bool) ([]byte, error) {
+	flight := fmt.Sprintf("%s/%s/%t", key, common.IncomingPriority(ctx), wait)
+	for {
+		leader := false
+		ch := s.inflight.DoChan(flight, func() (interface{}, error) {
+			leader = true
+			_, output, err := m.sendRequest(ctx, "embed", params, image, wait)
+			if err != nil {
+				return nil, err
+			}
+			s.cache.put(key, output)
+			return output, nil
+		})
 
-		resp := <-respChan
-		logger.Info("received embed response")
-		if resp.Error != nil {
-			return resp.Error
-		}
-		if code := resp.Response.StatusCode; code != 200 {
-			return errors.Errorf("unexpected HTTP status code %d", code)
+		select {
+		case res := <-ch:
+			if !leader {
+				s.coalesced.Add(1)
+				if res.Err != nil && isCancellation(res.Err) && ctx.Err() == nil {
+					continue
+				}
+			}
+			if res.Err != nil {
+				return nil, res.Err
+			}
+			return res.Val.([]byte), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
-		return nil
-	case <-ctx.Done():
-		logger.Info("queueing timeout")
-		return ctx.Err()
 	}
 }
 
-type mEmbedRequest struct {
-	Uuid     string
-	Context  context.Context
-	Body     map[string]string
-	RespChan chan *mEmbedResponse
+func isCancellation(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	code := status.Code(err)
+	return code == codes.Canceled || code == codes.DeadlineExceeded
 }
 
-type mEmbedResponse struct {
-	Response *http.Response
-	Error    error
-}
+// sendRequest sends a request to the first available worker of the model in the priority class of the incoming call,
+// and returns the result and the payload of the response. If the queue of the class is full, it fails with
+// RESOURCE_EXHAUSTED unless asked to wait.
+func (m *mModel) sendRequest(ctx context.Context, method string, params map[string]interface{}, payload []byte, wait bool) (json.RawMessage, []byte, error) {
+	// the span lasts until a worker picks up the request
+	_, queueSpan := tracer.Start(ctx, "embed_queue.wait", trace.WithAttributes(attribute.String("model", m.config.Name)))
 
-func (s *mServer) mustStartEmbedServer(device string) {
-	data, err := s.options.script.ReadFile("script/embed_server.py")
-	if err != nil {
-		zap.L().Fatal(err.Error())
+	req := &mWorkerRequest{
+		Uuid:      uuid.NewString(),
+		Context:   ctx,
+		Priority:  common.IncomingPriority(ctx),
+		Method:    method,
+		Params:    params,
+		Payload:   payload,
+		RespChan:  make(chan *mWorkerResponse, 1),
+		QueueSpan: queueSpan,
 	}
-	device = common.FormatDevice(device)
+	queueSpan.SetAttributes(attribute.String("uuid", req.Uuid), attribute.String("priority", req.Priority))
 
-	// find a free port
-	port, err := freePort()
-	if err != nil {
-		zap.L().Fatal(err.Error())
+	logger := zap.L().With(zap.String("uuid", req.Uuid), zap.String("model", m.config.Name), zap.String("method", method), zap.String("priority", req.Priority))
+	if err := m.queue.push(req, wait); err != nil {
+		queueSpan.End()
+		logger.Info("failed to queue worker request", zap.Error(err))
+		return nil, nil, err
 	}
+	logger.Info("queued worker request")
 
-	go func() {
-		zap.L().Info("started embed server", zap.String("device", device), zap.Int("port", port))
-		ctx := context.Background()
-		err = common.RunPython(ctx,
-			s.options.pythonBin,
-			"-c", string(data),
-			"--model-checkpoint", s.options.encoderCheckpoint,
-			"--model-type", s.options.encoderType,
-			"--device", device,
-			"--port", strconv.Itoa(port),
-			"--log-prefix", strconv.Itoa(port),
-		)
-		if err != nil {
-			zap.L().Fatal(err.Error())
-		}
-	}()
-
-	// serve embed request
-	ch := make(chan *mEmbedRequest)
-	go s.serveEmbedRequest(port, ch)
-}
-
-func (s *mServer) serveEmbedRequest(port int, ch chan *mEmbedRequest) {
-	logger := zap.L().With(zap.Int("port", port))
-	for {
-		s.embedServerPool <- ch
-		logger.Info("enqueued embedder")
-
-		req := <-ch
-		l := logger.With(zap.String("uuid", req.Uuid))
-		l.Info("met embed request")
-
-		ctx, body := req.Context, req.Body
-		resp, err := s.requestEmbed(ctx, body, port)
-		req.RespChan <- &mEmbedResponse{
-			Response: resp,
-			Error:    err,
+	// a request cancelled while running is cancelled on the worker as well, which then responds to it soon
+	select {
+	case resp := <-req.RespChan:
+		logger.Info("received worker response")
+		if resp.Error != nil {
+			return nil, nil, resp.Error
 		}
-		l.Info("finished embed request")
+		return resp.Result, resp.Payload, nil
+	case <-ctx.Done():
+		m.queue.remove(req)
+		logger.Info("cancelled waiting for worker response")
+		return nil, nil, ctx.Err()
 	}
 }
 
-func (s *mServer) listenEmbedRequest() {
-	for req := range s.embedReqQueue {
-		logger := zap.L().With(zap.String("uuid", req.Uuid))
-		logger.Info("dequeued embed request")
-		select {
-		case ch := <-s.embedServerPool:
-			logger.Info("found embedder")
-			ch <- req
-		case <-req.Context.Done():
-			logger.Info("waiting for embedder timeout")
-			req.RespChan <- &mEmbedResponse{
-				Error: req.Context.Err(),
-			}
-		}
-	}
+type mWorkerRequest struct {
+	Uuid      string
+	Context   context.Context
+	Priority  string
+	Method    string
+	Params    map[string]interface{}
+	Payload   []byte
+	RespChan  chan *mWorkerResponse
+	QueueSpan trace.Span
 }
 
-func (s *mServer) requestEmbed(ctx context.Context, body map[string]string, port int) (*http.Response, error) {
-	// prepare body
-	bodyJson, err := json.Marshal(body)
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
+func (r *mWorkerRequest) pickedUp(worker string) {
+	zap.L().Info("worker picked up request", zap.String("uuid", r.Uuid), zap.String("worker", worker))
+	r.QueueSpan.SetAttributes(attribute.String("worker", worker))
+	r.QueueSpan.End()
+}
 
-	// prepare request
-	url := fmt.Sprintf("http://127.0.0.1:%d/embed", port)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(bodyJson))
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
-	req = req.WithContext(ctx)
+type mWorkerResponse struct {
+	Result  json.RawMessage
+	Payload []byte
+	Error   error
+}
 
-	// send request
-	client := http.DefaultClient
-	resp, err := client.Do(req)
+func (m *mModel) newWorker(device string) *worker {
+	data, err := fs.ReadFile(m.options.script, "embed_worker.py")
 	if err != nil {
-		return nil, errors.WithStack(err)
+		zap.L().Fatal(err.Error())
 	}
+	device = common.FormatDevice(device)
+	name := fmt.Sprintf("%s@%s#%d", m.config.Name, device, len(m.workers))
 
-	// done
-	return resp, nil
+	w := newWorker(name, m.options.pythonBin,
+		"-c", string(data),
+		"--model-checkpoint", m.config.EncoderCheckpoint,
+		"--model-type", m.config.EncoderType,
+		"--decoder-path", m.config.DecoderPath,
+		"--device", device,
+		"--log-prefix", name,
+	)
+	w.device = device
+	return w
 }
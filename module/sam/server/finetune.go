@@ -0,0 +1,458 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"nutsh/module/common"
+	servicev1 "nutsh/proto/gen/go/service/v1"
+)
+
+// Fine-tuning jobs are kept in the workspace, each in its own directory along with its artifacts:
+//
+//	${WORKSPACE}/finetune/${ID}/job.json
+//	${WORKSPACE}/finetune/${ID}/decoder.onnx
+//	${WORKSPACE}/finetune/${ID}/decoder_quantized.onnx
+//
+// while images of samples are downloaded once for all jobs into `${WORKSPACE}/image`.
+
+const (
+	finetuneJobFile               = "job.json"
+	finetuneDecoderFile           = "decoder.onnx"
+	finetuneQuantizedDecoderFile  = "decoder_quantized.onnx"
+	finetuneStderrLimit           = 4096
+	finetuneDefaultNumEpoch       = 10
+	finetuneDefaultLearningRate   = 1e-4
+	finetuneInterruptedByShutdown = "interrupted by the shutdown of the server"
+	finetuneInterruptedByRestart  = "interrupted by a restart of the server"
+)
+
+type finetuneJob struct {
+	mu  sync.Mutex
+	job *servicev1.FinetuneJob
+	dir string
+
+	// cancel stops the job while it is running, and done is closed once it ends
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// updated is closed and replaced whenever the job changes
+	updated chan struct{}
+}
+
+func (j *finetuneJob) snapshot() *servicev1.FinetuneJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return proto.Clone(j.job).(*servicev1.FinetuneJob)
+}
+
+// update changes the job, saves it and wakes up those watching it.
+func (j *finetuneJob) update(f func(job *servicev1.FinetuneJob)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	f(j.job)
+	if err := j.save(); err != nil {
+		zap.L().Error("failed to save fine-tuning job", zap.String("id", j.job.Id), zap.Error(err))
+	}
+	close(j.updated)
+	j.updated = make(chan struct{})
+}
+
+func (j *finetuneJob) save() error {
+	data, err := protojson.Marshal(j.job)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// write to a temporary file first such that the job is never left half written
+	path := filepath.Join(j.dir, finetuneJobFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(path+".tmp", path))
+}
+
+func (s *mServer) StartFinetune(ctx context.Context, req *servicev1.StartFinetuneRequest) (*servicev1.StartFinetuneResponse, error) {
+	resp, err := s.startFinetune(ctx, req)
+	if err != nil {
+		zap.L().Error("error", zap.Error(err))
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *mServer) GetFinetune(ctx context.Context, req *servicev1.GetFinetuneRequest) (*servicev1.GetFinetuneResponse, error) {
+	j, err := s.finetune(req.GetId())
+	if err != nil {
+		zap.L().Error("error", zap.Error(err))
+		return nil, err
+	}
+	return &servicev1.GetFinetuneResponse{Job: j.snapshot()}, nil
+}
+
+func (s *mServer) ListFinetunes(ctx context.Context, req *servicev1.ListFinetunesRequest) (*servicev1.ListFinetunesResponse, error) {
+	s.finetunesMu.Lock()
+	defer s.finetunesMu.Unlock()
+	resp := &servicev1.ListFinetunesResponse{}
+	for _, id := range s.finetuneIds {
+		resp.Jobs = append(resp.Jobs, s.finetunes[id].snapshot())
+	}
+	return resp, nil
+}
+
+func (s *mServer) CancelFinetune(ctx context.Context, req *servicev1.CancelFinetuneRequest) (*servicev1.CancelFinetuneResponse, error) {
+	resp, err := s.cancelFinetune(ctx, req)
+	if err != nil {
+		zap.L().Error("error", zap.Error(err))
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *mServer) WatchFinetune(req *servicev1.WatchFinetuneRequest, stream servicev1.FinetuneService_WatchFinetuneServer) error {
+	err := s.watchFinetune(req, stream)
+	if err != nil {
+		zap.L().Error("error", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (s *mServer) finetune(id string) (*finetuneJob, error) {
+	s.finetunesMu.Lock()
+	defer s.finetunesMu.Unlock()
+	j, ok := s.finetunes[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "fine-tuning job %s is not found", id)
+	}
+	return j, nil
+}
+
+func (s *mServer) addFinetune(j *finetuneJob) {
+	s.finetunesMu.Lock()
+	defer s.finetunesMu.Unlock()
+	s.finetunes[j.job.Id] = j
+	s.finetuneIds = append(s.finetuneIds, j.job.Id)
+}
+
+func (s *mServer) startFinetune(ctx context.Context, req *servicev1.StartFinetuneRequest) (*servicev1.StartFinetuneResponse, error) {
+	if s.options.workspace == "" {
+		return nil, status.Error(codes.FailedPrecondition, "fine-tuning is not enabled without a workspace")
+	}
+	m, err := s.model(req.GetBaseDecoderUuid())
+	if err != nil {
+		return nil, err
+	}
+	if m.base != nil {
+		// the training starts from the decoder in the checkpoint of the encoder
+		return nil, status.Errorf(codes.InvalidArgument, "decoder %s is already fine-tuned", m.decoderUuid)
+	}
+	sampleDir := req.GetSampleDir()
+	if info, err := os.Stat(sampleDir); sampleDir == "" || err != nil || !info.IsDir() {
+		return nil, status.Errorf(codes.InvalidArgument, "sample directory %q is not found", sampleDir)
+	}
+
+	hp := &servicev1.FinetuneHyperparameters{
+		NumEpoch: finetuneDefaultNumEpoch,
+		Lr:       finetuneDefaultLearningRate,
+	}
+	if h := req.GetHyperparameters(); h != nil {
+		if h.NumEpoch > 0 {
+			hp.NumEpoch = h.NumEpoch
+		}
+		if h.Lr > 0 {
+			hp.Lr = h.Lr
+		}
+		hp.Wd = h.Wd
+	}
+	device := req.GetDevice()
+	if device == "" {
+		device = m.config.Devices[0]
+	}
+
+	id := uuid.NewString()
+	dir := filepath.Join(s.options.workspace, "finetune", id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	jobCtx, cancel := context.WithCancel(s.ctx)
+	j := &finetuneJob{
+		job: &servicev1.FinetuneJob{
+			Id:              id,
+			Status:          servicev1.FinetuneJob_STATUS_RUNNING,
+			BaseDecoderUuid: m.decoderUuid,
+			SampleDir:       sampleDir,
+			Hyperparameters: hp,
+			CreateTime:      time.Now().Format(time.RFC3339),
+		},
+		dir:     dir,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		updated: make(chan struct{}),
+	}
+	if err := j.save(); err != nil {
+		cancel()
+		return nil, err
+	}
+	s.addFinetune(j)
+	zap.L().Info("started fine-tuning", zap.String("id", id), zap.String("decoder", m.decoderUuid), zap.String("sampleDir", sampleDir))
+
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		s.runFinetune(jobCtx, j, m, common.FormatDevice(device))
+	}()
+
+	return &servicev1.StartFinetuneResponse{Job: j.snapshot()}, nil
+}
+
+// runFinetune trains the decoder and serves it if succeeded.
+func (s *mServer) runFinetune(ctx context.Context, j *finetuneJob, m *mModel, device string) {
+	defer close(j.done)
+	defer j.cancel()
+
+	logger := zap.L().With(zap.String("id", j.job.Id))
+	err := s.trainFinetune(ctx, j, m, device)
+	var fm *mModel
+	if err == nil {
+		fm, err = s.serveFinetuned(m, j)
+	}
+
+	j.update(func(job *servicev1.FinetuneJob) {
+		job.EndTime = time.Now().Format(time.RFC3339)
+		switch {
+		case err == nil:
+			job.Status = servicev1.FinetuneJob_STATUS_SUCCEEDED
+			job.Artifacts = &servicev1.FinetuneArtifacts{
+				DecoderUuid:          fm.decoderUuid,
+				DecoderPath:          filepath.Join(j.dir, finetuneDecoderFile),
+				QuantizedDecoderPath: fm.config.DecoderPath,
+			}
+		case s.ctx.Err() != nil:
+			job.Status = servicev1.FinetuneJob_STATUS_FAILED
+			job.Error = finetuneInterruptedByShutdown
+		case ctx.Err() != nil:
+			job.Status = servicev1.FinetuneJob_STATUS_CANCELLED
+		default:
+			job.Status = servicev1.FinetuneJob_STATUS_FAILED
+			job.Error = err.Error()
+		}
+	})
+	if err != nil {
+		logger.Error("fine-tuning ended without success", zap.Error(err))
+		return
+	}
+	logger.Info("fine-tuning succeeded", zap.String("decoder", fm.decoderUuid))
+}
+
+// trainFinetune runs the fine-tuning script, which reports the metrics of each epoch as a line of JSON on its stdout.
+func (s *mServer) trainFinetune(ctx context.Context, j *finetuneJob, m *mModel, device string) error {
+	args := s.options.finetuneEntry
+	var env []string
+	if len(args) == 0 {
+		// the script is run as a module to import its siblings
+		if err := common.EjectScripts(s.options.script, filepath.Join(j.dir, "script")); err != nil {
+			return err
+		}
+		args = []string{"-m", "script.finetune"}
+		env = append(env, "PYTHONPATH="+j.dir)
+	}
+
+	hp := j.job.Hyperparameters
+	args = append(append([]string(nil), args...),
+		"--model-type", m.config.EncoderType,
+		"--checkpoint", m.config.EncoderCheckpoint,
+		"--output", filepath.Join(j.dir, finetuneQuantizedDecoderFile),
+		"--output-original", filepath.Join(j.dir, finetuneDecoderFile),
+		"--device", device,
+		"--sample-dir", j.job.SampleDir,
+		"--workspace", s.options.workspace,
+		"--lr", fmt.Sprint(hp.Lr),
+		"--wd", fmt.Sprint(hp.Wd),
+		"--num-epoch", fmt.Sprint(hp.NumEpoch),
+		"--report-metrics",
+	)
+	stdout, stdoutW := io.Pipe()
+	c := &common.Command{
+		Bin:         s.options.pythonBin,
+		Args:        args,
+		Name:        "finetune-" + j.job.Id,
+		Env:         env,
+		Stdout:      stdoutW,
+		StderrLimit: finetuneStderrLimit,
+	}
+	p, err := c.Start(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		// all output has been copied
+		<-p.Done()
+		stdoutW.Close()
+	}()
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		var epoch struct {
+			Epoch    uint32  `json:"epoch"`
+			NumEpoch uint32  `json:"num_epoch"`
+			Loss     float32 `json:"loss"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &epoch); err != nil || epoch.Epoch == 0 {
+			// anything else printed, e.g. by libraries
+			zap.L().Debug("fine-tuning output", zap.String("id", j.job.Id), zap.ByteString("line", scanner.Bytes()))
+			continue
+		}
+		j.update(func(job *servicev1.FinetuneJob) {
+			job.Epochs = append(job.Epochs, &servicev1.FinetuneEpoch{
+				Epoch:    epoch.Epoch,
+				NumEpoch: epoch.NumEpoch,
+				Loss:     epoch.Loss,
+			})
+		})
+	}
+
+	// drain anything left unscanned, e.g. a line too long, for the copying to end
+	io.Copy(io.Discard, stdout)
+
+	if err := p.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// serveFinetuned serves the quantized decoder fine-tuned from the model by the job.
+func (s *mServer) serveFinetuned(m *mModel, j *finetuneJob) (*mModel, error) {
+	name := fmt.Sprintf("%s-finetuned-%s", m.config.Name, j.job.Id[:8])
+	fm, err := m.withDecoder(name, filepath.Join(j.dir, finetuneQuantizedDecoderFile))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.addModel(fm); err != nil {
+		return nil, err
+	}
+	zap.L().Info("serving fine-tuned decoder", zap.String("model", name), zap.String("uuid", fm.decoderUuid))
+	return fm, nil
+}
+
+func (s *mServer) cancelFinetune(ctx context.Context, req *servicev1.CancelFinetuneRequest) (*servicev1.CancelFinetuneResponse, error) {
+	j, err := s.finetune(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	j.cancel()
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &servicev1.CancelFinetuneResponse{Job: j.snapshot()}, nil
+}
+
+func (s *mServer) watchFinetune(req *servicev1.WatchFinetuneRequest, stream servicev1.FinetuneService_WatchFinetuneServer) error {
+	j, err := s.finetune(req.GetId())
+	if err != nil {
+		return err
+	}
+
+	sent := 0
+	for {
+		j.mu.Lock()
+		epochs := j.job.Epochs[sent:]
+		running := j.job.Status == servicev1.FinetuneJob_STATUS_RUNNING
+		updated := j.updated
+		j.mu.Unlock()
+
+		for _, epoch := range epochs {
+			if err := stream.Send(&servicev1.WatchFinetuneResponse{Epoch: epoch}); err != nil {
+				return err
+			}
+		}
+		sent += len(epochs)
+		if !running {
+			return nil
+		}
+
+		select {
+		case <-updated:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// loadFinetunes loads the jobs in the workspace, serving the decoders fine-tuned if their base models are served,
+// while the jobs which were running are marked as failed.
+func (s *mServer) loadFinetunes() error {
+	if s.options.workspace == "" {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(s.options.workspace, "finetune", "*", finetuneJobFile))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var jobs []*finetuneJob
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		job := &servicev1.FinetuneJob{}
+		if err := protojson.Unmarshal(data, job); err != nil {
+			return errors.Wrapf(err, "failed to read fine-tuning job at %s", path)
+		}
+		done := make(chan struct{})
+		close(done)
+		jobs = append(jobs, &finetuneJob{
+			job:     job,
+			dir:     filepath.Dir(path),
+			cancel:  func() {},
+			done:    done,
+			updated: make(chan struct{}),
+		})
+	}
+	sort.SliceStable(jobs, func(a, b int) bool {
+		return jobs[a].job.CreateTime < jobs[b].job.CreateTime
+	})
+
+	for _, j := range jobs {
+		switch j.job.Status {
+		case servicev1.FinetuneJob_STATUS_RUNNING:
+			j.update(func(job *servicev1.FinetuneJob) {
+				job.Status = servicev1.FinetuneJob_STATUS_FAILED
+				job.Error = finetuneInterruptedByRestart
+				job.EndTime = time.Now().Format(time.RFC3339)
+			})
+		case servicev1.FinetuneJob_STATUS_SUCCEEDED:
+			m, err := s.model(j.job.BaseDecoderUuid)
+			if err != nil {
+				zap.L().Warn("not serving the fine-tuned decoder as its base is not served", zap.String("id", j.job.Id), zap.Error(err))
+				break
+			}
+			if _, err := s.serveFinetuned(m, j); err != nil {
+				zap.L().Warn("failed to serve the fine-tuned decoder", zap.String("id", j.job.Id), zap.Error(err))
+			}
+		}
+		s.addFinetune(j)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	servicev1 "nutsh/proto/gen/go/service/v1"
+)
+
+// requireFinetuneServer creates a server fine-tuning by `testdata/fake_finetune.py` in the workspace.
+func requireFinetuneServer(t *testing.T, workspace string) *mServer {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &mServer{
+		options: &Options{
+			pythonBin:     python,
+			workspace:     workspace,
+			finetuneEntry: []string{"testdata/fake_finetune.py"},
+		},
+		ctx:    ctx,
+		cancel: cancel,
+		models: []*mModel{{
+			config:      ModelConfig{Name: "fake", EncoderType: "vit_b", Devices: []string{"cpu"}},
+			decoderUuid: "sam.vit_b.base",
+			queue:       newRequestQueue(0, 0),
+		}},
+		finetunes: make(map[string]*finetuneJob),
+	}
+	require.NoError(t, s.loadFinetunes())
+	t.Cleanup(s.Clean)
+	return s
+}
+
+func requireSampleDir(t *testing.T, names ...string) string {
+	dir := t.TempDir()
+	for _, name := range names {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644))
+	}
+	return dir
+}
+
+type watchFinetuneStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	epochs []*servicev1.FinetuneEpoch
+}
+
+func (s *watchFinetuneStream) Send(resp *servicev1.WatchFinetuneResponse) error {
+	s.epochs = append(s.epochs, resp.GetEpoch())
+	return nil
+}
+
+func (s *watchFinetuneStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestFinetune(t *testing.T) {
+	ctx := context.Background()
+	workspace := t.TempDir()
+	s := requireFinetuneServer(t, workspace)
+
+	started, err := s.StartFinetune(ctx, &servicev1.StartFinetuneRequest{
+		SampleDir:       requireSampleDir(t, "a.json", "b.json"),
+		Hyperparameters: &servicev1.FinetuneHyperparameters{NumEpoch: 3},
+	})
+	require.NoError(t, err)
+	id := started.GetJob().GetId()
+	require.Equal(t, servicev1.FinetuneJob_STATUS_RUNNING, started.GetJob().GetStatus())
+	require.Equal(t, "sam.vit_b.base", started.GetJob().GetBaseDecoderUuid())
+
+	// the stream follows the job until it ends
+	stream := &watchFinetuneStream{ctx: ctx}
+	require.NoError(t, s.WatchFinetune(&servicev1.WatchFinetuneRequest{Id: id}, stream))
+	require.Len(t, stream.epochs, 3)
+	require.EqualValues(t, 3, stream.epochs[2].GetEpoch())
+	require.InDelta(t, 1.0/3, stream.epochs[2].GetLoss(), 1e-6)
+
+	got, err := s.GetFinetune(ctx, &servicev1.GetFinetuneRequest{Id: id})
+	require.NoError(t, err)
+	job := got.GetJob()
+	require.Equal(t, servicev1.FinetuneJob_STATUS_SUCCEEDED, job.GetStatus())
+	require.FileExists(t, job.GetArtifacts().GetDecoderPath())
+	require.FileExists(t, job.GetArtifacts().GetQuantizedDecoderPath())
+
+	// the fine-tuned decoder is served by the workers of its base
+	m, err := s.model(job.GetArtifacts().GetDecoderUuid())
+	require.NoError(t, err)
+	require.Same(t, s.models[0], m.base)
+	require.Same(t, s.models[0].queue, m.queue)
+	def, err := s.model("")
+	require.NoError(t, err)
+	require.Same(t, s.models[0], def)
+
+	// and still served after a restart
+	s.Clean()
+	s = requireFinetuneServer(t, workspace)
+	_, err = s.model(job.GetArtifacts().GetDecoderUuid())
+	require.NoError(t, err)
+	list, err := s.ListFinetunes(ctx, &servicev1.ListFinetunesRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetJobs(), 1)
+	require.Equal(t, servicev1.FinetuneJob_STATUS_SUCCEEDED, list.GetJobs()[0].GetStatus())
+}
+
+func TestFinetuneFailure(t *testing.T) {
+	ctx := context.Background()
+	s := requireFinetuneServer(t, t.TempDir())
+
+	_, err := s.StartFinetune(ctx, &servicev1.StartFinetuneRequest{SampleDir: filepath.Join(t.TempDir(), "missing")})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	started, err := s.StartFinetune(ctx, &servicev1.StartFinetuneRequest{SampleDir: requireSampleDir(t)})
+	require.NoError(t, err)
+	stream := &watchFinetuneStream{ctx: ctx}
+	require.NoError(t, s.WatchFinetune(&servicev1.WatchFinetuneRequest{Id: started.GetJob().GetId()}, stream))
+
+	got, err := s.GetFinetune(ctx, &servicev1.GetFinetuneRequest{Id: started.GetJob().GetId()})
+	require.NoError(t, err)
+	require.Equal(t, servicev1.FinetuneJob_STATUS_FAILED, got.GetJob().GetStatus())
+	require.Contains(t, got.GetJob().GetError(), "no samples found")
+	require.Len(t, s.servedModels(), 1)
+}
+
+func TestFinetuneCancel(t *testing.T) {
+	ctx := context.Background()
+	s := requireFinetuneServer(t, t.TempDir())
+
+	started, err := s.StartFinetune(ctx, &servicev1.StartFinetuneRequest{
+		SampleDir:       requireSampleDir(t, "a.json"),
+		Hyperparameters: &servicev1.FinetuneHyperparameters{NumEpoch: 100000},
+	})
+	require.NoError(t, err)
+	id := started.GetJob().GetId()
+	require.Eventually(t, func() bool {
+		got, err := s.GetFinetune(ctx, &servicev1.GetFinetuneRequest{Id: id})
+		return err == nil && len(got.GetJob().GetEpochs()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancelled, err := s.CancelFinetune(ctx, &servicev1.CancelFinetuneRequest{Id: id})
+	require.NoError(t, err)
+	require.Equal(t, servicev1.FinetuneJob_STATUS_CANCELLED, cancelled.GetJob().GetStatus())
+	require.NotEmpty(t, cancelled.GetJob().GetEndTime())
+}
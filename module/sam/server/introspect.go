@@ -2,6 +2,8 @@ package server
 
 import (
 	"context"
+	"io/fs"
+	"nutsh/module/common"
 	servicev1 "nutsh/proto/gen/go/service/v1"
 
 	"github.com/pkg/errors"
@@ -17,16 +19,82 @@ func (s *mServer) Introspect(ctx context.Context, req *servicev1.IntrospectReque
 	return resp, nil
 }
 
+// All SAM encoders resize images such that their longer side is of this length.
+const samInputSize = 1024
+
 func (s *mServer) introspect(ctx context.Context, req *servicev1.IntrospectRequest) (*servicev1.IntrospectResponse, error) {
-	data, err := s.options.script.ReadFile("script/input.js")
+	data, err := fs.ReadFile(s.options.script, "input.js")
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
+	// all SAM decoders take the same input
+	feedJs := string(data)
+
+	var decoders []*servicev1.DecoderInfo
+	var queues []*servicev1.QueueStatus
+	models := s.servedModels()
+	for _, m := range models {
+		decoders = append(decoders, &servicev1.DecoderInfo{
+			Uuid:        m.decoderUuid,
+			FeedJs:      feedJs,
+			Name:        m.config.Name,
+			EncoderType: m.config.EncoderType,
+			Devices:     m.devices(),
+			InputSize:   samInputSize,
+		})
+		queues = append(queues, m.queueStatus())
+	}
+
 	resp := &servicev1.IntrospectResponse{
-		DecoderUuid:   s.decoderUuid,
-		DecoderFeedJs: string(data),
+		DecoderUuid:   models[0].decoderUuid,
+		DecoderFeedJs: feedJs,
+		Decoders:      decoders,
+		Queues:        queues,
+		Cache:         s.cacheStatus(),
 	}
 
 	return resp, nil
 }
+
+func (m *mModel) queueStatus() *servicev1.QueueStatus {
+	lengths, rejected := m.queue.stats()
+	var devices []*servicev1.DeviceQueueStatus
+	for _, w := range m.workers {
+		devices = append(devices, &servicev1.DeviceQueueStatus{
+			Device:    w.device,
+			Busy:      w.busy.Load(),
+			Served:    w.served.Load(),
+			Cancelled: w.cancelled.Load(),
+			State:     w.state.Load().(string),
+			Restarts:  uint32(w.restarts.Load()),
+		})
+	}
+	return &servicev1.QueueStatus{
+		DecoderUuid: m.decoderUuid,
+		Interactive: uint32(lengths[common.PriorityInteractive]),
+		Batch:       uint32(lengths[common.PriorityBatch]),
+		Rejected:    rejected,
+		Devices:     devices,
+	}
+}
+
+func (s *mServer) cacheStatus() *servicev1.CacheStatus {
+	stats := s.cache.stats()
+	return &servicev1.CacheStatus{
+		Entries:   uint32(stats.entries),
+		Size:      uint64(stats.size),
+		Capacity:  uint64(stats.capacity),
+		Hits:      stats.hits,
+		Misses:    stats.misses,
+		Coalesced: s.coalesced.Load(),
+	}
+}
+
+func (m *mModel) devices() []string {
+	var devices []string
+	for _, w := range m.workers {
+		devices = append(devices, w.device)
+	}
+	return devices
+}
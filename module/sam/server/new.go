@@ -1,40 +1,68 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	servicev1 "nutsh/proto/gen/go/service/v1"
+	"strings"
+	"sync"
+	"sync/atomic"
 
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
-func New(opts ...Option) (servicev1.OnlineSegmentationServiceServer, func()) {
+// Server serves segmentation, and fine-tuning of the decoders served.
+type Server interface {
+	servicev1.OnlineSegmentationServiceServer
+	servicev1.FinetuneServiceServer
+}
+
+func New(opts ...Option) (Server, func()) {
 	o := &Options{
-		pythonBin: "python",
-		devices:   []string{"cpu"},
+		pythonBin:           "python",
+		devices:             []string{"cpu"},
+		maxInteractiveQueue: 64,
+		maxBatchQueue:       256,
 	}
 	for _, opt := range opts {
 		opt(o)
 	}
-
-	// read the decoder and use its md5 as its uuid
-	decoderHash, err := fileHash(o.decoderPath)
-	if err != nil {
-		zap.L().Fatal(err.Error())
+	if len(o.models) == 0 {
+		zap.L().Fatal("no model to serve")
 	}
-	decoderUuid := fmt.Sprintf("sam.%s.%s", o.encoderType, decoderHash)
-	zap.L().Info("loaded decoder", zap.String("path", o.decoderPath), zap.String("uuid", decoderUuid))
 
+	ctx, cancel := context.WithCancel(context.Background())
 	s := &mServer{
-		options:         o,
-		decoderUuid:     decoderUuid,
-		embedReqQueue:   make(chan *mEmbedRequest),
-		embedServerPool: make(chan chan *mEmbedRequest, len(o.devices)),
+		options:   o,
+		ctx:       ctx,
+		cancel:    cancel,
+		cache:     newEmbeddingCache(o.cacheSize),
+		finetunes: make(map[string]*finetuneJob),
+	}
+	for _, config := range o.models {
+		m, err := newModel(o, config)
+		if err != nil {
+			zap.L().Fatal(err.Error())
+		}
+		if _, err := s.model(m.decoderUuid); err == nil {
+			zap.L().Fatal("duplicated decoder", zap.String("uuid", m.decoderUuid))
+		}
+		s.models = append(s.models, m)
 	}
 
-	for _, device := range o.devices {
-		go s.mustStartEmbedServer(device)
+	for _, m := range s.models {
+		m.start(ctx, &s.workers, s.updateHealth)
+	}
+	s.updateHealth()
+
+	if err := s.loadFinetunes(); err != nil {
+		zap.L().Fatal("failed to load fine-tuning jobs", zap.Error(err))
 	}
-	go s.listenEmbedRequest()
 
 	return s, s.Clean
 }
@@ -42,10 +70,180 @@ func New(opts ...Option) (servicev1.OnlineSegmentationServiceServer, func()) {
 type mServer struct {
 	options *Options
 
-	decoderUuid     string
-	embedReqQueue   chan *mEmbedRequest
-	embedServerPool chan chan *mEmbedRequest
+	// models served, the first of which is the default one, followed by the configured ones and then the fine-tuned
+	// ones added while serving
+	models   []*mModel
+	modelsMu sync.RWMutex
+
+	// cancel stops all workers and fine-tuning jobs, which are waited by the group
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+
+	// embeddings recently computed, and those being computed shared by concurrent identical requests
+	cache     *embeddingCache
+	inflight  singleflight.Group
+	coalesced atomic.Uint64
+
+	// serializes updates of the health status, which are triggered by workers
+	healthMu sync.Mutex
+
+	// fine-tuning jobs by their ids, and the ids in the order of creation
+	finetunes   map[string]*finetuneJob
+	finetuneIds []string
+	finetunesMu sync.Mutex
+}
+
+// servedModels returns the models served at the moment.
+func (s *mServer) servedModels() []*mModel {
+	s.modelsMu.RLock()
+	defer s.modelsMu.RUnlock()
+	return append([]*mModel(nil), s.models...)
+}
+
+// addModel serves one more model unless its decoder is already served.
+func (s *mServer) addModel(m *mModel) error {
+	s.modelsMu.Lock()
+	defer s.modelsMu.Unlock()
+	for _, served := range s.models {
+		if served.decoderUuid == m.decoderUuid {
+			return errors.Errorf("decoder %s is already served", m.decoderUuid)
+		}
+	}
+	s.models = append(s.models, m)
+	return nil
+}
+
+// model finds the model of the decoder, which can be omitted if only one model is configured.
+func (s *mServer) model(decoderUuid string) (*mModel, error) {
+	models := s.servedModels()
+	if decoderUuid == "" {
+		var configured []*mModel
+		for _, m := range models {
+			if m.base == nil {
+				configured = append(configured, m)
+			}
+		}
+		if len(configured) == 1 {
+			return configured[0], nil
+		}
+	}
+	var uuids []string
+	for _, m := range models {
+		if m.decoderUuid == decoderUuid {
+			return m, nil
+		}
+		uuids = append(uuids, m.decoderUuid)
+	}
+	return nil, status.Errorf(codes.NotFound, "unaccepted decoder uuid %s (availables are: [%s])", decoderUuid, strings.Join(uuids, ", "))
+}
+
+// updateHealth reports the server as serving only while all workers are ready.
+func (s *mServer) updateHealth() {
+	h := s.options.health
+	if h == nil {
+		return
+	}
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	status := healthpb.HealthCheckResponse_SERVING
+	for _, m := range s.servedModels() {
+		for _, w := range m.workers {
+			if w.state.Load() != workerReady {
+				status = healthpb.HealthCheckResponse_NOT_SERVING
+			}
+		}
+	}
+	h.SetServingStatus("", status)
+	h.SetServingStatus(servicev1.OnlineSegmentationService_ServiceDesc.ServiceName, status)
 }
 
+// Clean stops all workers and waits for their processes to exit.
 func (s *mServer) Clean() {
+	if h := s.options.health; h != nil {
+		h.Shutdown()
+	}
+	s.cancel()
+	s.workers.Wait()
+}
+
+// mModel serves a model with its own workers, one on each of its devices, which pull requests from the queue once
+// they are ready.
+type mModel struct {
+	options *Options
+	config  ModelConfig
+
+	// the model whose encoder, and thus queue and workers, are shared by this fine-tuned one
+	base *mModel
+
+	decoderUuid string
+	queue       *requestQueue
+	workers     []*worker
+}
+
+func newModel(o *Options, config ModelConfig) (*mModel, error) {
+	if config.Name == "" {
+		config.Name = config.EncoderType
+	}
+	if len(config.Devices) == 0 {
+		config.Devices = o.devices
+	}
+
+	// read the decoder and use its md5 as its uuid
+	decoderHash, err := fileHash(config.DecoderPath)
+	if err != nil {
+		return nil, err
+	}
+	decoderUuid := fmt.Sprintf("sam.%s.%s", config.EncoderType, decoderHash)
+	zap.L().Info("loaded decoder",
+		zap.String("model", config.Name),
+		zap.String("path", config.DecoderPath),
+		zap.String("uuid", decoderUuid),
+		zap.Strings("devices", config.Devices),
+	)
+
+	m := &mModel{
+		options:     o,
+		config:      config,
+		decoderUuid: decoderUuid,
+		queue:       newRequestQueue(o.maxInteractiveQueue, o.maxBatchQueue),
+	}
+	for _, device := range config.Devices {
+		m.workers = append(m.workers, m.newWorker(device))
+	}
+	return m, nil
+}
+
+// start runs the workers of the model, which call the function whenever their state changes.
+func (m *mModel) start(ctx context.Context, wg *sync.WaitGroup, onState func()) {
+	for _, w := range m.workers {
+		w := w
+		w.onState = onState
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run(ctx, m.queue)
+		}()
+	}
+}
+
+// withDecoder creates a model of a fine-tuned decoder, which shares the workers of the model as embeddings do not
+// depend on the decoder.
+func (m *mModel) withDecoder(name, decoderPath string) (*mModel, error) {
+	decoderHash, err := fileHash(decoderPath)
+	if err != nil {
+		return nil, err
+	}
+	config := m.config
+	config.Name = name
+	config.DecoderPath = decoderPath
+	return &mModel{
+		options:     m.options,
+		config:      config,
+		base:        m,
+		decoderUuid: fmt.Sprintf("sam.%s.%s", config.EncoderType, decoderHash),
+		queue:       m.queue,
+		workers:     m.workers,
+	}, nil
 }
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealth(t *testing.T) {
+	ctx := context.Background()
+	h := health.NewServer()
+	w1, w2 := newWorker("w1", "python"), newWorker("w2", "python")
+	s := &mServer{
+		options: &Options{health: h},
+		models:  []*mModel{{workers: []*worker{w1, w2}}},
+	}
+	check := func() healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := h.Check(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		return resp.Status
+	}
+
+	s.updateHealth()
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check())
+
+	// serving once all workers are ready
+	w1.onState, w2.onState = s.updateHealth, s.updateHealth
+	w1.setState(workerReady)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check())
+	w2.setState(workerReady)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, check())
+
+	// and not while any of them is restarting
+	w1.setState(workerRestarting)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check())
+}
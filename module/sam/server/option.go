@@ -1,16 +1,31 @@
 package server
 
 import (
-	"embed"
+	"io/fs"
+
+	"google.golang.org/grpc/health"
 )
 
 type Options struct {
-	encoderType       string
-	encoderCheckpoint string
-	decoderPath       string
-	pythonBin         string
-	script            embed.FS
-	devices           []string
+	models    []ModelConfig
+	pythonBin string
+	script    fs.FS
+	devices   []string
+
+	// the maximum number of requests queued for each model by priority class, unlimited if not positive
+	maxInteractiveQueue int
+	maxBatchQueue       int
+
+	// the maximum size of embeddings cached in bytes, or nothing cached if not positive
+	cacheSize int64
+
+	health *health.Server
+
+	// the directory keeping fine-tuning jobs, without which fine-tuning is disabled
+	workspace string
+
+	// the command line of the fine-tuning script run by Python, which is replaced in tests
+	finetuneEntry []string
 }
 
 type Option func(*Options)
@@ -21,22 +36,64 @@ func WithPython(bin string) Option {
 	}
 }
 
-func WithScript(script embed.FS) Option {
+// WithScript sets the scripts to run, which are the files at the root of the file system.
+func WithScript(script fs.FS) Option {
 	return func(o *Options) {
 		o.script = script
 	}
 }
 
+// WithModel adds a model to serve on the default devices.
 func WithModel(encoderType, encoderCheckpoint, decoderPath string) Option {
 	return func(o *Options) {
-		o.encoderType = encoderType
-		o.encoderCheckpoint = encoderCheckpoint
-		o.decoderPath = decoderPath
+		o.models = append(o.models, ModelConfig{
+			EncoderType:       encoderType,
+			EncoderCheckpoint: encoderCheckpoint,
+			DecoderPath:       decoderPath,
+		})
 	}
 }
 
+func WithModels(models ...ModelConfig) Option {
+	return func(o *Options) {
+		o.models = append(o.models, models...)
+	}
+}
+
+// WithDevices sets the default devices of models which do not specify their own.
 func WithDevices(devices []string) Option {
 	return func(o *Options) {
 		o.devices = devices
 	}
 }
+
+// WithQueueLimits sets the maximum number of interactive and batch requests queued for each model, beyond which
+// requests are rejected with RESOURCE_EXHAUSTED. A limit which is not positive means unlimited.
+func WithQueueLimits(interactive, batch int) Option {
+	return func(o *Options) {
+		o.maxInteractiveQueue = interactive
+		o.maxBatchQueue = batch
+	}
+}
+
+// WithCacheSize sets the maximum size in bytes of the embeddings cached in memory, or disables caching if not positive.
+func WithCacheSize(bytes int64) Option {
+	return func(o *Options) {
+		o.cacheSize = bytes
+	}
+}
+
+// WithHealth reports the health of the server to the health service, which is serving only while all workers are
+// ready.
+func WithHealth(h *health.Server) Option {
+	return func(o *Options) {
+		o.health = h
+	}
+}
+
+// WithWorkspace sets the directory to keep fine-tuning jobs and their artifacts, which enables fine-tuning.
+func WithWorkspace(dir string) Option {
+	return func(o *Options) {
+		o.workspace = dir
+	}
+}
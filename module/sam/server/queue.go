@@ -0,0 +1,135 @@
+package server
+
+import (
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"nutsh/module/common"
+)
+
+// Priority classes in the order to serve.
+var priorities = []string{common.PriorityInteractive, common.PriorityBatch}
+
+// requestQueue holds requests to a model waiting for a worker, serving interactive ones before batch ones and each
+// class in order of arrival.
+type requestQueue struct {
+	mu       sync.Mutex
+	pending  map[string][]*mWorkerRequest
+	limits   map[string]int
+	rejected uint64
+
+	// wake is signalled when requests are pushed, and space when requests leave, each waking up a single waiter which
+	// passes the signal on if there is still more
+	wake  chan struct{}
+	space chan struct{}
+}
+
+// newRequestQueue creates a queue holding at most the given number of requests of each class, or unlimited if not
+// positive.
+func newRequestQueue(interactiveLimit, batchLimit int) *requestQueue {
+	return &requestQueue{
+		pending: make(map[string][]*mWorkerRequest),
+		limits: map[string]int{
+			common.PriorityInteractive: interactiveLimit,
+			common.PriorityBatch:       batchLimit,
+		},
+		wake:  make(chan struct{}, 1),
+		space: make(chan struct{}, 1),
+	}
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// push enqueues the request, failing with RESOURCE_EXHAUSTED if its class is full, or waiting for space instead if
+// asked to, which applies backpressure to streams.
+func (q *requestQueue) push(req *mWorkerRequest, wait bool) error {
+	for {
+		q.mu.Lock()
+		pending := q.pending[req.Priority]
+		if limit := q.limits[req.Priority]; limit <= 0 || len(pending) < limit {
+			q.pending[req.Priority] = append(pending, req)
+			more := limit <= 0 || len(pending)+1 < limit
+			q.mu.Unlock()
+
+			signal(q.wake)
+			if more {
+				signal(q.space)
+			}
+			return nil
+		}
+		if !wait {
+			q.rejected++
+			q.mu.Unlock()
+			return status.Errorf(codes.ResourceExhausted, "too many %s requests are queued", req.Priority)
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.space:
+		case <-req.Context.Done():
+			return req.Context.Err()
+		}
+	}
+}
+
+// pop dequeues the next request, or returns nil if there is none.
+func (q *requestQueue) pop() *mWorkerRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, p := range priorities {
+		pending := q.pending[p]
+		if len(pending) == 0 {
+			continue
+		}
+		req := pending[0]
+		pending[0] = nil
+		q.pending[p] = pending[1:]
+
+		signal(q.space)
+		if q.length() > 0 {
+			signal(q.wake)
+		}
+		return req
+	}
+	return nil
+}
+
+// remove removes a request which is cancelled while queued.
+func (q *requestQueue) remove(req *mWorkerRequest) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending := q.pending[req.Priority]
+	for i, r := range pending {
+		if r == req {
+			q.pending[req.Priority] = append(pending[:i:i], pending[i+1:]...)
+			signal(q.space)
+			return
+		}
+	}
+}
+
+func (q *requestQueue) length() int {
+	n := 0
+	for _, pending := range q.pending {
+		n += len(pending)
+	}
+	return n
+}
+
+// stats reports the number of pending requests by class and the number of rejected ones.
+func (q *requestQueue) stats() (map[string]int, uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	lengths := make(map[string]int)
+	for p, pending := range q.pending {
+		lengths[p] = len(pending)
+	}
+	return lengths, q.rejected
+}
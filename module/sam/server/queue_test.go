@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"nutsh/module/common"
+)
+
+func newQueuedRequest(ctx context.Context, priority string) *mWorkerRequest {
+	return &mWorkerRequest{Context: ctx, Priority: priority}
+}
+
+func TestRequestQueuePriority(t *testing.T) {
+	ctx := context.Background()
+	q := newRequestQueue(0, 0)
+
+	b1 := newQueuedRequest(ctx, common.PriorityBatch)
+	b2 := newQueuedRequest(ctx, common.PriorityBatch)
+	i1 := newQueuedRequest(ctx, common.PriorityInteractive)
+	for _, req := range []*mWorkerRequest{b1, b2, i1} {
+		require.NoError(t, q.push(req, false))
+	}
+
+	// interactive requests jump the queue while each class is served in order
+	require.Same(t, i1, q.pop())
+	require.Same(t, b1, q.pop())
+	require.Same(t, b2, q.pop())
+	require.Nil(t, q.pop())
+}
+
+func TestRequestQueueLimit(t *testing.T) {
+	ctx := context.Background()
+	q := newRequestQueue(1, 1)
+
+	require.NoError(t, q.push(newQueuedRequest(ctx, common.PriorityInteractive), false))
+	err := q.push(newQueuedRequest(ctx, common.PriorityInteractive), false)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// classes are limited separately
+	require.NoError(t, q.push(newQueuedRequest(ctx, common.PriorityBatch), false))
+
+	lengths, rejected := q.stats()
+	require.Equal(t, 1, lengths[common.PriorityInteractive])
+	require.Equal(t, 1, lengths[common.PriorityBatch])
+	require.EqualValues(t, 1, rejected)
+}
+
+func TestRequestQueueWait(t *testing.T) {
+	ctx := context.Background()
+	q := newRequestQueue(0, 1)
+
+	first := newQueuedRequest(ctx, common.PriorityBatch)
+	require.NoError(t, q.push(first, true))
+
+	// a waiting push proceeds once there is space
+	pushed := make(chan error, 1)
+	second := newQueuedRequest(ctx, common.PriorityBatch)
+	go func() { pushed <- q.push(second, true) }()
+	select {
+	case <-pushed:
+		t.Fatal("pushed into a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.Same(t, first, q.pop())
+	require.NoError(t, <-pushed)
+	require.Same(t, second, q.pop())
+
+	// or fails once its context is done
+	require.NoError(t, q.push(first, true))
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, q.push(newQueuedRequest(cctx, common.PriorityBatch), true), context.Canceled)
+}
+
+func TestRequestQueueRemove(t *testing.T) {
+	ctx := context.Background()
+	q := newRequestQueue(0, 0)
+
+	r1 := newQueuedRequest(ctx, common.PriorityInteractive)
+	r2 := newQueuedRequest(ctx, common.PriorityInteractive)
+	require.NoError(t, q.push(r1, false))
+	require.NoError(t, q.push(r2, false))
+
+	q.remove(r1)
+	require.Same(t, r2, q.pop())
+	require.Nil(t, q.pop())
+}
+
+func TestRequestQueueCoalescedByPriority(t *testing.T) {
+	ctx := context.Background()
+	m := &mModel{queue: newRequestQueue(1, 1)}
+	s := &mServer{cache: newEmbeddingCache(0)}
+	require.NoError(t, m.queue.push(newQueuedRequest(ctx, common.PriorityBatch), false))
+
+	// a batch request of the image waits for space behind the full batch queue
+	batchCtx, cancelBatch := context.WithCancel(metadata.NewIncomingContext(ctx, metadata.Pairs(common.PriorityMetadataKey, common.PriorityBatch)))
+	defer cancelBatch()
+	go s.embedCoalesced(batchCtx, m, "key", nil, nil, true)
+	time.Sleep(50 * time.Millisecond)
+
+	// while an interactive one of the same image is queued on its own
+	embedded := make(chan []byte, 1)
+	go func() {
+		output, err := s.embedCoalesced(ctx, m, "key", nil, nil, false)
+		require.NoError(t, err)
+		embedded <- output
+	}()
+	require.Eventually(t, func() bool {
+		lengths, _ := m.queue.stats()
+		return lengths[common.PriorityInteractive] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	req := m.queue.pop()
+	require.Equal(t, common.PriorityInteractive, req.Priority)
+	req.RespChan <- &mWorkerResponse{Payload: []byte("embedding")}
+	require.Equal(t, []byte("embedding"), <-embedded)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	schemav1 "nutsh/proto/gen/go/schema/v1"
+	servicev1 "nutsh/proto/gen/go/service/v1"
+)
+
+func (s *mServer) Segment(ctx context.Context, req *servicev1.SegmentRequest) (*servicev1.SegmentResponse, error) {
+	resp, err := s.segment(ctx, req)
+	if err != nil {
+		zap.L().Error("error", zap.Error(err))
+		return nil, err
+	}
+	return resp, nil
+}
+
+// segmentOutput is the result of segmentation by a worker.
+type segmentOutput struct {
+	CocoEncodedRle string  `json:"coco_encoded_rle"`
+	Width          uint32  `json:"width"`
+	Height         uint32  `json:"height"`
+	Score          float32 `json:"score"`
+}
+
+func (s *mServer) segment(ctx context.Context, req *servicev1.SegmentRequest) (*servicev1.SegmentResponse, error) {
+	m, err := s.model(req.GetDecoderUuid())
+	if err != nil {
+		return nil, err
+	}
+
+	box := req.GetBox()
+	hasBox := box != nil && box.Width > 0 && box.Height > 0
+	if len(req.GetPointPrompts()) == 0 && !hasBox {
+		return nil, status.Error(codes.InvalidArgument, "missing point prompts or box")
+	}
+
+	points := [][]interface{}{}
+	for _, p := range req.GetPointPrompts() {
+		points = append(points, []interface{}{p.X, p.Y, p.IsPositive})
+	}
+	params := map[string]interface{}{
+		"points": points,
+		// workers of a model serve its fine-tuned decoders as well
+		"decoder_path": m.config.DecoderPath,
+	}
+	if crop := req.GetCrop(); crop != nil && crop.Width > 0 && crop.Height > 0 {
+		params["crop"] = []uint32{crop.X, crop.Y, crop.Width, crop.Height}
+	}
+	if hasBox {
+		params["box"] = []uint32{box.X, box.Y, box.Width, box.Height}
+	}
+	result, _, err := m.sendRequest(ctx, "segment", params, req.GetOriginalImage(), false)
+	if err != nil {
+		return nil, err
+	}
+	var output segmentOutput
+	if err := json.Unmarshal(result, &output); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &servicev1.SegmentResponse{
+		Mask: &schemav1.Mask{
+			CocoEncodedRle: output.CocoEncodedRle,
+			Size: &schemav1.GridSize{
+				Width:  output.Width,
+				Height: output.Height,
+			},
+		},
+		Score: output.Score,
+	}, nil
+}
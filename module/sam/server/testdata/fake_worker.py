@@ -0,0 +1,80 @@
+# A stand-in of `script/embed_worker.py` speaking the same protocol without any model, which embeds an image by
+# reversing its bytes.
+
+import os
+import sys
+import json
+import time
+import queue
+import struct
+import threading
+
+
+def read_frame(f):
+    size = f.read(4)
+    if len(size) < 4:
+        return None
+    (n,) = struct.unpack(">I", size)
+    return f.read(n)
+
+
+def write_message(f, header, payload=b""):
+    data = json.dumps(header).encode("utf-8")
+    f.write(struct.pack(">I", len(data)) + data + struct.pack(">I", len(payload)) + payload)
+    f.flush()
+
+
+def read_requests(reader, requests, cancelled):
+    while True:
+        header = read_frame(reader)
+        if header is None:
+            requests.put(None)
+            return
+        payload = read_frame(reader)
+        header = json.loads(header)
+        if header["method"] == "cancel":
+            cancelled.add(header["params"]["id"])
+        else:
+            requests.put((header, payload))
+
+
+def main():
+    reader, writer = sys.stdin.buffer, sys.stdout.buffer
+    requests, cancelled = queue.Queue(), set()
+    threading.Thread(target=read_requests, args=(reader, requests, cancelled), daemon=True).start()
+
+    frozen = False
+    while True:
+        message = requests.get()
+        if message is None:
+            return
+        header, payload = message
+        method = header["method"]
+
+        if frozen:
+            # stop responding as if stuck
+            time.sleep(3600)
+        if method == "ping":
+            write_message(writer, {"result": {}})
+        elif method == "embed":
+            write_message(writer, {"result": {}}, payload[::-1])
+        elif method == "sleep":
+            # sleep for the given seconds unless cancelled
+            deadline = time.time() + header["params"]["seconds"]
+            while time.time() < deadline and header["id"] not in cancelled:
+                time.sleep(0.01)
+            if header["id"] in cancelled:
+                write_message(writer, {"error": "cancelled", "cancelled": True})
+            else:
+                write_message(writer, {"result": {}})
+        elif method == "freeze":
+            frozen = True
+            write_message(writer, {"result": {}})
+        elif method == "crash":
+            os._exit(3)
+        else:
+            write_message(writer, {"error": f"unknown method {method}"})
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,385 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/propagation"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"nutsh/module/common"
+)
+
+// A worker is a Python process running a model on a device, which reads requests from its stdin and writes responses
+// to its stdout one at a time. Each request or response is a message of a JSON header followed by a binary payload,
+// both prefixed with their length in a 4-byte big-endian integer.
+
+const (
+	workerStarting   = "starting"
+	workerReady      = "ready"
+	workerRestarting = "restarting"
+)
+
+// Messages larger than this are rejected as the stream must have been corrupted.
+const maxWorkerMessageSize = 1 << 30
+
+type workerRequest struct {
+	// the id of the request to refer to when cancelling it
+	Id string `json:"id,omitempty"`
+
+	Method string                 `json:"method"`
+	Params map[string]interface{} `json:"params,omitempty"`
+
+	// the W3C trace context, logged by the worker to correlate with traces
+	Traceparent string `json:"traceparent,omitempty"`
+}
+
+type workerResponse struct {
+	Result json.RawMessage `json:"result,omitempty"`
+
+	// the error of handling the request, which leaves the worker running
+	Error string `json:"error,omitempty"`
+
+	// whether the request is stopped as it is cancelled while running
+	Cancelled bool `json:"cancelled,omitempty"`
+}
+
+// A request running on a worker is cancelled by sending a `cancel` request with the id of the running one in its
+// params, which does not have any response of its own. The worker checks for cancellation between steps, and then
+// responds to the running request with an error.
+const workerCancelMethod = "cancel"
+
+type worker struct {
+	name   string
+	device string
+	python string
+	args   []string
+
+	// the backoff before restarting a failed worker, doubled on each failure before the worker gets ready
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	// idle workers are pinged periodically, and restarted if they fail to respond in time
+	pingInterval time.Duration
+	pingTimeout  time.Duration
+
+	state    atomic.Value
+	restarts atomic.Int32
+
+	// called whenever the state changes if set before running
+	onState func()
+
+	busy      atomic.Bool
+	served    atomic.Uint64
+	cancelled atomic.Uint64
+}
+
+func newWorker(name string, python string, args ...string) *worker {
+	w := &worker{
+		name:         name,
+		python:       python,
+		args:         args,
+		minBackoff:   time.Second,
+		maxBackoff:   time.Minute,
+		pingInterval: 30 * time.Second,
+		pingTimeout:  10 * time.Second,
+	}
+	w.state.Store(workerStarting)
+	return w
+}
+
+func (w *worker) setState(state string) {
+	w.state.Store(state)
+	if w.onState != nil {
+		w.onState()
+	}
+}
+
+// run keeps the worker serving requests from the queue, restarting it whenever it fails, until the context is done.
+func (w *worker) run(ctx context.Context, queue *requestQueue) {
+	logger := zap.L().With(zap.String("worker", w.name))
+	backoff := w.minBackoff
+	for {
+		ready, err := w.serve(ctx, queue)
+		if ctx.Err() != nil {
+			return
+		}
+		if ready {
+			backoff = w.minBackoff
+		}
+		w.setState(workerRestarting)
+		w.restarts.Add(1)
+		logger.Error("worker failed, restarting", zap.Duration("backoff", backoff), zap.Error(err))
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+		if backoff > w.maxBackoff {
+			backoff = w.maxBackoff
+		}
+	}
+}
+
+// serve starts the worker process and serves requests until it fails, and reports whether it has got ready.
+func (w *worker) serve(ctx context.Context, queue *requestQueue) (bool, error) {
+	logger := zap.L().With(zap.String("worker", w.name))
+	// the process is killed once the context is done
+	p, err := startWorkerProcess(ctx, w.name, w.python, w.args...)
+	if err != nil {
+		return false, err
+	}
+	defer p.kill()
+	logger.Info("started worker", zap.Int("pid", p.proc.Pid()))
+
+	// the worker responds to the first ping once the model is loaded
+	if _, _, err := p.call(ctx, &workerRequest{Method: "ping"}, nil, 0); err != nil {
+		return false, p.exitError(err)
+	}
+	w.setState(workerReady)
+	logger.Info("worker is ready")
+
+	ticker := time.NewTicker(w.pingInterval)
+	defer ticker.Stop()
+	for {
+		if req := queue.pop(); req != nil {
+			if err := w.handle(p, req); err != nil {
+				return true, err
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case <-ticker.C:
+			if _, _, err := p.call(ctx, &workerRequest{Method: "ping"}, nil, w.pingTimeout); err != nil {
+				return true, errors.Wrap(p.exitError(err), "health check failed")
+			}
+		case <-queue.wake:
+		}
+	}
+}
+
+// handle runs a request on the process and replies to it, and fails only if the process fails.
+func (w *worker) handle(p *workerProcess, req *mWorkerRequest) error {
+	req.pickedUp(w.name)
+	if err := req.Context.Err(); err != nil {
+		req.RespChan <- &mWorkerResponse{Error: err}
+		return nil
+	}
+
+	w.busy.Store(true)
+	defer w.busy.Store(false)
+	defer w.served.Add(1)
+
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(req.Context, carrier)
+	resp, payload, err := p.call(req.Context, &workerRequest{
+		Id:          req.Uuid,
+		Method:      req.Method,
+		Params:      req.Params,
+		Traceparent: carrier.Get("traceparent"),
+	}, req.Payload, 0)
+	if err != nil {
+		err = p.exitError(err)
+		req.RespChan <- &mWorkerResponse{Error: errors.Wrap(err, "worker failed")}
+		return err
+	}
+	if resp.Cancelled {
+		w.cancelled.Add(1)
+		zap.L().Info("cancelled running request", zap.String("uuid", req.Uuid), zap.String("worker", w.name))
+		req.RespChan <- &mWorkerResponse{Error: status.Error(codes.Canceled, resp.Error)}
+		return nil
+	}
+	if resp.Error != "" {
+		req.RespChan <- &mWorkerResponse{Error: errors.New(resp.Error)}
+		return nil
+	}
+	req.RespChan <- &mWorkerResponse{Result: resp.Result, Payload: payload}
+	return nil
+}
+
+// the number of trailing bytes of stderr explaining the exit of a worker
+const workerStderrLimit = 4 * 1024
+
+type workerProcess struct {
+	proc   *common.Process
+	stdin  *os.File
+	stdout *os.File
+	reader *bufio.Reader
+}
+
+func startWorkerProcess(ctx context.Context, name string, python string, args ...string) (*workerProcess, error) {
+	// pipes are created explicitly, since those created by the command are closed once it exits, which races with
+	// reading the last response
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	c := &common.Command{
+		Bin:    python,
+		Args:   args,
+		Name:   name,
+		Stdin:  stdinR,
+		Stdout: stdoutW,
+
+		// the whole stderr is logged anyway
+		StderrLimit: workerStderrLimit,
+	}
+	proc, err := c.Start(ctx)
+	stdinR.Close()
+	stdoutW.Close()
+	if err != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		return nil, err
+	}
+
+	return &workerProcess{
+		proc:   proc,
+		stdin:  stdinW,
+		stdout: stdoutR,
+		reader: bufio.NewReader(stdoutR),
+	}, nil
+}
+
+func (p *workerProcess) kill() {
+	p.proc.Kill()
+	p.proc.Wait()
+	p.stdin.Close()
+	p.stdout.Close()
+}
+
+// exitError explains a failure of communication by the exit of the process if it has exited, along with the tail of
+// its stderr.
+func (p *workerProcess) exitError(err error) error {
+	select {
+	case <-p.proc.Done():
+		if err := p.proc.Wait(); err != nil {
+			return errors.Wrapf(err, "worker exited")
+		}
+		return errors.New("worker exited")
+	case <-time.After(100 * time.Millisecond):
+		return err
+	}
+}
+
+// call sends a request and waits for its response, failing if it takes longer than the timeout unless it is zero. If
+// the request has an id, it is cancelled once the context is done, while the response is still waited for. The process
+// should not be used anymore once the call fails.
+func (p *workerProcess) call(ctx context.Context, req *workerRequest, payload []byte, timeout time.Duration) (*workerResponse, []byte, error) {
+	header, err := json.Marshal(req)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	if err := writeWorkerMessage(p.stdin, header, payload); err != nil {
+		return nil, nil, err
+	}
+
+	if req.Id != "" {
+		// the response is read only after the cancellation is written, if any, which is thus the only other write
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			select {
+			case <-ctx.Done():
+				p.cancel(req.Id)
+			case <-done:
+			}
+		}()
+		defer func() {
+			close(done)
+			<-stopped
+		}()
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := p.stdout.SetReadDeadline(deadline); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	header, payload, err = readWorkerMessage(p.reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var resp workerResponse
+	if err := json.Unmarshal(header, &resp); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return &resp, payload, nil
+}
+
+func (p *workerProcess) cancel(id string) {
+	header, err := json.Marshal(&workerRequest{
+		Method: workerCancelMethod,
+		Params: map[string]interface{}{"id": id},
+	})
+	if err == nil {
+		err = writeWorkerMessage(p.stdin, header, nil)
+	}
+	if err != nil {
+		// the failure of the process will be noticed by reading the response
+		zap.L().Warn("failed to cancel worker request", zap.String("uuid", id), zap.Error(err))
+	}
+}
+
+func writeWorkerMessage(w io.Writer, header []byte, payload []byte) error {
+	buf := make([]byte, 0, 8+len(header)+len(payload))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(header)))
+	buf = append(buf, header...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = append(buf, payload...)
+	_, err := w.Write(buf)
+	return errors.WithStack(err)
+}
+
+func readWorkerMessage(r io.Reader) ([]byte, []byte, error) {
+	header, err := readWorkerFrame(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	payload, err := readWorkerFrame(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, payload, nil
+}
+
+func readWorkerFrame(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxWorkerMessageSize {
+		return nil, errors.Errorf("worker message of %d bytes is too large", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return data, nil
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// requireFakeWorker runs a worker of `testdata/fake_worker.py` serving the returned model until the test ends.
+func requireFakeWorker(t *testing.T) (*mModel, *worker) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+
+	m := &mModel{
+		config: ModelConfig{Name: "fake"},
+		queue:  newRequestQueue(0, 0),
+	}
+	w := newWorker("fake", python, "testdata/fake_worker.py")
+	w.minBackoff = 10 * time.Millisecond
+	w.maxBackoff = 10 * time.Millisecond
+	w.pingInterval = 50 * time.Millisecond
+	w.pingTimeout = 200 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.run(ctx, m.queue)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+	return m, w
+}
+
+func TestWorker(t *testing.T) {
+	ctx := context.Background()
+	m, w := requireFakeWorker(t)
+
+	_, output, err := m.sendRequest(ctx, "embed", nil, []byte("image"), false)
+	require.NoError(t, err)
+	require.Equal(t, []byte("egami"), output)
+	require.Equal(t, workerReady, w.state.Load())
+
+	// errors of requests leave the worker running
+	_, _, err = m.sendRequest(ctx, "unknown", nil, nil, false)
+	require.EqualError(t, err, "unknown method unknown")
+	_, _, err = m.sendRequest(ctx, "embed", nil, []byte("image"), false)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, w.restarts.Load())
+}
+
+func TestWorkerRestart(t *testing.T) {
+	ctx := context.Background()
+	m, w := requireFakeWorker(t)
+
+	_, _, err := m.sendRequest(ctx, "crash", nil, nil, false)
+	require.ErrorContains(t, err, "worker exited")
+
+	// the request waits for the worker to restart
+	_, output, err := m.sendRequest(ctx, "embed", nil, []byte("image"), false)
+	require.NoError(t, err)
+	require.Equal(t, []byte("egami"), output)
+	require.EqualValues(t, 1, w.restarts.Load())
+}
+
+func TestWorkerHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	m, w := requireFakeWorker(t)
+
+	_, _, err := m.sendRequest(ctx, "freeze", nil, nil, false)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return w.restarts.Load() > 0 }, 5*time.Second, 10*time.Millisecond)
+
+	_, output, err := m.sendRequest(ctx, "embed", nil, []byte("image"), false)
+	require.NoError(t, err)
+	require.Equal(t, []byte("egami"), output)
+}
+
+func TestWorkerCancel(t *testing.T) {
+	m, w := requireFakeWorker(t)
+	require.Eventually(t, func() bool { return w.state.Load() == workerReady }, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err := m.sendRequest(ctx, "sleep", map[string]interface{}{"seconds": 10}, nil, false)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the running request is stopped rather than keeping the worker busy
+	require.Eventually(t, func() bool { return w.cancelled.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	_, output, err := m.sendRequest(context.Background(), "embed", nil, []byte("image"), false)
+	require.NoError(t, err)
+	require.Equal(t, []byte("egami"), output)
+	require.EqualValues(t, 2, w.served.Load())
+	require.EqualValues(t, 0, w.restarts.Load())
+}
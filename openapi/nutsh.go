@@ -113,6 +113,15 @@ func NewNutsh() *openapi3.T {
 				},
 			},
 			"/project/{projectId}/samples": &openapi3.PathItem{
+				Get: &openapi3.Operation{
+					OperationID: "ListProjectSamples",
+					Parameters: openapi3.Parameters{
+						builder.ParameterRef("projectId"),
+					},
+					Responses: openapi3.Responses{
+						"200": builder.OK("ListProjectSamplesResp"),
+					},
+				},
 				Post: &openapi3.Operation{
 					OperationID: "CreateProjectSample",
 					Parameters: openapi3.Parameters{
@@ -125,6 +134,145 @@ func NewNutsh() *openapi3.T {
 				},
 			},
 
+			"/project/{projectId}/sample/{sampleId}": &openapi3.PathItem{
+				Get: &openapi3.Operation{
+					OperationID: "GetProjectSample",
+					Parameters: openapi3.Parameters{
+						builder.ParameterRef("projectId"),
+						builder.ParameterRef("sampleId"),
+					},
+					Responses: openapi3.Responses{
+						"200": builder.OK("GetProjectSampleResp"),
+						"404": builder.NotFound(),
+					},
+				},
+				Delete: &openapi3.Operation{
+					OperationID: "DeleteProjectSample",
+					Parameters: openapi3.Parameters{
+						builder.ParameterRef("projectId"),
+						builder.ParameterRef("sampleId"),
+					},
+					Responses: openapi3.Responses{
+						"200": builder.OK("DeleteProjectSampleResp"),
+						"404": builder.NotFound(),
+					},
+				},
+			},
+
+			"/project/{projectId}/webhooks": &openapi3.PathItem{
+				Get: &openapi3.Operation{
+					OperationID: "ListProjectWebhooks",
+					Parameters: openapi3.Parameters{
+						builder.ParameterRef("projectId"),
+					},
+					Responses: openapi3.Responses{
+						"200": builder.OK("ListProjectWebhooksResp"),
+					},
+				},
+				Post: &openapi3.Operation{
+					OperationID: "CreateProjectWebhook",
+					Parameters: openapi3.Parameters{
+						builder.ParameterRef("projectId"),
+					},
+					RequestBody: builder.Request("CreateProjectWebhookReq"),
+					Responses: openapi3.Responses{
+						"200": builder.OK("CreateProjectWebhookResp"),
+						"400": builder.BadRequest(),
+						"404": builder.NotFound(),
+					},
+				},
+			},
+
+			"/project/{projectId}/precompute_jobs": &openapi3.PathItem{
+				Get: &openapi3.Operation{
+					OperationID: "ListProjectPrecomputeJobs",
+					Parameters: openapi3.Parameters{
+						builder.ParameterRef("projectId"),
+					},
+					Responses: openapi3.Responses{
+						"200": builder.OK("ListProjectPrecomputeJobsResp"),
+					},
+				},
+				Post: &openapi3.Operation{
+					OperationID: "CreateProjectPrecomputeJob",
+					Parameters: openapi3.Parameters{
+						builder.ParameterRef("projectId"),
+					},
+					RequestBody: builder.Request("CreateProjectPrecomputeJobReq"),
+					Responses: openapi3.Responses{
+						"200": builder.OK("CreateProjectPrecomputeJobResp"),
+						"400": builder.BadRequest(),
+						"404": builder.NotFound(),
+					},
+				},
+			},
+
+			// Precompute job
+
+			"/precompute_job/{precomputeJobId}": &openapi3.PathItem{
+				Get: &openapi3.Operation{
+					OperationID: "GetPrecomputeJob",
+					Parameters: openapi3.Parameters{
+						builder.ParameterRef("precomputeJobId"),
+					},
+					Responses: openapi3.Responses{
+						"200": builder.OK("GetPrecomputeJobResp"),
+						"404": builder.NotFound(),
+					},
+				},
+			},
+			"/precompute_job/{precomputeJobId}/_cancel": &openapi3.PathItem{
+				Post: &openapi3.Operation{
+					OperationID: "CancelPrecomputeJob",
+					Parameters: openapi3.Parameters{
+						builder.ParameterRef("precomputeJobId"),
+					},
+					Responses: openapi3.Responses{
+						"200": builder.OK("CancelPrecomputeJobResp"),
+						"400": builder.BadRequest(),
+						"404": builder.NotFound(),
+					},
+				},
+			},
+
+			// Webhook
+
+			"/webhook/{webhookId}": &openapi3.PathItem{
+				Delete: &openapi3.Operation{
+					OperationID: "DeleteWebhook",
+					Parameters: openapi3.Parameters{
+						builder.ParameterRef("webhookId"),
+					},
+					Responses: openapi3.Responses{
+						"200": builder.OK("DeleteWebhookResp"),
+						"404": builder.NotFound(),
+					},
+				},
+			},
+			"/webhook/{webhookId}/deliveries": &openapi3.PathItem{
+				Get: &openapi3.Operation{
+					OperationID: "ListWebhookDeliveries",
+					Parameters: openapi3.Parameters{
+						builder.ParameterRef("webhookId"),
+					},
+					Responses: openapi3.Responses{
+						"200": builder.OK("ListWebhookDeliveriesResp"),
+					},
+				},
+			},
+			"/webhook/{webhookId}/_test": &openapi3.PathItem{
+				Post: &openapi3.Operation{
+					OperationID: "TestWebhook",
+					Parameters: openapi3.Parameters{
+						builder.ParameterRef("webhookId"),
+					},
+					Responses: openapi3.Responses{
+						"200": builder.OK("TestWebhookResp"),
+						"404": builder.NotFound(),
+					},
+				},
+			},
+
 			// Video
 
 			"/videos": &openapi3.PathItem{
@@ -243,6 +391,42 @@ func NewNutsh() *openapi3.T {
 				},
 			},
 
+			"/online_segmentation/_segment": &openapi3.PathItem{
+				Post: &openapi3.Operation{
+					OperationID: "SegmentImage",
+					RequestBody: builder.Request("SegmentImageReq"),
+					Responses: openapi3.Responses{
+						"200": builder.OK("SegmentImageResp"),
+						"400": builder.BadRequest(),
+						"429": &openapi3.ResponseRef{Value: openapi3.NewResponse()},
+					},
+				},
+			},
+
+			"/online_segmentation/_prefetch": &openapi3.PathItem{
+				Post: &openapi3.Operation{
+					OperationID: "PrefetchOnlineSegmentationEmbeddings",
+					RequestBody: builder.Request("PrefetchOnlineSegmentationEmbeddingsReq"),
+					Responses: openapi3.Responses{
+						"200": builder.OK("PrefetchOnlineSegmentationEmbeddingsResp"),
+						"400": builder.BadRequest(),
+						"404": builder.NotFound(),
+					},
+				},
+			},
+			"/online_segmentation/job/{jobId}": &openapi3.PathItem{
+				Get: &openapi3.Operation{
+					OperationID: "GetOnlineSegmentationJob",
+					Parameters: openapi3.Parameters{
+						builder.ParameterRef("jobId"),
+					},
+					Responses: openapi3.Responses{
+						"200": builder.OK("GetOnlineSegmentationJobResp"),
+						"404": builder.NotFound(),
+					},
+				},
+			},
+
 			// Track
 			"/track": &openapi3.PathItem{
 				Post: &openapi3.Operation{
@@ -250,6 +434,7 @@ func NewNutsh() *openapi3.T {
 					RequestBody: builder.Request("TrackReq"),
 					Responses: openapi3.Responses{
 						"200": builder.OK("TrackResp"),
+						"400": builder.BadRequest(),
 					},
 				},
 			},
@@ -272,6 +457,38 @@ func NewNutsh() *openapi3.T {
 						Schema:   builder.PrimitiveSchemaRef(builder.IdType),
 					},
 				},
+				"jobId": &openapi3.ParameterRef{
+					Value: &openapi3.Parameter{
+						Name:     "jobId",
+						In:       openapi3.ParameterInPath,
+						Required: true,
+						Schema:   builder.PrimitiveSchemaRef(builder.IdType),
+					},
+				},
+				"sampleId": &openapi3.ParameterRef{
+					Value: &openapi3.Parameter{
+						Name:     "sampleId",
+						In:       openapi3.ParameterInPath,
+						Required: true,
+						Schema:   builder.PrimitiveSchemaRef(builder.IdType),
+					},
+				},
+				"precomputeJobId": &openapi3.ParameterRef{
+					Value: &openapi3.Parameter{
+						Name:     "precomputeJobId",
+						In:       openapi3.ParameterInPath,
+						Required: true,
+						Schema:   builder.PrimitiveSchemaRef(builder.IdType),
+					},
+				},
+				"webhookId": &openapi3.ParameterRef{
+					Value: &openapi3.Parameter{
+						Name:     "webhookId",
+						In:       openapi3.ParameterInPath,
+						Required: true,
+						Schema:   builder.PrimitiveSchemaRef(builder.IdType),
+					},
+				},
 			},
 			Schemas: openapi3.Schemas{
 				// Config
@@ -284,6 +501,37 @@ func NewNutsh() *openapi3.T {
 							"readonly":                    builder.PrimitiveSchemaRef(openapi3.TypeBoolean),
 							"online_segmentation_enabled": builder.PrimitiveSchemaRef(openapi3.TypeBoolean),
 							"track_enabled":               builder.PrimitiveSchemaRef(openapi3.TypeBoolean),
+							"yjs_status":                  builder.SchemaRef("ProcessStatus"),
+							"grpc_connections": &openapi3.SchemaRef{
+								Value: &openapi3.Schema{
+									Type:  openapi3.TypeArray,
+									Items: builder.SchemaRef("GrpcConnection"),
+								},
+							},
+						},
+					},
+				},
+
+				"GrpcConnection": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"name", "state"},
+						Properties: openapi3.Schemas{
+							"name":  builder.PrimitiveSchemaRef(openapi3.TypeString),
+							"state": builder.PrimitiveSchemaRef(openapi3.TypeString),
+						},
+					},
+				},
+
+				"ProcessStatus": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"state", "healthy", "restarts"},
+						Properties: openapi3.Schemas{
+							"state":    builder.PrimitiveSchemaRef(openapi3.TypeString),
+							"healthy":  builder.PrimitiveSchemaRef(openapi3.TypeBoolean),
+							"restarts": builder.PrimitiveSchemaRef(openapi3.TypeInteger),
+							"error":    builder.PrimitiveSchemaRef(openapi3.TypeString),
 						},
 					},
 				},
@@ -501,6 +749,62 @@ func NewNutsh() *openapi3.T {
 					},
 				},
 
+				"Sample": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"id", "image_url", "sample_json", "create_time"},
+						Properties: openapi3.Schemas{
+							"id":           builder.PrimitiveSchemaRef(builder.IdType),
+							"image_url":    builder.PrimitiveSchemaRef(openapi3.TypeString),
+							"segmentation": builder.SchemaRef("SegmentationSample"),
+							"sample_json":  builder.PrimitiveSchemaRef(openapi3.TypeString, builder.WithSchemaRefDescription("serialized schema.v1.Sample proto as created")),
+							"create_time":  builder.PrimitiveSchemaRef(openapi3.TypeString),
+						},
+					},
+				},
+
+				"SegmentationSample": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"point_prompts", "mask"},
+						Properties: openapi3.Schemas{
+							"crop":          builder.SchemaRef("GridRect"),
+							"point_prompts": builder.ArraySchemaRef("PointPrompt"),
+							"mask":          builder.SchemaRef("Mask"),
+						},
+					},
+				},
+
+				"ListProjectSamplesResp": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"samples"},
+						Properties: openapi3.Schemas{
+							"samples": builder.ArraySchemaRef("Sample"),
+						},
+					},
+				},
+
+				"GetProjectSampleResp": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"sample"},
+						Properties: openapi3.Schemas{
+							"sample": builder.SchemaRef("Sample"),
+						},
+					},
+				},
+
+				"DeleteProjectSampleResp": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"sample"},
+						Properties: openapi3.Schemas{
+							"sample": builder.SchemaRef("Sample"),
+						},
+					},
+				},
+
 				// Video
 
 				"Video": &openapi3.SchemaRef{
@@ -633,6 +937,118 @@ func NewNutsh() *openapi3.T {
 					},
 				},
 
+				// Webhook
+
+				"Webhook": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"id", "project_id", "url", "events"},
+						Properties: openapi3.Schemas{
+							"id":         builder.PrimitiveSchemaRef(builder.IdType),
+							"project_id": builder.PrimitiveSchemaRef(builder.IdType),
+							"url":        builder.PrimitiveSchemaRef(openapi3.TypeString),
+							"events": &openapi3.SchemaRef{
+								Value: &openapi3.Schema{
+									Type:        openapi3.TypeArray,
+									Items:       builder.PrimitiveSchemaRef(openapi3.TypeString),
+									Description: "Subscribed events, e.g. `video.created`, `video.deleted`, `annotation.updated` and `project.exported`.",
+								},
+							},
+						},
+					},
+				},
+
+				"WebhookDelivery": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"id", "webhook_id", "event", "payload", "attempts", "succeeded", "create_time"},
+						Properties: openapi3.Schemas{
+							"id":         builder.PrimitiveSchemaRef(builder.IdType),
+							"webhook_id": builder.PrimitiveSchemaRef(builder.IdType),
+							"event":      builder.PrimitiveSchemaRef(openapi3.TypeString),
+							"payload": builder.PrimitiveSchemaRef(
+								openapi3.TypeString,
+								builder.WithSchemaRefDescription("The serialized Json body sent to the webhook."),
+							),
+							"attempts":    builder.PrimitiveSchemaRef(openapi3.TypeInteger),
+							"succeeded":   builder.PrimitiveSchemaRef(openapi3.TypeBoolean),
+							"status_code": builder.PrimitiveSchemaRef(openapi3.TypeInteger),
+							"error":       builder.PrimitiveSchemaRef(openapi3.TypeString),
+							"create_time": builder.PrimitiveSchemaRef(openapi3.TypeString),
+						},
+					},
+				},
+
+				"ListProjectWebhooksResp": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"webhooks"},
+						Properties: openapi3.Schemas{
+							"webhooks": builder.ArraySchemaRef("Webhook"),
+						},
+					},
+				},
+
+				"CreateProjectWebhookReq": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"url", "events"},
+						Properties: openapi3.Schemas{
+							"url": builder.PrimitiveSchemaRef(openapi3.TypeString),
+							"secret": builder.PrimitiveSchemaRef(
+								openapi3.TypeString,
+								builder.WithSchemaRefDescription("Key to sign payloads with HMAC-SHA256. It is never returned."),
+							),
+							"events": &openapi3.SchemaRef{
+								Value: &openapi3.Schema{
+									Type:  openapi3.TypeArray,
+									Items: builder.PrimitiveSchemaRef(openapi3.TypeString),
+								},
+							},
+						},
+					},
+				},
+
+				"CreateProjectWebhookResp": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"webhook"},
+						Properties: openapi3.Schemas{
+							"webhook": builder.SchemaRef("Webhook"),
+						},
+					},
+				},
+
+				"DeleteWebhookResp": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"webhook"},
+						Properties: openapi3.Schemas{
+							"webhook": builder.SchemaRef("Webhook"),
+						},
+					},
+				},
+
+				"ListWebhookDeliveriesResp": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"deliveries"},
+						Properties: openapi3.Schemas{
+							"deliveries": builder.ArraySchemaRef("WebhookDelivery"),
+						},
+					},
+				},
+
+				"TestWebhookResp": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"delivery"},
+						Properties: openapi3.Schemas{
+							"delivery": builder.SchemaRef("WebhookDelivery"),
+						},
+					},
+				},
+
 				// Online segmentation
 
 				"OnlineSegmentationDecoder": &openapi3.SchemaRef{
@@ -643,6 +1059,8 @@ func NewNutsh() *openapi3.T {
 							"uuid":    builder.PrimitiveSchemaRef(openapi3.TypeString),
 							"url":     builder.PrimitiveSchemaRef(openapi3.TypeString),
 							"feed_js": builder.PrimitiveSchemaRef(openapi3.TypeString),
+							// a human-readable name of the model, if provided by the server
+							"name": builder.PrimitiveSchemaRef(openapi3.TypeString),
 						},
 					},
 				},
@@ -653,6 +1071,13 @@ func NewNutsh() *openapi3.T {
 						Properties: openapi3.Schemas{
 							// online segmentation is disabled when this field is missing
 							"decoder": builder.SchemaRef("OnlineSegmentationDecoder"),
+							// all decoders served, the first of which is the default one above
+							"decoders": &openapi3.SchemaRef{
+								Value: &openapi3.Schema{
+									Type:  openapi3.TypeArray,
+									Items: builder.SchemaRef("OnlineSegmentationDecoder"),
+								},
+							},
 						},
 					},
 				},
@@ -667,12 +1092,200 @@ func NewNutsh() *openapi3.T {
 					},
 				},
 
+				"SegmentImageReq": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"image_url", "decoder_uuid", "point_prompts"},
+						Properties: openapi3.Schemas{
+							"image_url":     builder.PrimitiveSchemaRef(openapi3.TypeString),
+							"decoder_uuid":  builder.PrimitiveSchemaRef(openapi3.TypeString),
+							"point_prompts": builder.ArraySchemaRef("PointPrompt"),
+							"box":           builder.SchemaRef("GridRect"),
+							"crop":          builder.SchemaRef("GridRect"),
+						},
+					},
+				},
+
+				"SegmentImageResp": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"mask", "score"},
+						Properties: openapi3.Schemas{
+							"mask": builder.SchemaRef("Mask"),
+							"score": builder.PrimitiveSchemaRef(
+								openapi3.TypeNumber,
+								builder.WithSchemaRefDescription("The predicted IoU of the mask."),
+							),
+						},
+					},
+				},
+
+				"PointPrompt": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"x", "y", "is_positive"},
+						Properties: openapi3.Schemas{
+							"x":           builder.PrimitiveSchemaRef(openapi3.TypeNumber),
+							"y":           builder.PrimitiveSchemaRef(openapi3.TypeNumber),
+							"is_positive": builder.PrimitiveSchemaRef(openapi3.TypeBoolean),
+						},
+					},
+				},
+
+				"PrefetchOnlineSegmentationEmbeddingsReq": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"video_id", "decoder_uuid"},
+						Properties: openapi3.Schemas{
+							"video_id":     builder.PrimitiveSchemaRef(builder.IdType),
+							"decoder_uuid": builder.PrimitiveSchemaRef(openapi3.TypeString),
+							"start_frame": builder.PrimitiveSchemaRef(
+								openapi3.TypeInteger,
+								builder.WithSchemaRefDescription("The first frame to prefetch, which defaults to the first frame of the video."),
+							),
+							"end_frame": builder.PrimitiveSchemaRef(
+								openapi3.TypeInteger,
+								builder.WithSchemaRefDescription("The frame after the last one to prefetch, which defaults to the end of the video."),
+							),
+						},
+					},
+				},
+
+				"PrefetchOnlineSegmentationEmbeddingsResp": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"job"},
+						Properties: openapi3.Schemas{
+							"job": builder.SchemaRef("OnlineSegmentationJob"),
+						},
+					},
+				},
+
+				"GetOnlineSegmentationJobResp": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"job"},
+						Properties: openapi3.Schemas{
+							"job": builder.SchemaRef("OnlineSegmentationJob"),
+						},
+					},
+				},
+
+				"OnlineSegmentationJob": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"id", "video_id", "decoder_uuid", "state", "frames"},
+						Properties: openapi3.Schemas{
+							"id":           builder.PrimitiveSchemaRef(builder.IdType),
+							"video_id":     builder.PrimitiveSchemaRef(builder.IdType),
+							"decoder_uuid": builder.PrimitiveSchemaRef(openapi3.TypeString),
+							"state": builder.PrimitiveSchemaRef(
+								openapi3.TypeString,
+								builder.WithSchemaRefDescription("One of `running`, `succeeded` and `failed`, the last of which means some frames failed."),
+							),
+							"frames": builder.ArraySchemaRef("OnlineSegmentationJobFrame"),
+						},
+					},
+				},
+
+				"PrecomputeJob": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"id", "project_id", "decoder_uuid", "state", "total_frames", "ready_frames", "failed_frames", "create_time"},
+						Properties: openapi3.Schemas{
+							"id":           builder.PrimitiveSchemaRef(builder.IdType),
+							"project_id":   builder.PrimitiveSchemaRef(builder.IdType),
+							"decoder_uuid": builder.PrimitiveSchemaRef(openapi3.TypeString),
+							"state": builder.PrimitiveSchemaRef(
+								openapi3.TypeString,
+								builder.WithSchemaRefDescription("One of `running`, `succeeded`, `failed` and `cancelled`, where `failed` means some frames failed."),
+							),
+							"total_frames":  builder.PrimitiveSchemaRef(openapi3.TypeInteger),
+							"ready_frames":  builder.PrimitiveSchemaRef(openapi3.TypeInteger),
+							"failed_frames": builder.PrimitiveSchemaRef(openapi3.TypeInteger),
+							"error": builder.PrimitiveSchemaRef(
+								openapi3.TypeString,
+								builder.WithSchemaRefDescription("The last error of failed frames."),
+							),
+							"create_time": builder.PrimitiveSchemaRef(openapi3.TypeString),
+						},
+					},
+				},
+
+				"CreateProjectPrecomputeJobReq": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"decoder_uuid"},
+						Properties: openapi3.Schemas{
+							"decoder_uuid": builder.PrimitiveSchemaRef(openapi3.TypeString),
+						},
+					},
+				},
+
+				"CreateProjectPrecomputeJobResp": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"job"},
+						Properties: openapi3.Schemas{
+							"job": builder.SchemaRef("PrecomputeJob"),
+						},
+					},
+				},
+
+				"ListProjectPrecomputeJobsResp": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"jobs"},
+						Properties: openapi3.Schemas{
+							"jobs": builder.ArraySchemaRef("PrecomputeJob"),
+						},
+					},
+				},
+
+				"GetPrecomputeJobResp": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"job"},
+						Properties: openapi3.Schemas{
+							"job": builder.SchemaRef("PrecomputeJob"),
+						},
+					},
+				},
+
+				"CancelPrecomputeJobResp": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"job"},
+						Properties: openapi3.Schemas{
+							"job": builder.SchemaRef("PrecomputeJob"),
+						},
+					},
+				},
+
+				"OnlineSegmentationJobFrame": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"index", "state"},
+						Properties: openapi3.Schemas{
+							"index": builder.PrimitiveSchemaRef(openapi3.TypeInteger),
+							"state": builder.PrimitiveSchemaRef(
+								openapi3.TypeString,
+								builder.WithSchemaRefDescription("One of `pending`, `ready` and `failed`."),
+							),
+							"embedding_url": builder.PrimitiveSchemaRef(openapi3.TypeString),
+							"error":         builder.PrimitiveSchemaRef(openapi3.TypeString),
+						},
+					},
+				},
+
 				// Track
 
 				"TrackReq": &openapi3.SchemaRef{
 					Value: &openapi3.Schema{
-						Type:     openapi3.TypeObject,
-						Required: []string{"first_frame_url", "subsequent_frame_urls", "first_frame_mask"},
+						Type: openapi3.TypeObject,
+						Description: "Either tracks a single mask forward from the first frame with `first_frame_url`, `first_frame_mask` " +
+							"and `subsequent_frame_urls`, or tracks the masks of any number of entities over a range of frames from a " +
+							"keyframe within it with `frame_urls`, `keyframe_index`, `keyframe_masks` and `direction`.",
 						Properties: openapi3.Schemas{
 							"first_frame_url": builder.PrimitiveSchemaRef(openapi3.TypeString),
 							"subsequent_frame_urls": &openapi3.SchemaRef{
@@ -682,6 +1295,21 @@ func NewNutsh() *openapi3.T {
 								},
 							},
 							"first_frame_mask": builder.SchemaRef("Mask"),
+							"frame_urls": &openapi3.SchemaRef{
+								Value: &openapi3.Schema{
+									Type:  openapi3.TypeArray,
+									Items: builder.PrimitiveSchemaRef(openapi3.TypeString),
+								},
+							},
+							"keyframe_index": builder.PrimitiveSchemaRef(
+								openapi3.TypeInteger,
+								builder.WithSchemaRefDescription("The index of the keyframe in `frame_urls`, which defaults to 0."),
+							),
+							"keyframe_masks": builder.ArraySchemaRef("EntityMask"),
+							"direction": builder.PrimitiveSchemaRef(
+								openapi3.TypeString,
+								builder.WithSchemaRefDescription("One of `forward`, `backward` and `both`, which defaults to `forward`."),
+							),
 						},
 					},
 				},
@@ -689,14 +1317,51 @@ func NewNutsh() *openapi3.T {
 				"TrackResp": &openapi3.SchemaRef{
 					Value: &openapi3.Schema{
 						Type:     openapi3.TypeObject,
-						Required: []string{"subsequent_frame_masks"},
+						Required: []string{"subsequent_frame_masks", "frame_masks"},
 						Properties: openapi3.Schemas{
 							"subsequent_frame_masks": &openapi3.SchemaRef{
 								Value: &openapi3.Schema{
-									Type:  openapi3.TypeArray,
-									Items: builder.SchemaRef("Mask"),
+									Type:        openapi3.TypeArray,
+									Items:       builder.SchemaRef("Mask"),
+									Description: "The masks of the subsequent frames in order, if tracking from the first frame.",
 								},
 							},
+							"frame_masks": &openapi3.SchemaRef{
+								Value: &openapi3.Schema{
+									Type:        openapi3.TypeArray,
+									Items:       builder.SchemaRef("FrameMask"),
+									Description: "The masks of each entity on each tracked frame, if tracking over a range of frames.",
+								},
+							},
+						},
+					},
+				},
+
+				"EntityMask": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"entity_id", "mask"},
+						Properties: openapi3.Schemas{
+							"entity_id": builder.PrimitiveSchemaRef(openapi3.TypeString),
+							"mask":      builder.SchemaRef("Mask"),
+						},
+					},
+				},
+
+				"FrameMask": &openapi3.SchemaRef{
+					Value: &openapi3.Schema{
+						Type:     openapi3.TypeObject,
+						Required: []string{"frame_index", "entity_id", "mask"},
+						Properties: openapi3.Schemas{
+							"frame_index": builder.PrimitiveSchemaRef(
+								openapi3.TypeInteger,
+								builder.WithSchemaRefDescription("The index of the frame in the range, where the first frame has index 0 when tracking from it."),
+							),
+							"entity_id": builder.PrimitiveSchemaRef(
+								openapi3.TypeString,
+								builder.WithSchemaRefDescription("The entity of the mask, which is empty when tracking a single mask from the first frame."),
+							),
+							"mask": builder.SchemaRef("Mask"),
 						},
 					},
 				},
@@ -6,7 +6,14 @@ from typing import Callable, Iterator, List
 from grpc import ServicerContext, StatusCode
 
 from .proto.service.v1 import track_pb2_grpc
-from .proto.service.v1.track_pb2 import FrameMask, TrackRequest, TrackResponse
+from .proto.service.v1.track_pb2 import (
+    TRACK_DIRECTION_BACKWARD,
+    TRACK_DIRECTION_BOTH,
+    TRACK_DIRECTION_FORWARD,
+    FrameMask,
+    TrackRequest,
+    TrackResponse,
+)
 from .proto.schema.v1.train_pb2 import Mask
 from .lib.image import prepare_images
 
@@ -24,26 +31,47 @@ class TrackService(track_pb2_grpc.TrackServiceServicer):
 
     def Track(self, request: TrackRequest, context: ServicerContext) -> TrackResponse:
         logging.info("received a Track request")
-        im_paths = self._prepare_images(request=request, context=context)
-
-        # predict
-        tracker = self.on_reqeust(im_paths[0], request.first_image_mask)
-        masks = [tracker.predict(im_path) for im_path in im_paths[1:]]
-
-        return TrackResponse(subsequent_image_masks=masks)
+        frame_masks = list(self._track(request=request, context=context))
+        if not request.image_uris:
+            return TrackResponse(subsequent_image_masks=[m.mask for m in frame_masks])
+        return TrackResponse(frame_masks=frame_masks)
 
     def TrackStream(self, request: TrackRequest, context: ServicerContext) -> Iterator[FrameMask]:
         logging.info("received a TrackStream request")
-        im_paths = self._prepare_images(request=request, context=context)
+        yield from self._track(request=request, context=context)
+
+    def _track(self, request: TrackRequest, context: ServicerContext) -> Iterator[FrameMask]:
+        """Tracks each object away from the keyframe, forward before backward when tracking in both directions, where
+        a request in the legacy form tracks its only object forward from the first image."""
+        if request.image_uris:
+            im_uris = list(request.image_uris)
+            keyframe = request.keyframe_index
+            entity_masks = [(m.entity_id, m.mask) for m in request.keyframe_masks]
+            direction = request.direction
+        else:
+            im_uris = [request.first_image_uri, *request.subsequent_image_uris]
+            keyframe = 0
+            entity_masks = [("", request.first_image_mask)]
+            direction = TRACK_DIRECTION_FORWARD
+        if keyframe >= len(im_uris) or not entity_masks:
+            context.abort(StatusCode.INVALID_ARGUMENT, "invalid keyframe or masks")
+
+        # images are prepared once however many objects are tracked
+        im_paths = self._prepare_images(im_uris=im_uris, context=context)
 
-        # predict
-        tracker = self.on_reqeust(im_paths[0], request.first_image_mask)
-        for i, im_path in enumerate(im_paths[1:]):
-            mask = tracker.predict(im_path)
-            yield FrameMask(frame_index=i+1, mask=mask)
+        passes = []
+        if direction != TRACK_DIRECTION_BACKWARD:
+            passes.append(range(keyframe + 1, len(im_paths)))
+        if direction in (TRACK_DIRECTION_BACKWARD, TRACK_DIRECTION_BOTH):
+            passes.append(range(keyframe - 1, -1, -1))
+        for frames in passes:
+            # each pass starts over from the keyframe
+            trackers = [(eid, self.on_reqeust(im_paths[keyframe], mask)) for eid, mask in entity_masks]
+            for i in frames:
+                for eid, tracker in trackers:
+                    yield FrameMask(frame_index=i, mask=tracker.predict(im_paths[i]), entity_id=eid)
 
-    def _prepare_images(self, request: TrackRequest, context: ServicerContext) -> List[str]:
-        im_uris = [request.first_image_uri, *request.subsequent_image_uris]
+    def _prepare_images(self, im_uris: List[str], context: ServicerContext) -> List[str]:
         im_dir = os.path.join(self.workspace, "images")
         if not os.path.exists(im_dir):
             os.makedirs(im_dir)
@@ -53,4 +81,4 @@ class TrackService(track_pb2_grpc.TrackServiceServicer):
             logging.error(f"failed to prepare images: {e}")
             context.abort_with_status(StatusCode.INTERNAL.value)
 
-        return im_paths
\ No newline at end of file
+        return im_paths